@@ -11,9 +11,6 @@ var Version []byte
 //go:embed lagoon.yml
 var DefaultConfigData []byte
 
-//go:embed rsync
-var RsyncLinuxBinBytes []byte
-
 func GetVersion() string {
 	return strings.TrimSuffix(string(Version), "\n")
 }
@@ -21,7 +18,3 @@ func GetVersion() string {
 func GetDefaultConfig() ([]byte, error) {
 	return DefaultConfigData, nil
 }
-
-func RsyncBin() []byte {
-	return RsyncLinuxBinBytes
-}
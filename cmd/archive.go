@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -38,6 +40,8 @@ or other resources from a specified environment.`,
 		return nil
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
+		// Errors from here on are from the archiving, not how the command was used
+		cmd.SilenceUsage = true
 
 		var services map[string]utils.Service
 
@@ -47,13 +51,13 @@ or other resources from a specified environment.`,
 		} else {
 			serviceMap, err := utils.GetServices(dockerComposeFile)
 			if err != nil {
-				utils.LogFatalError(err.Error(), nil)
+				return err
 			}
 			services = serviceMap
 		}
 
 		if len(services) == 0 {
-			utils.LogFatalError(fmt.Sprint("No Lagoon Services found"), nil)
+			return errors.New("No Lagoon Services found")
 		}
 
 		// let's begin by doing the mariadb and postgres services only
@@ -67,7 +71,7 @@ or other resources from a specified environment.`,
 		if !skipVolumeAutodiscovery { // we're actually not going to look at the defined items anyways, so skip
 			rs, ok := services[ServiceName]
 			if !ok {
-				utils.LogFatalError(fmt.Sprintf("Unable to locate run service in dockerfile: %v", ServiceName), nil)
+				return fmt.Errorf("Unable to locate run service in dockerfile: %v", ServiceName)
 			}
 			runService = rs
 		}
@@ -75,7 +79,7 @@ or other resources from a specified environment.`,
 		tasks, err := discoverSyncTasks(services, runService, skipVolumeAutodiscovery, false)
 
 		if err != nil {
-			utils.LogFatalError(err.Error(), nil)
+			return err
 		}
 
 		// This will _only_ run locally - and so we set up the environments thusly
@@ -88,14 +92,14 @@ or other resources from a specified environment.`,
 		// okay - we got here, we may need a temporary directory
 		dirname, err := os.MkdirTemp(os.TempDir(), "lagoon-sync-archive-*")
 		if err != nil {
-			utils.LogFatalError(err.Error(), nil)
+			return err
 		}
 		defer os.RemoveAll(dirname)
 
 		archive, err := utils.InitArchive(archiveFile, rootCmd.Version)
 
 		if err != nil {
-			utils.LogFatalError(err.Error(), nil)
+			return err
 		}
 
 		areVolumesOverridden := false
@@ -104,7 +108,7 @@ or other resources from a specified environment.`,
 			for _, volumePath := range overrideVolumes {
 				err = archive.AddItem("files", volumePath, nil)
 				if err != nil {
-					utils.LogFatalError(err.Error(), nil)
+					return err
 				}
 			}
 		}
@@ -116,57 +120,57 @@ or other resources from a specified environment.`,
 
 				s, err := synchers.NewBaseMariaDbSyncRootFromService(task.Service)
 				if err != nil {
-					utils.LogFatalError(err.Error(), nil)
+					return err
 				}
 
 				transferResourceName := fmt.Sprintf("mysql-%v.sql.gz", task.Service.Name)
 				s.SetTransferResource(filepath.Join(dirname, transferResourceName))
 				// We can simply run the source command directly.
-				err = synchers.SyncRunSourceCommand(environment, s, false, nil)
+				err = synchers.SyncRunSourceCommand(context.Background(), environment, s, false, nil, nil)
 				if err != nil {
-					utils.LogFatalError(err.Error(), nil)
+					return err
 				}
 				//we'll save the syncher detail for reloading on the other side
 				syncherJson, err := json.Marshal(s)
 				if err != nil {
-					utils.LogFatalError(err.Error(), nil)
+					return err
 				}
 				err = archive.AddItem("mariadb", s.GetTransferResource(environment).Name, map[string]string{
 					"syncher": string(syncherJson),
 				})
 				if err != nil {
-					utils.LogFatalError(err.Error(), nil)
+					return err
 				}
 			case "postgres":
 				s, err := synchers.NewBasePostgresSyncRootFromService(task.Service)
 				if err != nil {
-					utils.LogFatalError(err.Error(), nil)
+					return err
 				}
 
 				transferResourceName := fmt.Sprintf("postgres-%v.sql.gz", task.Service.Name)
 				s.SetTransferResource(filepath.Join(dirname, transferResourceName))
 				// We can simply run the source command directly.
-				err = synchers.SyncRunSourceCommand(environment, s, false, nil)
+				err = synchers.SyncRunSourceCommand(context.Background(), environment, s, false, nil, nil)
 				if err != nil {
-					utils.LogFatalError(err.Error(), nil)
+					return err
 				}
 				//we'll save the syncher detail for reloading on the other side
 				syncherJson, err := json.Marshal(s)
 				if err != nil {
-					utils.LogFatalError(err.Error(), nil)
+					return err
 				}
 				err = archive.AddItem("postgres", s.GetTransferResource(environment).Name, map[string]string{
 					"syncher": string(syncherJson),
 				})
 				if err != nil {
-					utils.LogFatalError(err.Error(), nil)
+					return err
 				}
 			case "files":
 				// this should be the simplest, we just add it to the archive
 				if !areVolumesOverridden {
 					err = archive.AddItem("files", task.VolumePath, nil)
 					if err != nil {
-						utils.LogFatalError(err.Error(), nil)
+						return err
 					}
 				}
 			}
@@ -176,7 +180,7 @@ or other resources from a specified environment.`,
 		err = archive.WriteArchive()
 
 		if err != nil {
-			utils.LogFatalError(err.Error(), nil)
+			return err
 		}
 
 		return nil
@@ -209,18 +213,21 @@ or other resources from a specified environment.`,
 			return fmt.Errorf("--use-service-api is not yet implemented")
 		}
 
+		// Errors from here on are from the extraction, not how the command was used
+		cmd.SilenceUsage = true
+
 		// let's pull the manifest out of this thing.
 		manifest, err := utils.ExtractManifest(archiveInputFile)
 
 		if err != nil {
-			utils.LogFatalError(err.Error(), nil)
+			return err
 		}
 
 		// we'll set up a temp dir for extraction / working
 		tmpdir, err := os.MkdirTemp(os.TempDir(), "lagoon-sync-extract-*")
 
 		if err != nil {
-			utils.LogFatalError("Unable to create a temporary directory", nil)
+			return fmt.Errorf("Unable to create a temporary directory: %w", err)
 		}
 
 		defer os.RemoveAll(tmpdir)
@@ -239,55 +246,55 @@ or other resources from a specified environment.`,
 				var ok bool
 				// grab the syncher data from the manifest
 				if data, ok = item.Data["syncher"]; ok != true {
-					utils.LogFatalError("Unable to find syncher for mariadb service", nil)
+					return errors.New("Unable to find syncher for mariadb service")
 				}
 
 				err = json.Unmarshal([]byte(data), &s)
 				if err != nil {
-					utils.LogFatalError(err.Error(), nil)
+					return err
 				}
 
 				// We'll want to remove the leading `/` from this
 
 				err = utils.ExtractFromArchive(archiveInputFile, item.Filename, tmpdir, true, nil)
 				if err != nil {
-					utils.LogFatalError(err.Error(), nil)
+					return err
 				}
 
 				s.TransferResourceOverride = filepath.Join(tmpdir, s.TransferResourceOverride)
-				err = synchers.SyncRunTargetCommand(environment, &s, dryRun, nil)
+				err = synchers.SyncRunTargetCommand(context.Background(), environment, &s, dryRun, nil, nil)
 				if err != nil {
-					utils.LogFatalError(err.Error(), nil)
+					return err
 				}
 			case "postgres":
 				var s synchers.PostgresSyncRoot
 				var data string
 				var ok bool
 				if data, ok = item.Data["syncher"]; ok != true {
-					utils.LogFatalError("Unable to find syncher for postgres service", nil)
+					return errors.New("Unable to find syncher for postgres service")
 				}
 
 				err = json.Unmarshal([]byte(data), &s)
 				if err != nil {
-					utils.LogFatalError(err.Error(), nil)
+					return err
 				}
 
 				err = utils.ExtractFromArchive(archiveInputFile, item.Filename, tmpdir, true, nil)
 				if err != nil {
-					utils.LogFatalError(err.Error(), nil)
+					return err
 				}
 
 				s.TransferResourceOverride = filepath.Join(tmpdir, s.TransferResourceOverride)
-				err = synchers.SyncRunTargetCommand(environment, &s, dryRun, nil)
+				err = synchers.SyncRunTargetCommand(context.Background(), environment, &s, dryRun, nil, nil)
 				if err != nil {
-					utils.LogFatalError(err.Error(), nil)
+					return err
 				}
 			case "files":
 
 				err = utils.ExtractFromArchive(archiveInputFile, item.Filename, extractionRoot, true, fileExtractionIgnoreList)
 
 				if err != nil {
-					utils.LogFatalError(err.Error(), nil)
+					return err
 				}
 			}
 		}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/uselagoon/lagoon-sync/synchers"
+	"github.com/uselagoon/lagoon-sync/utils"
+)
+
+// The process exit codes, so that scripts can tell why a sync failed
+const (
+	exitCodeError               = 1
+	exitCodeConfigInvalid       = 2
+	exitCodeSSHAuth             = 3
+	exitCodeRemoteCommandFailed = 4
+	exitCodeTransferFailed      = 5
+	exitCodeProtectedTarget     = 6
+	exitCodeCancelled           = 130
+)
+
+// exitCodeForError picks the exit code for err. An error can be more than one of these - a transfer that failed
+// because ssh couldn't authenticate, say - so the most specific cause is checked first.
+func exitCodeForError(err error) int {
+	var remoteErr *utils.ErrRemoteCommandFailed
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, context.Canceled):
+		return exitCodeCancelled
+	case errors.Is(err, synchers.ErrProtectedTarget):
+		return exitCodeProtectedTarget
+	case errors.Is(err, synchers.ErrConfigInvalid):
+		return exitCodeConfigInvalid
+	case errors.Is(err, utils.ErrSSHAuth):
+		return exitCodeSSHAuth
+	case errors.Is(err, synchers.ErrTransferFailed):
+		return exitCodeTransferFailed
+	case errors.As(err, &remoteErr):
+		return exitCodeRemoteCommandFailed
+	default:
+		return exitCodeError
+	}
+}
+
+// exitCodeForResults picks the exit code for a run of several syncs - the code of their failures if they all
+// failed for the same reason, and the generic error code if not
+func exitCodeForResults(results []SyncResult) int {
+	exitCode := 0
+	for _, result := range results {
+		if result.Success {
+			continue
+		}
+		resultCode := exitCodeForError(result.Error)
+		if resultCode == 0 {
+			resultCode = exitCodeError
+		}
+		if exitCode != 0 && exitCode != resultCode {
+			return exitCodeError
+		}
+		exitCode = resultCode
+	}
+	return exitCode
+}
+
+// exitWithError logs the error and exits with the code for it
+func exitWithError(err error) {
+	utils.LogError(err.Error(), nil)
+	os.Exit(exitCodeForError(err))
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/uselagoon/lagoon-sync/synchers"
+	"github.com/uselagoon/lagoon-sync/utils"
+)
+
+func TestExitCodeForError(t *testing.T) {
+	remoteErr := &utils.ErrRemoteCommandFailed{ExitCode: 2, Stderr: "mysqldump: Got error: 1045"}
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "No error", err: nil, want: 0},
+		{name: "Other error", err: errors.New("something went wrong"), want: exitCodeError},
+		{name: "Invalid config", err: fmt.Errorf("%w: syncer of type 'nope' not registered", synchers.ErrConfigInvalid), want: exitCodeConfigInvalid},
+		{name: "ssh authentication", err: fmt.Errorf("%w: no valid authentication methods provided", utils.ErrSSHAuth), want: exitCodeSSHAuth},
+		{name: "Remote command", err: fmt.Errorf("export failed: %w", remoteErr), want: exitCodeRemoteCommandFailed},
+		{name: "Transfer", err: fmt.Errorf("%w: %w", synchers.ErrTransferFailed, remoteErr), want: exitCodeTransferFailed},
+		{name: "Transfer that couldn't authenticate", err: fmt.Errorf("%w: %w", synchers.ErrTransferFailed, utils.ErrSSHAuth), want: exitCodeSSHAuth},
+		{name: "Protected target", err: fmt.Errorf("%w: main is protected, since it's a production environment", synchers.ErrProtectedTarget), want: exitCodeProtectedTarget},
+		{name: "Interrupted", err: fmt.Errorf("import failed: %w", context.Canceled), want: exitCodeCancelled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCodeForError(tt.err); got != tt.want {
+				t.Errorf("exitCodeForError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExitCodeForResults(t *testing.T) {
+	transferErr := fmt.Errorf("%w: connection reset", synchers.ErrTransferFailed)
+	tests := []struct {
+		name    string
+		results []SyncResult
+		want    int
+	}{
+		{
+			name:    "All succeeded",
+			results: []SyncResult{{Success: true}, {Success: true}},
+			want:    0,
+		},
+		{
+			name:    "Failures for the same reason",
+			results: []SyncResult{{Success: true}, {Error: transferErr}, {Error: transferErr}},
+			want:    exitCodeTransferFailed,
+		},
+		{
+			name:    "Failures for different reasons",
+			results: []SyncResult{{Error: transferErr}, {Error: synchers.ErrConfigInvalid}},
+			want:    exitCodeError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCodeForResults(tt.results); got != tt.want {
+				t.Errorf("exitCodeForResults() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
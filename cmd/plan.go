@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/uselagoon/lagoon-sync/lagoonsync"
+	synchers "github.com/uselagoon/lagoon-sync/synchers"
+	"github.com/uselagoon/lagoon-sync/utils"
+)
+
+// planBandwidth is the transfer speed the plan's estimate uses, in megabytes a second
+var planBandwidth float64
+
+var planCmd = &cobra.Command{
+	Use:   "plan [mariadb|files|postgres|etc.]",
+	Short: "Preview what a sync would transfer",
+	Long: `Connects to the source and reports what syncing from it would do, without changing anything: the size of each
+table or the files that would be synced, the free disk space on both ends, whether the tools the sync needs are
+available, and roughly how long the transfer would take.`,
+	Args: cobra.ExactArgs(1),
+	Run:  planCommandRun,
+}
+
+func planCommandRun(cmd *cobra.Command, args []string) {
+	syncerType := args[0]
+
+	configRoot, err := loadConfigRoot()
+	if err != nil {
+		exitWithError(fmt.Errorf("Failed to load configuration: %w", err))
+	}
+
+	ProjectName = resolveProjectName(ProjectName, configRoot)
+	if ProjectName == "" {
+		utils.LogFatalError("No Project name given", nil)
+	}
+	if planBandwidth <= 0 {
+		exitWithError(fmt.Errorf("%w: the bandwidth has to be more than 0", synchers.ErrConfigInvalid))
+	}
+
+	sshOptions := buildSSHOptions(configRoot, SSHHost, SSHPort, SSHKey, SSHVerbose, SSHSkipAgent, RsyncArguments, SSHHostKeyPolicy, SSHKnownHostsFile)
+	client, err := newSyncClient(configRoot, sshOptions)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	ctx, stop := interruptContext()
+	plan, err := client.Plan(ctx, lagoonsync.PlanRequest{
+		SyncRequest: lagoonsync.SyncRequest{
+			SyncerType:        syncerType,
+			ProjectName:       ProjectName,
+			SourceEnvironment: sourceEnvironmentName,
+			TargetEnvironment: targetEnvironmentName,
+			ServiceName:       ServiceName,
+			Tables:            syncTables,
+		},
+		Bandwidth: int64(planBandwidth * 1000 * 1000),
+	})
+	stop()
+	client.Close()
+	if err != nil {
+		exitWithError(fmt.Errorf("There was an error planning the sync: %w", err))
+	}
+
+	utils.LogEvent("plan", plan)
+	printPlan(plan)
+}
+
+// printPlan prints the plan for people to read, unless the output is JSON
+func printPlan(plan *synchers.SyncPlan) {
+	if utils.IsJSONOutput() {
+		return
+	}
+
+	printText("\nSyncing %s from %s to %s would transfer:\n\n", plan.SyncerType, plan.Source.Environment, plan.Target.Environment)
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(table, "NAME\tSIZE\tROWS/FILES\tSTATUS")
+	for _, item := range plan.Items {
+		fmt.Fprintf(table, "%s\t%s\t%d\t%s\n", item.Name, formatBytes(item.Bytes), item.Count, item.Status)
+	}
+	table.Flush()
+
+	estimate := time.Duration(plan.EstimatedTransferMs) * time.Millisecond
+	printText("\nTotal: %s, taking about %s at %.1f MB/s\n\n", formatBytes(plan.TransferBytes), estimate.Round(time.Second), planBandwidth)
+
+	for _, environment := range []synchers.SyncPlanEnvironment{plan.Source, plan.Target} {
+		free := "unknown"
+		if environment.FreeBytes >= 0 {
+			free = formatBytes(environment.FreeBytes)
+		}
+		tools := make([]string, 0, len(environment.Tools))
+		for tool, found := range environment.Tools {
+			if found {
+				tools = append(tools, tool)
+			} else {
+				tools = append(tools, tool+" (missing)")
+			}
+		}
+		sort.Strings(tools)
+		printText("%s: %s free in %s, tools: %s\n", environment.Environment, free, environment.Directory, strings.Join(tools, ", "))
+	}
+
+	if len(plan.Warnings) > 0 {
+		printText("\nWarnings:\n")
+		for _, warning := range plan.Warnings {
+			printText("  - %s\n", warning)
+		}
+	}
+}
+
+// formatBytes formats a size in bytes with the largest unit that keeps it above 1
+func formatBytes(bytes int64) string {
+	const unit = 1000
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	value := float64(bytes)
+	units := []string{"B", "kB", "MB", "GB", "TB", "PB"}
+	i := 0
+	for value >= unit && i < len(units)-1 {
+		value /= unit
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", value, units[i])
+}
+
+func init() {
+	rootCmd.AddCommand(planCmd)
+	planCmd.Flags().StringVarP(&ProjectName, "project-name", "p", "", "The Lagoon project name of the remote system")
+	planCmd.Flags().StringVarP(&sourceEnvironmentName, "source-environment-name", "e", "", "The Lagoon environment name of the source system")
+	planCmd.MarkFlagRequired("source-environment-name")
+	planCmd.Flags().StringVarP(&targetEnvironmentName, "target-environment-name", "t", "", "The target environment name (defaults to local)")
+	planCmd.Flags().StringVarP(&ServiceName, "service-name", "s", "", "The service name (default is 'cli'")
+	planCmd.Flags().StringVarP(&SSHHost, "ssh-host", "H", "ssh.lagoon.amazeeio.cloud", "Specify your lagoon ssh host, defaults to 'ssh.lagoon.amazeeio.cloud'")
+	planCmd.Flags().StringVarP(&SSHPort, "ssh-port", "P", "32222", "Specify your ssh port, defaults to '32222'")
+	planCmd.Flags().StringVarP(&SSHKey, "ssh-key", "i", "", "Specify path to a specific SSH key to use for authentication")
+	planCmd.Flags().BoolVar(&SSHSkipAgent, "ssh-skip-agent", false, "Do not attempt to use an ssh-agent for key management")
+	planCmd.Flags().BoolVar(&SSHVerbose, "verbose", false, "Run ssh commands in verbose (useful for debugging)")
+	planCmd.Flags().StringVar(&SSHHostKeyPolicy, "ssh-host-key-policy", "", "How to verify ssh host keys - 'strict', 'accept-new' or 'insecure' (defaults to 'accept-new')")
+	planCmd.Flags().StringVar(&SSHKnownHostsFile, "ssh-known-hosts", "", "Path to the known_hosts file used to verify ssh host keys (defaults to ~/.ssh/known_hosts)")
+	planCmd.Flags().StringSliceVar(&syncTables, "tables", nil, "Only plan syncing these tables, by name, glob pattern ('cache_*') or regular expression ('/^cache_/') (mariadb only)")
+	planCmd.Flags().Float64Var(&planBandwidth, "bandwidth", 10, "The transfer speed, in megabytes a second, used to estimate how long the transfer takes")
+	planCmd.Flags().StringVarP(&APIEndpoint, "api", "A", "https://api.lagoon.amazeeio.cloud/graphql", "Specify your lagoon api endpoint - required for ssh-portal integration")
+	planCmd.Flags().BoolVar(&useSshPortal, "use-ssh-portal", false, "This will use the SSH Portal rather than the (soon to be removed) SSH Service on Lagoon core. Will become default in a future release.")
+}
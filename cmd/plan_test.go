@@ -0,0 +1,22 @@
+package cmd
+
+import "testing"
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		bytes int64
+		want  string
+	}{
+		{bytes: 0, want: "0 B"},
+		{bytes: 999, want: "999 B"},
+		{bytes: 1500, want: "1.5 kB"},
+		{bytes: 2500000000, want: "2.5 GB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := formatBytes(tt.bytes); got != tt.want {
+				t.Errorf("formatBytes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/manifoldco/promptui"
+	synchers "github.com/uselagoon/lagoon-sync/synchers"
+	"github.com/uselagoon/lagoon-sync/utils"
+)
+
+const overwriteProtectedTargetFlag = "i-understand-this-overwrites-production"
+
+var overwriteProtectedTarget bool
+
+var (
+	protectedTargetsLock      sync.Mutex
+	confirmedProtectedTargets = map[string]bool{}
+)
+
+// confirmProtectedTarget is asked before a protected environment is overwritten. It needs the
+// --i-understand-this-overwrites-production flag, and for the project and environment to be typed out - which
+// --no-interaction doesn't skip. Each environment is only confirmed once, however many syncs run into it.
+func confirmProtectedTarget(target synchers.Environment, reason string) error {
+	protectedTargetsLock.Lock()
+	defer protectedTargetsLock.Unlock()
+
+	expected := target.GetOpenshiftProjectName()
+	if confirmedProtectedTargets[expected] {
+		return nil
+	}
+	if !overwriteProtectedTarget {
+		return fmt.Errorf("overwriting it needs the --%s flag", overwriteProtectedTargetFlag)
+	}
+
+	utils.LogWarning(fmt.Sprintf("%s is protected, since %s", target.EnvironmentName, reason), nil)
+	prompt := promptui.Prompt{
+		Label: fmt.Sprintf("Type '%s' to confirm overwriting it", expected),
+	}
+	// Keep stdout for the events when the output is JSON
+	if utils.IsJSONOutput() {
+		prompt.Stdout = os.Stderr
+	}
+	typed, err := prompt.Run()
+	if err != nil {
+		return fmt.Errorf("unable to confirm overwriting it: %w", err)
+	}
+	if typed != expected {
+		return errors.New("the confirmation didn't match, so it's left alone")
+	}
+
+	confirmedProtectedTargets[expected] = true
+	return nil
+}
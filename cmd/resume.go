@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/uselagoon/lagoon-sync/lagoonsync"
+	synchers "github.com/uselagoon/lagoon-sync/synchers"
+	"github.com/uselagoon/lagoon-sync/utils"
+)
+
+var resumeCmd = &cobra.Command{
+	Use:   "resume [run-id]",
+	Short: "Resume a sync that didn't complete",
+	Long: `Picks a sync run back up from the last phase it completed, reusing any dump that has already been generated.
+The run id is printed at the start of every sync, and again if a sync fails.`,
+	Args: cobra.ExactArgs(1),
+	Run:  resumeCommandRun,
+}
+
+func resumeCommandRun(cmd *cobra.Command, args []string) {
+	journal, err := synchers.LoadSyncJournal(synchers.GetJournalDirectory(), args[0])
+	if err != nil {
+		exitWithError(err)
+	}
+
+	if journal.IsFinished() {
+		log.Printf("Sync run %s has already completed, there is nothing to resume", journal.RunId)
+		return
+	}
+
+	// Load the same configuration the original run used
+	if journal.ConfigFile != "" {
+		if err := processConfig(journal.ConfigFile); err != nil {
+			exitWithError(fmt.Errorf("Failed to load configuration: %w", err))
+		}
+	}
+	configRoot, err := loadConfigRoot()
+	if err != nil {
+		exitWithError(fmt.Errorf("Failed to load configuration: %w", err))
+	}
+
+	client, err := lagoonsync.NewClient(lagoonsync.Config{
+		SyncConfig:             configRoot,
+		ConfigFile:             absoluteConfigFilePath(),
+		SSH:                    journal.SSHOptions,
+		UseSSHPortal:           journal.UseSshPortal,
+		APIEndpoint:            journal.APIEndpoint,
+		JournalDirectory:       synchers.GetJournalDirectory(),
+		ManifestDirectory:      synchers.GetManifestDirectory(),
+		RunSyncProcess:         runSyncProcess,
+		ConfirmProtectedTarget: confirmProtectedTarget,
+		ConfirmDeletions:       confirmDeletions,
+	})
+	if err != nil {
+		exitWithError(err)
+	}
+
+	sourceEnvironment := synchers.Environment{ProjectName: journal.ProjectName, EnvironmentName: journal.SourceEnvironment}
+	targetEnvironment := synchers.Environment{ProjectName: journal.ProjectName, EnvironmentName: journal.TargetEnvironment}
+
+	if !noCliInteraction {
+		utils.SetShowSpinner(true)
+
+		confirmationResult, err := confirmPrompt(fmt.Sprintf("Project: %s - you are about to resume syncing %s from %s to %s (completed: %v), is this correct",
+			journal.ProjectName,
+			journal.SyncerType,
+			sourceEnvironment.EnvironmentName, targetEnvironment.EnvironmentName,
+			journal.CompletedPhases))
+		utils.SetColour(true)
+		if err != nil || !confirmationResult {
+			utils.LogFatalError("User cancelled sync - exiting", nil)
+		}
+	}
+
+	started := time.Now()
+	ctx, stop := interruptContext()
+	result, err := client.Resume(ctx, journal.RunId)
+	stop()
+	client.Close()
+	logSyncSummary(started, []syncSummaryEntry{{SyncReport: result.Report}})
+
+	if err != nil {
+		exitWithError(fmt.Errorf("There was an error running the sync process: %w", err))
+	}
+
+	log.Printf("\n------\nSuccessful sync of %s from %s to %s\n------", journal.SyncerType, sourceEnvironment.GetOpenshiftProjectName(), targetEnvironment.GetOpenshiftProjectName())
+}
+
+func init() {
+	rootCmd.AddCommand(resumeCmd)
+	resumeCmd.Flags().BoolVarP(&noCliInteraction, "no-interaction", "y", false, "Disallow interaction")
+	resumeCmd.Flags().BoolVar(&overwriteProtectedTarget, overwriteProtectedTargetFlag, false, "Allow overwriting a protected environment, which also has to be confirmed by typing out its project and environment name")
+}
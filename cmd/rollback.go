@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/uselagoon/lagoon-sync/lagoonsync"
+	synchers "github.com/uselagoon/lagoon-sync/synchers"
+	"github.com/uselagoon/lagoon-sync/utils"
+)
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback [mariadb|postgres|etc.] [run-id]",
+	Short: "Restore a target database to how it was before a sync",
+	Long: `Restores the target of a sync run from the backup it took before importing. The target's database is
+cleared first, so tables the sync created are removed too. Only syncs run with --backup-target can be rolled back.
+The run id is printed at the start of every sync.`,
+	Args: cobra.ExactArgs(2),
+	Run:  rollbackCommandRun,
+}
+
+func rollbackCommandRun(cmd *cobra.Command, args []string) {
+	syncerType, runId := args[0], args[1]
+	journal, err := synchers.LoadSyncJournal(synchers.GetJournalDirectory(), runId)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	// Load the same configuration the original run used
+	if journal.ConfigFile != "" {
+		if err := processConfig(journal.ConfigFile); err != nil {
+			exitWithError(fmt.Errorf("Failed to load configuration: %w", err))
+		}
+	}
+	configRoot, err := loadConfigRoot()
+	if err != nil {
+		exitWithError(fmt.Errorf("Failed to load configuration: %w", err))
+	}
+
+	client, err := lagoonsync.NewClient(lagoonsync.Config{
+		SyncConfig:             configRoot,
+		ConfigFile:             absoluteConfigFilePath(),
+		SSH:                    journal.SSHOptions,
+		UseSSHPortal:           journal.UseSshPortal,
+		APIEndpoint:            journal.APIEndpoint,
+		JournalDirectory:       synchers.GetJournalDirectory(),
+		ConfirmProtectedTarget: confirmProtectedTarget,
+	})
+	if err != nil {
+		exitWithError(err)
+	}
+
+	targetEnvironment := synchers.Environment{ProjectName: journal.ProjectName, EnvironmentName: journal.TargetEnvironment}
+	if !noCliInteraction {
+		confirmationResult, err := confirmPrompt(fmt.Sprintf("Project: %s - you are about to restore the %s database of %s to how it was before sync run %s, is this correct",
+			journal.ProjectName,
+			journal.SyncerType,
+			targetEnvironment.EnvironmentName,
+			journal.RunId))
+		utils.SetColour(true)
+		if err != nil || !confirmationResult {
+			utils.LogFatalError("User cancelled rollback - exiting", nil)
+		}
+	}
+
+	ctx, stop := interruptContext()
+	err = client.Rollback(ctx, syncerType, runId)
+	stop()
+	client.Close()
+	if err != nil {
+		exitWithError(fmt.Errorf("There was an error rolling back: %w", err))
+	}
+
+	log.Printf("\n------\nRestored %s on %s from %s\n------", journal.SyncerType, targetEnvironment.GetOpenshiftProjectName(), journal.BackupFile)
+}
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+	rollbackCmd.Flags().BoolVarP(&noCliInteraction, "no-interaction", "y", false, "Disallow interaction")
+	rollbackCmd.Flags().BoolVar(&overwriteProtectedTarget, overwriteProtectedTargetFlag, false, "Allow overwriting a protected environment, which also has to be confirmed by typing out its project and environment name")
+}
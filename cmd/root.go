@@ -19,6 +19,7 @@ var cfgFile string
 var lagoonSyncDefaultsFile string
 var lagoonSyncCfgFile string
 var ShowDebug bool
+var outputFormat string
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -39,13 +40,20 @@ func Version() string {
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		os.Exit(exitCodeForError(err))
 	}
 }
 
 func init() {
 	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
-		return initConfig()
+		if err := utils.SetOutputFormat(outputFormat); err != nil {
+			return err
+		}
+		if err := initConfig(); err != nil {
+			return err
+		}
+		utils.SetShowDebug(viper.GetBool("show-debug"))
+		return nil
 	}
 	rootCmd.SetVersionTemplate(Version())
 
@@ -56,6 +64,7 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "Path to the file used to set lagoon-sync configuration")
 	rootCmd.PersistentFlags().BoolVar(&ShowDebug, "show-debug", false, "Shows debug information")
 	viper.BindPFlag("show-debug", rootCmd.PersistentFlags().Lookup("show-debug"))
+	rootCmd.PersistentFlags().StringVar(&outputFormat, "output", utils.OutputFormatText, "Output format - 'text', or 'json' for newline delimited JSON events")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
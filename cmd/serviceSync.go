@@ -1,14 +1,19 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/charmbracelet/huh"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/uselagoon/lagoon-sync/lagoonsync"
 	synchers "github.com/uselagoon/lagoon-sync/synchers"
 	"github.com/uselagoon/lagoon-sync/utils"
 )
@@ -17,6 +22,7 @@ var dockerComposeFile string
 var sersyncListOnly bool
 var allServices bool
 var serviceToRunSync string
+var parallelTasks int
 
 // We use this to filter the standard service types we can sync.
 var supportedSynchableServicetypes = []string{
@@ -26,11 +32,13 @@ var supportedSynchableServicetypes = []string{
 	"postgres",
 	"postgres-single",
 	"postgres-dbaas",
+	"redis",
+	"redis-persistent",
 }
 
 // SyncTask represents a single resource (DB or volume) to be synced
 type SyncTask struct {
-	Type       string // "mariadb", "postgres", "files"
+	Type       string // "mariadb", "postgres", "redis", "files"
 	Service    utils.Service
 	VolumePath string // only populated for files
 	Label      string // human-readable label for display
@@ -48,7 +56,7 @@ func servicesCommandRun(cmd *cobra.Command, args []string) {
 	services, err := utils.GetServices(dockerComposeFile)
 
 	if err != nil {
-		utils.LogFatalError(err.Error(), nil)
+		exitWithError(err)
 	}
 
 	if sersyncListOnly {
@@ -59,7 +67,7 @@ func servicesCommandRun(cmd *cobra.Command, args []string) {
 	// Load configuration
 	configRoot, err := loadConfigRoot()
 	if err != nil {
-		utils.LogFatalError(fmt.Sprintf("Failed to load configuration: %v", err), nil)
+		exitWithError(fmt.Errorf("Failed to load configuration: %w", err))
 	}
 	if viper.ConfigFileUsed() == "" {
 		utils.LogWarning("No configuration has been given/found for syncer: ", SyncerType)
@@ -90,23 +98,14 @@ func servicesCommandRun(cmd *cobra.Command, args []string) {
 	} else {
 		runService, err = selectServiceFromList(services, "Select service to use to do the transfer (typically your 'cli' service)", []string{})
 		if err != nil {
-			utils.LogFatalError(err.Error(), nil)
+			exitWithError(err)
 		}
 	}
 
-	// Build source and target environments
-	sourceEnvironment, targetEnvironment := buildEnvironments(ProjectName, runService.Name, sourceEnvironmentName, targetEnvironmentName)
-
-	configRootTyped := configRoot
-	sshOptions := buildSSHOptions(configRootTyped, SSHHost, SSHPort, SSHKey, SSHVerbose, SSHSkipAgent, RsyncArguments)
-	sshOptionWrapper, err := buildSSHOptionWrapper(ProjectName, sshOptions, configRootTyped, APIEndpoint, useSshPortal)
+	sshOptions := buildSSHOptions(configRoot, SSHHost, SSHPort, SSHKey, SSHVerbose, SSHSkipAgent, RsyncArguments, SSHHostKeyPolicy, SSHKnownHostsFile)
+	client, err := newSyncClient(configRoot, sshOptions)
 	if err != nil {
-		utils.LogFatalError(fmt.Sprintf("Failed to configure SSH options: %v", err), nil)
-	}
-
-	// Add assertion - we no longer support Remote to Remote syncs
-	if sourceEnvironment.EnvironmentName != synchers.LOCAL_ENVIRONMENT_NAME && targetEnvironment.EnvironmentName != synchers.LOCAL_ENVIRONMENT_NAME {
-		utils.LogFatalError("Remote to Remote transfers are not supported", nil)
+		exitWithError(err)
 	}
 
 	// Gather tasks: either discover all, or let user pick one interactively
@@ -114,12 +113,12 @@ func servicesCommandRun(cmd *cobra.Command, args []string) {
 	if allServices {
 		tasks, err = discoverSyncTasks(services, runService, false, false)
 		if err != nil {
-			utils.LogFatalError(fmt.Sprintf("Failed to discover sync tasks: %v", err), nil)
+			exitWithError(fmt.Errorf("Failed to discover sync tasks: %w", err))
 		}
 	} else {
 		task, err := gatherSingleTask(services, runService)
 		if err != nil {
-			utils.LogFatalError(err.Error(), nil)
+			exitWithError(err)
 		}
 		tasks = []SyncTask{task}
 	}
@@ -130,10 +129,25 @@ func servicesCommandRun(cmd *cobra.Command, args []string) {
 	}
 
 	// Execute all tasks through the same path
-	results := executeSyncTasks(tasks, sourceEnvironment, targetEnvironment, sshOptionWrapper)
+	ctx, stop := interruptContext()
+	started := time.Now()
+	results := executeSyncTasks(ctx, client, tasks, lagoonsync.SyncRequest{
+		ProjectName:          ProjectName,
+		SourceEnvironment:    sourceEnvironmentName,
+		TargetEnvironment:    targetEnvironmentName,
+		ServiceName:          runService.Name,
+		TransferResourceName: namedTransferResource,
+		DryRun:               dryRun,
+		Stream:               streamTransfer,
+		SkipSourceCleanup:    skipSourceCleanup,
+		SkipTargetCleanup:    skipTargetCleanup,
+		SkipTargetImport:     skipTargetImport,
+	}, parallelTasks)
+	stop()
+	client.Close()
 
 	// Report results
-	reportSyncResults(results)
+	reportSyncResults(results, started)
 }
 
 // gatherSingleTask uses the interactive menus to build one SyncTask from user selection
@@ -167,12 +181,7 @@ func gatherSingleTask(services map[string]utils.Service, runService utils.Servic
 			return SyncTask{}, err
 		}
 
-		serviceType := ""
-		if strings.Contains(syncService.Type, "mariadb") {
-			serviceType = "mariadb"
-		} else if strings.Contains(syncService.Type, "postgres") {
-			serviceType = "postgres"
-		}
+		serviceType := syncerTypeForService(syncService.Type)
 
 		return SyncTask{
 			Type:    serviceType,
@@ -196,14 +205,7 @@ func discoverSyncTasks(services map[string]utils.Service, runService utils.Servi
 
 			// Check if this is a supported DB service type
 			if utils.SliceContains(supportedSynchableServicetypes, svc.Type) {
-				serviceType := ""
-				if strings.Contains(svc.Type, "mariadb") {
-					serviceType = "mariadb"
-				} else if strings.Contains(svc.Type, "postgres") {
-					serviceType = "postgres"
-				}
-
-				if serviceType != "" {
+				if serviceType := syncerTypeForService(svc.Type); serviceType != "" {
 					task := SyncTask{
 						Type:    serviceType,
 						Service: svc,
@@ -243,6 +245,16 @@ func discoverSyncTasks(services map[string]utils.Service, runService utils.Servi
 	return tasks, nil
 }
 
+// syncerTypeForService is the syncer that syncs a service of the Lagoon service type, or "" if there isn't one
+func syncerTypeForService(serviceType string) string {
+	for _, syncerType := range []string{"mariadb", "postgres", "redis"} {
+		if strings.Contains(serviceType, syncerType) {
+			return syncerType
+		}
+	}
+	return ""
+}
+
 // selectSyncTasks presents a multi-select checklist of tasks
 func selectSyncTasks(tasks []SyncTask) ([]SyncTask, error) {
 	options := make([]huh.Option[int], len(tasks))
@@ -276,17 +288,66 @@ type SyncResult struct {
 	Task     SyncTask
 	Success  bool
 	Error    error
-	Duration string // optional, for future use
+	Duration string               // how long the task took to run
+	Report   *synchers.SyncReport // what the sync did, nil if it never started
 }
 
-// executeSyncTasks runs all sync tasks sequentially
-func executeSyncTasks(tasks []SyncTask, sourceEnv, targetEnv synchers.Environment, sshWrapper *synchers.SSHOptionWrapper) []SyncResult {
-	var results []SyncResult
+// executeSyncTasks runs the sync tasks using a pool of `parallel` workers, each task a copy of the request with the
+// task's syncer. Results are returned in the same order as the tasks, regardless of the order they complete in.
+func executeSyncTasks(ctx context.Context, client *lagoonsync.Client, tasks []SyncTask, request lagoonsync.SyncRequest, parallel int) []SyncResult {
+	if parallel < 1 {
+		parallel = 1
+	}
 
-	for _, task := range tasks {
-		result := SyncResult{Task: task}
+	results := make([]SyncResult, len(tasks))
+	sourceEnv := synchers.Environment{ProjectName: request.ProjectName, EnvironmentName: request.SourceEnvironment, ServiceName: request.ServiceName}
+	syncers, syncerErrors := createTaskSyncers(tasks, sourceEnv)
+
+	taskIndexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < parallel && w < len(tasks); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range taskIndexes {
+				if syncerErrors[i] != nil {
+					results[i] = SyncResult{Task: tasks[i], Error: syncerErrors[i], Report: skippedSyncReport(tasks[i], synchers.SyncStatusFailed, syncerErrors[i])}
+					continue
+				}
+				// Once cancelled, the tasks that haven't started yet are skipped
+				if ctx.Err() != nil {
+					err := fmt.Errorf("skipped: %w", ctx.Err())
+					results[i] = SyncResult{Task: tasks[i], Error: err, Report: skippedSyncReport(tasks[i], synchers.SyncStatusCancelled, err)}
+					continue
+				}
+				// When tasks run side by side, their log lines are prefixed so they can be told apart
+				var logger *utils.Logger
+				if parallel > 1 {
+					logger = utils.NewLogger(tasks[i].Label)
+				}
+				results[i] = executeSyncTask(ctx, client, tasks[i], syncers[i], request, logger)
+			}
+		}()
+	}
+
+	for i := range tasks {
+		taskIndexes <- i
+	}
+	close(taskIndexes)
+	wg.Wait()
 
-		// Create syncher based on task type
+	return results
+}
+
+// createTaskSyncers creates the syncer for each task up front. Database syncers name their dumps using the time
+// they were created, so if two come out with the same name they're regenerated - otherwise concurrent syncs of two
+// databases could end up writing to the same file.
+func createTaskSyncers(tasks []SyncTask, sourceEnv synchers.Environment) ([]synchers.Syncer, []error) {
+	syncers := make([]synchers.Syncer, len(tasks))
+	syncerErrors := make([]error, len(tasks))
+	transferResources := map[string]bool{}
+
+	for i, task := range tasks {
 		var syncher synchers.Syncer
 		var err error
 
@@ -295,49 +356,84 @@ func executeSyncTasks(tasks []SyncTask, sourceEnv, targetEnv synchers.Environmen
 			syncher, err = synchers.NewBaseMariaDbSyncRootFromService(task.Service)
 		case "postgres":
 			syncher, err = synchers.NewBasePostgresSyncRootFromService(task.Service)
+		case "redis":
+			syncher, err = synchers.NewBaseRedisSyncRootFromService(task.Service)
 		case "files":
 			syncher, err = synchers.NewBaseFilesSyncRootFromService(task.Service, task.VolumePath)
 		}
 
 		if err != nil {
-			result.Error = fmt.Errorf("failed to create syncher for %s: %w", task.Label, err)
-			results = append(results, result)
+			syncerErrors[i] = fmt.Errorf("failed to create syncher for %s: %w", task.Label, err)
 			continue
 		}
 
-		// Execute sync process
-		syncArgs := synchers.RunSyncProcessFunctionTypeArguments{
-			SourceEnvironment:    sourceEnv,
-			TargetEnvironment:    targetEnv,
-			LagoonSyncer:         syncher,
-			SyncerType:           SyncerType,
-			DryRun:               dryRun,
-			SshOptionWrapper:     sshWrapper,
-			SkipSourceCleanup:    skipSourceCleanup,
-			SkipTargetCleanup:    skipTargetCleanup,
-			SkipTargetImport:     skipTargetImport,
-			TransferResourceName: namedTransferResource,
+		if task.Type != "files" {
+			for transferResources[syncher.GetTransferResource(sourceEnv).Name] {
+				if syncher, err = syncher.PrepareSyncer(); err != nil {
+					break
+				}
+			}
+			if err != nil {
+				syncerErrors[i] = fmt.Errorf("failed to prepare syncher for %s: %w", task.Label, err)
+				continue
+			}
+			transferResources[syncher.GetTransferResource(sourceEnv).Name] = true
 		}
 
-		fmt.Printf("\n[SYNCING] %s...\n", task.Label)
-		err = runSyncProcess(syncArgs)
+		syncers[i] = syncher
+	}
 
-		if err != nil {
-			result.Error = err
-			fmt.Printf("[FAILED] %s: %v\n", task.Label, err)
-		} else {
-			result.Success = true
-			fmt.Printf("[SUCCESS] %s\n", task.Label)
-		}
+	return syncers, syncerErrors
+}
+
+// skippedSyncReport reports on a task that was never run
+func skippedSyncReport(task SyncTask, status string, err error) *synchers.SyncReport {
+	return &synchers.SyncReport{SyncerType: task.Type, Status: status, Error: err.Error(), Phases: []synchers.PhaseReport{}}
+}
+
+// executeSyncTask runs a single sync task and times it
+func executeSyncTask(ctx context.Context, client *lagoonsync.Client, task SyncTask, syncher synchers.Syncer, request lagoonsync.SyncRequest, logger *utils.Logger) SyncResult {
+	result := SyncResult{Task: task}
+	start := time.Now()
 
-		results = append(results, result)
+	request.SyncerType = task.Type
+	request.Syncer = syncher
+	request.Logger = logger
+
+	printText("\n[SYNCING] %s...\n", task.Label)
+	syncResult, err := client.Sync(ctx, request)
+	result.Report = syncResult.Report
+	result.Duration = time.Since(start).Round(time.Second).String()
+
+	if err != nil {
+		result.Error = err
+		printText("[FAILED] %s: %v\n", task.Label, err)
+	} else {
+		result.Success = true
+		printText("[SUCCESS] %s (%s)\n", task.Label, result.Duration)
 	}
 
-	return results
+	return result
 }
 
-// reportSyncResults prints the final summary
-func reportSyncResults(results []SyncResult) {
+// reportSyncResults prints the final summary, or logs it as an event when the output is JSON
+func reportSyncResults(results []SyncResult, started time.Time) {
+	if utils.IsJSONOutput() {
+		var entries []syncSummaryEntry
+		failureCount := 0
+		for _, result := range results {
+			entries = append(entries, syncSummaryEntry{Label: result.Task.Label, SyncReport: result.Report})
+			if !result.Success {
+				failureCount++
+			}
+		}
+		logSyncSummary(started, entries)
+		if failureCount > 0 {
+			os.Exit(exitCodeForResults(results))
+		}
+		return
+	}
+
 	fmt.Println("\n==================== SYNC SUMMARY ====================")
 
 	successCount := 0
@@ -347,7 +443,7 @@ func reportSyncResults(results []SyncResult) {
 	for _, result := range results {
 		if result.Success {
 			successCount++
-			fmt.Printf("✓ %s\n", result.Task.Label)
+			fmt.Printf("✓ %s (%s)\n", result.Task.Label, result.Duration)
 		} else {
 			failureCount++
 			fmt.Printf("✗ %s\n", result.Task.Label)
@@ -368,7 +464,8 @@ func reportSyncResults(results []SyncResult) {
 	fmt.Println("====================================================\n")
 
 	if failureCount > 0 {
-		log.Fatalf("Sync completed with %d errors", failureCount)
+		log.Printf("Sync completed with %d errors", failureCount)
+		os.Exit(exitCodeForResults(results))
 	} else if !dryRun {
 		log.Printf("✓ All %d syncs completed successfully", successCount)
 	}
@@ -497,6 +594,7 @@ func printSSHOptions(opts synchers.SSHOptions, indent string) {
 	fmt.Printf("%sPrivate Key: %s\n", indent, opts.PrivateKey)
 	fmt.Printf("%sSkip Agent:  %v\n", indent, opts.SkipAgent)
 	fmt.Printf("%sRsync Args:  %s\n", indent, opts.RsyncArgs)
+	fmt.Printf("%sHost Keys:   %s\n", indent, opts.GetHostKeyVerification().GetPolicy())
 }
 
 func init() {
@@ -504,6 +602,7 @@ func init() {
 	serviceCmd.Flags().StringVarP(&dockerComposeFile, "docker-compose-file", "f", "", "Path to docker-compose.yml (defaults to docker-compose.yml in current directory)")
 	serviceCmd.Flags().BoolVarP(&sersyncListOnly, "list-only", "l", false, "only display service sync options (default false)")
 	serviceCmd.Flags().BoolVar(&allServices, "all-services", false, "sync all discovered database services and volumes (default false, enables multi-select)")
+	serviceCmd.Flags().IntVar(&parallelTasks, "parallel", 1, "The number of services/volumes to sync at the same time")
 	serviceCmd.PersistentFlags().StringVarP(&ProjectName, "project-name", "p", "", "The Lagoon project name of the remote system")
 	serviceCmd.PersistentFlags().StringVarP(&serviceToRunSync, "run-service", "", "", "The service to use to run the transfer (typically cli)")
 	serviceCmd.PersistentFlags().StringVarP(&sourceEnvironmentName, "source-environment-name", "e", "", "The Lagoon environment name of the source system")
@@ -515,13 +614,17 @@ func init() {
 	serviceCmd.PersistentFlags().StringVarP(&SSHKey, "ssh-key", "i", "", "Specify path to a specific SSH key to use for authentication")
 	serviceCmd.PersistentFlags().BoolVar(&SSHSkipAgent, "ssh-skip-agent", false, "Do not attempt to use an ssh-agent for key management")
 	serviceCmd.PersistentFlags().BoolVar(&SSHVerbose, "verbose", false, "Run ssh commands in verbose (useful for debugging)")
+	serviceCmd.PersistentFlags().StringVar(&SSHHostKeyPolicy, "ssh-host-key-policy", "", "How to verify ssh host keys - 'strict', 'accept-new' or 'insecure' (defaults to 'accept-new')")
+	serviceCmd.PersistentFlags().StringVar(&SSHKnownHostsFile, "ssh-known-hosts", "", "Path to the known_hosts file used to verify ssh host keys (defaults to ~/.ssh/known_hosts)")
 	serviceCmd.PersistentFlags().BoolVarP(&noCliInteraction, "no-interaction", "y", false, "Disallow interaction")
 	serviceCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "Don't run the commands, just preview what will be run")
+	serviceCmd.PersistentFlags().BoolVar(&streamTransfer, "stream", false, "Pipe database exports straight into the import on the target, without writing dump files (database services only)")
 	serviceCmd.PersistentFlags().StringVarP(&RsyncArguments, "rsync-args", "r", "--omit-dir-times --no-perms --no-group --no-owner --chmod=ugo=rwX --recursive --compress", "Pass through arguments to change the behaviour of rsync")
 	serviceCmd.PersistentFlags().BoolVar(&skipSourceCleanup, "skip-source-cleanup", false, "Don't clean up any of the files generated on the source")
 	serviceCmd.PersistentFlags().BoolVar(&skipTargetCleanup, "skip-target-cleanup", false, "Don't clean up any of the files generated on the target")
 	serviceCmd.PersistentFlags().BoolVar(&skipTargetImport, "skip-target-import", false, "This will skip the import step on the target, in combination with 'no-target-cleanup' this essentially produces a resource dump")
 	serviceCmd.PersistentFlags().StringVarP(&namedTransferResource, "transfer-resource-name", "", "", "The name of the temporary file to be used to transfer generated resources (db dumps, etc) - random /tmp file otherwise")
+	serviceCmd.PersistentFlags().BoolVar(&overwriteProtectedTarget, overwriteProtectedTargetFlag, false, "Allow overwriting a protected environment, which also has to be confirmed by typing out its project and environment name")
 	serviceCmd.PersistentFlags().StringVarP(&APIEndpoint, "api", "A", "https://api.lagoon.amazeeio.cloud/graphql", "Specify your lagoon api endpoint - required for ssh-portal integration")
 	serviceCmd.PersistentFlags().BoolVar(&useSshPortal, "use-ssh-portal", false, "This will use the SSH Portal rather than the (soon to be removed) SSH Service on Lagoon core. Will become default in a future release.")
 	runSyncProcess = synchers.RunSyncProcess
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uselagoon/lagoon-sync/lagoonsync"
+	synchers "github.com/uselagoon/lagoon-sync/synchers"
+	"github.com/uselagoon/lagoon-sync/utils"
+)
+
+func Test_executeSyncTasks(t *testing.T) {
+	mariadbService := utils.Service{Name: "mariadb", Type: "mariadb"}
+	cliService := utils.Service{Name: "cli", Type: "cli", Volumes: map[string]string{"files": "/app/files"}}
+
+	tasks := []SyncTask{
+		{Type: "mariadb", Service: mariadbService, Label: "first"},
+		{Type: "mariadb", Service: mariadbService, Label: "second"},
+		{Type: "files", Service: cliService, VolumePath: "/app/files", Label: "third"},
+		{Type: "files", Service: cliService, Label: "broken"}, // no volume path, so the syncer can't be created
+	}
+
+	tests := []struct {
+		name     string
+		parallel int
+	}{
+		{name: "Sequential", parallel: 1},
+		{name: "Parallel", parallel: 3},
+		{name: "More workers than tasks", parallel: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mx sync.Mutex
+			running, maxRunning := 0, 0
+			transferResources := map[string]bool{}
+
+			client, err := lagoonsync.NewClient(lagoonsync.Config{RunSyncProcess: func(ctx context.Context, args synchers.RunSyncProcessFunctionTypeArguments) error {
+				mx.Lock()
+				running++
+				if running > maxRunning {
+					maxRunning = running
+				}
+				transferResources[args.LagoonSyncer.GetTransferResource(args.SourceEnvironment).Name] = true
+				mx.Unlock()
+
+				time.Sleep(10 * time.Millisecond)
+
+				mx.Lock()
+				running--
+				mx.Unlock()
+
+				if args.LagoonSyncer.GetTransferResource(args.SourceEnvironment).IsDirectory {
+					return errors.New("files failed")
+				}
+				return nil
+			}})
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+
+			results := executeSyncTasks(context.Background(), client, tasks, lagoonsync.SyncRequest{SourceEnvironment: "main", ServiceName: "cli"}, tt.parallel)
+
+			if len(results) != len(tasks) {
+				t.Fatalf("executeSyncTasks() returned %v results, want %v", len(results), len(tasks))
+			}
+			for i, result := range results {
+				if result.Task.Label != tasks[i].Label {
+					t.Errorf("executeSyncTasks() result %v is for %v, want %v", i, result.Task.Label, tasks[i].Label)
+				}
+			}
+			if !results[0].Success || !results[1].Success || results[2].Success || results[3].Success {
+				t.Errorf("executeSyncTasks() unexpected outcomes: %+v", results)
+			}
+			if results[0].Duration == "" || results[2].Duration == "" {
+				t.Errorf("executeSyncTasks() didn't record durations: %+v", results)
+			}
+
+			wantMaxRunning := tt.parallel
+			if wantMaxRunning > 3 {
+				wantMaxRunning = 3 // only three of the tasks can actually run
+			}
+			if maxRunning > wantMaxRunning {
+				t.Errorf("executeSyncTasks() ran %v tasks at once, want at most %v", maxRunning, wantMaxRunning)
+			}
+			if len(transferResources) != 3 {
+				t.Errorf("executeSyncTasks() tasks shared transfer resources: %v", transferResources)
+			}
+		})
+	}
+}
+
+func Test_discoverSyncTasks(t *testing.T) {
+	services := map[string]utils.Service{
+		"cli":      {Name: "cli", Type: "cli-persistent", Volumes: map[string]string{"files": "/app/files"}},
+		"mariadb":  {Name: "mariadb", Type: "mariadb-single"},
+		"sessions": {Name: "sessions", Type: "redis-persistent"},
+		"solr":     {Name: "solr", Type: "solr"},
+	}
+
+	tasks, err := discoverSyncTasks(services, services["cli"], false, false)
+	if err != nil {
+		t.Fatalf("discoverSyncTasks() error = %v", err)
+	}
+	var types []string
+	for _, task := range tasks {
+		types = append(types, task.Type+":"+task.Service.Name)
+	}
+	want := []string{"mariadb:mariadb", "redis:sessions", "files:cli"}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("discoverSyncTasks() = %v, want %v", types, want)
+	}
+
+	syncers, syncerErrors := createTaskSyncers(tasks, synchers.Environment{EnvironmentName: "main"})
+	if syncerErrors[1] != nil {
+		t.Fatalf("createTaskSyncers() error = %v", syncerErrors[1])
+	}
+	if _, ok := syncers[1].(*synchers.RedisSyncRoot); !ok {
+		t.Errorf("createTaskSyncers() = %T, want a redis syncer", syncers[1])
+	}
+}
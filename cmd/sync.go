@@ -1,11 +1,14 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/uselagoon/lagoon-sync/lagoonsync"
 	synchers "github.com/uselagoon/lagoon-sync/synchers"
 	"github.com/uselagoon/lagoon-sync/utils"
 )
@@ -21,17 +24,24 @@ var SSHPort string
 var SSHKey string
 var SSHVerbose bool
 var SSHSkipAgent bool
+var SSHHostKeyPolicy string
+var SSHKnownHostsFile string
 var CmdSSHKey string
 var noCliInteraction bool
 var dryRun bool
+var streamTransfer bool
 var verboseSSH bool
 var RsyncArguments string
 var runSyncProcess synchers.RunSyncProcessFunctionType
 var skipSourceCleanup bool
 var skipTargetCleanup bool
+var keepFailedFiles bool
 var skipTargetImport bool
 var localTransferResourceName string
 var namedTransferResource string
+var syncTables []string
+var backupTarget bool
+var deleteRemovedFiles bool
 
 var APIEndpoint string
 var useSshPortal bool // This is our feature flag for now. With the major version, we change the ssh config details for lagoon-sync files
@@ -52,12 +62,10 @@ func syncCommandRun(cmd *cobra.Command, args []string) {
 	// 3. a reference to a custom syncer, also defined in the config file.
 	SyncerType := args[0]
 
-	viper.Set("syncer-type", args[0])
-
 	// Load configuration
 	configRoot, err := loadConfigRoot()
 	if err != nil {
-		utils.LogFatalError(fmt.Sprintf("Failed to load configuration: %v", err), nil)
+		exitWithError(fmt.Errorf("Failed to load configuration: %w", err))
 	}
 	if viper.ConfigFileUsed() == "" {
 		utils.LogWarning("No configuration has been given/found for syncer: ", SyncerType)
@@ -66,22 +74,23 @@ func syncCommandRun(cmd *cobra.Command, args []string) {
 	// Resolve project name from multiple sources
 	ProjectName = resolveProjectName(ProjectName, configRoot)
 
-	// Set service default to 'cli'
-	if ServiceName == "" {
-		ServiceName = getServiceName(SyncerType)
+	if ProjectName == "" {
+		utils.LogFatalError("No Project name given", nil)
 	}
 
-	// Build source and target environments
-	sourceEnvironment, targetEnvironment := buildEnvironments(ProjectName, ServiceName, sourceEnvironmentName, targetEnvironmentName)
+	// Build SSH options from config, env vars, and flags
+	sshOptions := buildSSHOptions(configRoot, SSHHost, SSHPort, SSHKey, SSHVerbose, SSHSkipAgent, RsyncArguments, SSHHostKeyPolicy, SSHKnownHostsFile)
+	utils.LogDebugInfo("Config that is used for SSH", sshOptions)
 
-	// Resolve the appropriate syncer
-	lagoonSyncer, err := resolveSyncer(SyncerType, configRoot)
+	client, err := newSyncClient(configRoot, sshOptions)
 	if err != nil {
-		utils.LogFatalError(err.Error(), nil)
+		exitWithError(err)
 	}
 
-	if ProjectName == "" {
-		utils.LogFatalError("No Project name given", nil)
+	sourceEnvironment := synchers.Environment{ProjectName: ProjectName, EnvironmentName: sourceEnvironmentName}
+	targetEnvironment := synchers.Environment{ProjectName: ProjectName, EnvironmentName: targetEnvironmentName}
+	if targetEnvironment.EnvironmentName == "" {
+		targetEnvironment.EnvironmentName = synchers.LOCAL_ENVIRONMENT_NAME
 	}
 
 	if !noCliInteraction {
@@ -100,46 +109,31 @@ func syncCommandRun(cmd *cobra.Command, args []string) {
 		}
 	}
 
-	// Build SSH options from config, env vars, and flags
-	sshOptions := buildSSHOptions(configRoot, SSHHost, SSHPort, SSHKey, SSHVerbose, SSHSkipAgent, RsyncArguments)
-
-	// Build SSH option wrapper with optional SSH portal integration
-	sshOptionWrapper, err := buildSSHOptionWrapper(ProjectName, sshOptions, configRoot, APIEndpoint, useSshPortal)
-	if err != nil {
-		utils.LogFatalError(fmt.Sprintf("Failed to configure SSH options: %v", err), nil)
-	}
-
-	// let's update the named transfer resource if it is set
-	if namedTransferResource != "" {
-		err = lagoonSyncer.SetTransferResource(namedTransferResource)
-		if err != nil {
-			utils.LogFatalError(err.Error(), nil)
-		}
-	}
-
-	utils.LogDebugInfo("Config that is used for SSH", sshOptions)
-
-	// Add assertion - we no longer support Remote to Remote syncs
-	if sourceEnvironment.EnvironmentName != synchers.LOCAL_ENVIRONMENT_NAME && targetEnvironment.EnvironmentName != synchers.LOCAL_ENVIRONMENT_NAME {
-		utils.LogFatalError("Remote to Remote transfers are not supported", nil)
-	}
-
-	err = runSyncProcess(synchers.RunSyncProcessFunctionTypeArguments{
-		SourceEnvironment: sourceEnvironment,
-		TargetEnvironment: targetEnvironment,
-		LagoonSyncer:      lagoonSyncer,
-		SyncerType:        SyncerType,
-		DryRun:            dryRun,
-		//SshOptions:           sshOptions,
-		SshOptionWrapper:     sshOptionWrapper,
-		SkipTargetCleanup:    skipTargetCleanup,
+	started := time.Now()
+	ctx, stop := interruptContext()
+	result, err := client.Sync(ctx, lagoonsync.SyncRequest{
+		SyncerType:           SyncerType,
+		ProjectName:          ProjectName,
+		SourceEnvironment:    sourceEnvironment.EnvironmentName,
+		TargetEnvironment:    targetEnvironment.EnvironmentName,
+		ServiceName:          ServiceName,
+		TransferResourceName: namedTransferResource,
+		Tables:               syncTables,
+		DryRun:               dryRun,
+		Stream:               streamTransfer,
 		SkipSourceCleanup:    skipSourceCleanup,
+		SkipTargetCleanup:    skipTargetCleanup,
 		SkipTargetImport:     skipTargetImport,
-		TransferResourceName: namedTransferResource,
+		BackupTarget:         backupTarget,
+		Delete:               deleteRemovedFiles,
+		KeepFailedFiles:      keepFailedFiles,
 	})
+	stop()
+	client.Close()
+	logSyncSummary(started, []syncSummaryEntry{{SyncReport: result.Report}})
 
 	if err != nil {
-		utils.LogFatalError("There was an error running the sync process:", err)
+		exitWithError(fmt.Errorf("There was an error running the sync process: %w", err))
 	}
 
 	if !dryRun {
@@ -147,6 +141,19 @@ func syncCommandRun(cmd *cobra.Command, args []string) {
 	}
 }
 
+// confirmDeletions asks before a sync removes files from the target, once the files have been listed. Passing
+// --no-interaction along with --delete removes them without asking.
+func confirmDeletions(target synchers.Environment, files []string) error {
+	if noCliInteraction {
+		return nil
+	}
+	confirmed, err := confirmPrompt(fmt.Sprintf("Remove these %d files from %s", len(files), target.EnvironmentName))
+	if err != nil || !confirmed {
+		return errors.New("removing the files was cancelled")
+	}
+	return nil
+}
+
 func init() {
 	rootCmd.AddCommand(syncCmd)
 	syncCmd.PersistentFlags().StringVarP(&ProjectName, "project-name", "p", "", "The Lagoon project name of the remote system")
@@ -160,13 +167,21 @@ func init() {
 	syncCmd.PersistentFlags().StringVarP(&SSHKey, "ssh-key", "i", "", "Specify path to a specific SSH key to use for authentication")
 	syncCmd.PersistentFlags().BoolVar(&SSHSkipAgent, "ssh-skip-agent", false, "Do not attempt to use an ssh-agent for key management")
 	syncCmd.PersistentFlags().BoolVar(&SSHVerbose, "verbose", false, "Run ssh commands in verbose (useful for debugging)")
+	syncCmd.PersistentFlags().StringVar(&SSHHostKeyPolicy, "ssh-host-key-policy", "", "How to verify ssh host keys - 'strict', 'accept-new' or 'insecure' (defaults to 'accept-new')")
+	syncCmd.PersistentFlags().StringVar(&SSHKnownHostsFile, "ssh-known-hosts", "", "Path to the known_hosts file used to verify ssh host keys (defaults to ~/.ssh/known_hosts)")
 	syncCmd.PersistentFlags().BoolVarP(&noCliInteraction, "no-interaction", "y", false, "Disallow interaction")
 	syncCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "Don't run the commands, just preview what will be run")
+	syncCmd.PersistentFlags().BoolVar(&streamTransfer, "stream", false, "Pipe the database export straight into the import on the target, without writing dump files (database syncers only)")
 	syncCmd.PersistentFlags().StringVarP(&RsyncArguments, "rsync-args", "r", "--omit-dir-times --no-perms --no-group --no-owner --chmod=ugo=rwX --recursive --compress", "Pass through arguments to change the behaviour of rsync")
 	syncCmd.PersistentFlags().BoolVar(&skipSourceCleanup, "skip-source-cleanup", false, "Don't clean up any of the files generated on the source")
 	syncCmd.PersistentFlags().BoolVar(&skipTargetCleanup, "skip-target-cleanup", false, "Don't clean up any of the files generated on the target")
+	syncCmd.PersistentFlags().BoolVar(&keepFailedFiles, "keep-files-on-failure", false, "Keep the files generated by a sync that fails, so that 'lagoon-sync resume' can pick up from them rather than starting over")
 	syncCmd.PersistentFlags().BoolVar(&skipTargetImport, "skip-target-import", false, "This will skip the import step on the target, in combination with 'no-target-cleanup' this essentially produces a resource dump")
 	syncCmd.PersistentFlags().StringVarP(&namedTransferResource, "transfer-resource-name", "", "", "The name of the temporary file to be used to transfer generated resources (db dumps, etc) - random /tmp file otherwise")
+	syncCmd.PersistentFlags().BoolVar(&backupTarget, "backup-target", false, "Back up the target database before importing over it, so that the sync can be undone with 'lagoon-sync rollback' (database syncers only)")
+	syncCmd.PersistentFlags().BoolVar(&deleteRemovedFiles, "delete", false, "Remove files from the target that are no longer on the source, after listing them and asking to confirm (files syncers only)")
+	syncCmd.PersistentFlags().BoolVar(&overwriteProtectedTarget, overwriteProtectedTargetFlag, false, "Allow overwriting a protected environment, which also has to be confirmed by typing out its project and environment name")
+	syncCmd.PersistentFlags().StringSliceVar(&syncTables, "tables", nil, "Only sync these tables, by name, glob pattern ('cache_*') or regular expression ('/^cache_/') - overrides the tables in the config (mariadb only)")
 	syncCmd.PersistentFlags().StringVarP(&APIEndpoint, "api", "A", "https://api.lagoon.amazeeio.cloud/graphql", "Specify your lagoon api endpoint - required for ssh-portal integration")
 	syncCmd.PersistentFlags().BoolVar(&useSshPortal, "use-ssh-portal", false, "This will use the SSH Portal rather than the (soon to be removed) SSH Service on Lagoon core. Will become default in a future release.")
 	// By default, we hook up the syncers.RunSyncProcess function to the runSyncProcess variable
@@ -1,66 +1,33 @@
 package cmd
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
 
 	"github.com/manifoldco/promptui"
 	"github.com/spf13/viper"
+	"github.com/uselagoon/lagoon-sync/lagoonsync"
 	synchers "github.com/uselagoon/lagoon-sync/synchers"
 	"github.com/uselagoon/lagoon-sync/utils"
 )
 
-// getServiceName will return the name of the service in which we run the commands themselves. This is typically
-// the cli pod in a project
-// TODO: this needs to be expanded to be dynamic in the future.
-func getServiceName(SyncerType string) string {
-	if SyncerType == "mongodb" {
-		return SyncerType
-	}
-	return "cli"
-}
-
-func getEnvironmentSshDetails(conn utils.ApiConn, projectName string, defaultSshOptions synchers.SSHOptions) (synchers.SSHOptions, map[string]synchers.SSHOptions, error) {
-	environments, err := conn.GetProjectEnvironmentDeployTargets(projectName)
-	retMap := map[string]synchers.SSHOptions{}
-
-	if err != nil {
-		return synchers.SSHOptions{}, retMap, err
-	}
-
-	var defaultOptions synchers.SSHOptions
-	defaultSet := false
-
-	for _, environment := range *environments {
-		retMap[environment.Name] = synchers.SSHOptions{
-			Host:       environment.DeployTarget.SSHHost,
-			Port:       environment.DeployTarget.SSHPort,
-			Verbose:    defaultSshOptions.Verbose,
-			PrivateKey: "",
-			SkipAgent:  defaultSshOptions.SkipAgent,
-			RsyncArgs:  defaultSshOptions.RsyncArgs,
-		}
-
-		if environment.EnvironmentType == "production" {
-			defaultOptions = retMap[environment.Name]
-			defaultSet = true
-		}
-	}
-	if defaultSet == false {
-		return synchers.SSHOptions{}, retMap, errors.New("COULD NOT FIND DEFAULT OPTION SET")
-	}
-	return defaultOptions, retMap, nil
-}
-
 func confirmPrompt(message string) (bool, error) {
 	prompt := promptui.Prompt{
 		Label:     message,
 		IsConfirm: true,
 	}
+	// Keep stdout for the events when the output is JSON
+	if utils.IsJSONOutput() {
+		prompt.Stdout = os.Stderr
+	}
 	result, err := prompt.Run()
 	if result == "y" {
 		return true, err
@@ -68,25 +35,81 @@ func confirmPrompt(message string) (bool, error) {
 	return false, err
 }
 
-// loadConfigRoot loads and unmarshals the lagoon config file if present
-func loadConfigRoot() (synchers.SyncherConfigRoot, error) {
-	var configRoot synchers.SyncherConfigRoot
-
-	if viper.ConfigFileUsed() == "" {
-		return configRoot, nil
+// printText prints to stdout, unless the output is JSON - in which case stdout is kept for the events
+func printText(format string, a ...interface{}) {
+	if !utils.IsJSONOutput() {
+		fmt.Printf(format, a...)
 	}
+}
 
-	lagoonConfigBytestream, err := LoadLagoonConfig(viper.ConfigFileUsed())
-	if err != nil {
-		return configRoot, fmt.Errorf("couldn't load lagoon config file: %w", err)
+// syncSummaryEntry is a single sync in the summary, labelled when several syncs are run together
+type syncSummaryEntry struct {
+	Label string `json:"label,omitempty"`
+	*synchers.SyncReport
+}
+
+// syncSummary is the event that ends a run's JSON output
+type syncSummary struct {
+	Status     string             `json:"status"`
+	DurationMs int64              `json:"durationMs"`
+	Syncs      []syncSummaryEntry `json:"syncs"`
+}
+
+// logSyncSummary logs the summary of a run. The run has failed if any sync failed, and was cancelled if any sync
+// was cancelled without the others failing.
+func logSyncSummary(started time.Time, entries []syncSummaryEntry) {
+	summary := syncSummary{
+		Status:     synchers.SyncStatusSucceeded,
+		DurationMs: time.Since(started).Milliseconds(),
+		Syncs:      entries,
+	}
+	for _, entry := range entries {
+		switch {
+		case entry.SyncReport == nil || entry.Status == synchers.SyncStatusFailed:
+			summary.Status = synchers.SyncStatusFailed
+		case entry.Status == synchers.SyncStatusCancelled && summary.Status != synchers.SyncStatusFailed:
+			summary.Status = synchers.SyncStatusCancelled
+		}
 	}
+	utils.LogEvent("summary", summary)
+}
 
-	loadedConfigRoot, err := synchers.UnmarshallLagoonYamlToLagoonSyncStructure(lagoonConfigBytestream)
-	if err != nil {
-		return configRoot, fmt.Errorf("issue unmarshalling sync configuration from %v: %w", viper.ConfigFileUsed(), err)
+// interruptContext returns a context that is cancelled on the first SIGINT or SIGTERM, which stops the sync and
+// cleans up whatever it has generated so far. A second signal exits straight away.
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-signals:
+			utils.LogWarning("Interrupted - stopping and cleaning up, interrupt again to exit immediately", nil)
+			cancel()
+		case <-stopped:
+			return
+		}
+		select {
+		case <-signals:
+			utils.LogFatalError("Interrupted again, exiting without cleaning up", nil)
+		case <-stopped:
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		close(stopped)
+		cancel()
 	}
+}
 
-	return loadedConfigRoot, nil
+// loadConfigRoot loads and unmarshals the lagoon config file if present
+func loadConfigRoot() (synchers.SyncherConfigRoot, error) {
+	if viper.ConfigFileUsed() == "" {
+		return synchers.SyncherConfigRoot{}, nil
+	}
+	return lagoonsync.LoadConfig(viper.ConfigFileUsed())
 }
 
 // resolveProjectName determines the project name from flags, env vars, or config
@@ -108,44 +131,23 @@ func resolveProjectName(flagValue string, configRoot synchers.SyncherConfigRoot)
 	return ""
 }
 
-// buildEnvironments creates source and target Environment structs
-func buildEnvironments(projectName, serviceName, sourceEnvName, targetEnvName string) (synchers.Environment, synchers.Environment) {
-	sourceEnvironment := synchers.Environment{
-		ProjectName:     projectName,
-		EnvironmentName: sourceEnvName,
-		ServiceName:     serviceName,
-	}
-
-	// Default target to local if not specified
-	if targetEnvName == "" {
-		targetEnvName = synchers.LOCAL_ENVIRONMENT_NAME
-	}
-
-	targetEnvironment := synchers.Environment{
-		ProjectName:     projectName,
-		EnvironmentName: targetEnvName,
-		ServiceName:     serviceName,
+// absoluteConfigFilePath returns the full path to the config file in use, so it can be found again from another
+// working directory (when resuming a sync, for instance)
+func absoluteConfigFilePath() string {
+	if viper.ConfigFileUsed() == "" {
+		return ""
 	}
-
-	return sourceEnvironment, targetEnvironment
-}
-
-// resolveSyncer gets the appropriate syncer from the config, falling back to custom syncer
-func resolveSyncer(syncerType string, configRoot synchers.SyncherConfigRoot) (synchers.Syncer, error) {
-	lagoonSyncer, err := synchers.GetSyncerForTypeFromConfigRoot(syncerType, configRoot)
+	configFile, err := filepath.Abs(viper.ConfigFileUsed())
 	if err != nil {
-		// Fall back to custom syncer
-		lagoonSyncer, err = synchers.GetCustomSync(configRoot, syncerType)
-		if err != nil {
-			return nil, fmt.Errorf("could not find syncer for type %s: %w", syncerType, err)
-		}
+		return viper.ConfigFileUsed()
 	}
-	return lagoonSyncer, nil
+	return configFile
 }
 
 // buildSSHOptions constructs SSH options from config, env vars, and flags
 // Priority for host/port: flag (if not default) -> env var -> config -> flag default
-func buildSSHOptions(configRoot synchers.SyncherConfigRoot, flagHost, flagPort, flagKey string, flagVerbose, flagSkipAgent bool, rsyncArgs string) synchers.SSHOptions {
+// Host key policy and known_hosts file: flag -> config, pinned fingerprints can only be set in config
+func buildSSHOptions(configRoot synchers.SyncherConfigRoot, flagHost, flagPort, flagKey string, flagVerbose, flagSkipAgent bool, rsyncArgs string, flagHostKeyPolicy, flagKnownHostsFile string) synchers.SSHOptions {
 	// Decode SSH config from file if present
 	sshConfig := synchers.SSHOptions{}
 	if configRoot.LagoonSync["ssh"] != nil {
@@ -186,51 +188,56 @@ func buildSSHOptions(configRoot synchers.SyncherConfigRoot, flagHost, flagPort,
 		sshVerbose = sshConfig.Verbose
 	}
 
-	return synchers.SSHOptions{
-		Host:       sshHost,
-		PrivateKey: sshKey,
-		Port:       sshPort,
-		Verbose:    sshVerbose,
-		RsyncArgs:  rsyncArgs,
-		SkipAgent:  flagSkipAgent,
+	hostKeyPolicy := sshConfig.HostKeyPolicy
+	if flagHostKeyPolicy != "" {
+		hostKeyPolicy = flagHostKeyPolicy
 	}
-}
-
-// buildSSHOptionWrapper creates and configures an SSH option wrapper, optionally with SSH portal integration
-func buildSSHOptionWrapper(projectName string, baseOptions synchers.SSHOptions, configRoot synchers.SyncherConfigRoot, apiEndpoint string, usePortal bool) (*synchers.SSHOptionWrapper, error) {
-	sshOptionWrapper := synchers.NewSshOptionWrapper(projectName, baseOptions) 
 
-	if !usePortal {
-		return sshOptionWrapper, nil
+	knownHostsFile := sshConfig.KnownHostsFile
+	if flagKnownHostsFile != "" {
+		knownHostsFile = flagKnownHostsFile
 	}
 
-	// Resolve API endpoint
-	apiEndPoint := apiEndpoint
-	if apiEndpoint == "https://api.lagoon.amazeeio.cloud/graphql" { // using default, check for overrides
-		envApiHost, exists := os.LookupEnv("LAGOON_CONFIG_API_HOST")
-		if exists {
-			apiEndPoint = envApiHost + "/graphql"
-		} else if configRoot.Api != "" {
-			apiEndPoint = configRoot.Api
-		}
+	return synchers.SSHOptions{
+		Host:                sshHost,
+		PrivateKey:          sshKey,
+		Port:                sshPort,
+		Verbose:             sshVerbose,
+		RsyncArgs:           rsyncArgs,
+		SkipAgent:           flagSkipAgent,
+		HostKeyPolicy:       hostKeyPolicy,
+		KnownHostsFile:      knownHostsFile,
+		HostKeyFingerprints: sshConfig.HostKeyFingerprints,
 	}
+}
 
-	// Initialize API connection and fetch environment SSH details
-	apiConn := utils.ApiConn{}
-	err := apiConn.Init(apiEndPoint, baseOptions.PrivateKey, baseOptions.Host, baseOptions.Port)
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize API connection: %w", err)
+// resolveAPIEndpoint picks the Lagoon API used with the ssh portal
+// Priority: flag (if not default) -> LAGOON_CONFIG_API_HOST env var -> config -> flag default
+func resolveAPIEndpoint(flagValue string, configRoot synchers.SyncherConfigRoot) string {
+	if flagValue != lagoonsync.DefaultAPIEndpoint {
+		return flagValue
 	}
-
-	defaultSshOption, sshopts, err := getEnvironmentSshDetails(apiConn, projectName, baseOptions)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get environment SSH details: %w", err)
+	if envApiHost, exists := os.LookupEnv("LAGOON_CONFIG_API_HOST"); exists {
+		return envApiHost + "/graphql"
 	}
-
-	sshOptionWrapper.SetDefaultSshOptions(defaultSshOption)
-	for envName, option := range sshopts {
-		sshOptionWrapper.AddSsshOptionForEnvironment(envName, option)
+	if configRoot.Api != "" {
+		return configRoot.Api
 	}
+	return flagValue
+}
 
-	return sshOptionWrapper, nil
+// newSyncClient creates the client that runs syncs, configured from the config file and flags
+func newSyncClient(configRoot synchers.SyncherConfigRoot, sshOptions synchers.SSHOptions) (*lagoonsync.Client, error) {
+	return lagoonsync.NewClient(lagoonsync.Config{
+		SyncConfig:             configRoot,
+		ConfigFile:             absoluteConfigFilePath(),
+		SSH:                    sshOptions,
+		UseSSHPortal:           useSshPortal,
+		APIEndpoint:            resolveAPIEndpoint(APIEndpoint, configRoot),
+		JournalDirectory:       synchers.GetJournalDirectory(),
+		ManifestDirectory:      synchers.GetManifestDirectory(),
+		RunSyncProcess:         runSyncProcess,
+		ConfirmProtectedTarget: confirmProtectedTarget,
+		ConfirmDeletions:       confirmDeletions,
+	})
 }
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"testing"
@@ -10,6 +11,9 @@ import (
 )
 
 func Test_syncCommandRun(t *testing.T) {
+	// Keep the journals of these runs out of the real journal directory
+	t.Setenv("LAGOON_SYNC_JOURNAL_DIR", t.TempDir())
+
 	type args struct {
 		cmd  *cobra.Command
 		args []string
@@ -30,7 +34,7 @@ func Test_syncCommandRun(t *testing.T) {
 					"mariadb",
 				},
 			},
-			runSyncProcess: func(args synchers.RunSyncProcessFunctionTypeArguments) error {
+			runSyncProcess: func(ctx context.Context, args synchers.RunSyncProcessFunctionTypeArguments) error {
 				sshOptions := args.SshOptionWrapper.Default
 				if sshOptions.Port != "32222" {
 					return errors.New(fmt.Sprintf("Expecting ssh port 32222 - found: %v", sshOptions.Port))
@@ -53,7 +57,7 @@ func Test_syncCommandRun(t *testing.T) {
 					"mariadb",
 				},
 			},
-			runSyncProcess: func(args synchers.RunSyncProcessFunctionTypeArguments) error {
+			runSyncProcess: func(ctx context.Context, args synchers.RunSyncProcessFunctionTypeArguments) error {
 				sshOptions := args.SshOptionWrapper.Default
 				if sshOptions.Port != "777" {
 					return errors.New(fmt.Sprintf("Expecting ssh port 777 - found: %v", sshOptions.Port))
@@ -67,3 +67,9 @@ func ProcessServicesFromCompose(project *types.Project) []LagoonServiceDefinitio
 	}
 	return serviceDefinitions
 }
+
+// isSolrServiceType reports whether a lagoon.type is one of the solr types, which come in several flavours -
+// solr, solr-persistent, and the older solr-php-persistent among them
+func isSolrServiceType(serviceType string) bool {
+	return strings.HasPrefix(serviceType, "solr")
+}
@@ -52,6 +52,23 @@ func TestProcessServicesFromCompose(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "Solr services",
+			args: args{ComposeFile: "./test-assets/solr-docker-compose.yml"},
+			want: []LagoonServiceDefinition{
+				{
+					ServiceName: "solr",
+					ServiceType: "solr",
+					image:       "uselagoon/solr-8-drupal:latest",
+				},
+				{
+					ServiceName: "search",
+					ServiceType: "solr-persistent",
+					image:       "uselagoon/solr-9-drupal:latest",
+				},
+			},
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
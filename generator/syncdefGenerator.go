@@ -14,6 +14,8 @@ type configTemplateData struct {
 	Mariadb    []synchers.MariadbSyncRoot
 	Filesystem []synchers.FilesSyncRoot
 	Postgres   []synchers.PostgresSyncRoot
+	Redis      []synchers.RedisSyncRoot
+	Solr       []synchers.SolrSyncRoot
 	Ssh        string
 	Api        string
 }
@@ -23,6 +25,8 @@ func BuildConfigStanzaFromServices(services []LagoonServiceDefinition) (string,
 	mariadbServices := []synchers.MariadbSyncRoot{}
 	filesystemServices := []synchers.FilesSyncRoot{}
 	postgresServices := []synchers.PostgresSyncRoot{}
+	redisServices := []synchers.RedisSyncRoot{}
+	solrServices := []synchers.SolrSyncRoot{}
 	serviceCount := 0
 	// we go through the service definitions and try to generate text for them
 	for _, v := range services {
@@ -59,6 +63,22 @@ func BuildConfigStanzaFromServices(services []LagoonServiceDefinition) (string,
 			}
 			serviceCount += 1
 			postgresServices = append(postgresServices, sr)
+		case "redis", "redis-persistent":
+			sr, err := GenerateRedisSyncRootFromService(v)
+			if err != nil {
+				return "", err
+			}
+			serviceCount += 1
+			redisServices = append(redisServices, sr)
+		default:
+			if isSolrServiceType(v.ServiceType) {
+				sr, err := GenerateSolrSyncRootFromService(v)
+				if err != nil {
+					return "", err
+				}
+				serviceCount += 1
+				solrServices = append(solrServices, sr)
+			}
 		}
 	}
 
@@ -70,6 +90,8 @@ func BuildConfigStanzaFromServices(services []LagoonServiceDefinition) (string,
 		Mariadb:    mariadbServices,
 		Filesystem: filesystemServices,
 		Postgres:   postgresServices,
+		Redis:      redisServices,
+		Solr:       solrServices,
 	}
 
 	retString, err := generateSyncStanza(templateData)
@@ -124,6 +146,20 @@ lagoon-sync:
       port:     "{{ .Config.DbPort }}"
       database: "{{ .Config.DbDatabase }}"
 {{- end }}
+{{- range .Redis }}
+  {{ .ServiceName }}:
+    type: {{ .Type }}
+    config:
+      hostname: "{{ .Config.Hostname }}"
+      port:     "{{ .Config.Port }}"
+{{- end }}
+{{- range .Solr }}
+  {{ .ServiceName }}:
+    type: {{ .Type }}
+    config:
+      url:  "{{ .Config.Url }}"
+      core: "{{ .Config.Core }}"
+{{- end }}
 {{- range .Filesystem }}
   {{ .ServiceName }}:
     type: {{ .Type }}
@@ -211,6 +247,38 @@ func GeneratePgqlSyncRootFromService(definition LagoonServiceDefinition) (synche
 	return syncRoot, nil
 }
 
+func GenerateRedisSyncRootFromService(definition LagoonServiceDefinition) (synchers.RedisSyncRoot, error) {
+	serviceNameUppercase := strings.ToUpper(definition.ServiceName)
+
+	syncRoot := synchers.RedisSyncRoot{
+		Type:        synchers.RedisSyncPlugin{}.GetPluginId(),
+		ServiceName: definition.ServiceName,
+		Config:      synchers.BaseRedisSync{},
+	}
+	syncRoot.Config.SetDefaults()
+
+	syncRoot.Config.Hostname = fmt.Sprintf("${%v_HOST:-%v}", serviceNameUppercase, definition.ServiceName)
+	syncRoot.Config.Port = fmt.Sprintf("${%v_PORT:-6379}", serviceNameUppercase)
+
+	return syncRoot, nil
+}
+
+func GenerateSolrSyncRootFromService(definition LagoonServiceDefinition) (synchers.SolrSyncRoot, error) {
+	serviceNameUppercase := strings.ToUpper(definition.ServiceName)
+
+	syncRoot := synchers.SolrSyncRoot{
+		Type:        synchers.SolrSyncPlugin{}.GetPluginId(),
+		ServiceName: definition.ServiceName,
+		Config:      synchers.BaseSolrSync{},
+	}
+	syncRoot.Config.SetDefaults()
+
+	// the core defaults to the "drupal" core that the solr-drupal images come with
+	syncRoot.Config.Url = fmt.Sprintf("http://${%v_HOST:-%v}:${%v_PORT:-8983}/solr", serviceNameUppercase, definition.ServiceName, serviceNameUppercase)
+
+	return syncRoot, nil
+}
+
 func GenerateFilesSyncRootFromPersistentService(definition LagoonServiceDefinition) (synchers.FilesSyncRoot, error) {
 	syncRoot := synchers.FilesSyncRoot{
 		ServiceName: definition.ServiceName,
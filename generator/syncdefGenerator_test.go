@@ -161,6 +161,41 @@ func TestBuildConfigStanzaFromServices(t *testing.T) {
 			},
 			},
 		},
+		{
+			name: "Redis instances",
+			shouldContain: []string{
+				"type: " + synchers.RedisSyncPlugin{}.GetPluginId(),
+				`hostname: "${CACHE_HOST:-cache}"`,
+				`port:     "${SESSIONS_PORT:-6379}"`,
+			},
+			args: args{services: []LagoonServiceDefinition{
+				{
+					ServiceName: "cache",
+					ServiceType: "redis",
+				},
+				{
+					ServiceName: "sessions",
+					ServiceType: "redis-persistent",
+				},
+			},
+			},
+		},
+		{
+			name: "Solr instances",
+			shouldContain: []string{
+				"type: " + synchers.SolrSyncPlugin{}.GetPluginId(),
+				`url:  "http://${SEARCH_HOST:-search}:${SEARCH_PORT:-8983}/solr"`,
+				`core: "drupal"`,
+			},
+			args: args{services: []LagoonServiceDefinition{
+				{
+					ServiceName: "search",
+					ServiceType: "solr-persistent",
+					image:       "uselagoon/solr-9-drupal:latest",
+				},
+			},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
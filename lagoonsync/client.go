@@ -0,0 +1,155 @@
+// Package lagoonsync runs lagoon-sync's syncs from Go, for tools that want to drive them without shelling out to
+// the lagoon-sync binary. Everything a sync needs is passed in explicitly - nothing is read from command line flags,
+// viper or package globals.
+//
+//	config, err := lagoonsync.LoadConfig(".lagoon.yml")
+//	...
+//	client, err := lagoonsync.NewClient(lagoonsync.Config{SyncConfig: config, SSH: synchers.SSHOptions{Host: "ssh.lagoon.amazeeio.cloud", Port: "32222"}})
+//	...
+//	defer client.Close()
+//	result, err := client.Sync(ctx, lagoonsync.SyncRequest{SyncerType: "mariadb", ProjectName: "my-project", SourceEnvironment: "main"})
+package lagoonsync
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/uselagoon/lagoon-sync/synchers"
+	"github.com/uselagoon/lagoon-sync/utils"
+)
+
+// DefaultAPIEndpoint is the Lagoon API used to look up ssh details when Config.UseSSHPortal is set
+const DefaultAPIEndpoint = "https://api.lagoon.amazeeio.cloud/graphql"
+
+// Config is what a Client needs to run syncs
+type Config struct {
+	// SyncConfig is the lagoon-sync configuration, usually loaded with LoadConfig. The zero value uses the defaults
+	// of each syncer.
+	SyncConfig synchers.SyncherConfigRoot
+	// ConfigFile is the file SyncConfig was loaded from. It's recorded in each sync's journal, so that the sync can
+	// be resumed with the same configuration.
+	ConfigFile string
+	// SSH are the ssh options used to reach every environment, unless UseSSHPortal looks them up per environment
+	SSH synchers.SSHOptions
+	// UseSSHPortal looks up the ssh host and port of each of the project's environments from the Lagoon API
+	UseSSHPortal bool
+	// APIEndpoint is the Lagoon API used with UseSSHPortal, DefaultAPIEndpoint if empty
+	APIEndpoint string
+	// JournalDirectory is where syncs are journaled so that they can be resumed - see synchers.GetJournalDirectory.
+	// Syncs aren't journaled if it's empty.
+	JournalDirectory string
+	// ManifestDirectory is where incremental files syncs keep the manifests of the files they've synced - see
+	// synchers.GetManifestDirectory. Without it, incremental files syncs transfer every file each time.
+	ManifestDirectory string
+	// Logger logs the progress of every sync that doesn't have a logger of its own. A nil Logger logs without a
+	// prefix.
+	Logger *utils.Logger
+	// RunSyncProcess runs each sync, synchers.RunSyncProcess if nil
+	RunSyncProcess synchers.RunSyncProcessFunctionType
+	// ConfirmProtectedTarget is asked before a sync, resume or rollback overwrites a protected environment, with
+	// the reason it's protected. Protected environments are only overwritten if it's set and returns nil - see
+	// synchers.ProtectedEnvironments.
+	ConfirmProtectedTarget func(target synchers.Environment, reason string) error
+	// LookupEnvironmentTypes looks up the Lagoon type of each of a project's environments, which protected
+	// environments are matched on, when the ssh portal hasn't already looked them up. They're looked up from the
+	// Lagoon API with a token fetched over ssh if it's nil.
+	LookupEnvironmentTypes func(projectName string, sshOptions synchers.SSHOptions, apiEndpoint string) (map[string]string, error)
+	// ConfirmDeletions is asked before a sync run with SyncRequest.Delete removes files from the target, with the
+	// files it would remove. They're removed without asking if it's nil.
+	ConfirmDeletions func(target synchers.Environment, files []string) error
+}
+
+// Client runs syncs with the configuration it was created with. It's safe to run several syncs at once.
+type Client struct {
+	config                Config
+	phasePolicies         synchers.PhasePolicies
+	backupPolicy          synchers.BackupPolicy
+	protectedEnvironments synchers.ProtectedEnvironments
+}
+
+// NewClient checks the configuration and returns a client that syncs with it. Invalid configuration is reported
+// with synchers.ErrConfigInvalid.
+func NewClient(config Config) (*Client, error) {
+	if err := config.SSH.GetHostKeyVerification().Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %w", synchers.ErrConfigInvalid, err)
+	}
+
+	phasePolicies, err := synchers.DecodePhasePolicies(config.SyncConfig.LagoonSync["phases"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid phase policies: %w", err)
+	}
+
+	backupPolicy, err := synchers.DecodeBackupPolicy(config.SyncConfig.LagoonSync["backups"])
+	if err != nil {
+		return nil, err
+	}
+
+	protectedEnvironments, err := synchers.DecodeProtectedEnvironments(config.SyncConfig.LagoonSync["protected-environments"])
+	if err != nil {
+		return nil, err
+	}
+
+	if config.APIEndpoint == "" {
+		config.APIEndpoint = DefaultAPIEndpoint
+	}
+	if config.RunSyncProcess == nil {
+		config.RunSyncProcess = synchers.RunSyncProcess
+	}
+	if config.LookupEnvironmentTypes == nil {
+		config.LookupEnvironmentTypes = lookupEnvironmentTypes
+	}
+
+	return &Client{config: config, phasePolicies: phasePolicies, backupPolicy: backupPolicy, protectedEnvironments: protectedEnvironments}, nil
+}
+
+// Close closes the ssh connections the client's syncs opened. Syncs share their connections, so this is only done
+// once all of them are complete.
+func (c *Client) Close() {
+	utils.CloseSSHConnections()
+}
+
+// LoadConfig loads the lagoon-sync configuration from a .lagoon.yml or lagoon-sync config file
+func LoadConfig(path string) (synchers.SyncherConfigRoot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return synchers.SyncherConfigRoot{}, fmt.Errorf("%w: couldn't load lagoon config file: %w", synchers.ErrConfigInvalid, err)
+	}
+
+	configRoot, err := synchers.UnmarshallLagoonYamlToLagoonSyncStructure(data)
+	if err != nil {
+		return synchers.SyncherConfigRoot{}, fmt.Errorf("%w: issue unmarshalling sync configuration from %v: %w", synchers.ErrConfigInvalid, path, err)
+	}
+	return configRoot, nil
+}
+
+// checkProtectedTarget stops the target being overwritten if it's protected, unless ConfirmProtectedTarget agrees
+// to it. The environment types are those the ssh portal looked up, if it was used - otherwise the target's type is
+// looked up with the ssh options. A remote target whose type can't be found is treated as protected, since it could
+// be production.
+func (c *Client) checkProtectedTarget(protected synchers.ProtectedEnvironments, target synchers.Environment, environmentTypes map[string]string, sshOptions synchers.SSHOptions, apiEndpoint string) error {
+	reason := protected.Protects(target.EnvironmentName, environmentTypes[target.EnvironmentName])
+	if reason == "" && target.EnvironmentName != synchers.LOCAL_ENVIRONMENT_NAME && protected.ProtectsEnvironmentTypes() {
+		var err error
+		if environmentTypes == nil {
+			environmentTypes, err = c.config.LookupEnvironmentTypes(target.ProjectName, sshOptions, apiEndpoint)
+		}
+		switch {
+		case err != nil:
+			reason = fmt.Sprintf("its environment type couldn't be looked up (%v)", err)
+		case environmentTypes[target.EnvironmentName] == "":
+			reason = "its environment type isn't known to the Lagoon API"
+		default:
+			reason = protected.Protects(target.EnvironmentName, environmentTypes[target.EnvironmentName])
+		}
+	}
+	if reason == "" {
+		return nil
+	}
+	if c.config.ConfirmProtectedTarget == nil {
+		return fmt.Errorf("%w: %s is protected, since %s", synchers.ErrProtectedTarget, target.EnvironmentName, reason)
+	}
+	if err := c.config.ConfirmProtectedTarget(target, reason); err != nil {
+		return fmt.Errorf("%w: %s is protected, since %s: %w", synchers.ErrProtectedTarget, target.EnvironmentName, reason, err)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package lagoonsync
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/uselagoon/lagoon-sync/synchers"
+)
+
+// PlanRequest describes a sync to plan
+type PlanRequest struct {
+	SyncRequest
+	// Bandwidth is the transfer speed in bytes a second the transfer time is estimated with,
+	// synchers.DefaultPlanBandwidth if not set
+	Bandwidth int64
+}
+
+// Plan reports what a sync would transfer, and whether both ends of it have the tools and space it needs. Nothing is
+// changed in either environment, so the request's DryRun, Skip and BackupTarget options make no difference to it.
+func (c *Client) Plan(ctx context.Context, request PlanRequest) (*synchers.SyncPlan, error) {
+	logger := request.Logger
+	if logger == nil {
+		logger = c.config.Logger
+	}
+
+	serviceName := request.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName(request.SyncerType)
+	}
+	sourceEnvironment, targetEnvironment := buildEnvironments(request.ProjectName, serviceName, request.SourceEnvironment, request.TargetEnvironment)
+
+	lagoonSyncer := request.Syncer
+	if lagoonSyncer == nil {
+		var err error
+		if lagoonSyncer, err = resolveSyncer(request.SyncerType, c.config.SyncConfig); err != nil {
+			return nil, err
+		}
+		if request.TransferResourceName != "" {
+			if err := lagoonSyncer.SetTransferResource(request.TransferResourceName); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := selectTables(lagoonSyncer, request.SyncerType, request.Tables); err != nil {
+		return nil, err
+	}
+
+	sshOptionWrapper, _, err := buildSSHOptionWrapper(request.ProjectName, c.config.SSH, c.config.UseSSHPortal, c.config.APIEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to configure SSH options: %w", err)
+	}
+
+	return synchers.PlanSync(ctx, synchers.PlanSyncArguments{
+		SourceEnvironment: sourceEnvironment,
+		TargetEnvironment: targetEnvironment,
+		LagoonSyncer:      lagoonSyncer,
+		SyncerType:        request.SyncerType,
+		SshOptionWrapper:  sshOptionWrapper,
+		Bandwidth:         request.Bandwidth,
+		Logger:            logger,
+	})
+}
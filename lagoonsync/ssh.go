@@ -0,0 +1,99 @@
+package lagoonsync
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/uselagoon/lagoon-sync/synchers"
+	"github.com/uselagoon/lagoon-sync/utils"
+)
+
+// buildSSHOptionWrapper creates the ssh options for each of the project's environments. With the ssh portal, they're
+// looked up from the Lagoon API along with the type of each environment - otherwise every environment uses the base
+// options, and no environment types are known.
+func buildSSHOptionWrapper(projectName string, baseOptions synchers.SSHOptions, usePortal bool, apiEndpoint string) (*synchers.SSHOptionWrapper, map[string]string, error) {
+	if err := baseOptions.GetHostKeyVerification().Validate(); err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", synchers.ErrConfigInvalid, err)
+	}
+
+	sshOptionWrapper := synchers.NewSshOptionWrapper(projectName, baseOptions)
+
+	if !usePortal {
+		return sshOptionWrapper, nil, nil
+	}
+
+	apiConn := utils.ApiConn{}
+	err := apiConn.Init(apiEndpoint, baseOptions.PrivateKey, baseOptions.Host, baseOptions.Port, baseOptions.GetHostKeyVerification())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize API connection: %w", err)
+	}
+
+	defaultSshOption, sshopts, environmentTypes, err := getEnvironmentSshDetails(apiConn, projectName, baseOptions)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get environment SSH details: %w", err)
+	}
+
+	sshOptionWrapper.SetDefaultSshOptions(defaultSshOption)
+	for envName, option := range sshopts {
+		sshOptionWrapper.AddSsshOptionForEnvironment(envName, option)
+	}
+
+	return sshOptionWrapper, environmentTypes, nil
+}
+
+// lookupEnvironmentTypes looks up the type of each of the project's environments from the Lagoon API, with a token
+// fetched over ssh. It's how protected environments are found when the ssh portal isn't used.
+func lookupEnvironmentTypes(projectName string, sshOptions synchers.SSHOptions, apiEndpoint string) (map[string]string, error) {
+	apiConn := utils.ApiConn{}
+	if err := apiConn.Init(apiEndpoint, sshOptions.PrivateKey, sshOptions.Host, sshOptions.Port, sshOptions.GetHostKeyVerification()); err != nil {
+		return nil, fmt.Errorf("failed to initialize API connection: %w", err)
+	}
+	environments, err := apiConn.GetProjectEnvironmentDeployTargets(projectName)
+	if err != nil {
+		return nil, err
+	}
+	environmentTypes := map[string]string{}
+	for _, environment := range *environments {
+		environmentTypes[environment.Name] = strings.ToLower(string(environment.EnvironmentType))
+	}
+	return environmentTypes, nil
+}
+
+func getEnvironmentSshDetails(conn utils.ApiConn, projectName string, defaultSshOptions synchers.SSHOptions) (synchers.SSHOptions, map[string]synchers.SSHOptions, map[string]string, error) {
+	environments, err := conn.GetProjectEnvironmentDeployTargets(projectName)
+	retMap := map[string]synchers.SSHOptions{}
+	environmentTypes := map[string]string{}
+
+	if err != nil {
+		return synchers.SSHOptions{}, retMap, environmentTypes, err
+	}
+
+	var defaultOptions synchers.SSHOptions
+	defaultSet := false
+
+	for _, environment := range *environments {
+		environmentTypes[environment.Name] = strings.ToLower(string(environment.EnvironmentType))
+		retMap[environment.Name] = synchers.SSHOptions{
+			Host:       environment.DeployTarget.SSHHost,
+			Port:       environment.DeployTarget.SSHPort,
+			Verbose:    defaultSshOptions.Verbose,
+			PrivateKey: "",
+			SkipAgent:  defaultSshOptions.SkipAgent,
+			RsyncArgs:  defaultSshOptions.RsyncArgs,
+			// Fingerprints are carried over too, so any pinned keys need to cover the ssh portal hosts
+			HostKeyPolicy:       defaultSshOptions.HostKeyPolicy,
+			KnownHostsFile:      defaultSshOptions.KnownHostsFile,
+			HostKeyFingerprints: defaultSshOptions.HostKeyFingerprints,
+		}
+
+		if environment.EnvironmentType == "production" {
+			defaultOptions = retMap[environment.Name]
+			defaultSet = true
+		}
+	}
+	if defaultSet == false {
+		return synchers.SSHOptions{}, retMap, environmentTypes, errors.New("COULD NOT FIND DEFAULT OPTION SET")
+	}
+	return defaultOptions, retMap, environmentTypes, nil
+}
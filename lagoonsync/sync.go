@@ -0,0 +1,398 @@
+package lagoonsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/uselagoon/lagoon-sync/synchers"
+	"github.com/uselagoon/lagoon-sync/utils"
+)
+
+// SyncRequest describes a single sync
+type SyncRequest struct {
+	// SyncerType is the syncer to run - one of the built in syncers, or a custom syncer or alias from the config
+	SyncerType string
+	// Syncer is run instead of the syncer SyncerType resolves to, which then only names the sync in its report.
+	// Syncs with their own Syncer aren't journaled, since the syncer can't be recreated to resume them.
+	Syncer synchers.Syncer
+	// ProjectName is the Lagoon project the environments belong to
+	ProjectName string
+	// SourceEnvironment is the environment synced from
+	SourceEnvironment string
+	// TargetEnvironment is the environment synced to, the local environment if empty
+	TargetEnvironment string
+	// ServiceName is the service the sync's commands are run in, the cli service (or mongodb's own) if empty
+	ServiceName string
+	// TransferResourceName is where the syncer writes what it exports, a generated name in /tmp if empty. It's left
+	// to a Syncer passed in to set its own.
+	TransferResourceName string
+	// Tables limits a database sync to these tables, which can be table names, glob patterns or /regular
+	// expressions/. It's only supported by syncers implementing synchers.TableSelector.
+	Tables []string
+	// DryRun logs the commands that would be run without running them
+	DryRun bool
+	// Stream pipes database exports straight into the import on the target, without writing dump files
+	Stream bool
+	// SkipSourceCleanup leaves the files generated on the source
+	SkipSourceCleanup bool
+	// SkipTargetCleanup leaves the files generated on the target
+	SkipTargetCleanup bool
+	// SkipTargetImport transfers the export to the target without importing it
+	SkipTargetImport bool
+	// KeepFailedFiles keeps the files generated by a journaled sync that fails, so that Resume picks up from them
+	// rather than starting over. They're cleaned up otherwise.
+	KeepFailedFiles bool
+	// BackupTarget backs up the target's database before importing over it, so that the sync can be undone with
+	// Client.Rollback. It's only supported by syncers implementing synchers.TargetBackuper, and needs the sync
+	// to be journaled.
+	BackupTarget bool
+	// Delete removes files from the target that are no longer on the source, once Config.ConfirmDeletions has
+	// agreed to it. It's only supported by syncers that sync a directory of files.
+	Delete bool
+	// Logger logs the progress of this sync, the client's logger if nil
+	Logger *utils.Logger
+}
+
+// SyncResult is the outcome of a sync
+type SyncResult struct {
+	// RunId identifies the sync's journal, which Resume picks up from. It's empty if the sync wasn't journaled.
+	RunId string
+	// Report records what the sync did, and how each of its phases went
+	Report *synchers.SyncReport
+}
+
+// Sync runs a sync, stopping and cleaning up if ctx is cancelled. The result is returned even if the sync fails,
+// so that its report can be inspected.
+func (c *Client) Sync(ctx context.Context, request SyncRequest) (result *SyncResult, err error) {
+	result = &SyncResult{Report: &synchers.SyncReport{}}
+	defer func() { reportEarlyFailure(result.Report, request.SyncerType, err) }()
+	logger := request.Logger
+	if logger == nil {
+		logger = c.config.Logger
+	}
+
+	serviceName := request.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName(request.SyncerType)
+	}
+	sourceEnvironment, targetEnvironment := buildEnvironments(request.ProjectName, serviceName, request.SourceEnvironment, request.TargetEnvironment)
+
+	lagoonSyncer := request.Syncer
+	if lagoonSyncer == nil {
+		if lagoonSyncer, err = resolveSyncer(request.SyncerType, c.config.SyncConfig); err != nil {
+			return result, err
+		}
+	}
+
+	sshOptionWrapper, environmentTypes, err := buildSSHOptionWrapper(request.ProjectName, c.config.SSH, c.config.UseSSHPortal, c.config.APIEndpoint)
+	if err != nil {
+		return result, fmt.Errorf("Failed to configure SSH options: %w", err)
+	}
+
+	// A dry run doesn't change anything, so it can preview a sync into a protected environment
+	if !request.DryRun {
+		if err := c.checkProtectedTarget(c.protectedEnvironments, targetEnvironment, environmentTypes, c.config.SSH, c.config.APIEndpoint); err != nil {
+			return result, err
+		}
+	}
+
+	if request.TransferResourceName != "" && request.Syncer == nil {
+		if err := lagoonSyncer.SetTransferResource(request.TransferResourceName); err != nil {
+			return result, err
+		}
+	}
+
+	if err := selectTables(lagoonSyncer, request.SyncerType, request.Tables); err != nil {
+		return result, err
+	}
+
+	hooks, err := synchers.DecodeSyncHooks(c.config.SyncConfig.LagoonSync[request.SyncerType])
+	if err != nil {
+		return result, err
+	}
+
+	var journal *synchers.SyncJournal
+	if !request.DryRun && request.Syncer == nil && c.config.JournalDirectory != "" {
+		journal, err = synchers.NewSyncJournal(c.config.JournalDirectory, synchers.SyncJournal{
+			SyncerType:           request.SyncerType,
+			ConfigFile:           c.config.ConfigFile,
+			ProjectName:          request.ProjectName,
+			SourceEnvironment:    sourceEnvironment.EnvironmentName,
+			TargetEnvironment:    targetEnvironment.EnvironmentName,
+			ServiceName:          serviceName,
+			TransferResourceName: lagoonSyncer.GetTransferResource(sourceEnvironment).Name,
+			Tables:               request.Tables,
+			SkipSourceCleanup:    request.SkipSourceCleanup,
+			SkipTargetCleanup:    request.SkipTargetCleanup,
+			SkipTargetImport:     request.SkipTargetImport,
+			BackupTarget:         request.BackupTarget,
+			Delete:               request.Delete,
+			Stream:               request.Stream,
+			KeepFailedFiles:      request.KeepFailedFiles,
+			SSHOptions:           c.config.SSH,
+			UseSshPortal:         c.config.UseSSHPortal,
+			APIEndpoint:          c.config.APIEndpoint,
+		})
+		if err != nil {
+			// Not being able to journal shouldn't stop the sync, it just can't be resumed
+			logger.LogWarning("Unable to create a journal for this sync, it won't be resumable", err.Error())
+			journal = nil
+		} else {
+			result.RunId = journal.RunId
+			logger.LogProcessStep("Sync run id", journal.RunId)
+		}
+	}
+
+	// A backup nobody can find the run id of can't be rolled back
+	if request.BackupTarget && journal == nil && !request.DryRun {
+		return result, fmt.Errorf("%w: backing up the target needs the sync to be journaled, so that it can be rolled back", synchers.ErrConfigInvalid)
+	}
+
+	err = c.config.RunSyncProcess(ctx, synchers.RunSyncProcessFunctionTypeArguments{
+		SourceEnvironment:    sourceEnvironment,
+		TargetEnvironment:    targetEnvironment,
+		LagoonSyncer:         lagoonSyncer,
+		SyncerType:           request.SyncerType,
+		DryRun:               request.DryRun,
+		SshOptionWrapper:     sshOptionWrapper,
+		SkipSourceCleanup:    request.SkipSourceCleanup,
+		SkipTargetCleanup:    request.SkipTargetCleanup,
+		SkipTargetImport:     request.SkipTargetImport,
+		TransferResourceName: request.TransferResourceName,
+		Stream:               request.Stream,
+		Journal:              journal,
+		Logger:               logger,
+		PhasePolicies:        c.phasePolicies,
+		Report:               result.Report,
+		Hooks:                hooks,
+		BackupTarget:         request.BackupTarget,
+		BackupPolicy:         c.backupPolicy,
+		Delete:               request.Delete,
+		ManifestDirectory:    c.config.ManifestDirectory,
+		ConfirmDeletions:     c.config.ConfirmDeletions,
+		KeepFailedFiles:      request.KeepFailedFiles,
+	})
+	return result, err
+}
+
+// Resume picks up a journaled sync that failed or was interrupted, skipping the phases it had already completed.
+// It runs with the configuration and ssh options the sync was started with.
+func (c *Client) Resume(ctx context.Context, runId string) (result *SyncResult, err error) {
+	result = &SyncResult{RunId: runId, Report: &synchers.SyncReport{}}
+	syncerType := ""
+	defer func() { reportEarlyFailure(result.Report, syncerType, err) }()
+	if c.config.JournalDirectory == "" {
+		return result, errors.New("unable to resume without a journal directory")
+	}
+
+	journal, err := synchers.LoadSyncJournal(c.config.JournalDirectory, runId)
+	if err != nil {
+		return result, err
+	}
+	if journal.IsFinished() {
+		return result, fmt.Errorf("sync run %s has already completed, there is nothing to resume", runId)
+	}
+	syncerType = journal.SyncerType
+
+	rebuilt, err := c.rebuildJournaledSync(journal)
+	if err != nil {
+		return result, err
+	}
+	if err := c.checkProtectedTarget(rebuilt.protectedEnvironments, rebuilt.targetEnvironment, rebuilt.environmentTypes, rebuilt.sshOptions, rebuilt.apiEndpoint); err != nil {
+		return result, err
+	}
+	lagoonSyncer := rebuilt.lagoonSyncer
+
+	// Point the syncer back at the resource the original run generated
+	if lagoonSyncer.GetTransferResource(rebuilt.sourceEnvironment).Name != journal.TransferResourceName {
+		err = lagoonSyncer.SetTransferResource(journal.TransferResourceName)
+		if err != nil && journal.HasCompleted(synchers.SyncPhaseSourceExport) {
+			return result, fmt.Errorf("Unable to resume sync run %s: %w", journal.RunId, err)
+		}
+	}
+
+	if err := selectTables(lagoonSyncer, journal.SyncerType, journal.Tables); err != nil {
+		return result, err
+	}
+
+	hooks, err := synchers.DecodeSyncHooks(rebuilt.syncConfig.LagoonSync[journal.SyncerType])
+	if err != nil {
+		return result, err
+	}
+
+	err = c.config.RunSyncProcess(ctx, synchers.RunSyncProcessFunctionTypeArguments{
+		SourceEnvironment: rebuilt.sourceEnvironment,
+		TargetEnvironment: rebuilt.targetEnvironment,
+		LagoonSyncer:      lagoonSyncer,
+		SyncerType:        journal.SyncerType,
+		SshOptionWrapper:  rebuilt.sshOptionWrapper,
+		SkipSourceCleanup: journal.SkipSourceCleanup,
+		SkipTargetCleanup: journal.SkipTargetCleanup,
+		SkipTargetImport:  journal.SkipTargetImport,
+		Stream:            journal.Stream,
+		Journal:           journal,
+		Logger:            c.config.Logger,
+		PhasePolicies:     rebuilt.phasePolicies,
+		Report:            result.Report,
+		Hooks:             hooks,
+		BackupTarget:      journal.BackupTarget,
+		BackupPolicy:      rebuilt.backupPolicy,
+		Delete:            journal.Delete,
+		ManifestDirectory: c.config.ManifestDirectory,
+		ConfirmDeletions:  c.config.ConfirmDeletions,
+		KeepFailedFiles:   journal.KeepFailedFiles,
+	})
+	return result, err
+}
+
+// Rollback restores the target of a sync run with the backup it took before importing, which it only does if it
+// was run with SyncRequest.BackupTarget. The syncer type has to match the run's, as a check that the right run is
+// being rolled back.
+func (c *Client) Rollback(ctx context.Context, syncerType string, runId string) error {
+	if c.config.JournalDirectory == "" {
+		return errors.New("unable to roll back without a journal directory")
+	}
+
+	journal, err := synchers.LoadSyncJournal(c.config.JournalDirectory, runId)
+	if err != nil {
+		return err
+	}
+	if journal.SyncerType != syncerType {
+		return fmt.Errorf("sync run %s is a %s sync, not %s", runId, journal.SyncerType, syncerType)
+	}
+	if journal.BackupFile == "" {
+		return fmt.Errorf("sync run %s didn't back up its target, so it can't be rolled back", runId)
+	}
+
+	rebuilt, err := c.rebuildJournaledSync(journal)
+	if err != nil {
+		return err
+	}
+	if err := c.checkProtectedTarget(rebuilt.protectedEnvironments, rebuilt.targetEnvironment, rebuilt.environmentTypes, rebuilt.sshOptions, rebuilt.apiEndpoint); err != nil {
+		return err
+	}
+	return synchers.SyncRestoreTarget(ctx, rebuilt.targetEnvironment, rebuilt.lagoonSyncer, journal.SyncerType, journal.BackupFile, false, rebuilt.sshOptionWrapper, c.config.Logger)
+}
+
+// journaledSync is what a journaled sync was run with
+type journaledSync struct {
+	syncConfig            synchers.SyncherConfigRoot
+	phasePolicies         synchers.PhasePolicies
+	backupPolicy          synchers.BackupPolicy
+	protectedEnvironments synchers.ProtectedEnvironments
+	lagoonSyncer          synchers.Syncer
+	sourceEnvironment     synchers.Environment
+	targetEnvironment     synchers.Environment
+	sshOptionWrapper      *synchers.SSHOptionWrapper
+	environmentTypes      map[string]string
+	sshOptions            synchers.SSHOptions
+	apiEndpoint           string
+}
+
+// rebuildJournaledSync rebuilds a journaled sync with the configuration and ssh options it was started with
+func (c *Client) rebuildJournaledSync(journal *synchers.SyncJournal) (*journaledSync, error) {
+	rebuilt := &journaledSync{
+		syncConfig:            c.config.SyncConfig,
+		phasePolicies:         c.phasePolicies,
+		backupPolicy:          c.backupPolicy,
+		protectedEnvironments: c.protectedEnvironments,
+	}
+
+	var err error
+	if journal.ConfigFile != "" && journal.ConfigFile != c.config.ConfigFile {
+		if rebuilt.syncConfig, err = LoadConfig(journal.ConfigFile); err != nil {
+			return nil, fmt.Errorf("Failed to load configuration: %w", err)
+		}
+		if rebuilt.phasePolicies, err = synchers.DecodePhasePolicies(rebuilt.syncConfig.LagoonSync["phases"]); err != nil {
+			return nil, fmt.Errorf("invalid phase policies: %w", err)
+		}
+		if rebuilt.backupPolicy, err = synchers.DecodeBackupPolicy(rebuilt.syncConfig.LagoonSync["backups"]); err != nil {
+			return nil, err
+		}
+		if rebuilt.protectedEnvironments, err = synchers.DecodeProtectedEnvironments(rebuilt.syncConfig.LagoonSync["protected-environments"]); err != nil {
+			return nil, err
+		}
+	}
+
+	rebuilt.sourceEnvironment, rebuilt.targetEnvironment = buildEnvironments(journal.ProjectName, journal.ServiceName, journal.SourceEnvironment, journal.TargetEnvironment)
+
+	if rebuilt.lagoonSyncer, err = resolveSyncer(journal.SyncerType, rebuilt.syncConfig); err != nil {
+		return nil, err
+	}
+
+	rebuilt.sshOptions = journal.SSHOptions
+	rebuilt.apiEndpoint = journal.APIEndpoint
+	if rebuilt.apiEndpoint == "" {
+		rebuilt.apiEndpoint = c.config.APIEndpoint
+	}
+	if rebuilt.sshOptionWrapper, rebuilt.environmentTypes, err = buildSSHOptionWrapper(journal.ProjectName, journal.SSHOptions, journal.UseSshPortal, rebuilt.apiEndpoint); err != nil {
+		return nil, fmt.Errorf("Failed to configure SSH options: %w", err)
+	}
+	return rebuilt, nil
+}
+
+// selectTables limits the sync to the tables, if any are given
+func selectTables(lagoonSyncer synchers.Syncer, syncerType string, tables []string) error {
+	if len(tables) == 0 {
+		return nil
+	}
+	selector, ok := lagoonSyncer.(synchers.TableSelector)
+	if !ok {
+		return fmt.Errorf("%w: the %s syncer can't sync a selection of tables", synchers.ErrConfigInvalid, syncerType)
+	}
+	return selector.SetTables(tables)
+}
+
+// reportEarlyFailure fills in the report of a sync that failed before it got as far as running
+func reportEarlyFailure(report *synchers.SyncReport, syncerType string, err error) {
+	if err == nil || report.Status != "" {
+		return
+	}
+	report.SyncerType = syncerType
+	report.Status = synchers.SyncStatusFailed
+	report.Error = err.Error()
+	report.Phases = []synchers.PhaseReport{}
+}
+
+// defaultServiceName is the service a syncer's commands are run in, which is typically the cli service. Solr
+// snapshots are written to the solr service's own filesystem, so they're taken and restored there.
+func defaultServiceName(syncerType string) string {
+	if syncerType == "mongodb" || syncerType == "solr" {
+		return syncerType
+	}
+	return "cli"
+}
+
+// buildEnvironments creates the source and target environments, the target defaulting to the local environment
+func buildEnvironments(projectName, serviceName, sourceEnvName, targetEnvName string) (synchers.Environment, synchers.Environment) {
+	sourceEnvironment := synchers.Environment{
+		ProjectName:     projectName,
+		EnvironmentName: sourceEnvName,
+		ServiceName:     serviceName,
+	}
+
+	if targetEnvName == "" {
+		targetEnvName = synchers.LOCAL_ENVIRONMENT_NAME
+	}
+
+	targetEnvironment := synchers.Environment{
+		ProjectName:     projectName,
+		EnvironmentName: targetEnvName,
+		ServiceName:     serviceName,
+	}
+
+	return sourceEnvironment, targetEnvironment
+}
+
+// resolveSyncer gets the syncer for the type from the config, falling back to a custom syncer
+func resolveSyncer(syncerType string, configRoot synchers.SyncherConfigRoot) (synchers.Syncer, error) {
+	lagoonSyncer, err := synchers.GetSyncerForTypeFromConfigRoot(syncerType, configRoot)
+	if err != nil {
+		lagoonSyncer, err = synchers.GetCustomSync(configRoot, syncerType)
+		if err != nil {
+			return nil, fmt.Errorf("could not find syncer for type %s: %w", syncerType, err)
+		}
+	}
+	return lagoonSyncer, nil
+}
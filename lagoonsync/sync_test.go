@@ -0,0 +1,347 @@
+package lagoonsync
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/uselagoon/lagoon-sync/synchers"
+	"github.com/uselagoon/lagoon-sync/utils"
+)
+
+// lookupTestEnvironmentTypes stands in for the Lagoon API, with main being the project's production environment
+func lookupTestEnvironmentTypes(projectName string, sshOptions synchers.SSHOptions, apiEndpoint string) (map[string]string, error) {
+	return map[string]string{"main": "production", "dev": "development"}, nil
+}
+
+func TestClient_Sync(t *testing.T) {
+	filesSyncer, err := synchers.NewBaseFilesSyncRootFromService(utils.Service{Name: "nginx", Type: "nginx", Volumes: map[string]string{"files": "/app/files"}}, "/app/files")
+	if err != nil {
+		t.Fatalf("NewBaseFilesSyncRootFromService() error = %v", err)
+	}
+
+	tests := []struct {
+		name            string
+		request         SyncRequest
+		runErr          error
+		wantService     string
+		wantTarget      string
+		wantJournal     bool
+		wantErr         bool
+		wantReportError bool
+	}{
+		{
+			name:        "Defaults to the cli service and the local environment",
+			request:     SyncRequest{SyncerType: "mariadb", ProjectName: "project", SourceEnvironment: "main"},
+			wantService: "cli",
+			wantTarget:  synchers.LOCAL_ENVIRONMENT_NAME,
+			wantJournal: true,
+		},
+		{
+			name:        "Mongodb runs in its own service",
+			request:     SyncRequest{SyncerType: "mongodb", ProjectName: "project", SourceEnvironment: "main", TargetEnvironment: "dev"},
+			wantService: "mongodb",
+			wantTarget:  "dev",
+			wantJournal: true,
+		},
+		{
+			name:        "Solr runs in its own service",
+			request:     SyncRequest{SyncerType: "solr", ProjectName: "project", SourceEnvironment: "main"},
+			wantService: "solr",
+			wantTarget:  synchers.LOCAL_ENVIRONMENT_NAME,
+			wantJournal: true,
+		},
+		{
+			name:        "Dry runs aren't journaled",
+			request:     SyncRequest{SyncerType: "mariadb", ProjectName: "project", SourceEnvironment: "main", DryRun: true},
+			wantService: "cli",
+			wantTarget:  synchers.LOCAL_ENVIRONMENT_NAME,
+		},
+		{
+			name:        "Syncs with their own syncer aren't journaled",
+			request:     SyncRequest{SyncerType: "files", Syncer: filesSyncer, ProjectName: "project", SourceEnvironment: "main", ServiceName: "nginx"},
+			wantService: "nginx",
+			wantTarget:  synchers.LOCAL_ENVIRONMENT_NAME,
+		},
+		{
+			name:            "Unknown syncers fail before running",
+			request:         SyncRequest{SyncerType: "nope", ProjectName: "project", SourceEnvironment: "main"},
+			wantErr:         true,
+			wantReportError: true,
+		},
+		{
+			name:            "Tables can't be selected on syncers without tables",
+			request:         SyncRequest{SyncerType: "mongodb", ProjectName: "project", SourceEnvironment: "main", Tables: []string{"users"}},
+			wantErr:         true,
+			wantReportError: true,
+		},
+		{
+			name:            "Backups need a journal to be rolled back with",
+			request:         SyncRequest{SyncerType: "files", Syncer: filesSyncer, ProjectName: "project", SourceEnvironment: "main", ServiceName: "nginx", BackupTarget: true},
+			wantErr:         true,
+			wantReportError: true,
+		},
+		{
+			name:        "Failures are returned with the report",
+			request:     SyncRequest{SyncerType: "mariadb", ProjectName: "project", SourceEnvironment: "main"},
+			runErr:      errors.New("import failed"),
+			wantService: "cli",
+			wantTarget:  synchers.LOCAL_ENVIRONMENT_NAME,
+			wantJournal: true,
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ran *synchers.RunSyncProcessFunctionTypeArguments
+			client, err := NewClient(Config{
+				JournalDirectory:       t.TempDir(),
+				LookupEnvironmentTypes: lookupTestEnvironmentTypes,
+				RunSyncProcess: func(ctx context.Context, args synchers.RunSyncProcessFunctionTypeArguments) error {
+					ran = &args
+					args.Report.SyncerType = args.SyncerType
+					args.Report.Status = synchers.SyncStatusSucceeded
+					return tt.runErr
+				},
+			})
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+
+			result, err := client.Sync(context.Background(), tt.request)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Sync() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if result == nil || result.Report == nil {
+				t.Fatalf("Sync() returned no report")
+			}
+			if tt.wantReportError {
+				if ran != nil {
+					t.Errorf("Sync() ran a sync that should have failed first")
+				}
+				if result.Report.Status != synchers.SyncStatusFailed || result.Report.Error == "" {
+					t.Errorf("Sync() report = %+v, want a failure", result.Report)
+				}
+				return
+			}
+
+			if ran == nil {
+				t.Fatalf("Sync() didn't run the sync")
+			}
+			if ran.SourceEnvironment.ServiceName != tt.wantService || ran.TargetEnvironment.ServiceName != tt.wantService {
+				t.Errorf("Sync() ran in service %v, want %v", ran.SourceEnvironment.ServiceName, tt.wantService)
+			}
+			if ran.TargetEnvironment.EnvironmentName != tt.wantTarget {
+				t.Errorf("Sync() synced to %v, want %v", ran.TargetEnvironment.EnvironmentName, tt.wantTarget)
+			}
+			if (ran.Journal != nil) != tt.wantJournal || (result.RunId != "") != tt.wantJournal {
+				t.Errorf("Sync() journaled = %v with run id %q, want journaled %v", ran.Journal != nil, result.RunId, tt.wantJournal)
+			}
+			if result.Report.Status != synchers.SyncStatusSucceeded {
+				t.Errorf("Sync() report = %+v, want the report the sync filled in", result.Report)
+			}
+		})
+	}
+}
+
+func TestClient_Resume(t *testing.T) {
+	journalDirectory := t.TempDir()
+	runs := 0
+	var ran synchers.RunSyncProcessFunctionTypeArguments
+	client, err := NewClient(Config{
+		JournalDirectory:       journalDirectory,
+		LookupEnvironmentTypes: lookupTestEnvironmentTypes,
+		RunSyncProcess: func(ctx context.Context, args synchers.RunSyncProcessFunctionTypeArguments) error {
+			runs++
+			ran = args
+			if runs == 1 {
+				if err := args.Journal.MarkCompleted(synchers.SyncPhaseSourceExport); err != nil {
+					return err
+				}
+				return errors.New("transfer failed")
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	result, err := client.Sync(context.Background(), SyncRequest{SyncerType: "mariadb", ProjectName: "project", SourceEnvironment: "main", TargetEnvironment: "dev", TransferResourceName: "/tmp/resume-test.sql", Tables: []string{"cache_*"}})
+	if err == nil || result.RunId == "" {
+		t.Fatalf("Sync() = %+v, %v, want a failed journaled sync", result, err)
+	}
+
+	if _, err := client.Resume(context.Background(), result.RunId); err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+	if ran.TargetEnvironment.EnvironmentName != "dev" || ran.SourceEnvironment.ServiceName != "cli" {
+		t.Errorf("Resume() ran with environments %+v and %+v, want those of the original sync", ran.SourceEnvironment, ran.TargetEnvironment)
+	}
+	if name := ran.LagoonSyncer.GetTransferResource(ran.SourceEnvironment).Name; name != "/tmp/resume-test.sql" {
+		t.Errorf("Resume() used transfer resource %v, want the original sync's", name)
+	}
+	if tables := ran.LagoonSyncer.(*synchers.MariadbSyncRoot).Config.Tables; len(tables) != 1 || tables[0] != "cache_*" {
+		t.Errorf("Resume() synced tables %v, want the original sync's", tables)
+	}
+	if ran.Journal == nil || !ran.Journal.HasCompleted(synchers.SyncPhaseSourceExport) {
+		t.Errorf("Resume() didn't pick up the original sync's journal")
+	}
+
+	if _, err := client.Resume(context.Background(), "no-such-run"); err == nil {
+		t.Errorf("Resume() of an unknown run didn't fail")
+	}
+}
+
+func TestClient_Rollback(t *testing.T) {
+	client, err := NewClient(Config{
+		JournalDirectory: t.TempDir(),
+		RunSyncProcess: func(ctx context.Context, args synchers.RunSyncProcessFunctionTypeArguments) error {
+			if args.BackupTarget {
+				return args.Journal.RecordBackup(args.BackupPolicy.BackupFile(args.SyncerType, args.Journal.RunId))
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	backedUp, err := client.Sync(context.Background(), SyncRequest{SyncerType: "mariadb", ProjectName: "project", SourceEnvironment: "main", BackupTarget: true})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	notBackedUp, err := client.Sync(context.Background(), SyncRequest{SyncerType: "mariadb", ProjectName: "project", SourceEnvironment: "main"})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		syncerType string
+		runId      string
+	}{
+		{name: "Unknown run", syncerType: "mariadb", runId: "no-such-run"},
+		{name: "Wrong syncer type", syncerType: "postgres", runId: backedUp.RunId},
+		{name: "Run without a backup", syncerType: "mariadb", runId: notBackedUp.RunId},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := client.Rollback(context.Background(), tt.syncerType, tt.runId); err == nil {
+				t.Errorf("Rollback() didn't fail")
+			}
+		})
+	}
+}
+
+func TestClient_SyncProtectedTarget(t *testing.T) {
+	config := synchers.SyncherConfigRoot{LagoonSync: map[string]interface{}{
+		"protected-environments": map[string]interface{}{"environments": []interface{}{"main"}},
+	}}
+	unknownTypes := func(projectName string, sshOptions synchers.SSHOptions, apiEndpoint string) (map[string]string, error) {
+		return nil, errors.New("the API is unreachable")
+	}
+	tests := []struct {
+		name    string
+		config  *synchers.SyncherConfigRoot
+		lookup  func(projectName string, sshOptions synchers.SSHOptions, apiEndpoint string) (map[string]string, error)
+		request SyncRequest
+		confirm func(target synchers.Environment, reason string) error
+		wantRun bool
+	}{
+		{
+			name:    "Protected targets aren't synced into",
+			request: SyncRequest{SyncerType: "mariadb", ProjectName: "project", SourceEnvironment: "dev", TargetEnvironment: "main"},
+		},
+		{
+			name:    "Unless it's confirmed",
+			request: SyncRequest{SyncerType: "mariadb", ProjectName: "project", SourceEnvironment: "dev", TargetEnvironment: "main"},
+			confirm: func(target synchers.Environment, reason string) error { return nil },
+			wantRun: true,
+		},
+		{
+			name:    "A refused confirmation stops the sync",
+			request: SyncRequest{SyncerType: "mariadb", ProjectName: "project", SourceEnvironment: "dev", TargetEnvironment: "main"},
+			confirm: func(target synchers.Environment, reason string) error { return errors.New("not confirmed") },
+		},
+		{
+			name:    "Dry runs don't need confirming",
+			request: SyncRequest{SyncerType: "mariadb", ProjectName: "project", SourceEnvironment: "dev", TargetEnvironment: "main", DryRun: true},
+			wantRun: true,
+		},
+		{
+			name:    "Production environments are protected without the ssh portal",
+			config:  &synchers.SyncherConfigRoot{},
+			request: SyncRequest{SyncerType: "mariadb", ProjectName: "project", SourceEnvironment: "dev", TargetEnvironment: "main"},
+		},
+		{
+			name:    "Other environments aren't",
+			config:  &synchers.SyncherConfigRoot{},
+			request: SyncRequest{SyncerType: "mariadb", ProjectName: "project", SourceEnvironment: "main", TargetEnvironment: "dev"},
+			wantRun: true,
+		},
+		{
+			name:    "Environments the API doesn't know are protected",
+			config:  &synchers.SyncherConfigRoot{},
+			request: SyncRequest{SyncerType: "mariadb", ProjectName: "project", SourceEnvironment: "main", TargetEnvironment: "feature"},
+		},
+		{
+			name:    "Environments whose type can't be looked up are protected",
+			config:  &synchers.SyncherConfigRoot{},
+			lookup:  unknownTypes,
+			request: SyncRequest{SyncerType: "mariadb", ProjectName: "project", SourceEnvironment: "main", TargetEnvironment: "dev"},
+		},
+		{
+			name:    "Unless they're confirmed",
+			config:  &synchers.SyncherConfigRoot{},
+			lookup:  unknownTypes,
+			request: SyncRequest{SyncerType: "mariadb", ProjectName: "project", SourceEnvironment: "main", TargetEnvironment: "dev"},
+			confirm: func(target synchers.Environment, reason string) error { return nil },
+			wantRun: true,
+		},
+		{
+			name: "Types aren't looked up if environments aren't protected by type",
+			config: &synchers.SyncherConfigRoot{LagoonSync: map[string]interface{}{
+				"protected-environments": map[string]interface{}{"environment-types": []interface{}{}},
+			}},
+			lookup:  unknownTypes,
+			request: SyncRequest{SyncerType: "mariadb", ProjectName: "project", SourceEnvironment: "main", TargetEnvironment: "dev"},
+			wantRun: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ran := false
+			// Unless the case has its own config, main is listed as protected
+			syncConfig := config
+			if tt.config != nil {
+				syncConfig = *tt.config
+			}
+			lookup := tt.lookup
+			if lookup == nil {
+				lookup = lookupTestEnvironmentTypes
+			}
+			client, err := NewClient(Config{
+				SyncConfig:             syncConfig,
+				JournalDirectory:       t.TempDir(),
+				LookupEnvironmentTypes: lookup,
+				RunSyncProcess: func(ctx context.Context, args synchers.RunSyncProcessFunctionTypeArguments) error {
+					ran = true
+					return nil
+				},
+				ConfirmProtectedTarget: tt.confirm,
+			})
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+
+			_, err = client.Sync(context.Background(), tt.request)
+			if ran != tt.wantRun {
+				t.Errorf("Sync() ran = %v, want %v", ran, tt.wantRun)
+			}
+			if !tt.wantRun && !errors.Is(err, synchers.ErrProtectedTarget) {
+				t.Errorf("Sync() error = %v, want %v", err, synchers.ErrProtectedTarget)
+			}
+		})
+	}
+}
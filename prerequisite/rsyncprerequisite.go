@@ -1,7 +1,6 @@
 package prerequisite
 
 import (
-	"log"
 	"os/exec"
 	"strings"
 )
@@ -18,7 +17,7 @@ func (p *RsyncPrerequisite) GetValue() bool {
 	cmd := exec.Command("sh", "-c", "which rsync || which /tmp/*rsync* || true")
 	stdoutStderr, err := cmd.Output()
 	if err != nil {
-		log.Fatal(err)
+		return false
 	}
 
 	p.RsyncPath = strings.TrimSuffix(string(stdoutStderr), "\n")
@@ -0,0 +1,138 @@
+package synchers
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/uselagoon/lagoon-sync/utils"
+)
+
+const (
+	defaultBackupDirectory = "/tmp/lagoon-sync-backups"
+	defaultBackupKeep      = 3
+)
+
+// TargetBackuper is implemented by database syncers that can back up the target's database before importing over
+// it, and restore it from that backup
+type TargetBackuper interface {
+	// GetBackupCommand will return the command that dumps the target's database into the backup file
+	GetBackupCommand(environment Environment, backupFile string) SyncCommand
+	// GetRestoreCommand will return the command that restores the target's database from the backup file, clearing
+	// the database first so that nothing imported since the backup was taken is left behind
+	GetRestoreCommand(environment Environment, backupFile string) SyncCommand
+}
+
+// BackupPolicy controls where the backups taken with --backup-target are kept, and how many of them, configured
+// under `lagoon-sync: backups:`
+type BackupPolicy struct {
+	// Directory is where backups are kept on the target, /tmp/lagoon-sync-backups if not set. It's expanded by the
+	// target's shell, so it can refer to environment variables like $HOME.
+	Directory string `yaml:"directory,omitempty" json:"directory,omitempty" mapstructure:"directory"`
+	// Keep is how many backups of each syncer type are kept, the oldest being removed first, 3 if not set
+	Keep int `yaml:"keep,omitempty" json:"keep,omitempty" mapstructure:"keep"`
+}
+
+// DecodeBackupPolicy reads the backup policy from the `backups` section of the lagoon-sync config
+func DecodeBackupPolicy(raw interface{}) (BackupPolicy, error) {
+	policy := BackupPolicy{}
+	if raw != nil {
+		if err := mapstructure.Decode(raw, &policy); err != nil {
+			return BackupPolicy{}, fmt.Errorf("%w: unable to parse the backup policy: %v", ErrConfigInvalid, err)
+		}
+	}
+	if policy.Keep < 0 {
+		return BackupPolicy{}, fmt.Errorf("%w: the backup policy can't keep a negative number of backups", ErrConfigInvalid)
+	}
+	if policy.Directory == "" {
+		policy.Directory = defaultBackupDirectory
+	}
+	if policy.Keep == 0 {
+		policy.Keep = defaultBackupKeep
+	}
+	return policy, nil
+}
+
+// backupFilePrefix is what the names of the backups of a syncer type start with
+func (p BackupPolicy) backupFilePrefix(syncerType string) string {
+	return path.Join(p.Directory, fmt.Sprintf("lagoon_sync_backup_%s_", syncerType))
+}
+
+// BackupFile is where the backup taken by a sync run is kept
+func (p BackupPolicy) BackupFile(syncerType string, runId string) string {
+	return p.backupFilePrefix(syncerType) + runId
+}
+
+// SyncBackupTarget backs up the target's database into the backup file, and then removes the oldest backups of
+// the syncer type beyond the number the policy keeps. The referenced backups, which journals still refer to, are
+// never removed, so those syncs can still be rolled back.
+func SyncBackupTarget(ctx context.Context, environment Environment, syncer Syncer, syncerType string, backupFile string, referencedBackups []string, policy BackupPolicy, dryRun bool, sshOptionWrapper *SSHOptionWrapper, logger *utils.Logger) error {
+	backuper, ok := syncer.(TargetBackuper)
+	if !ok {
+		return fmt.Errorf("%w: the %s syncer can't back up the target", ErrConfigInvalid, syncerType)
+	}
+
+	logger.LogProcessStep("Backing up the target before importing", backupFile)
+	backupCommand := backuper.GetBackupCommand(environment, backupFile)
+	if backupCommand.substitutions == nil {
+		backupCommand.substitutions = map[string]interface{}{}
+	}
+	backupCommand.command = "mkdir -p {{ .backupDirectory }} && " + backupCommand.command
+	backupCommand.substitutions["backupDirectory"] = policy.Directory
+	if err := runLoggedCommand(ctx, environment, backupCommand, "Running the following to back up the target", dryRun, sshOptionWrapper, logger); err != nil {
+		return fmt.Errorf("unable to back up the target: %w", err)
+	}
+
+	// Pruning is best effort, a backup that can't be removed doesn't stop the sync
+	// The backups are matched by name, since the directory can be one the target's shell expands. An empty name
+	// never matches, so there's always a pattern to match against.
+	referenced := []string{"''"}
+	for _, backup := range referencedBackups {
+		referenced = append(referenced, shellQuote(path.Base(backup)))
+	}
+	pruneCommand := generateSyncCommand("ls -1t {{ .prefix }}* 2>/dev/null | tail -n +{{ .first }} | while read -r backup; do case \"${backup##*/}\" in {{ .referenced }}) ;; *) rm -f \"$backup\" ;; esac; done", map[string]interface{}{
+		"prefix":     policy.backupFilePrefix(syncerType),
+		"first":      policy.Keep + 1,
+		"referenced": strings.Join(referenced, "|"),
+	})
+	if err := runLoggedCommand(ctx, environment, pruneCommand, "Removing old backups of the target", dryRun, sshOptionWrapper, logger); err != nil {
+		logger.LogWarning("Unable to remove old backups of the target", err.Error())
+	}
+	return nil
+}
+
+// SyncRestoreTarget restores the target's database from a backup taken before a sync imported over it
+func SyncRestoreTarget(ctx context.Context, environment Environment, syncer Syncer, syncerType string, backupFile string, dryRun bool, sshOptionWrapper *SSHOptionWrapper, logger *utils.Logger) error {
+	backuper, ok := syncer.(TargetBackuper)
+	if !ok {
+		return fmt.Errorf("%w: the %s syncer can't restore the target", ErrConfigInvalid, syncerType)
+	}
+
+	logger.LogProcessStep("Restoring the target from", backupFile)
+	restoreCommand := backuper.GetRestoreCommand(environment, backupFile)
+	// The restore clears the database first, so it mustn't start without a backup to restore
+	if restoreCommand.substitutions == nil {
+		restoreCommand.substitutions = map[string]interface{}{}
+	}
+	restoreCommand.command = "{ test -s {{ .backupFile }} || { echo \"The backup {{ .backupFile }} is missing or empty\" >&2; exit 1; }; } && " + restoreCommand.command
+	restoreCommand.substitutions["backupFile"] = backupFile
+	if err := runLoggedCommand(ctx, environment, restoreCommand, "Running the following to restore the target", dryRun, sshOptionWrapper, logger); err != nil {
+		return fmt.Errorf("unable to restore the target: %w", err)
+	}
+	return nil
+}
+
+// runLoggedCommand logs a command and then runs it in the environment, unless this is a dry run
+func runLoggedCommand(ctx context.Context, environment Environment, command SyncCommand, message string, dryRun bool, sshOptionWrapper *SSHOptionWrapper, logger *utils.Logger) error {
+	rendered, err := renderCommand(command)
+	if err != nil {
+		return err
+	}
+	logger.LogExecutionStep(message, rendered.loggedString)
+	if dryRun {
+		return nil
+	}
+	return runSyncCommand(ctx, environment, rendered, sshOptionWrapper, logger)
+}
@@ -0,0 +1,225 @@
+package synchers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecodeBackupPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     interface{}
+		want    BackupPolicy
+		wantErr bool
+	}{
+		{
+			name: "Defaults",
+			raw:  nil,
+			want: BackupPolicy{Directory: defaultBackupDirectory, Keep: defaultBackupKeep},
+		},
+		{
+			name: "Configured",
+			raw:  map[string]interface{}{"directory": "$HOME/backups", "keep": 10},
+			want: BackupPolicy{Directory: "$HOME/backups", Keep: 10},
+		},
+		{
+			name:    "Negative keep",
+			raw:     map[string]interface{}{"keep": -1},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeBackupPolicy(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeBackupPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("DecodeBackupPolicy() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// backupTestSyncer is a journalTestSyncer that backs up by writing a marker into the backup file
+type backupTestSyncer struct {
+	journalTestSyncer
+}
+
+func (s backupTestSyncer) GetBackupCommand(environment Environment, backupFile string) SyncCommand {
+	return generateSyncCommand("echo backup > {{ .backupFile }}", map[string]interface{}{"backupFile": backupFile})
+}
+
+func (s backupTestSyncer) GetRestoreCommand(environment Environment, backupFile string) SyncCommand {
+	return generateSyncCommand("cp {{ .backupFile }} "+filepath.Join(s.dir, "restored"), map[string]interface{}{"backupFile": backupFile})
+}
+
+func TestRunSyncProcess_BacksUpTarget(t *testing.T) {
+	tests := []struct {
+		name         string
+		syncer       func(dir string) Syncer
+		backedUp     bool
+		wantBackup   bool
+		wantImported bool
+		wantErr      bool
+	}{
+		{
+			name:         "Backs up before importing",
+			syncer:       func(dir string) Syncer { return backupTestSyncer{journalTestSyncer{dir: dir}} },
+			wantBackup:   true,
+			wantImported: true,
+		},
+		{
+			name:         "A resumed run keeps its original backup",
+			syncer:       func(dir string) Syncer { return backupTestSyncer{journalTestSyncer{dir: dir}} },
+			backedUp:     true,
+			wantImported: true,
+		},
+		{
+			name:    "Syncers that can't back up fail before doing anything",
+			syncer:  func(dir string) Syncer { return journalTestSyncer{dir: dir} },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			backupDirectory := filepath.Join(t.TempDir(), "backups")
+			journalDirectory := t.TempDir()
+			journal, err := NewSyncJournal(journalDirectory, SyncJournal{SyncerType: "test"})
+			if err != nil {
+				t.Fatalf("NewSyncJournal() error = %v", err)
+			}
+			journal.MarkCompleted(SyncPhasePrerequisite)
+			journal.MarkCompleted(SyncPhaseSourceExport)
+			journal.MarkCompleted(SyncPhaseTransfer)
+			if tt.backedUp {
+				journal.RecordBackup(filepath.Join(dir, "original-backup"))
+			}
+
+			// An old backup of the same syncer type, which is pruned since only one is kept
+			if err := os.MkdirAll(backupDirectory, 0700); err != nil {
+				t.Fatal(err)
+			}
+			oldBackup := filepath.Join(backupDirectory, "lagoon_sync_backup_test_1")
+			if err := os.WriteFile(oldBackup, []byte("old"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			// An old backup that an earlier run's journal still refers to, which is kept so it can be rolled back to
+			referencedBackup := filepath.Join(backupDirectory, "lagoon_sync_backup_test_2")
+			if err := os.WriteFile(referencedBackup, []byte("referenced"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			earlierRun, err := NewSyncJournal(journalDirectory, SyncJournal{SyncerType: "test"})
+			if err != nil {
+				t.Fatalf("NewSyncJournal() error = %v", err)
+			}
+			earlierRun.RecordBackup(referencedBackup)
+
+			err = RunSyncProcess(context.Background(), RunSyncProcessFunctionTypeArguments{
+				SourceEnvironment: Environment{EnvironmentName: "main"},
+				TargetEnvironment: Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME},
+				LagoonSyncer:      tt.syncer(dir),
+				SyncerType:        "test",
+				SshOptionWrapper:  &SSHOptionWrapper{},
+				Journal:           journal,
+				BackupTarget:      true,
+				BackupPolicy:      BackupPolicy{Directory: backupDirectory, Keep: 1},
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RunSyncProcess() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			backupFile := filepath.Join(backupDirectory, "lagoon_sync_backup_test_"+journal.RunId)
+			if _, err := os.Stat(backupFile); (err == nil) != tt.wantBackup {
+				t.Errorf("RunSyncProcess() backed up = %v, want %v", err == nil, tt.wantBackup)
+			}
+			if tt.wantBackup {
+				if journal.BackupFile != backupFile {
+					t.Errorf("RunSyncProcess() recorded backup %v, want %v", journal.BackupFile, backupFile)
+				}
+				if _, err := os.Stat(oldBackup); err == nil {
+					t.Errorf("RunSyncProcess() didn't prune the old backup")
+				}
+				if _, err := os.Stat(referencedBackup); err != nil {
+					t.Errorf("RunSyncProcess() pruned a backup a journal refers to")
+				}
+			}
+			if _, err := os.Stat(filepath.Join(dir, "imported")); (err == nil) != tt.wantImported {
+				t.Errorf("RunSyncProcess() imported = %v, want %v", err == nil, tt.wantImported)
+			}
+		})
+	}
+}
+
+func TestSyncRestoreTarget(t *testing.T) {
+	dir := t.TempDir()
+	backupFile := filepath.Join(dir, "backup")
+	if err := os.WriteFile(backupFile, []byte("backup"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := SyncRestoreTarget(context.Background(), Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME}, backupTestSyncer{journalTestSyncer{dir: dir}}, "test", backupFile, false, &SSHOptionWrapper{}, nil)
+	if err != nil {
+		t.Fatalf("SyncRestoreTarget() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "restored")); err != nil {
+		t.Errorf("SyncRestoreTarget() didn't run the restore command")
+	}
+
+	if err := SyncRestoreTarget(context.Background(), Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME}, journalTestSyncer{dir: dir}, "test", backupFile, false, &SSHOptionWrapper{}, nil); err == nil {
+		t.Errorf("SyncRestoreTarget() of a syncer that can't restore didn't fail")
+	}
+
+	// The restore clears the database, so nothing is run without a backup to restore
+	os.Remove(filepath.Join(dir, "restored"))
+	if err := SyncRestoreTarget(context.Background(), Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME}, backupTestSyncer{journalTestSyncer{dir: dir}}, "test", filepath.Join(dir, "missing"), false, &SSHOptionWrapper{}, nil); err == nil {
+		t.Errorf("SyncRestoreTarget() of a missing backup didn't fail")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "restored")); err == nil {
+		t.Errorf("SyncRestoreTarget() ran the restore command without a backup")
+	}
+}
+
+func TestGetRestoreCommand_ClearsDatabase(t *testing.T) {
+	mariadb := &MariadbSyncRoot{}
+	mariadb.setDefaults()
+	postgres := &PostgresSyncRoot{}
+	postgres.Config.setDefaults()
+
+	tests := []struct {
+		name     string
+		backuper TargetBackuper
+		clear    string
+		restore  string
+	}{
+		{
+			name:     "MariaDB drops every view and table",
+			backuper: mariadb,
+			clear:    "DROP TABLE IF EXISTS",
+			restore:  "< /backups/backup",
+		},
+		{
+			name:     "Postgres recreates the public schema",
+			backuper: postgres,
+			clear:    "DROP SCHEMA IF EXISTS public CASCADE; CREATE SCHEMA public;",
+			restore:  "pg_restore",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.backuper.GetRestoreCommand(Environment{EnvironmentName: "main"}, "/backups/backup").GetRedactedCommand()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The database is cleared before the backup is restored
+			clear, restore := strings.Index(got, tt.clear), strings.Index(got, tt.restore)
+			if clear == -1 || restore == -1 || clear > restore {
+				t.Errorf("GetRestoreCommand() = %v, want %v before %v", got, tt.clear, tt.restore)
+			}
+		})
+	}
+}
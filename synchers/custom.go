@@ -1,7 +1,6 @@
 package synchers
 
 import (
-	"errors"
 	"fmt"
 	"reflect"
 
@@ -24,6 +23,7 @@ type CustomSyncRoot struct {
 	TransferResource string                 `yaml:"transfer-resource"`
 	Source           BaseCustomSyncCommands `yaml:"source"`
 	Target           BaseCustomSyncCommands `yaml:"target"`
+	Transport        string                 `yaml:"transport"`
 }
 
 func (m CustomSyncRoot) SetTransferResource(transferResourceName string) error {
@@ -96,7 +96,7 @@ func (m CustomSyncPlugin) UnmarshallYaml(root SyncherConfigRoot, targetService s
 	lagoonSyncer, _ := custom.PrepareSyncer()
 
 	if custom.TransferResource == "" {
-		return lagoonSyncer, errors.New("Transfer resource MUST be set on custom syncher definition")
+		return lagoonSyncer, fmt.Errorf("%w: transfer resource MUST be set on custom syncher definition", ErrConfigInvalid)
 	}
 
 	return lagoonSyncer, nil
@@ -161,6 +161,14 @@ func (m CustomSyncRoot) GetLocalCommand(targetEnvironment Environment) []SyncCom
 	return ret
 }
 
+func (root CustomSyncRoot) GetRemoteStreamCommand(sourceEnvironment Environment) SyncCommand {
+	return generateNoOpSyncCommand()
+}
+
+func (m CustomSyncRoot) GetLocalStreamCommand(targetEnvironment Environment) SyncCommand {
+	return generateNoOpSyncCommand()
+}
+
 func (m CustomSyncRoot) GetFilesToCleanup(environment Environment) []string {
 	transferResource := m.GetTransferResource(environment)
 	return []string{
@@ -171,5 +179,6 @@ func (m CustomSyncRoot) GetFilesToCleanup(environment Environment) []string {
 func (m CustomSyncRoot) GetTransferResource(environment Environment) SyncerTransferResource {
 	return SyncerTransferResource{
 		Name:        m.TransferResource,
-		IsDirectory: false}
+		IsDirectory: false,
+		Transport:   m.Transport}
 }
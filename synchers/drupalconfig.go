@@ -17,6 +17,7 @@ type DrupalconfigSyncRoot struct {
 	Config         BaseDrupalconfigSync
 	LocalOverrides DrupalconfigSyncLocal `yaml:"local"`
 	TransferId     string
+	Transport      string `yaml:"transport"`
 }
 
 type DrupalconfigSyncLocal struct {
@@ -91,6 +92,19 @@ func (m DrupalconfigSyncRoot) GetLocalCommand(environment Environment) []SyncCom
 
 }
 
+// IsImportRetryable is true since a config import always brings the site in line with the exported config
+func (m DrupalconfigSyncRoot) IsImportRetryable() bool {
+	return true
+}
+
+func (root DrupalconfigSyncRoot) GetRemoteStreamCommand(environment Environment) SyncCommand {
+	return generateNoOpSyncCommand()
+}
+
+func (m DrupalconfigSyncRoot) GetLocalStreamCommand(environment Environment) SyncCommand {
+	return generateNoOpSyncCommand()
+}
+
 func (m DrupalconfigSyncRoot) GetFilesToCleanup(environment Environment) []string {
 	transferResource := m.GetTransferResource(environment)
 	return []string{
@@ -101,7 +115,8 @@ func (m DrupalconfigSyncRoot) GetFilesToCleanup(environment Environment) []strin
 func (m DrupalconfigSyncRoot) GetTransferResource(environment Environment) SyncerTransferResource {
 	return SyncerTransferResource{
 		Name:        fmt.Sprintf("%vdrupalconfig-sync-%v", m.GetOutputDirectory(), m.TransferId),
-		IsDirectory: true}
+		IsDirectory: true,
+		Transport:   m.Transport}
 }
 
 func (m *DrupalconfigSyncRoot) SetTransferResource(transferResourceName string) error {
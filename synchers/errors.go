@@ -0,0 +1,12 @@
+package synchers
+
+import "errors"
+
+var (
+	// ErrConfigInvalid is returned when a syncer's configuration can't be used
+	ErrConfigInvalid = errors.New("invalid configuration")
+	// ErrTransferFailed is returned when a resource couldn't be transferred between environments
+	ErrTransferFailed = errors.New("transfer failed")
+	// ErrProtectedTarget is returned when a sync would overwrite a protected environment without being allowed to
+	ErrProtectedTarget = errors.New("protected target environment")
+)
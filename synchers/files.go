@@ -2,18 +2,40 @@ package synchers
 
 import (
 	"fmt"
-	"log"
 	"reflect"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/spf13/viper"
 	"github.com/uselagoon/lagoon-sync/utils"
 )
 
 type BaseFilesSync struct {
 	SyncPath string `yaml:"sync-directory"`
 	Exclude  []string
+	// Include limits the sync to the files matching these patterns, which match the way excludes do
+	Include []string `yaml:"include,omitempty"`
+	// MaxFileSize skips files larger than this - a number of bytes, or one with a k, M or G suffix
+	MaxFileSize string `yaml:"max-file-size,omitempty"`
+	// ModifiedSince skips files last modified before this - a date, or a duration before the sync like "30d"
+	ModifiedSince string `yaml:"modified-since,omitempty"`
+	// Paths are synced in place of the sync directory, each with its own filters, when several are synced at once
+	Paths []FilesSyncPath `yaml:"paths,omitempty"`
+}
+
+// FilesSyncPath is one of the directories synced by a files syncer with several
+type FilesSyncPath struct {
+	SyncPath       string             `yaml:"sync-directory"`
+	Include        []string           `yaml:"include,omitempty"`
+	Exclude        []string           `yaml:"exclude,omitempty"`
+	MaxFileSize    string             `yaml:"max-file-size,omitempty"`
+	ModifiedSince  string             `yaml:"modified-since,omitempty"`
+	LocalOverrides FilesSyncPathLocal `yaml:"local,omitempty"`
+}
+
+type FilesSyncPathLocal struct {
+	SyncPath string `yaml:"sync-directory,omitempty"`
 }
 
 func (filesConfig *BaseFilesSync) setDefaults() {
@@ -28,6 +50,20 @@ type FilesSyncRoot struct {
 	Config         BaseFilesSync
 	LocalOverrides FilesSyncLocal `yaml:"local"`
 	TransferId     string
+	Transport      string `yaml:"transport"`
+	// Incremental keeps a manifest of the files synced, so that the next sync only transfers those that have changed
+	Incremental bool `yaml:"incremental"`
+	// Placeholders replaces large images with generated ones of the same dimensions when syncing to local
+	Placeholders FilesPlaceholders `yaml:"placeholders"`
+
+	// preparedAt is when the syncer was prepared, which durations in modified-since are counted back from
+	preparedAt time.Time
+}
+
+type FilesPlaceholders struct {
+	Enabled bool `yaml:"enabled"`
+	// Threshold is the size an image has to be over to be replaced, in the same form as max-file-size
+	Threshold string `yaml:"threshold,omitempty"`
 }
 
 type FilesSyncLocal struct {
@@ -58,7 +94,9 @@ func (m FilesSyncPlugin) UnmarshallYaml(root SyncherConfigRoot, targetService st
 
 	// If yaml config is there then unmarshall into struct and override default values if there are any
 	if len(root.LagoonSync) != 0 {
-		_ = UnmarshalIntoStruct(configMap, &filesroot)
+		if err := UnmarshalIntoStruct(configMap, &filesroot); err != nil {
+			return nil, fmt.Errorf("%w: unable to parse the %s config: %v", ErrConfigInvalid, targetService, err)
+		}
 		utils.LogDebugInfo("Config that will be used for sync", filesroot)
 	}
 
@@ -69,11 +107,10 @@ func (m FilesSyncPlugin) UnmarshallYaml(root SyncherConfigRoot, targetService st
 
 	if filesroot.Config.IsBaseFilesSyncStructEmpty() && &filesroot == nil {
 		m.isConfigEmpty = true
-		log.Fatalf("No configuration could be found for %v in %v", m.GetPluginId(), viper.GetViper().ConfigFileUsed())
+		return nil, fmt.Errorf("%w: no configuration could be found for %v", ErrConfigInvalid, m.GetPluginId())
 	}
 
-	lagoonSyncer, _ := filesroot.PrepareSyncer()
-	return lagoonSyncer, nil
+	return filesroot.PrepareSyncer()
 }
 
 func init() {
@@ -85,10 +122,40 @@ func (m *FilesSyncRoot) IsInitialized() (bool, error) {
 }
 
 func (root *FilesSyncRoot) PrepareSyncer() (Syncer, error) {
-	root.TransferId = strconv.FormatInt(time.Now().UnixNano(), 10)
+	root.preparedAt = time.Now()
+	root.TransferId = strconv.FormatInt(root.preparedAt.UnixNano(), 10)
+	if _, err := parseFileSize(root.Placeholders.Threshold); err != nil {
+		return nil, fmt.Errorf("%w: invalid placeholder threshold: %v", ErrConfigInvalid, err)
+	}
+	for _, syncPath := range root.syncPaths() {
+		if syncPath.SyncPath == "" {
+			return nil, fmt.Errorf("%w: every one of the files syncer's paths needs a sync-directory", ErrConfigInvalid)
+		}
+		if _, err := parseFileSize(syncPath.MaxFileSize); err != nil {
+			return nil, fmt.Errorf("%w: invalid max-file-size for %s: %v", ErrConfigInvalid, syncPath.SyncPath, err)
+		}
+		if _, err := parseModifiedSince(syncPath.ModifiedSince, root.preparedAt); err != nil {
+			return nil, fmt.Errorf("%w: invalid modified-since for %s: %v", ErrConfigInvalid, syncPath.SyncPath, err)
+		}
+	}
 	return root, nil
 }
 
+// syncPaths are the directories the syncer syncs - its paths, or the sync directory if it has none
+func (root *FilesSyncRoot) syncPaths() []FilesSyncPath {
+	if len(root.Config.Paths) > 0 {
+		return root.Config.Paths
+	}
+	return []FilesSyncPath{{
+		SyncPath:       root.Config.SyncPath,
+		Include:        root.Config.Include,
+		Exclude:        root.Config.Exclude,
+		MaxFileSize:    root.Config.MaxFileSize,
+		ModifiedSince:  root.Config.ModifiedSince,
+		LocalOverrides: FilesSyncPathLocal{SyncPath: root.LocalOverrides.Config.SyncPath},
+	}}
+}
+
 func (root *FilesSyncRoot) GetPrerequisiteCommand(environment Environment, command string) SyncCommand {
 	return SyncCommand{}
 }
@@ -105,6 +172,80 @@ func (m *FilesSyncRoot) GetLocalCommand(environment Environment) []SyncCommand {
 	}
 }
 
+// IsImportRetryable is true since files are copied into place by the transfer, there's no import to repeat
+func (m *FilesSyncRoot) IsImportRetryable() bool {
+	return true
+}
+
+func (root *FilesSyncRoot) GetRemoteStreamCommand(environment Environment) SyncCommand {
+	return generateNoOpSyncCommand()
+}
+
+func (m *FilesSyncRoot) GetLocalStreamCommand(environment Environment) SyncCommand {
+	return generateNoOpSyncCommand()
+}
+
+// GetPlanCommand totals the files under each of the sync directories with rsync, so that the excludes, includes and
+// size limit are applied the same way the transfer applies them. Without rsync, the files are found with find,
+// which only matches the patterns against the names of files and directories. Neither can tell when files were
+// last modified, so modified-since is left out of the totals.
+func (m *FilesSyncRoot) GetPlanCommand(environment Environment) SyncCommand {
+	var commands []string
+	substitutions := map[string]interface{}{"status": SyncPlanItemSynced}
+	for i, transferResource := range m.GetTransferResources(environment) {
+		rsyncFilters := ""
+		findFilters := ""
+		for _, exclude := range transferResource.ExcludeResources {
+			rsyncFilters += " --exclude=" + shellQuote(exclude)
+			if findFilters != "" {
+				findFilters += " -o"
+			}
+			findFilters += " -name " + shellQuote(strings.Trim(exclude, "/"))
+		}
+		if findFilters != "" {
+			findFilters = ` \(` + findFilters + ` \) -prune -o`
+		}
+		if len(transferResource.IncludeResources) > 0 {
+			rsyncFilters += " --include='*/'"
+			findIncludes := ""
+			for _, include := range transferResource.IncludeResources {
+				rsyncFilters += " --include=" + shellQuote(include)
+				if findIncludes != "" {
+					findIncludes += " -o"
+				}
+				if strings.Contains(include, "/") {
+					findIncludes += " -path " + shellQuote("*/"+strings.TrimPrefix(include, "/"))
+				} else {
+					findIncludes += " -name " + shellQuote(include)
+				}
+			}
+			rsyncFilters += " --exclude='*'"
+			findFilters += ` \(` + findIncludes + ` \)`
+		}
+		if transferResource.MaxFileSize > 0 {
+			rsyncFilters += fmt.Sprintf(" --max-size=%d", transferResource.MaxFileSize)
+			findFilters += fmt.Sprintf(" -size -%dc", transferResource.MaxFileSize+1)
+		}
+
+		// Each directory has its own substitutions, numbered by its position
+		substitution := func(name string, value string) string {
+			key := name + strconv.Itoa(i)
+			substitutions[key] = value
+			return "{{ ." + key + " }}"
+		}
+		syncPath := substitution("syncPath", transferResource.Name)
+		total := `END { printf "%s\t%.0f\t%d\t{{ .status }}\n", path, bytes, files }`
+		commands = append(commands, `if command -v rsync >/dev/null 2>&1; then `+
+			`rsync -r --list-only`+substitution("rsyncFilters", rsyncFilters)+` `+syncPath+`/ | awk -v path=`+syncPath+` '$1 !~ /^d/ { gsub(",", "", $2); files++; bytes += $2 } `+total+`'; `+
+			`else find `+syncPath+substitution("findFilters", findFilters)+` -type f -exec ls -ln {} + | awk -v path=`+syncPath+` '{ files++; bytes += $5 } `+total+`'; fi`)
+	}
+	return generateSyncCommand(strings.Join(commands, "; "), substitutions)
+}
+
+func (m *FilesSyncRoot) GetPlanTools() ([]string, []string) {
+	return []string{"rsync"}, []string{"rsync"}
+}
+
 func (m *FilesSyncRoot) GetFilesToCleanup(environment Environment) []string {
 	transferResource := m.GetTransferResource(environment)
 	return []string{
@@ -112,35 +253,106 @@ func (m *FilesSyncRoot) GetFilesToCleanup(environment Environment) []string {
 	}
 }
 
+// GetTransferResource returns the first of the directories the syncer syncs
 func (m *FilesSyncRoot) GetTransferResource(environment Environment) SyncerTransferResource {
-	config := m.Config
-	if environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
-		config = m.getEffectiveLocalDetails()
+	return m.GetTransferResources(environment)[0]
+}
+
+// GetTransferResources returns each of the directories the syncer syncs, along with their filters
+func (m *FilesSyncRoot) GetTransferResources(environment Environment) []SyncerTransferResource {
+	preparedAt := m.preparedAt
+	if preparedAt.IsZero() {
+		preparedAt = time.Now()
+	}
+	// Checked by PrepareSyncer too
+	placeholderSize, _ := parseFileSize(m.Placeholders.Threshold)
+	if m.Placeholders.Threshold == "" {
+		placeholderSize = defaultPlaceholderSize
 	}
-	return SyncerTransferResource{
-		Name:             fmt.Sprintf(config.SyncPath),
-		IsDirectory:      true,
-		SkipCleanup:      true,
-		ExcludeResources: m.Config.Exclude,
+	var transferResources []SyncerTransferResource
+	for _, syncPath := range m.syncPaths() {
+		name := syncPath.SyncPath
+		if environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME && syncPath.LocalOverrides.SyncPath != "" {
+			name = syncPath.LocalOverrides.SyncPath
+		}
+		// Both have been checked by PrepareSyncer
+		maxFileSize, _ := parseFileSize(syncPath.MaxFileSize)
+		modifiedSince, _ := parseModifiedSince(syncPath.ModifiedSince, preparedAt)
+		transferResources = append(transferResources, SyncerTransferResource{
+			Name:             name,
+			IsDirectory:      true,
+			SkipCleanup:      true,
+			ExcludeResources: syncPath.Exclude,
+			IncludeResources: syncPath.Include,
+			MaxFileSize:      maxFileSize,
+			ModifiedSince:    modifiedSince,
+			Transport:        m.Transport,
+			Incremental:      m.Incremental,
+			Placeholders:     m.Placeholders.Enabled,
+			PlaceholderSize:  placeholderSize,
+		})
 	}
+	return transferResources
 }
 
 func (m *FilesSyncRoot) SetTransferResource(transferResourceName string) error {
 	return fmt.Errorf("Setting the transfer resource is not supported for files")
 }
 
-func (syncConfig *FilesSyncRoot) getEffectiveLocalDetails() BaseFilesSync {
-	returnDetails := BaseFilesSync{
-		SyncPath: syncConfig.Config.SyncPath,
+var fileSizePattern = regexp.MustCompile(`^(\d+)\s*([kKmMgG]?)[bB]?$`)
+
+// parseFileSize reads a size like "500k" or "10M", in multiples of 1024. An empty size is 0, meaning no limit.
+func parseFileSize(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+	match := fileSizePattern.FindStringSubmatch(strings.TrimSpace(size))
+	if match == nil {
+		return 0, fmt.Errorf("'%s' isn't a size like 500k, 10M or 1G", size)
+	}
+	bytes, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return 0, err
 	}
+	switch strings.ToLower(match[2]) {
+	case "k":
+		bytes *= 1024
+	case "m":
+		bytes *= 1024 * 1024
+	case "g":
+		bytes *= 1024 * 1024 * 1024
+	}
+	return bytes, nil
+}
+
+var modifiedSinceDurationPattern = regexp.MustCompile(`^(\d+)([dw])$`)
 
-	assignLocalOverride := func(target *string, override *string) {
-		if len(*override) > 0 {
-			*target = *override
+// parseModifiedSince reads a modified-since, which is either a date (2024-06-01), a time (2024-06-01T12:00:00Z), or
+// a duration before now - in days (30d), weeks (2w), or anything time.ParseDuration reads (12h). An empty
+// modified-since is the zero time, meaning no limit.
+func parseModifiedSince(modifiedSince string, now time.Time) (time.Time, error) {
+	modifiedSince = strings.TrimSpace(modifiedSince)
+	if modifiedSince == "" {
+		return time.Time{}, nil
+	}
+	if match := modifiedSinceDurationPattern.FindStringSubmatch(modifiedSince); match != nil {
+		count, err := strconv.Atoi(match[1])
+		if err != nil {
+			return time.Time{}, err
+		}
+		days := count
+		if match[2] == "w" {
+			days = count * 7
 		}
+		return now.AddDate(0, 0, -days), nil
 	}
-
-	//TODO: can this be replaced with reflection?
-	assignLocalOverride(&returnDetails.SyncPath, &syncConfig.LocalOverrides.Config.SyncPath)
-	return returnDetails
+	if duration, err := time.ParseDuration(modifiedSince); err == nil {
+		return now.Add(-duration), nil
+	}
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if parsed, err := time.Parse(layout, modifiedSince); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("'%s' isn't a date like 2024-06-01 or a duration like 30d", modifiedSince)
 }
@@ -0,0 +1,545 @@
+package synchers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uselagoon/lagoon-sync/utils"
+)
+
+// deletionPreviewLength is how many of the files a sync would delete are listed in its logs
+const deletionPreviewLength = 20
+
+// FileState is a file in a files sync's directory, as it was when it was listed
+type FileState struct {
+	Size  int64 `json:"size"`
+	Mtime int64 `json:"mtime"`
+	// Hash is the md5 of the file's contents, empty if it hasn't been hashed
+	Hash string `json:"hash,omitempty"`
+	// Placeholder is set if a placeholder was generated on the target in place of the file
+	Placeholder bool `json:"placeholder,omitempty"`
+}
+
+// FilesManifest records the files an incremental files sync last synced from the source to the target, so that
+// the next sync only transfers the files that have changed since
+type FilesManifest struct {
+	SourceEnvironment string               `json:"sourceEnvironment"`
+	TargetEnvironment string               `json:"targetEnvironment"`
+	Directory         string               `json:"directory"`
+	UpdatedAt         time.Time            `json:"updatedAt"`
+	Files             map[string]FileState `json:"files"`
+}
+
+// GetManifestDirectory returns where incremental files syncs keep their manifests - LAGOON_SYNC_MANIFEST_DIR if set,
+// otherwise a directory in the user's cache dir, so that they outlive the dumps in the temp dir
+func GetManifestDirectory() string {
+	if dir, exists := os.LookupEnv("LAGOON_SYNC_MANIFEST_DIR"); exists && dir != "" {
+		return dir
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+	return filepath.Join(cacheDir, "lagoon-sync", "manifests")
+}
+
+var unsafeManifestNameCharacters = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// manifestPath is where the manifest of the syncer's syncs of a directory from the source to the target is kept.
+// Syncers can sync several directories, which are told apart by a hash of their path.
+func manifestPath(directory string, syncerType string, sourceEnvironment Environment, targetEnvironment Environment, sourceDirectory string) string {
+	name := strings.Join([]string{sourceEnvironment.ProjectName, sourceEnvironment.EnvironmentName, targetEnvironment.EnvironmentName, syncerType}, "_")
+	directoryHash := md5.Sum([]byte(sourceDirectory))
+	return filepath.Join(directory, unsafeManifestNameCharacters.ReplaceAllString(name, "-")+"_"+hex.EncodeToString(directoryHash[:4])+".json")
+}
+
+// LoadFilesManifest reads a manifest, returning an empty one if there isn't one yet
+func LoadFilesManifest(manifestFile string) (*FilesManifest, error) {
+	manifest := &FilesManifest{Files: map[string]FileState{}}
+	data, err := os.ReadFile(manifestFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("unable to parse files manifest %v: %w", manifestFile, err)
+	}
+	if manifest.Files == nil {
+		manifest.Files = map[string]FileState{}
+	}
+	return manifest, nil
+}
+
+// Save writes the manifest, replacing the previous one only once it has been written in full
+func (m *FilesManifest) Save(manifestFile string) error {
+	if err := os.MkdirAll(filepath.Dir(manifestFile), 0700); err != nil {
+		return fmt.Errorf("unable to create manifest directory: %w", err)
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	temporaryFile := manifestFile + ".tmp"
+	if err := os.WriteFile(temporaryFile, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temporaryFile, manifestFile)
+}
+
+// filesSyncChanges is how the files on the source differ from those last synced to the target
+type filesSyncChanges struct {
+	// unchanged are the files that are the same as when they were last synced
+	unchanged map[string]FileState
+	// changed are the files that need to be transferred
+	changed []string
+	// touched are the files whose modification time has changed since they were last synced, but not their size.
+	// They have to be hashed to tell whether they have changed.
+	touched []string
+}
+
+// diffFiles compares the files on the source with those in the manifest of the last sync, and with those on the
+// target. Modification times aren't kept by the default rsync arguments, so the target's are never compared - a
+// file is only unchanged if it's the same on the source as when it was last synced, and the target still has a
+// file of its size.
+func diffFiles(sourceFiles map[string]FileState, targetFiles map[string]FileState, manifest *FilesManifest) filesSyncChanges {
+	changes := filesSyncChanges{unchanged: map[string]FileState{}}
+	for _, file := range sortedFileNames(sourceFiles) {
+		source := sourceFiles[file]
+		synced, wasSynced := manifest.Files[file]
+		target, onTarget := targetFiles[file]
+		switch {
+		case !wasSynced || synced.Placeholder || !onTarget || synced.Size != source.Size || target.Size != source.Size:
+			changes.changed = append(changes.changed, file)
+		case synced.Mtime != source.Mtime || synced.Hash == "":
+			changes.touched = append(changes.touched, file)
+		default:
+			changes.unchanged[file] = synced
+		}
+	}
+	return changes
+}
+
+// removedFiles are the files on the target that are no longer on the source
+func removedFiles(sourceFiles map[string]FileState, targetFiles map[string]FileState) []string {
+	var removed []string
+	for _, file := range sortedFileNames(targetFiles) {
+		if _, onSource := sourceFiles[file]; !onSource {
+			removed = append(removed, file)
+		}
+	}
+	return removed
+}
+
+// SyncRunIncrementalTransfer transfers each of the syncer's resources, file by file where the files have to be picked
+// out. The files in an incremental or filtered directory are listed on both ends, and only those that pass its
+// filters and have changed since the manifest of the last sync was kept are transferred. If args.Delete is set,
+// files that are no longer on the source are removed from the target.
+func SyncRunIncrementalTransfer(ctx context.Context, args RunSyncProcessFunctionTypeArguments) error {
+	logger := args.Logger
+	logger.LogProcessStep("Beginning file transfer, comparing the files on both ends", nil)
+	if skip, err := checkTransferEnvironments(args.SourceEnvironment, args.TargetEnvironment, logger); skip || err != nil {
+		return err
+	}
+
+	sourceResources := getTransferResources(args.LagoonSyncer, args.SourceEnvironment)
+	targetResources := getTransferResources(args.LagoonSyncer, args.TargetEnvironment)
+	for i, sourceResource := range sourceResources {
+		var err error
+		if args.Delete || sourceResource.listsFiles() || sourceResource.placeholdersFor(args.TargetEnvironment) {
+			err = syncRunFileListTransfer(ctx, args, sourceResource, targetResources[i])
+		} else {
+			err = runTransport(ctx, args.SourceEnvironment, args.TargetEnvironment, sourceResource, targetResources[i], args.DryRun, args.SshOptionWrapper, logger, args.Report)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncRunFileListTransfer transfers the files in a directory that need to be, and removes those that are no longer on
+// the source if args.Delete is set
+func syncRunFileListTransfer(ctx context.Context, args RunSyncProcessFunctionTypeArguments, sourceResource SyncerTransferResource, targetResource SyncerTransferResource) error {
+	logger := args.Logger
+	if !sourceResource.IsDirectory {
+		return fmt.Errorf("%w: the %s syncer doesn't transfer a directory, so its files can't be picked out", ErrConfigInvalid, args.SyncerType)
+	}
+	transport, err := GetTransport(sourceResource.Transport)
+	if err != nil {
+		return err
+	}
+
+	manifest := &FilesManifest{Files: map[string]FileState{}}
+	manifestFile := ""
+	if sourceResource.Incremental && args.ManifestDirectory != "" {
+		manifestFile = manifestPath(args.ManifestDirectory, args.SyncerType, args.SourceEnvironment, args.TargetEnvironment, sourceResource.Name)
+		if manifest, err = LoadFilesManifest(manifestFile); err != nil {
+			return err
+		}
+		// A manifest of another directory says nothing about this one
+		if manifest.Directory != sourceResource.Name {
+			manifest.Files = map[string]FileState{}
+		}
+	}
+
+	sourceFiles, err := listFiles(ctx, args.SourceEnvironment, sourceResource, args.SshOptionWrapper, logger)
+	if err != nil {
+		return fmt.Errorf("unable to list the files on %s: %w", args.SourceEnvironment.EnvironmentName, err)
+	}
+	targetFiles, err := listFiles(ctx, args.TargetEnvironment, targetResource, args.SshOptionWrapper, logger)
+	if err != nil {
+		return fmt.Errorf("unable to list the files on %s: %w", args.TargetEnvironment.EnvironmentName, err)
+	}
+
+	// Files that are too big or too old are left out of the transfer, but aren't removed from the target
+	selectedFiles := map[string]FileState{}
+	for file, state := range sourceFiles {
+		if sourceResource.selects(state) {
+			selectedFiles[file] = state
+		}
+	}
+	if len(selectedFiles) < len(sourceFiles) {
+		logger.LogProcessStep(fmt.Sprintf("%d of the %d files in %s are too large or too old to be synced",
+			len(sourceFiles)-len(selectedFiles), len(sourceFiles), sourceResource.Name), nil)
+	}
+
+	// Large images are set aside to be replaced by placeholders, rather than transferred
+	placeholderFiles := map[string]FileState{}
+	if sourceResource.placeholdersFor(args.TargetEnvironment) {
+		for file, state := range selectedFiles {
+			if sourceResource.replacesWithPlaceholder(file, state) {
+				placeholderFiles[file] = state
+				delete(selectedFiles, file)
+			}
+		}
+	}
+
+	changes := diffFiles(selectedFiles, targetFiles, manifest)
+	// Without filters or a manifest to compare with, the whole directory is transferred as it always is
+	var transferFiles []string
+	var hashes map[string]string
+	switch {
+	case sourceResource.Incremental:
+		if hashes, err = hashFiles(ctx, args.SourceEnvironment, sourceResource, append(changes.touched, changes.changed...), args.DryRun, args.SshOptionWrapper, logger); err != nil {
+			return fmt.Errorf("unable to hash the files on %s: %w", args.SourceEnvironment.EnvironmentName, err)
+		}
+		for _, file := range changes.touched {
+			if hash := hashes[file]; hash != "" && hash == manifest.Files[file].Hash {
+				changes.unchanged[file] = FileState{Size: selectedFiles[file].Size, Mtime: selectedFiles[file].Mtime, Hash: hash}
+			} else {
+				changes.changed = append(changes.changed, file)
+			}
+		}
+		sort.Strings(changes.changed)
+		// Nothing is transferred when nothing's changed, rather than the whole directory
+		transferFiles = append([]string{}, changes.changed...)
+		logger.LogProcessStep(fmt.Sprintf("%d of the %d files in %s on %s have changed since they were last synced",
+			len(changes.changed), len(selectedFiles), sourceResource.Name, args.SourceEnvironment.EnvironmentName), nil)
+	case sourceResource.filtersFiles() || len(placeholderFiles) > 0:
+		transferFiles = sortedFileNames(selectedFiles)
+	}
+
+	placeholders, unreadable, err := syncPlaceholders(ctx, args, sourceResource, targetResource, placeholderFiles, targetFiles, manifest)
+	if err != nil {
+		return err
+	}
+	// Images a placeholder couldn't be made for are synced as they are
+	if len(unreadable) > 0 {
+		transferFiles = append(transferFiles, unreadable...)
+		sort.Strings(transferFiles)
+		changes.changed = append(changes.changed, unreadable...)
+		for _, file := range unreadable {
+			selectedFiles[file] = placeholderFiles[file]
+		}
+	}
+
+	removed := removedFiles(sourceFiles, targetFiles)
+	if args.Delete && len(removed) > 0 {
+		previewDeletions(args, targetResource, removed)
+		if !args.DryRun && args.ConfirmDeletions != nil {
+			if err := args.ConfirmDeletions(args.TargetEnvironment, removed); err != nil {
+				return fmt.Errorf("the files to be removed weren't confirmed: %w", err)
+			}
+		}
+	}
+
+	if transferFiles == nil || len(transferFiles) > 0 {
+		err = transport.Transfer(ctx, TransportArguments{
+			SourceEnvironment: args.SourceEnvironment,
+			TargetEnvironment: args.TargetEnvironment,
+			SourceResource:    sourceResource,
+			TargetResource:    targetResource,
+			Files:             transferFiles,
+			DryRun:            args.DryRun,
+			SshOptionWrapper:  args.SshOptionWrapper,
+			Logger:            logger,
+			Report:            args.Report,
+		})
+		if err != nil {
+			return transferError(err)
+		}
+	}
+
+	if args.Delete && len(removed) > 0 {
+		if err := deleteFiles(ctx, args.TargetEnvironment, targetResource, removed, args.DryRun, args.SshOptionWrapper, logger); err != nil {
+			return fmt.Errorf("unable to remove files from %s: %w", args.TargetEnvironment.EnvironmentName, err)
+		}
+		if !args.DryRun {
+			args.Report.recordDeletions(len(removed))
+		}
+	}
+
+	if manifestFile == "" || args.DryRun {
+		return nil
+	}
+	synced := &FilesManifest{
+		SourceEnvironment: args.SourceEnvironment.EnvironmentName,
+		TargetEnvironment: args.TargetEnvironment.EnvironmentName,
+		Directory:         sourceResource.Name,
+		UpdatedAt:         time.Now(),
+		Files:             changes.unchanged,
+	}
+	for _, file := range changes.changed {
+		synced.Files[file] = FileState{Size: selectedFiles[file].Size, Mtime: selectedFiles[file].Mtime, Hash: hashes[file]}
+	}
+	for file, state := range placeholders {
+		synced.Files[file] = state
+	}
+	// The files have been synced either way, so failing to keep the manifest only costs the next sync time
+	if err := synced.Save(manifestFile); err != nil {
+		logger.LogWarning("Unable to save the files manifest, the next sync will transfer every file", err.Error())
+	}
+	return nil
+}
+
+type deleteEventFields struct {
+	Environment string   `json:"environment"`
+	Directory   string   `json:"directory"`
+	Files       []string `json:"files"`
+	DryRun      bool     `json:"dryRun"`
+}
+
+// previewDeletions lists the files a sync would remove from the target, before anything is removed
+func previewDeletions(args RunSyncProcessFunctionTypeArguments, targetResource SyncerTransferResource, removed []string) {
+	args.Logger.LogEvent("delete", deleteEventFields{
+		Environment: args.TargetEnvironment.EnvironmentName,
+		Directory:   targetResource.Name,
+		Files:       removed,
+		DryRun:      args.DryRun,
+	})
+	args.Logger.LogWarning(fmt.Sprintf("%d files in %s on %s are no longer on %s, and will be removed",
+		len(removed), targetResource.Name, args.TargetEnvironment.EnvironmentName, args.SourceEnvironment.EnvironmentName), nil)
+	for i, file := range removed {
+		if i == deletionPreviewLength {
+			args.Logger.LogProcessStep(fmt.Sprintf("  ... and %d more", len(removed)-deletionPreviewLength), nil)
+			break
+		}
+		args.Logger.LogProcessStep("  "+file, nil)
+	}
+}
+
+// listFiles lists the files in the transfer resource's directory, by their path relative to it. Excluded files
+// are left out, so that they're neither transferred nor removed. A directory that doesn't exist has no files.
+func listFiles(ctx context.Context, environment Environment, resource SyncerTransferResource, sshOptionWrapper *SSHOptionWrapper, logger *utils.Logger) (map[string]FileState, error) {
+	logger.LogProcessStep("Listing the files on", environment.EnvironmentName)
+	files := map[string]FileState{}
+
+	if environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
+		root := filepath.FromSlash(resource.Name)
+		err := filepath.WalkDir(root, func(name string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) && name == root {
+					return fs.SkipAll
+				}
+				return err
+			}
+			relative, err := filepath.Rel(root, name)
+			if err != nil || relative == "." {
+				return err
+			}
+			relative = filepath.ToSlash(relative)
+			if isExcluded(relative, resource.ExcludeResources) {
+				if entry.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if !entry.Type().IsRegular() || !isIncluded(relative, resource.IncludeResources) {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			files[relative] = FileState{Size: info.Size(), Mtime: info.ModTime().Unix()}
+			return nil
+		})
+		return files, err
+	}
+
+	command := generateSyncCommand(`if [ -d {{ .directory }} ]; then cd {{ .directory }} && find . -type f -exec stat -c '%s %Y %n' {} +; fi`,
+		map[string]interface{}{"directory": shellQuote(resource.Name)})
+	output, err := runCapturedCommand(ctx, environment, command, nil, sshOptionWrapper, logger)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 3)
+		if len(fields) != 3 || !strings.HasPrefix(fields[2], "./") {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		mtime, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		relative := strings.TrimPrefix(fields[2], "./")
+		if isExcludedPath(relative, resource.ExcludeResources) || !isIncluded(relative, resource.IncludeResources) {
+			continue
+		}
+		files[relative] = FileState{Size: size, Mtime: mtime}
+	}
+	return files, scanner.Err()
+}
+
+// isExcludedPath is isExcluded for a file, which is also excluded if any of the directories it's in are
+func isExcludedPath(relativePath string, excludes []string) bool {
+	for dir := path.Dir(relativePath); dir != "."; dir = path.Dir(dir) {
+		if isExcluded(dir, excludes) {
+			return true
+		}
+	}
+	return isExcluded(relativePath, excludes)
+}
+
+// isIncluded matches a file against include patterns, the same way isExcluded matches excludes. Every file is
+// included if there are no patterns.
+func isIncluded(relativePath string, includes []string) bool {
+	return len(includes) == 0 || isExcluded(relativePath, includes)
+}
+
+// hashFiles works out the md5 of each of the files in the transfer resource's directory
+func hashFiles(ctx context.Context, environment Environment, resource SyncerTransferResource, files []string, dryRun bool, sshOptionWrapper *SSHOptionWrapper, logger *utils.Logger) (map[string]string, error) {
+	hashes := map[string]string{}
+	if len(files) == 0 || dryRun {
+		return hashes, nil
+	}
+	logger.LogProcessStep(fmt.Sprintf("Hashing %d files on", len(files)), environment.EnvironmentName)
+
+	if environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
+		for _, file := range files {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			hash, err := hashLocalFile(filepath.Join(filepath.FromSlash(resource.Name), filepath.FromSlash(file)))
+			if err != nil {
+				return nil, err
+			}
+			hashes[file] = hash
+		}
+		return hashes, nil
+	}
+
+	command := generateSyncCommand(`cd {{ .directory }} && tr '\n' '\0' | xargs -0 -r md5sum`,
+		map[string]interface{}{"directory": shellQuote(resource.Name)})
+	output, err := runCapturedCommand(ctx, environment, command, fileListReader(files), sshOptionWrapper, logger)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		// md5sum escapes the lines of files with unusual names with a leading backslash, they're left unhashed
+		hash, file, found := strings.Cut(scanner.Text(), "  ")
+		if found && len(hash) == md5.Size*2 {
+			hashes[file] = hash
+		}
+	}
+	return hashes, scanner.Err()
+}
+
+func hashLocalFile(name string) (string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := md5.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// deleteFiles removes files from the transfer resource's directory. The directories they were in are left.
+func deleteFiles(ctx context.Context, environment Environment, resource SyncerTransferResource, files []string, dryRun bool, sshOptionWrapper *SSHOptionWrapper, logger *utils.Logger) error {
+	command := generateSyncCommand(`cd {{ .directory }} && tr '\n' '\0' | xargs -0 -r rm -f --`,
+		map[string]interface{}{"directory": shellQuote(resource.Name)})
+	loggedString, _ := command.GetRedactedCommand()
+	logger.LogExecutionStep(fmt.Sprintf("Removing %d files on %s with", len(files), environment.EnvironmentName), loggedString)
+	if dryRun {
+		return nil
+	}
+
+	if environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
+		for _, file := range files {
+			err := os.Remove(filepath.Join(filepath.FromSlash(resource.Name), filepath.FromSlash(file)))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		return nil
+	}
+	_, err := runCapturedCommand(ctx, environment, command, fileListReader(files), sshOptionWrapper, logger)
+	return err
+}
+
+// runCapturedCommand runs a command in the environment with the given stdin, and returns what it wrote to stdout
+func runCapturedCommand(ctx context.Context, environment Environment, command SyncCommand, stdin io.Reader, sshOptionWrapper *SSHOptionWrapper, logger *utils.Logger) (string, error) {
+	rendered, err := renderCommand(command)
+	if err != nil {
+		return "", err
+	}
+	logger.LogExecutionStep(fmt.Sprintf("Running the following on %s", environment.EnvironmentName), rendered.loggedString)
+	if stdin == nil {
+		stdin = strings.NewReader("")
+	}
+	var stdout bytes.Buffer
+	err = runStreamCommand(ctx, environment, rendered, stdin, &stdout, sshOptionWrapper, logger)
+	return stdout.String(), err
+}
+
+// fileListReader reads a list of files one per line, the way rsync's --files-from and xargs take them
+func fileListReader(files []string) io.Reader {
+	return strings.NewReader(strings.Join(files, "\n") + "\n")
+}
+
+func sortedFileNames(files map[string]FileState) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,216 @@
+package synchers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffFiles(t *testing.T) {
+	manifest := &FilesManifest{Files: map[string]FileState{
+		"same.jpg":     {Size: 10, Mtime: 100, Hash: "a"},
+		"touched.jpg":  {Size: 10, Mtime: 100, Hash: "b"},
+		"resized.jpg":  {Size: 10, Mtime: 100, Hash: "c"},
+		"lost.jpg":     {Size: 10, Mtime: 100, Hash: "d"},
+		"unhashed.jpg": {Size: 10, Mtime: 100},
+	}}
+	source := map[string]FileState{
+		"same.jpg":     {Size: 10, Mtime: 100},
+		"touched.jpg":  {Size: 10, Mtime: 200},
+		"resized.jpg":  {Size: 20, Mtime: 200},
+		"lost.jpg":     {Size: 10, Mtime: 100},
+		"unhashed.jpg": {Size: 10, Mtime: 100},
+		"new.jpg":      {Size: 5, Mtime: 300},
+	}
+	target := map[string]FileState{
+		"same.jpg":     {Size: 10, Mtime: 999},
+		"touched.jpg":  {Size: 10, Mtime: 999},
+		"resized.jpg":  {Size: 10, Mtime: 999},
+		"unhashed.jpg": {Size: 10, Mtime: 999},
+		"old.jpg":      {Size: 1, Mtime: 999},
+	}
+
+	got := diffFiles(source, target, manifest)
+	want := filesSyncChanges{
+		unchanged: map[string]FileState{"same.jpg": {Size: 10, Mtime: 100, Hash: "a"}},
+		changed:   []string{"lost.jpg", "new.jpg", "resized.jpg"},
+		touched:   []string{"touched.jpg", "unhashed.jpg"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffFiles() = %+v, want %+v", got, want)
+	}
+
+	// Without a manifest, every file is transferred
+	got = diffFiles(source, target, &FilesManifest{Files: map[string]FileState{}})
+	if len(got.changed) != len(source) || len(got.unchanged) != 0 || len(got.touched) != 0 {
+		t.Errorf("diffFiles() without a manifest = %+v, want every file changed", got)
+	}
+
+	if removed := removedFiles(source, target); !reflect.DeepEqual(removed, []string{"old.jpg"}) {
+		t.Errorf("removedFiles() = %v", removed)
+	}
+}
+
+func TestFilesManifest_SaveAndLoad(t *testing.T) {
+	manifests := filepath.Join(t.TempDir(), "manifests")
+	source := Environment{ProjectName: "project", EnvironmentName: "pr-1/main"}
+	target := Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME}
+	manifestFile := manifestPath(manifests, "files", source, target, "/app/web/sites/default/files")
+	if name := filepath.Base(manifestFile); !strings.HasPrefix(name, "project_pr-1-main_local_files_") || !strings.HasSuffix(name, ".json") {
+		t.Errorf("manifestPath() = %v", manifestFile)
+	}
+	if manifestPath(manifests, "files", source, target, "/app/web/sites/default/private") == manifestFile {
+		t.Errorf("manifestPath() is the same for different directories")
+	}
+
+	manifest, err := LoadFilesManifest(manifestFile)
+	if err != nil || len(manifest.Files) != 0 {
+		t.Fatalf("LoadFilesManifest() of a missing manifest = %v, %v", manifest, err)
+	}
+
+	manifest.Directory = "/app/web/sites/default/files"
+	manifest.Files["a.jpg"] = FileState{Size: 1, Mtime: 2, Hash: "3"}
+	if err := manifest.Save(manifestFile); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	loaded, err := LoadFilesManifest(manifestFile)
+	if err != nil {
+		t.Fatalf("LoadFilesManifest() error = %v", err)
+	}
+	if loaded.Directory != manifest.Directory || !reflect.DeepEqual(loaded.Files, manifest.Files) {
+		t.Errorf("LoadFilesManifest() = %+v, want %+v", loaded, manifest)
+	}
+}
+
+func TestListAndDeleteFiles_Local(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.jpg", "nested/b.jpg", "css/style.css", "nested/tmp.log"} {
+		p := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		os.WriteFile(p, []byte(name), 0644)
+	}
+	local := Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME}
+	resource := SyncerTransferResource{Name: dir, IsDirectory: true, ExcludeResources: []string{"css", "*.log"}}
+
+	files, err := listFiles(context.Background(), local, resource, &SSHOptionWrapper{}, nil)
+	if err != nil {
+		t.Fatalf("listFiles() error = %v", err)
+	}
+	if got := sortedFileNames(files); !reflect.DeepEqual(got, []string{"a.jpg", "nested/b.jpg"}) {
+		t.Errorf("listFiles() = %v", got)
+	}
+	if files["nested/b.jpg"].Size != int64(len("nested/b.jpg")) {
+		t.Errorf("listFiles() size = %v", files["nested/b.jpg"].Size)
+	}
+
+	hashes, err := hashFiles(context.Background(), local, resource, []string{"a.jpg"}, false, &SSHOptionWrapper{}, nil)
+	if err != nil || hashes["a.jpg"] != "394659692a460258b45a99f1424ea357" {
+		t.Errorf("hashFiles() = %v, %v", hashes, err)
+	}
+
+	if err := deleteFiles(context.Background(), local, resource, []string{"nested/b.jpg", "missing.jpg"}, false, &SSHOptionWrapper{}, nil); err != nil {
+		t.Fatalf("deleteFiles() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "nested", "b.jpg")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("deleteFiles() didn't remove nested/b.jpg")
+	}
+
+	// Only the files matching an include pattern are listed, from any directory
+	resource.IncludeResources = []string{"*.jpg"}
+	os.WriteFile(filepath.Join(dir, "nested", "c.png"), []byte("c"), 0644)
+	files, err = listFiles(context.Background(), local, resource, &SSHOptionWrapper{}, nil)
+	if got := sortedFileNames(files); err != nil || !reflect.DeepEqual(got, []string{"a.jpg"}) {
+		t.Errorf("listFiles() with includes = %v, %v", got, err)
+	}
+
+	// A directory that isn't there yet has no files
+	files, err = listFiles(context.Background(), local, SyncerTransferResource{Name: filepath.Join(dir, "missing"), IsDirectory: true}, &SSHOptionWrapper{}, nil)
+	if err != nil || len(files) != 0 {
+		t.Errorf("listFiles() of a missing directory = %v, %v", files, err)
+	}
+}
+
+func TestRunSyncProcess_DeleteNeedsADirectory(t *testing.T) {
+	err := RunSyncProcess(context.Background(), RunSyncProcessFunctionTypeArguments{
+		SourceEnvironment: Environment{EnvironmentName: "main"},
+		TargetEnvironment: Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME},
+		LagoonSyncer:      journalTestSyncer{dir: t.TempDir()},
+		SyncerType:        "test",
+		SshOptionWrapper:  &SSHOptionWrapper{},
+		Delete:            true,
+	})
+	if !errors.Is(err, ErrConfigInvalid) {
+		t.Errorf("RunSyncProcess() error = %v, want ErrConfigInvalid", err)
+	}
+}
+
+// recordingTransport copies the files it's asked to between local directories, recording each transfer
+type recordingTransport struct {
+	transfers *[][]string
+}
+
+func (r recordingTransport) GetTransportId() string {
+	return "recording"
+}
+
+func (r recordingTransport) Transfer(ctx context.Context, args TransportArguments) error {
+	*r.transfers = append(*r.transfers, args.Files)
+	for _, file := range args.Files {
+		contents, err := os.ReadFile(filepath.Join(args.SourceResource.Name, file))
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(args.TargetResource.Name, file)), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(args.TargetResource.Name, file), contents, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestSyncRunFileListTransfer_Unchanged(t *testing.T) {
+	var transfers [][]string
+	RegisterTransport(recordingTransport{transfers: &transfers})
+	defer delete(transportMap, "recording")
+
+	source := t.TempDir()
+	target := t.TempDir()
+	os.WriteFile(filepath.Join(source, "a.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(source, "large.txt"), []byte("too large to sync"), 0644)
+	local := Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME}
+	sourceResource := SyncerTransferResource{Name: source, IsDirectory: true, Transport: "recording", Incremental: true, MaxFileSize: 1}
+	targetResource := SyncerTransferResource{Name: target, IsDirectory: true, Transport: "recording", Incremental: true, MaxFileSize: 1}
+	args := RunSyncProcessFunctionTypeArguments{
+		SourceEnvironment: local,
+		TargetEnvironment: local,
+		SyncerType:        "files",
+		SshOptionWrapper:  &SSHOptionWrapper{},
+		ManifestDirectory: t.TempDir(),
+		Report:            &SyncReport{},
+	}
+
+	if err := syncRunFileListTransfer(context.Background(), args, sourceResource, targetResource); err != nil {
+		t.Fatalf("syncRunFileListTransfer() error = %v", err)
+	}
+	if !reflect.DeepEqual(transfers, [][]string{{"a.txt"}}) {
+		t.Fatalf("first sync transferred %v, want only a.txt", transfers)
+	}
+
+	// Nothing's changed since, so nothing is transferred - in particular not the whole directory, which would bring
+	// the file that's too large with it
+	if err := syncRunFileListTransfer(context.Background(), args, sourceResource, targetResource); err != nil {
+		t.Fatalf("syncRunFileListTransfer() error = %v", err)
+	}
+	if len(transfers) != 1 {
+		t.Errorf("an unchanged sync transferred %v", transfers[1:])
+	}
+	if _, err := os.Stat(filepath.Join(target, "large.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the file that's too large was synced")
+	}
+}
@@ -0,0 +1,218 @@
+package synchers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/uselagoon/lagoon-sync/utils"
+)
+
+// defaultPlaceholderSize is how large an image has to be to be replaced by a placeholder, if no threshold is set
+const defaultPlaceholderSize = 100 * 1024
+
+// imageHeaderLength is how much of each image is read to find its dimensions. PNGs and GIFs have theirs at the
+// start, but JPEGs can have up to 64k of metadata, and a thumbnail, before them.
+const imageHeaderLength = 128 * 1024
+
+// imageHeaderLengthDigits is the width of the length written before each of the image headers read from a remote
+const imageHeaderLengthDigits = 12
+
+// maxPlaceholderPixels is the most pixels a placeholder is generated with, anything larger is synced as it is
+const maxPlaceholderPixels = 100_000_000
+
+// placeholderExtensions are the images that can be replaced by placeholders, all of them formats Go can write
+var placeholderExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true}
+
+// placeholderFormats are the formats, as image.DecodeConfig names them, that placeholders are written in
+var placeholderFormats = map[string]bool{"jpeg": true, "png": true, "gif": true}
+
+var placeholderColor = color.Gray{Y: 0xcc}
+
+// replacesWithPlaceholder reports whether a file in the resource's directory is an image large enough to be
+// replaced by a placeholder
+func (r SyncerTransferResource) replacesWithPlaceholder(file string, state FileState) bool {
+	return placeholderExtensions[strings.ToLower(path.Ext(file))] && state.Size > r.PlaceholderSize
+}
+
+// syncPlaceholders generates placeholders on the target for the given images, with the same dimensions and format
+// as those on the source. Placeholders that are already on the target for an image that hasn't changed since are
+// kept. It returns the files that have placeholders, as they're recorded in the manifest, and the images whose
+// dimensions couldn't be read, which have to be synced as they are.
+func syncPlaceholders(ctx context.Context, args RunSyncProcessFunctionTypeArguments, sourceResource SyncerTransferResource, targetResource SyncerTransferResource, files map[string]FileState, targetFiles map[string]FileState, manifest *FilesManifest) (map[string]FileState, []string, error) {
+	logger := args.Logger
+	placeholders := map[string]FileState{}
+	if len(files) == 0 {
+		return placeholders, nil, nil
+	}
+
+	var generate []string
+	for _, file := range sortedFileNames(files) {
+		state := files[file]
+		synced, wasSynced := manifest.Files[file]
+		_, onTarget := targetFiles[file]
+		if wasSynced && synced.Placeholder && onTarget && synced.Size == state.Size && synced.Mtime == state.Mtime {
+			placeholders[file] = synced
+			continue
+		}
+		generate = append(generate, file)
+	}
+	logger.LogProcessStep(fmt.Sprintf("Generating placeholders for %d of the %d images in %s larger than %d bytes",
+		len(generate), len(files), sourceResource.Name, sourceResource.PlaceholderSize), nil)
+	if len(generate) == 0 || args.DryRun {
+		return placeholders, nil, nil
+	}
+
+	var unreadable []string
+	err := readImageHeaders(ctx, args.SourceEnvironment, sourceResource, generate, args.SshOptionWrapper, logger, func(file string, header []byte) error {
+		config, format, err := image.DecodeConfig(bytes.NewReader(header))
+		if err != nil || !placeholderFormats[format] || config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxPlaceholderPixels {
+			unreadable = append(unreadable, file)
+			return nil
+		}
+		name := filepath.Join(filepath.FromSlash(targetResource.Name), filepath.FromSlash(file))
+		if err := writePlaceholder(name, config, format); err != nil {
+			return fmt.Errorf("unable to write a placeholder for %s: %w", file, err)
+		}
+		placeholders[file] = FileState{Size: files[file].Size, Mtime: files[file].Mtime, Placeholder: true}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	args.Report.recordPlaceholders(len(generate) - len(unreadable))
+	if len(unreadable) > 0 {
+		logger.LogWarning(fmt.Sprintf("The dimensions of %d images couldn't be read, so they'll be synced rather than replaced by placeholders", len(unreadable)), unreadable)
+	}
+	return placeholders, unreadable, nil
+}
+
+// readImageHeaders reads the start of each of the files in the resource's directory, enough to find an image's
+// dimensions, and hands it to onHeader. Files that can't be read are handed over empty.
+func readImageHeaders(ctx context.Context, environment Environment, resource SyncerTransferResource, files []string, sshOptionWrapper *SSHOptionWrapper, logger *utils.Logger, onHeader func(file string, header []byte) error) error {
+	if environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
+		for _, file := range files {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			header, err := readLocalImageHeader(filepath.Join(filepath.FromSlash(resource.Name), filepath.FromSlash(file)))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			if err := onHeader(file, header); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	rendered, err := renderCommand(imageHeadersCommand(resource))
+	if err != nil {
+		return err
+	}
+	logger.LogExecutionStep(fmt.Sprintf("Reading the dimensions of %d images on %s with", len(files), environment.EnvironmentName), rendered.loggedString)
+	headers := &imageHeaderWriter{files: files, onHeader: onHeader}
+	if err := runStreamCommand(ctx, environment, rendered, fileListReader(files), headers, sshOptionWrapper, logger); err != nil {
+		return err
+	}
+	if len(headers.files) > 0 {
+		return fmt.Errorf("only %d of the %d image headers were read", len(files)-len(headers.files), len(files))
+	}
+	return nil
+}
+
+// imageHeadersCommand reads the start of each of the files listed on its stdin. Each is written after its length,
+// so that they can be told apart on the way back.
+func imageHeadersCommand(resource SyncerTransferResource) SyncCommand {
+	return generateSyncCommand(`tmp=$(mktemp) && cd {{ .directory }} && while IFS= read -r file; do head -c {{ .length }} -- "$file" > "$tmp" 2>/dev/null || : > "$tmp"; printf '%0{{ .digits }}d' "$(($(wc -c < "$tmp")))"; cat "$tmp"; done; rm -f "$tmp"`,
+		map[string]interface{}{"directory": shellQuote(resource.Name), "length": imageHeaderLength, "digits": imageHeaderLengthDigits})
+}
+
+func readLocalImageHeader(name string) ([]byte, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(io.LimitReader(file, imageHeaderLength))
+}
+
+// imageHeaderWriter splits the image headers written by readImageHeaders' remote command as they arrive, so that only
+// one of them is held at a time
+type imageHeaderWriter struct {
+	files    []string
+	onHeader func(file string, header []byte) error
+	buffer   []byte
+}
+
+func (w *imageHeaderWriter) Write(p []byte) (int, error) {
+	w.buffer = append(w.buffer, p...)
+	for len(w.buffer) >= imageHeaderLengthDigits {
+		length, err := strconv.Atoi(string(w.buffer[:imageHeaderLengthDigits]))
+		if err != nil || length < 0 || length > imageHeaderLength {
+			return 0, fmt.Errorf("unexpected output while reading image headers")
+		}
+		end := imageHeaderLengthDigits + length
+		if len(w.buffer) < end {
+			break
+		}
+		if len(w.files) == 0 {
+			return 0, fmt.Errorf("more image headers were read than there are images")
+		}
+		if err := w.onHeader(w.files[0], w.buffer[imageHeaderLengthDigits:end]); err != nil {
+			return 0, err
+		}
+		w.files = w.files[1:]
+		w.buffer = append(w.buffer[:0], w.buffer[end:]...)
+	}
+	return len(p), nil
+}
+
+// uniformImage is a single colour image with bounds, which the encoders can write without it being held in memory
+type uniformImage struct {
+	*image.Uniform
+	bounds image.Rectangle
+}
+
+func (u uniformImage) Bounds() image.Rectangle {
+	return u.bounds
+}
+
+// writePlaceholder writes a plain image with the given dimensions, in the format the original image is in
+func writePlaceholder(name string, config image.Config, format string) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	bounds := image.Rect(0, 0, config.Width, config.Height)
+	placeholder := uniformImage{Uniform: image.NewUniform(placeholderColor), bounds: bounds}
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(file, placeholder, nil)
+	case "png":
+		err = png.Encode(file, placeholder)
+	case "gif":
+		err = gif.Encode(file, image.NewPaletted(bounds, color.Palette{placeholderColor}), nil)
+	default:
+		err = fmt.Errorf("placeholders can't be written as %s", format)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
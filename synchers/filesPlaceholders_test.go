@@ -0,0 +1,160 @@
+package synchers
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTestImage(t *testing.T, name string, width int, height int) {
+	t.Helper()
+	os.MkdirAll(filepath.Dir(name), 0755)
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWritePlaceholder(t *testing.T) {
+	for _, format := range []string{"jpeg", "png", "gif"} {
+		t.Run(format, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "nested", "image")
+			if err := writePlaceholder(name, image.Config{Width: 640, Height: 480}, format); err != nil {
+				t.Fatalf("writePlaceholder() error = %v", err)
+			}
+			file, err := os.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			config, gotFormat, err := image.DecodeConfig(file)
+			if err != nil || gotFormat != format || config.Width != 640 || config.Height != 480 {
+				t.Errorf("placeholder is a %dx%d %s, %v", config.Width, config.Height, gotFormat, err)
+			}
+		})
+	}
+}
+
+func TestImageHeadersCommand(t *testing.T) {
+	dir := t.TempDir()
+	writeTestImage(t, filepath.Join(dir, "a b.png"), 30, 20)
+	writeTestImage(t, filepath.Join(dir, "nested", "c.png"), 1, 2)
+	files := []string{"a b.png", "missing.png", "nested/c.png"}
+
+	headers := map[string][]byte{}
+	writer := &imageHeaderWriter{files: files, onHeader: func(file string, header []byte) error {
+		headers[file] = append([]byte{}, header...)
+		return nil
+	}}
+	rendered, err := renderCommand(imageHeadersCommand(SyncerTransferResource{Name: dir}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The headers are split up however they arrive
+	err = runStreamCommand(context.Background(), Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME}, rendered,
+		fileListReader(files), writerFunc(func(p []byte) (int, error) {
+			for i := range p {
+				if _, err := writer.Write(p[i : i+1]); err != nil {
+					return i, err
+				}
+			}
+			return len(p), nil
+		}), &SSHOptionWrapper{}, nil)
+	if err != nil {
+		t.Fatalf("imageHeadersCommand() error = %v", err)
+	}
+	if len(writer.files) != 0 || len(headers["missing.png"]) != 0 {
+		t.Errorf("imageHeadersCommand() headers = %v", headers)
+	}
+	for file, want := range map[string][2]int{"a b.png": {30, 20}, "nested/c.png": {1, 2}} {
+		config, err := png.DecodeConfig(bytes.NewReader(headers[file]))
+		if err != nil || config.Width != want[0] || config.Height != want[1] {
+			t.Errorf("header of %s = %+v, %v", file, config, err)
+		}
+	}
+}
+
+func TestSyncPlaceholders(t *testing.T) {
+	source := t.TempDir()
+	target := t.TempDir()
+	writeTestImage(t, filepath.Join(source, "large.png"), 300, 200)
+	writeTestImage(t, filepath.Join(source, "kept.png"), 10, 10)
+	os.WriteFile(filepath.Join(source, "broken.jpg"), []byte("not a jpeg"), 0644)
+
+	files := map[string]FileState{
+		"large.png":  {Size: 1000, Mtime: 1},
+		"kept.png":   {Size: 1000, Mtime: 1},
+		"broken.jpg": {Size: 1000, Mtime: 1},
+	}
+	manifest := &FilesManifest{Files: map[string]FileState{"kept.png": {Size: 1000, Mtime: 1, Placeholder: true}}}
+	report := &SyncReport{}
+	args := RunSyncProcessFunctionTypeArguments{
+		SourceEnvironment: Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME},
+		TargetEnvironment: Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME},
+		SshOptionWrapper:  &SSHOptionWrapper{},
+		Report:            report,
+	}
+
+	placeholders, unreadable, err := syncPlaceholders(context.Background(), args, SyncerTransferResource{Name: source}, SyncerTransferResource{Name: target},
+		files, map[string]FileState{"kept.png": {Size: 10}}, manifest)
+	if err != nil {
+		t.Fatalf("syncPlaceholders() error = %v", err)
+	}
+	if !reflect.DeepEqual(unreadable, []string{"broken.jpg"}) {
+		t.Errorf("syncPlaceholders() unreadable = %v", unreadable)
+	}
+	if len(placeholders) != 2 || !placeholders["large.png"].Placeholder || !placeholders["kept.png"].Placeholder {
+		t.Errorf("syncPlaceholders() = %v", placeholders)
+	}
+	if report.Placeholders != 1 {
+		t.Errorf("syncPlaceholders() recorded %d placeholders, want 1", report.Placeholders)
+	}
+	// Only the placeholder that wasn't already on the target is written
+	if _, err := os.Stat(filepath.Join(target, "kept.png")); err == nil {
+		t.Errorf("syncPlaceholders() rewrote an unchanged placeholder")
+	}
+	file, err := os.Open(filepath.Join(target, "large.png"))
+	if err != nil {
+		t.Fatalf("syncPlaceholders() didn't write large.png: %v", err)
+	}
+	defer file.Close()
+	if config, err := png.DecodeConfig(file); err != nil || config.Width != 300 || config.Height != 200 {
+		t.Errorf("placeholder of large.png = %+v, %v", config, err)
+	}
+}
+
+func TestSyncerTransferResource_ReplacesWithPlaceholder(t *testing.T) {
+	resource := SyncerTransferResource{PlaceholderSize: 100}
+	tests := []struct {
+		file string
+		size int64
+		want bool
+	}{
+		{file: "photo.JPG", size: 101, want: true},
+		{file: "nested/diagram.png", size: 101, want: true},
+		{file: "icon.png", size: 100, want: false},
+		{file: "document.pdf", size: 1000, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			if got := resource.replacesWithPlaceholder(tt.file, FileState{Size: tt.size}); got != tt.want {
+				t.Errorf("replacesWithPlaceholder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
@@ -0,0 +1,152 @@
+package synchers
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseFileSize(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{size: "", want: 0},
+		{size: "512", want: 512},
+		{size: "500k", want: 500 * 1024},
+		{size: "10M", want: 10 * 1024 * 1024},
+		{size: "1GB", want: 1024 * 1024 * 1024},
+		{size: "2 mb", want: 2 * 1024 * 1024},
+		{size: "ten megabytes", wantErr: true},
+		{size: "-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.size, func(t *testing.T) {
+			got, err := parseFileSize(tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFileSize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseFileSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseModifiedSince(t *testing.T) {
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		modifiedSince string
+		want          time.Time
+		wantErr       bool
+	}{
+		{modifiedSince: "", want: time.Time{}},
+		{modifiedSince: "30d", want: time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC)},
+		{modifiedSince: "2w", want: time.Date(2024, 6, 16, 12, 0, 0, 0, time.UTC)},
+		{modifiedSince: "12h", want: time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC)},
+		{modifiedSince: "2024-06-01", want: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{modifiedSince: "2024-06-01T08:00:00Z", want: time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC)},
+		{modifiedSince: "last month", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.modifiedSince, func(t *testing.T) {
+			got, err := parseModifiedSince(tt.modifiedSince, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseModifiedSince() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseModifiedSince() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilesSyncRoot_GetTransferResources(t *testing.T) {
+	root := SyncherConfigRoot{LagoonSync: map[string]interface{}{
+		"files": map[string]interface{}{
+			"transport":    "sftp",
+			"incremental":  true,
+			"placeholders": map[string]interface{}{"enabled": true},
+			"config": map[string]interface{}{
+				"paths": []interface{}{
+					map[string]interface{}{
+						"sync-directory": "/app/web/sites/default/files",
+						"exclude":        []interface{}{"css", "js"},
+						"max-file-size":  "10M",
+						"local":          map[string]interface{}{"sync-directory": "web/sites/default/files"},
+					},
+					map[string]interface{}{
+						"sync-directory": "/app/private",
+						"include":        []interface{}{"*.pdf"},
+						"modified-since": "30d",
+					},
+				},
+			},
+		},
+	}}
+	syncer, err := FilesSyncPlugin{}.UnmarshallYaml(root, "files")
+	if err != nil {
+		t.Fatalf("UnmarshallYaml() error = %v", err)
+	}
+
+	remote := getTransferResources(syncer, Environment{EnvironmentName: "main"})
+	local := getTransferResources(syncer, Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME})
+	if len(remote) != 2 || len(local) != 2 {
+		t.Fatalf("GetTransferResources() = %+v, want two resources", remote)
+	}
+	if remote[0].Name != "/app/web/sites/default/files" || local[0].Name != "web/sites/default/files" || local[1].Name != "/app/private" {
+		t.Errorf("GetTransferResources() names = %v, %v, %v", remote[0].Name, local[0].Name, local[1].Name)
+	}
+	if !reflect.DeepEqual(remote[0].ExcludeResources, []string{"css", "js"}) || remote[0].MaxFileSize != 10*1024*1024 {
+		t.Errorf("GetTransferResources() first path = %+v", remote[0])
+	}
+	if !reflect.DeepEqual(remote[1].IncludeResources, []string{"*.pdf"}) || remote[1].ModifiedSince.IsZero() {
+		t.Errorf("GetTransferResources() second path = %+v", remote[1])
+	}
+	for _, resource := range remote {
+		if resource.Transport != "sftp" || !resource.Incremental || !resource.listsFiles() {
+			t.Errorf("GetTransferResources() = %+v, want an incremental sftp transfer", resource)
+		}
+		if !resource.Placeholders || resource.PlaceholderSize != defaultPlaceholderSize {
+			t.Errorf("GetTransferResources() = %+v, want placeholders for images over the default size", resource)
+		}
+	}
+	if syncer.GetTransferResource(Environment{EnvironmentName: "main"}).Name != remote[0].Name {
+		t.Errorf("GetTransferResource() isn't the first path")
+	}
+
+	// A bad filter is caught when the syncer is configured, rather than halfway through a sync
+	root.LagoonSync["files"].(map[string]interface{})["config"] = map[string]interface{}{
+		"sync-directory": "/app/web/sites/default/files",
+		"max-file-size":  "huge",
+	}
+	if _, err := (FilesSyncPlugin{}).UnmarshallYaml(root, "files"); !errors.Is(err, ErrConfigInvalid) {
+		t.Errorf("UnmarshallYaml() error = %v, want ErrConfigInvalid", err)
+	}
+}
+
+func TestSyncerTransferResource_Selects(t *testing.T) {
+	since := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	resource := SyncerTransferResource{MaxFileSize: 100, ModifiedSince: since}
+	tests := []struct {
+		name string
+		file FileState
+		want bool
+	}{
+		{name: "small and recent", file: FileState{Size: 100, Mtime: since.Unix()}, want: true},
+		{name: "too large", file: FileState{Size: 101, Mtime: since.Unix()}, want: false},
+		{name: "too old", file: FileState{Size: 1, Mtime: since.Unix() - 1}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resource.selects(tt.file); got != tt.want {
+				t.Errorf("selects() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if !(SyncerTransferResource{}).selects(FileState{Size: 1 << 40}) {
+		t.Errorf("selects() without filters should select every file")
+	}
+}
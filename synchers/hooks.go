@@ -0,0 +1,105 @@
+package synchers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/uselagoon/lagoon-sync/utils"
+)
+
+// SyncHookPoint names a point during a sync where hook commands can be run
+type SyncHookPoint string
+
+const (
+	// SyncHookPreSource and SyncHookPostSource run on the source, before and after the export
+	SyncHookPreSource  SyncHookPoint = "pre-source"
+	SyncHookPostSource SyncHookPoint = "post-source"
+	// SyncHookPreImport and SyncHookPostImport run on the target, before and after the import
+	SyncHookPreImport  SyncHookPoint = "pre-import"
+	SyncHookPostImport SyncHookPoint = "post-import"
+	// SyncHookPostCleanup runs on the target once the sync has cleaned up
+	SyncHookPostCleanup SyncHookPoint = "post-cleanup"
+)
+
+// syncHookPresetsKey lists the presets to use in a syncer's hooks config, alongside the hook points
+const syncHookPresetsKey = "presets"
+
+// SyncHooks are the commands run at each point of a sync, configured under a syncer's `hooks:`
+type SyncHooks map[SyncHookPoint][]string
+
+// syncHookPresets are the hooks that commonly need to be run after syncing into a framework's site
+var syncHookPresets = map[string]SyncHooks{
+	"drupal": {
+		SyncHookPostImport: {"drush -y sql-sanitize", "drush -y updatedb", "drush -y cache:rebuild"},
+	},
+	"laravel": {
+		SyncHookPostImport: {"php artisan migrate --force", "php artisan optimize:clear"},
+	},
+	"wordpress": {
+		SyncHookPostImport: {"wp core update-db", "wp cache flush"},
+	},
+}
+
+// DecodeSyncHooks reads the hooks from a syncer's config. The commands of any presets it lists are run before the
+// commands given for the same point.
+func DecodeSyncHooks(syncerConfig interface{}) (SyncHooks, error) {
+	hooks := SyncHooks{}
+	if syncerConfig == nil {
+		return hooks, nil
+	}
+
+	config := struct {
+		Hooks map[string][]string `yaml:"hooks"`
+	}{}
+	if err := UnmarshalIntoStruct(syncerConfig, &config); err != nil {
+		return nil, fmt.Errorf("%w: unable to parse hooks: %v", ErrConfigInvalid, err)
+	}
+
+	for _, name := range config.Hooks[syncHookPresetsKey] {
+		preset, exists := syncHookPresets[name]
+		if !exists {
+			return nil, fmt.Errorf("%w: unknown hooks preset '%s', expected one of %s", ErrConfigInvalid, name, strings.Join(syncHookPresetNames(), ", "))
+		}
+		for point, commands := range preset {
+			hooks[point] = append(hooks[point], commands...)
+		}
+	}
+
+	for name, commands := range config.Hooks {
+		point := SyncHookPoint(name)
+		switch point {
+		case SyncHookPreSource, SyncHookPostSource, SyncHookPreImport, SyncHookPostImport, SyncHookPostCleanup:
+			hooks[point] = append(hooks[point], commands...)
+		default:
+			if name != syncHookPresetsKey {
+				return nil, fmt.Errorf("%w: unknown hook '%s', expected one of %s, %s, %s, %s or %s", ErrConfigInvalid, name, SyncHookPreSource, SyncHookPostSource, SyncHookPreImport, SyncHookPostImport, SyncHookPostCleanup)
+			}
+		}
+	}
+	return hooks, nil
+}
+
+func syncHookPresetNames() []string {
+	names := make([]string, 0, len(syncHookPresets))
+	for name := range syncHookPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runHooks runs the commands hooked into the point in the environment, stopping at the first that fails
+func runHooks(ctx context.Context, hooks SyncHooks, point SyncHookPoint, environment Environment, dryRun bool, sshOptionWrapper *SSHOptionWrapper, logger *utils.Logger) error {
+	for _, command := range hooks[point] {
+		logger.LogExecutionStep(fmt.Sprintf("Running %s hook on %s", point, environment.EnvironmentName), command)
+		if dryRun {
+			continue
+		}
+		if err := runSyncCommand(ctx, environment, plainCommand(command), sshOptionWrapper, logger); err != nil {
+			return fmt.Errorf("%s hook failed: %w", point, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package synchers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeSyncHooks(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  interface{}
+		want    SyncHooks
+		wantErr bool
+	}{
+		{
+			name:   "No config",
+			config: nil,
+			want:   SyncHooks{},
+		},
+		{
+			name:   "No hooks",
+			config: map[string]interface{}{"config": map[string]interface{}{"hostname": "mariadb"}},
+			want:   SyncHooks{},
+		},
+		{
+			name: "Hooks",
+			config: map[string]interface{}{"hooks": map[string]interface{}{
+				"pre-source":  []interface{}{"echo exporting"},
+				"post-import": []interface{}{"drush cr"},
+			}},
+			want: SyncHooks{
+				SyncHookPreSource:  {"echo exporting"},
+				SyncHookPostImport: {"drush cr"},
+			},
+		},
+		{
+			name: "Presets run before the hooks given for the same point",
+			config: map[string]interface{}{"hooks": map[string]interface{}{
+				"presets":     []interface{}{"drupal"},
+				"post-import": []interface{}{"drush uli"},
+			}},
+			want: SyncHooks{
+				SyncHookPostImport: {"drush -y sql-sanitize", "drush -y updatedb", "drush -y cache:rebuild", "drush uli"},
+			},
+		},
+		{
+			name:    "Unknown preset",
+			config:  map[string]interface{}{"hooks": map[string]interface{}{"presets": []interface{}{"joomla"}}},
+			wantErr: true,
+		},
+		{
+			name:    "Unknown hook point",
+			config:  map[string]interface{}{"hooks": map[string]interface{}{"post-transfer": []interface{}{"ls"}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeSyncHooks(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeSyncHooks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodeSyncHooks() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunSyncProcess_RunsHooks(t *testing.T) {
+	dir := t.TempDir()
+	hookLog := filepath.Join(dir, "hooks")
+	hook := func(name string) []string {
+		return []string{"echo " + name + " >> " + hookLog}
+	}
+
+	journal, err := NewSyncJournal(t.TempDir(), SyncJournal{SyncerType: "test"})
+	if err != nil {
+		t.Fatalf("NewSyncJournal() error = %v", err)
+	}
+	journal.MarkCompleted(SyncPhasePrerequisite)
+	journal.MarkCompleted(SyncPhaseSourceExport)
+	journal.MarkCompleted(SyncPhaseTransfer)
+
+	err = RunSyncProcess(context.Background(), RunSyncProcessFunctionTypeArguments{
+		SourceEnvironment: Environment{EnvironmentName: "main"},
+		TargetEnvironment: Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME},
+		LagoonSyncer:      journalTestSyncer{dir: dir},
+		SyncerType:        "test",
+		SshOptionWrapper:  &SSHOptionWrapper{},
+		Journal:           journal,
+		Hooks: SyncHooks{
+			SyncHookPreSource:   hook("pre-source"),
+			SyncHookPreImport:   hook("pre-import"),
+			SyncHookPostImport:  hook("post-import"),
+			SyncHookPostCleanup: hook("post-cleanup"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("RunSyncProcess() error = %v", err)
+	}
+
+	// The export had already completed, so its hooks aren't run again
+	ran, err := os.ReadFile(hookLog)
+	if err != nil {
+		t.Fatalf("RunSyncProcess() didn't run any hooks: %v", err)
+	}
+	if got, want := strings.Fields(string(ran)), []string{"pre-import", "post-import", "post-cleanup"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("RunSyncProcess() ran hooks %v, want %v", got, want)
+	}
+}
+
+func TestRunSyncProcess_FailingHookFailsTheImport(t *testing.T) {
+	dir := t.TempDir()
+	journal, err := NewSyncJournal(t.TempDir(), SyncJournal{SyncerType: "test"})
+	if err != nil {
+		t.Fatalf("NewSyncJournal() error = %v", err)
+	}
+	journal.MarkCompleted(SyncPhasePrerequisite)
+	journal.MarkCompleted(SyncPhaseSourceExport)
+	journal.MarkCompleted(SyncPhaseTransfer)
+
+	err = RunSyncProcess(context.Background(), RunSyncProcessFunctionTypeArguments{
+		SourceEnvironment: Environment{EnvironmentName: "main"},
+		TargetEnvironment: Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME},
+		LagoonSyncer:      journalTestSyncer{dir: dir},
+		SyncerType:        "test",
+		SshOptionWrapper:  &SSHOptionWrapper{},
+		Journal:           journal,
+		Hooks:             SyncHooks{SyncHookPostImport: {"exit 1"}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "post-import hook failed") {
+		t.Errorf("RunSyncProcess() error = %v, want the post-import hook to fail the sync", err)
+	}
+	if journal.HasCompleted(SyncPhaseTargetImport) {
+		t.Errorf("RunSyncProcess() marked the import completed, even though its hook failed")
+	}
+}
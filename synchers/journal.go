@@ -0,0 +1,208 @@
+package synchers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SyncPhase names one of the steps RunSyncProcess works through
+type SyncPhase string
+
+const (
+	SyncPhasePrerequisite SyncPhase = "prerequisite"
+	SyncPhaseSourceExport SyncPhase = "source-export"
+	SyncPhaseTransfer     SyncPhase = "transfer"
+	SyncPhaseTargetImport SyncPhase = "target-import"
+	SyncPhaseCleanup      SyncPhase = "cleanup"
+)
+
+// SyncJournal records the progress of a single sync run, so that a failed run can be picked up again from the
+// last phase that completed. Everything needed to rebuild the run is kept alongside the completed phases.
+type SyncJournal struct {
+	RunId                string      `json:"runId"`
+	SyncerType           string      `json:"syncerType"`
+	ConfigFile           string      `json:"configFile,omitempty"`
+	ProjectName          string      `json:"projectName"`
+	SourceEnvironment    string      `json:"sourceEnvironment"`
+	TargetEnvironment    string      `json:"targetEnvironment"`
+	ServiceName          string      `json:"serviceName"`
+	TransferResourceName string      `json:"transferResourceName"`
+	Tables               []string    `json:"tables,omitempty"`
+	BackupFile           string      `json:"backupFile,omitempty"`
+	SkipSourceCleanup    bool        `json:"skipSourceCleanup"`
+	SkipTargetCleanup    bool        `json:"skipTargetCleanup"`
+	SkipTargetImport     bool        `json:"skipTargetImport"`
+	BackupTarget         bool        `json:"backupTarget,omitempty"`
+	Delete               bool        `json:"delete,omitempty"`
+	Stream               bool        `json:"stream,omitempty"`
+	KeepFailedFiles      bool        `json:"keepFailedFiles,omitempty"`
+	SSHOptions           SSHOptions  `json:"sshOptions"`
+	UseSshPortal         bool        `json:"useSshPortal"`
+	APIEndpoint          string      `json:"apiEndpoint,omitempty"`
+	CompletedPhases      []SyncPhase `json:"completedPhases"`
+	LastError            string      `json:"lastError,omitempty"`
+	StartedAt            time.Time   `json:"startedAt"`
+	UpdatedAt            time.Time   `json:"updatedAt"`
+
+	directory string
+}
+
+// GetJournalDirectory returns where journals are kept - LAGOON_SYNC_JOURNAL_DIR if set, otherwise a directory in
+// the user's cache dir, so that the runs they can resume or roll back aren't lost when the temp dir is cleared
+func GetJournalDirectory() string {
+	if dir, exists := os.LookupEnv("LAGOON_SYNC_JOURNAL_DIR"); exists && dir != "" {
+		return dir
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+	return filepath.Join(cacheDir, "lagoon-sync", "runs")
+}
+
+// NewSyncJournal creates a journal for a new run in the given directory and writes it to disk
+func NewSyncJournal(directory string, journal SyncJournal) (*SyncJournal, error) {
+	journal.directory = directory
+	journal.RunId = strconv.FormatInt(time.Now().UnixNano(), 10)
+	journal.CompletedPhases = []SyncPhase{}
+	journal.StartedAt = time.Now()
+
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, fmt.Errorf("unable to create journal directory %v: %w", directory, err)
+	}
+
+	return &journal, journal.Save()
+}
+
+// LoadSyncJournal reads the journal for the given run id from the given directory
+func LoadSyncJournal(directory string, runId string) (*SyncJournal, error) {
+	journal := SyncJournal{}
+
+	data, err := os.ReadFile(journalPath(directory, runId))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no sync run with id %v found in %v", runId, directory)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &journal); err != nil {
+		return nil, fmt.Errorf("unable to parse journal for sync run %v: %w", runId, err)
+	}
+	journal.directory = directory
+
+	return &journal, nil
+}
+
+func journalPath(directory string, runId string) string {
+	return filepath.Join(directory, runId+".json")
+}
+
+// referencedBackupFiles lists the backups recorded by the journals kept alongside this one, which can still be
+// rolled back to
+func (j *SyncJournal) referencedBackupFiles() []string {
+	if j == nil {
+		return nil
+	}
+	paths, _ := filepath.Glob(journalPath(j.directory, "*"))
+	var backups []string
+	for _, journalFile := range paths {
+		journal, err := LoadSyncJournal(j.directory, strings.TrimSuffix(filepath.Base(journalFile), ".json"))
+		if err != nil || journal.BackupFile == "" {
+			continue
+		}
+		backups = append(backups, journal.BackupFile)
+	}
+	return backups
+}
+
+// Path returns the location of the journal on disk
+func (j *SyncJournal) Path() string {
+	return journalPath(j.directory, j.RunId)
+}
+
+// HasCompleted reports whether the given phase has been recorded as complete.
+// A nil journal hasn't completed anything, which lets callers use it without checking for journaling first.
+func (j *SyncJournal) HasCompleted(phase SyncPhase) bool {
+	if j == nil {
+		return false
+	}
+	for _, p := range j.CompletedPhases {
+		if p == phase {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFinished reports whether the run got all the way through to cleanup
+func (j *SyncJournal) IsFinished() bool {
+	return j.HasCompleted(SyncPhaseCleanup)
+}
+
+// MarkCompleted records the phase as complete and saves the journal
+func (j *SyncJournal) MarkCompleted(phase SyncPhase) error {
+	if j == nil || j.HasCompleted(phase) {
+		return nil
+	}
+	j.CompletedPhases = append(j.CompletedPhases, phase)
+	j.LastError = ""
+	return j.Save()
+}
+
+// RecordError stores the error that stopped the run and saves the journal
+func (j *SyncJournal) RecordError(err error) error {
+	if j == nil || err == nil {
+		return nil
+	}
+	j.LastError = err.Error()
+	return j.Save()
+}
+
+// RecordBackup stores where the target was backed up to before the import and saves the journal
+func (j *SyncJournal) RecordBackup(backupFile string) error {
+	if j == nil {
+		return nil
+	}
+	j.BackupFile = backupFile
+	return j.Save()
+}
+
+// Restart clears the completed phases, for a run whose generated files have been cleaned up, so that resuming
+// it starts from the beginning
+func (j *SyncJournal) Restart(err error) error {
+	if j == nil {
+		return nil
+	}
+	j.CompletedPhases = []SyncPhase{}
+	if err != nil {
+		j.LastError = err.Error()
+	}
+	return j.Save()
+}
+
+// Save writes the journal to disk
+func (j *SyncJournal) Save() error {
+	if j == nil {
+		return nil
+	}
+	j.UpdatedAt = time.Now()
+
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so an interrupted write doesn't leave a truncated journal behind
+	tmpPath := j.Path() + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("unable to write journal for sync run %v: %w", j.RunId, err)
+	}
+	return os.Rename(tmpPath, j.Path())
+}
@@ -0,0 +1,263 @@
+package synchers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSyncJournal_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+
+	journal, err := NewSyncJournal(dir, SyncJournal{
+		SyncerType:           "mariadb",
+		ProjectName:          "test-project",
+		SourceEnvironment:    "main",
+		TargetEnvironment:    LOCAL_ENVIRONMENT_NAME,
+		TransferResourceName: "/tmp/lagoon_sync_mariadb_1.sql.gz",
+	})
+	if err != nil {
+		t.Fatalf("NewSyncJournal() error = %v", err)
+	}
+
+	if err := journal.MarkCompleted(SyncPhaseSourceExport); err != nil {
+		t.Fatalf("MarkCompleted() error = %v", err)
+	}
+	if err := journal.RecordError(errors.New("transfer failed")); err != nil {
+		t.Fatalf("RecordError() error = %v", err)
+	}
+
+	loaded, err := LoadSyncJournal(dir, journal.RunId)
+	if err != nil {
+		t.Fatalf("LoadSyncJournal() error = %v", err)
+	}
+	if !reflect.DeepEqual(loaded.CompletedPhases, []SyncPhase{SyncPhaseSourceExport}) {
+		t.Errorf("LoadSyncJournal() completed phases = %v", loaded.CompletedPhases)
+	}
+	if loaded.TransferResourceName != journal.TransferResourceName || loaded.LastError != "transfer failed" {
+		t.Errorf("LoadSyncJournal() got = %+v", loaded)
+	}
+	if !loaded.HasCompleted(SyncPhaseSourceExport) || loaded.HasCompleted(SyncPhaseTransfer) || loaded.IsFinished() {
+		t.Errorf("LoadSyncJournal() reports the wrong phases as completed: %v", loaded.CompletedPhases)
+	}
+
+	if _, err := LoadSyncJournal(dir, "does-not-exist"); err == nil {
+		t.Errorf("LoadSyncJournal() expected an error for a missing run")
+	}
+}
+
+func TestSyncJournal_Nil(t *testing.T) {
+	var journal *SyncJournal
+	if journal.HasCompleted(SyncPhaseSourceExport) || journal.IsFinished() {
+		t.Errorf("nil journal should not have completed anything")
+	}
+	if err := journal.MarkCompleted(SyncPhaseSourceExport); err != nil {
+		t.Errorf("MarkCompleted() on nil journal error = %v", err)
+	}
+}
+
+func TestGetJournalDirectory(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(home, ".cache"))
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("LAGOON_SYNC_JOURNAL_DIR", "")
+	if got, want := GetJournalDirectory(), filepath.Join(cacheDir, "lagoon-sync", "runs"); got != want {
+		t.Errorf("GetJournalDirectory() = %v, want %v", got, want)
+	}
+
+	override := t.TempDir()
+	t.Setenv("LAGOON_SYNC_JOURNAL_DIR", override)
+	if got := GetJournalDirectory(); got != override {
+		t.Errorf("GetJournalDirectory() = %v, want %v", got, override)
+	}
+}
+
+// journalTestSyncer runs its export and import locally, touching marker files so we can see which phases ran
+type journalTestSyncer struct {
+	Syncer
+	dir string
+}
+
+func (s journalTestSyncer) IsInitialized() (bool, error) {
+	return true, nil
+}
+
+func (s journalTestSyncer) GetRemoteCommand(environment Environment) []SyncCommand {
+	return []SyncCommand{generateSyncCommand("touch "+filepath.Join(s.dir, "exported"), nil)}
+}
+
+func (s journalTestSyncer) GetLocalCommand(environment Environment) []SyncCommand {
+	return []SyncCommand{generateSyncCommand("touch "+filepath.Join(s.dir, "imported"), nil)}
+}
+
+func (s journalTestSyncer) GetTransferResource(environment Environment) SyncerTransferResource {
+	return SyncerTransferResource{Name: filepath.Join(s.dir, "dump"), SkipCleanup: true}
+}
+
+func TestRunSyncProcess_ResumesFromJournal(t *testing.T) {
+	dir := t.TempDir()
+	journal, err := NewSyncJournal(t.TempDir(), SyncJournal{SyncerType: "test"})
+	if err != nil {
+		t.Fatalf("NewSyncJournal() error = %v", err)
+	}
+	journal.MarkCompleted(SyncPhasePrerequisite)
+	journal.MarkCompleted(SyncPhaseSourceExport)
+	journal.MarkCompleted(SyncPhaseTransfer)
+
+	err = RunSyncProcess(context.Background(), RunSyncProcessFunctionTypeArguments{
+		SourceEnvironment: Environment{EnvironmentName: "main"},
+		TargetEnvironment: Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME},
+		LagoonSyncer:      journalTestSyncer{dir: dir},
+		SyncerType:        "test",
+		SshOptionWrapper:  &SSHOptionWrapper{},
+		Journal:           journal,
+	})
+	if err != nil {
+		t.Fatalf("RunSyncProcess() error = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "exported")); err == nil {
+		t.Errorf("RunSyncProcess() re-ran the source export, which was already completed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "imported")); err != nil {
+		t.Errorf("RunSyncProcess() didn't run the target import")
+	}
+	if !journal.IsFinished() {
+		t.Errorf("RunSyncProcess() didn't record the run as finished: %v", journal.CompletedPhases)
+	}
+}
+
+// hangingImportSyncer has an import that never finishes on its own
+type hangingImportSyncer struct {
+	journalTestSyncer
+}
+
+func (s hangingImportSyncer) GetLocalCommand(environment Environment) []SyncCommand {
+	return []SyncCommand{generateSyncCommand("sleep 10", nil)}
+}
+
+func (s hangingImportSyncer) GetTransferResource(environment Environment) SyncerTransferResource {
+	return SyncerTransferResource{Name: filepath.Join(s.dir, "dump")}
+}
+
+func (s hangingImportSyncer) GetFilesToCleanup(environment Environment) []string {
+	return []string{s.GetTransferResource(environment).Name}
+}
+
+func TestRunSyncProcess_CancelledCleansUp(t *testing.T) {
+	dir := t.TempDir()
+	dump := filepath.Join(dir, "dump")
+	os.WriteFile(dump, []byte("dump"), 0644)
+
+	journal, err := NewSyncJournal(t.TempDir(), SyncJournal{SyncerType: "test"})
+	if err != nil {
+		t.Fatalf("NewSyncJournal() error = %v", err)
+	}
+	journal.MarkCompleted(SyncPhasePrerequisite)
+	journal.MarkCompleted(SyncPhaseSourceExport)
+	journal.MarkCompleted(SyncPhaseTransfer)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = RunSyncProcess(ctx, RunSyncProcessFunctionTypeArguments{
+		SourceEnvironment: Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME},
+		TargetEnvironment: Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME},
+		LagoonSyncer:      hangingImportSyncer{journalTestSyncer{dir: dir}},
+		SyncerType:        "test",
+		SshOptionWrapper:  &SSHOptionWrapper{},
+		Journal:           journal,
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("RunSyncProcess() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("RunSyncProcess() took %v to stop after being cancelled", time.Since(start))
+	}
+	if _, err := os.Stat(dump); err == nil {
+		t.Errorf("RunSyncProcess() didn't clean up after being cancelled")
+	}
+	// The dump is gone, so resuming has to start over - even though a journal was kept
+	if len(journal.CompletedPhases) != 0 {
+		t.Errorf("RunSyncProcess() left completed phases in the journal after cleaning up: %v", journal.CompletedPhases)
+	}
+}
+
+// failingImportSyncer has an import that fails, leaving its dump behind
+type failingImportSyncer struct {
+	hangingImportSyncer
+}
+
+func (s failingImportSyncer) GetLocalCommand(environment Environment) []SyncCommand {
+	return []SyncCommand{generateSyncCommand("exit 1", nil)}
+}
+
+func TestRunSyncProcess_FailedCleansUp(t *testing.T) {
+	tests := []struct {
+		name          string
+		args          RunSyncProcessFunctionTypeArguments
+		wantDumpKept  bool
+		wantResumable bool
+	}{
+		{
+			name: "Failed runs are cleaned up and start over when resumed",
+		},
+		{
+			name:          "Failed runs can keep their files to resume from",
+			args:          RunSyncProcessFunctionTypeArguments{KeepFailedFiles: true},
+			wantDumpKept:  true,
+			wantResumable: true,
+		},
+		{
+			name:         "Cleaning up a failed run leaves what the skip cleanup options keep",
+			args:         RunSyncProcessFunctionTypeArguments{SkipSourceCleanup: true, SkipTargetCleanup: true},
+			wantDumpKept: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			dump := filepath.Join(dir, "dump")
+			os.WriteFile(dump, []byte("dump"), 0644)
+
+			journal, err := NewSyncJournal(t.TempDir(), SyncJournal{SyncerType: "test"})
+			if err != nil {
+				t.Fatalf("NewSyncJournal() error = %v", err)
+			}
+			journal.MarkCompleted(SyncPhasePrerequisite)
+			journal.MarkCompleted(SyncPhaseSourceExport)
+			journal.MarkCompleted(SyncPhaseTransfer)
+
+			args := tt.args
+			args.SourceEnvironment = Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME}
+			args.TargetEnvironment = Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME}
+			args.LagoonSyncer = failingImportSyncer{hangingImportSyncer{journalTestSyncer{dir: dir}}}
+			args.SyncerType = "test"
+			args.SshOptionWrapper = &SSHOptionWrapper{}
+			args.Journal = journal
+			if err := RunSyncProcess(context.Background(), args); err == nil {
+				t.Fatalf("RunSyncProcess() error = nil, want the import's error")
+			}
+
+			if _, err := os.Stat(dump); (err == nil) != tt.wantDumpKept {
+				t.Errorf("RunSyncProcess() kept the dump = %v, want %v", err == nil, tt.wantDumpKept)
+			}
+			if journal.HasCompleted(SyncPhaseTransfer) != tt.wantResumable {
+				t.Errorf("RunSyncProcess() left completed phases %v in the journal", journal.CompletedPhases)
+			}
+			if journal.LastError == "" {
+				t.Errorf("RunSyncProcess() didn't record the error in the journal")
+			}
+		})
+	}
+}
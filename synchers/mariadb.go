@@ -9,7 +9,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/spf13/viper"
 	"github.com/uselagoon/lagoon-sync/utils"
 )
 
@@ -19,6 +18,7 @@ type BaseMariaDbSync struct {
 	DbPassword      string   `yaml:"password"`
 	DbPort          string   `yaml:"port"`
 	DbDatabase      string   `yaml:"database"`
+	Tables          []string `yaml:"tables"`
 	IgnoreTable     []string `yaml:"ignore-table"`
 	IgnoreTableData []string `yaml:"ignore-table-data"`
 	OutputDirectory string
@@ -37,6 +37,11 @@ type MariadbSyncRoot struct {
 	LocalOverrides           MariadbSyncLocal `yaml:"local"`
 	TransferId               string
 	TransferResourceOverride string
+	Transport                string        `yaml:"transport"`
+	Sanitize                 SanitizeRules `yaml:"sanitize"`
+	SanitizeSalt             string        `yaml:"sanitizeSalt"`
+
+	salt string // the salt the sanitize rules hash with, generated once per run unless SanitizeSalt is set
 }
 
 func (m *MariadbSyncRoot) setDefaults() {
@@ -86,7 +91,12 @@ func (m MariadbSyncPlugin) UnmarshallYaml(root SyncherConfigRoot, targetService
 
 	// If yaml config is there then unmarshall into struct and override default values if there are any
 	if syncherConfig != nil {
-		_ = UnmarshalIntoStruct(syncherConfig, &mariadb)
+		if err := UnmarshalIntoStruct(syncherConfig, &mariadb); err != nil {
+			return nil, fmt.Errorf("%w: unable to parse the %s config: %v", ErrConfigInvalid, targetService, err)
+		}
+		if err := mariadb.Sanitize.Validate(); err != nil {
+			return nil, err
+		}
 		utils.LogDebugInfo("Config that will be used for sync", mariadb)
 	} else {
 		// If config from active config file is empty, then use defaults
@@ -96,7 +106,7 @@ func (m MariadbSyncPlugin) UnmarshallYaml(root SyncherConfigRoot, targetService
 	}
 	if mariadb.Config.IsBaseMariaDbStructureEmpty() && &mariadb == nil {
 		m.isConfigEmpty = true
-		utils.LogFatalError("No syncer configuration could be found in", viper.GetViper().ConfigFileUsed())
+		return nil, fmt.Errorf("%w: no syncer configuration could be found", ErrConfigInvalid)
 	}
 
 	lagoonSyncer, _ := mariadb.PrepareSyncer()
@@ -160,37 +170,17 @@ func (root *MariadbSyncRoot) GetRemoteCommand(sourceEnvironment Environment) []S
 
 	transferResource := root.GetTransferResource(sourceEnvironment)
 
-	var tablesToIgnore string
-	for _, s := range m.IgnoreTable {
-		tablesToIgnore += fmt.Sprintf("--ignore-table=%s.%s ", m.DbDatabase, s)
-	}
-
-	var tablesWhoseDataToIgnore string
-	for _, s := range m.IgnoreTableData {
-		tablesWhoseDataToIgnore += fmt.Sprintf("--ignore-table-data=%s.%s ", m.DbDatabase, s)
-	}
-
 	//We remove the `.gz` from the transfer resource name for because we _first_ generate a plain `.sql` file
 	//and _then_ gzip it
 	resourceNameWithoutGz := strings.TrimSuffix(transferResource.Name, filepath.Ext(transferResource.Name))
-	substitutions := map[string]interface{}{
-		"dumpOptions":      "--max-allowed-packet=500M --quick --add-locks --no-autocommit --single-transaction",
-		"hostname":         m.DbHostname,
-		"username":         m.DbUsername,
-		"password":         m.DbPassword,
-		"port":             m.DbPort,
-		"tablesToIgnore":   tablesWhoseDataToIgnore,
-		"database":         m.DbDatabase,
-		"transferResource": resourceNameWithoutGz,
-	}
+	dumpCommand := m.getDumpCommand()
+	dumpCommand.command += " > {{ .transferResource }}"
+	dumpCommand.substitutions["transferResource"] = resourceNameWithoutGz
 	return []SyncCommand{
+		dumpCommand,
 		{
-			command:       fmt.Sprintf("mysqldump {{ .dumpOptions }} -h{{ .hostname }} -u{{ .username }} -p{{ .password }} -P{{ .port }} {{ .tablesToIgnore }} {{ .database }} > {{ .transferResource }}"),
-			substitutions: substitutions,
-		},
-		{
-			command:       fmt.Sprintf("gzip {{ .transferResource }}"),
-			substitutions: substitutions,
+			command:       fmt.Sprintf("gzip -f {{ .transferResource }}"),
+			substitutions: dumpCommand.substitutions,
 		},
 	}
 }
@@ -202,26 +192,201 @@ func (m *MariadbSyncRoot) GetLocalCommand(targetEnvironment Environment) []SyncC
 	}
 	transferResource := m.GetTransferResource(targetEnvironment)
 	resourceNameWithoutGz := strings.TrimSuffix(transferResource.Name, filepath.Ext(transferResource.Name))
-	return []SyncCommand{
-		generateSyncCommand("gunzip {{ .transferResource }}",
+	// The dump is only unpacked if an earlier attempt hasn't already done so, so that a failed import can be retried
+	commands := []SyncCommand{
+		generateSyncCommand("test ! -f {{ .transferResource }} || gunzip -f {{ .transferResource }}",
 			map[string]interface{}{
 				"hostname":         l.DbHostname,
 				"username":         l.DbUsername,
-				"password":         l.DbPassword,
+				"password":         SensitiveValue(l.DbPassword),
 				"port":             l.DbPort,
 				"database":         l.DbDatabase,
 				"transferResource": transferResource.Name,
 			}),
-		generateSyncCommand("mysql -h{{ .hostname }} -u{{ .username }} -p{{ .password }} -P{{ .port }} {{ .database }} < {{ .resourceNameWithoutGz }}",
+		generateSyncCommand("MYSQL_PWD=\"{{ .password }}\" mysql -h{{ .hostname }} -u{{ .username }} -P{{ .port }} {{ .database }} < {{ .resourceNameWithoutGz }}",
 			map[string]interface{}{
 				"hostname":              l.DbHostname,
 				"username":              l.DbUsername,
-				"password":              l.DbPassword,
+				"password":              SensitiveValue(l.DbPassword),
 				"port":                  l.DbPort,
 				"database":              l.DbDatabase,
 				"resourceNameWithoutGz": resourceNameWithoutGz,
 			}),
 	}
+	if sanitizeCommand, ok := m.getSanitizeCommand(l, false); ok {
+		commands = append(commands, sanitizeCommand)
+	}
+	return commands
+}
+
+// getSanitizeCommand is the command applying the sanitize rules to the imported database. It's run as part of the
+// import, so that the import isn't complete until the data is sanitized. With force, the statements after one that
+// fails are still run. The SQL is piped in, so that the salt it sets can be passed in the environment rather than
+// in mysql's arguments.
+func (m *MariadbSyncRoot) getSanitizeCommand(l BaseMariaDbSync, force bool) (SyncCommand, bool) {
+	sql := m.Sanitize.sql(mariadbDialect)
+	if sql == "" {
+		return SyncCommand{}, false
+	}
+	if m.salt == "" {
+		m.salt = newSanitizeSalt(m.SanitizeSalt)
+	}
+	options := ""
+	if force {
+		options = " --force"
+	}
+	return generateSyncCommand("{ printf \"SET @lagoon_sync_salt = UNHEX('%s'); \" \"{{ .sanitizeSalt }}\"; printf '%s' {{ .sanitizeSql }}; } | "+
+		"MYSQL_PWD=\"{{ .password }}\" mysql"+options+" -h{{ .hostname }} -u{{ .username }} -P{{ .port }} {{ .database }}",
+		map[string]interface{}{
+			"hostname":     l.DbHostname,
+			"username":     l.DbUsername,
+			"password":     SensitiveValue(l.DbPassword),
+			"port":         l.DbPort,
+			"database":     l.DbDatabase,
+			"sanitizeSql":  shellQuote(sql),
+			"sanitizeSalt": SensitiveValue(m.salt),
+		}), true
+}
+
+func (m *MariadbSyncRoot) SanitizesData() bool {
+	return len(m.Sanitize) > 0
+}
+
+func (m *MariadbSyncRoot) GetSanitizeCommand(environment Environment) SyncCommand {
+	l := m.Config
+	if environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
+		l = m.getEffectiveLocalDetails()
+	}
+	command, _ := m.getSanitizeCommand(l, true)
+	return command
+}
+
+// IsImportRetryable is true since mysqldump drops and recreates each table before loading it
+func (m *MariadbSyncRoot) IsImportRetryable() bool {
+	return true
+}
+
+func (root *MariadbSyncRoot) GetRemoteStreamCommand(sourceEnvironment Environment) SyncCommand {
+	m := root.Config
+
+	if sourceEnvironment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
+		m = root.getEffectiveLocalDetails()
+	}
+
+	// The dump isn't compressed here, piping it through gzip would hide a failing mysqldump's exit code
+	return m.getDumpCommand()
+}
+
+// getDumpCommand is the mysqldump command writing the selected tables of the database to stdout
+func (m BaseMariaDbSync) getDumpCommand() SyncCommand {
+	selection := m.getTableSelection()
+	substitutions := map[string]interface{}{
+		"dumpOptions":    "--max-allowed-packet=500M --quick --add-locks --no-autocommit --single-transaction",
+		"hostname":       m.DbHostname,
+		"username":       m.DbUsername,
+		"password":       SensitiveValue(m.DbPassword),
+		"port":           m.DbPort,
+		"tablesToIgnore": strings.Join(selection.arguments, " "),
+		"database":       m.DbDatabase,
+		"tables":         selection.tables,
+	}
+	for name, query := range selection.substitutions {
+		substitutions[name] = query
+	}
+	// The password is passed in MYSQL_PWD rather than with -p, and as a SensitiveValue the command itself only refers
+	// to it, so that it doesn't appear in the process list
+	return generateSyncCommand(selection.prefix+"MYSQL_PWD=\"{{ .password }}\" mysqldump {{ .dumpOptions }} -h{{ .hostname }} -u{{ .username }} -P{{ .port }} {{ .tablesToIgnore }} {{ .database }} {{ .tables }}", substitutions)
+}
+
+// SetTables limits the sync to the given tables, in place of the tables in the config
+func (m *MariadbSyncRoot) SetTables(tables []string) error {
+	m.Config.Tables = tables
+	return nil
+}
+
+func (m *MariadbSyncRoot) GetLocalStreamCommand(targetEnvironment Environment) SyncCommand {
+	l := m.Config
+	if targetEnvironment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
+		l = m.getEffectiveLocalDetails()
+	}
+	importCommand := generateSyncCommand("MYSQL_PWD=\"{{ .password }}\" mysql -h{{ .hostname }} -u{{ .username }} -P{{ .port }} {{ .database }}",
+		map[string]interface{}{
+			"hostname": l.DbHostname,
+			"username": l.DbUsername,
+			"password": SensitiveValue(l.DbPassword),
+			"port":     l.DbPort,
+			"database": l.DbDatabase,
+		})
+	if sanitizeCommand, ok := m.getSanitizeCommand(l, false); ok {
+		importCommand.command += " && " + sanitizeCommand.command
+		importCommand.substitutions["sanitizeSql"] = sanitizeCommand.substitutions["sanitizeSql"]
+		importCommand.substitutions["sanitizeSalt"] = sanitizeCommand.substitutions["sanitizeSalt"]
+	}
+	return importCommand
+}
+
+func (m *MariadbSyncRoot) GetBackupCommand(environment Environment, backupFile string) SyncCommand {
+	l := m.Config
+	if environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
+		l = m.getEffectiveLocalDetails()
+	}
+	return generateSyncCommand("MYSQL_PWD=\"{{ .password }}\" mysqldump {{ .dumpOptions }} -h{{ .hostname }} -u{{ .username }} -P{{ .port }} {{ .database }} > {{ .backupFile }}",
+		map[string]interface{}{
+			"dumpOptions": "--max-allowed-packet=500M --quick --add-locks --no-autocommit --single-transaction",
+			"hostname":    l.DbHostname,
+			"username":    l.DbUsername,
+			"password":    SensitiveValue(l.DbPassword),
+			"port":        l.DbPort,
+			"database":    l.DbDatabase,
+			"backupFile":  backupFile,
+		})
+}
+
+func (m *MariadbSyncRoot) GetRestoreCommand(environment Environment, backupFile string) SyncCommand {
+	l := m.Config
+	if environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
+		l = m.getEffectiveLocalDetails()
+	}
+	return generateSyncCommand("MYSQL_PWD=\"{{ .password }}\" mysql -h{{ .hostname }} -u{{ .username }} -P{{ .port }} -e {{ .dropQuery }} {{ .database }} && "+
+		"MYSQL_PWD=\"{{ .password }}\" mysql -h{{ .hostname }} -u{{ .username }} -P{{ .port }} {{ .database }} < {{ .backupFile }}",
+		map[string]interface{}{
+			"hostname":   l.DbHostname,
+			"username":   l.DbUsername,
+			"password":   SensitiveValue(l.DbPassword),
+			"port":       l.DbPort,
+			"database":   l.DbDatabase,
+			"dropQuery":  shellQuote(mariadbDropAllQuery),
+			"backupFile": backupFile,
+		})
+}
+
+// mariadbDropAllQuery drops every view and table in the database, so that a restore doesn't leave behind tables
+// a sync created that weren't there when the backup was taken
+const mariadbDropAllQuery = "SET SESSION group_concat_max_len = 1000000; SET FOREIGN_KEY_CHECKS = 0; " +
+	"SELECT CONCAT('DROP VIEW IF EXISTS ', GROUP_CONCAT(CONCAT('`', table_name, '`'))) INTO @views FROM information_schema.tables WHERE table_schema = DATABASE() AND table_type = 'VIEW'; " +
+	"SET @views = IFNULL(@views, 'DO 0'); PREPARE dropViews FROM @views; EXECUTE dropViews; DEALLOCATE PREPARE dropViews; " +
+	"SELECT CONCAT('DROP TABLE IF EXISTS ', GROUP_CONCAT(CONCAT('`', table_name, '`'))) INTO @tables FROM information_schema.tables WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE'; " +
+	"SET @tables = IFNULL(@tables, 'DO 0'); PREPARE dropTables FROM @tables; EXECUTE dropTables; DEALLOCATE PREPARE dropTables;"
+
+// GetPlanCommand lists the size of each table from information_schema
+func (m *MariadbSyncRoot) GetPlanCommand(environment Environment) SyncCommand {
+	l := m.Config
+	if environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
+		l = m.getEffectiveLocalDetails()
+	}
+	return generateSyncCommand("MYSQL_PWD=\"{{ .password }}\" mysql -h{{ .hostname }} -u{{ .username }} -P{{ .port }} -N -B -e {{ .planQuery }} {{ .database }}",
+		map[string]interface{}{
+			"hostname":  l.DbHostname,
+			"username":  l.DbUsername,
+			"password":  SensitiveValue(l.DbPassword),
+			"port":      l.DbPort,
+			"database":  l.DbDatabase,
+			"planQuery": shellQuote(l.planQuery()),
+		})
+}
+
+func (m *MariadbSyncRoot) GetPlanTools() ([]string, []string) {
+	return []string{"mysqldump", "gzip"}, []string{"gunzip", "mysql"}
 }
 
 func (m *MariadbSyncRoot) GetFilesToCleanup(environment Environment) []string {
@@ -238,10 +403,11 @@ func (m *MariadbSyncRoot) GetTransferResource(environment Environment) SyncerTra
 	if m.TransferResourceOverride != "" {
 		resourceName = m.TransferResourceOverride
 	}
-	
+
 	return SyncerTransferResource{
 		Name:        resourceName,
-		IsDirectory: false}
+		IsDirectory: false,
+		Transport:   m.Transport}
 }
 
 func (m *MariadbSyncRoot) SetTransferResource(transferResourceName string) error {
@@ -264,6 +430,9 @@ func (syncConfig *MariadbSyncRoot) getEffectiveLocalDetails() BaseMariaDbSync {
 		DbPassword:      syncConfig.Config.DbPassword,
 		DbPort:          syncConfig.Config.DbPort,
 		DbDatabase:      syncConfig.Config.DbDatabase,
+		Tables:          syncConfig.Config.Tables,
+		IgnoreTable:     syncConfig.Config.IgnoreTable,
+		IgnoreTableData: syncConfig.Config.IgnoreTableData,
 		OutputDirectory: syncConfig.Config.OutputDirectory,
 	}
 
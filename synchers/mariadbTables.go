@@ -0,0 +1,152 @@
+package synchers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A table list in the mariadb config can mix table names, glob patterns like cache_* and regular expressions written
+// between slashes like /^cache_/. Lists that only name tables are passed to mysqldump as they are, lists with
+// patterns are expanded by querying information_schema in the environment the dump is run in.
+
+// isTableRegex is true for a table pattern written between slashes, like /^cache_/
+func isTableRegex(pattern string) bool {
+	return len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/")
+}
+
+// isTablePattern is true if the table list entry matches tables by pattern, rather than naming a single table
+func isTablePattern(table string) bool {
+	return isTableRegex(table) || strings.ContainsAny(table, "*?")
+}
+
+func containsTablePatterns(tables []string) bool {
+	for _, table := range tables {
+		if isTablePattern(table) {
+			return true
+		}
+	}
+	return false
+}
+
+// sqlString quotes a value as a MySQL string literal
+func sqlString(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// shellQuote quotes a value as a single shell word
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// globToLike turns a glob pattern into the equivalent pattern for a LIKE comparison
+func globToLike(glob string) string {
+	var like strings.Builder
+	for _, r := range glob {
+		switch r {
+		case '*':
+			like.WriteString("%")
+		case '?':
+			like.WriteString("_")
+		case '%', '_', '\\':
+			like.WriteString(`\` + string(r))
+		default:
+			like.WriteRune(r)
+		}
+	}
+	return like.String()
+}
+
+// tableListCondition is the SQL condition matching the table_name of every table in the list
+func tableListCondition(tables []string) string {
+	conditions := make([]string, 0, len(tables))
+	for _, table := range tables {
+		switch {
+		case isTableRegex(table):
+			conditions = append(conditions, "table_name REGEXP "+sqlString(table[1:len(table)-1]))
+		case isTablePattern(table):
+			conditions = append(conditions, "table_name LIKE "+sqlString(globToLike(table)))
+		default:
+			conditions = append(conditions, "table_name = "+sqlString(table))
+		}
+	}
+	return strings.Join(conditions, " OR ")
+}
+
+// mariadbTableListQuery is the query listing the mysqldump arguments for the tables in the list - the table names
+// themselves if option is empty, otherwise option=database.table for each of them
+func mariadbTableListQuery(tables []string, option string) string {
+	column := "table_name"
+	if option != "" {
+		column = fmt.Sprintf("CONCAT(%s, table_schema, '.', table_name)", sqlString(option+"="))
+	}
+	return fmt.Sprintf("SELECT %s FROM information_schema.tables WHERE table_schema = DATABASE() AND (%s)", column, tableListCondition(tables))
+}
+
+// mariadbTableSelection builds the parts of a mysqldump command that select which tables are dumped
+type mariadbTableSelection struct {
+	// prefix is run before mysqldump, setting the shell variables that patterns expand into
+	prefix string
+	// arguments are passed to mysqldump before the database name
+	arguments []string
+	// tables are passed to mysqldump after the database name
+	tables string
+	// substitutions are the queries the prefix runs
+	substitutions map[string]interface{}
+}
+
+// addList adds a table list to the selection, passed to mysqldump as option=database.table for each table, or as
+// the tables to dump if option is empty. Lists with patterns are expanded into the shell variable.
+func (s *mariadbTableSelection) addList(database string, tables []string, option string, variable string) {
+	if len(tables) == 0 {
+		return
+	}
+
+	if !containsTablePatterns(tables) {
+		if option == "" {
+			s.tables = strings.Join(tables, " ")
+			return
+		}
+		for _, table := range tables {
+			s.arguments = append(s.arguments, fmt.Sprintf("%s=%s.%s", option, database, table))
+		}
+		return
+	}
+
+	queryName := variable + "Query"
+	s.substitutions[queryName] = shellQuote(mariadbTableListQuery(tables, option))
+	s.prefix += fmt.Sprintf("%s=$(MYSQL_PWD=\"{{ .password }}\" mysql -h{{ .hostname }} -u{{ .username }} -P{{ .port }} -N -B -e {{ .%s }} {{ .database }}) && ", variable, queryName)
+	if option == "" {
+		// mysqldump dumps every table when it isn't given any, which is the opposite of what was asked for
+		s.prefix += fmt.Sprintf("{ [ -n \"$%s\" ] || { echo \"No tables in the database match the tables to sync\" >&2; false; }; } && ", variable)
+		s.tables = "$" + variable
+		return
+	}
+	s.arguments = append(s.arguments, "$"+variable)
+}
+
+// getTableSelection works out which of the database's tables are dumped - the tables in the include list, or all of
+// them if it's empty, less the ignored tables
+func (m BaseMariaDbSync) getTableSelection() mariadbTableSelection {
+	selection := mariadbTableSelection{substitutions: map[string]interface{}{}}
+	selection.addList(m.DbDatabase, m.Tables, "", "tables")
+	selection.addList(m.DbDatabase, m.IgnoreTable, "--ignore-table", "ignoreTables")
+	selection.addList(m.DbDatabase, m.IgnoreTableData, "--ignore-table-data", "ignoreTableData")
+	return selection
+}
+
+// planQuery is the query listing the size, estimated row count and plan status of each of the database's tables
+// that would be synced
+func (m BaseMariaDbSync) planQuery() string {
+	status := sqlString(SyncPlanItemSynced)
+	if len(m.IgnoreTableData) > 0 {
+		status = fmt.Sprintf("IF(%s, %s, %s)", tableListCondition(m.IgnoreTableData), sqlString(SyncPlanItemSchemaOnly), status)
+	}
+	if len(m.IgnoreTable) > 0 {
+		status = fmt.Sprintf("IF(%s, %s, %s)", tableListCondition(m.IgnoreTable), sqlString(SyncPlanItemIgnored), status)
+	}
+	condition := "table_schema = DATABASE()"
+	if len(m.Tables) > 0 {
+		condition += fmt.Sprintf(" AND (%s)", tableListCondition(m.Tables))
+	}
+	return fmt.Sprintf("SELECT table_name, COALESCE(data_length + index_length, 0), COALESCE(table_rows, 0), %s FROM information_schema.tables WHERE %s ORDER BY table_name", status, condition)
+}
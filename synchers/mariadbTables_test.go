@@ -0,0 +1,85 @@
+package synchers
+
+import (
+	"testing"
+)
+
+func TestBaseMariaDbSync_getDumpCommand(t *testing.T) {
+	const query = `MYSQL_PWD="${LAGOON_SYNC_SECRET_PASSWORD}" mysql -hdb -uuser -P3306 -N -B -e `
+	const dump = `MYSQL_PWD="${LAGOON_SYNC_SECRET_PASSWORD}" mysqldump --max-allowed-packet=500M --quick --add-locks --no-autocommit --single-transaction -hdb -uuser -P3306 `
+
+	tests := []struct {
+		name            string
+		tables          []string
+		ignoreTable     []string
+		ignoreTableData []string
+		want            string
+	}{
+		{
+			name: "Every table",
+			want: dump + ` drupal `,
+		},
+		{
+			name:            "Named tables",
+			tables:          []string{"node", "users"},
+			ignoreTable:     []string{"watchdog"},
+			ignoreTableData: []string{"cache", "sessions"},
+			want:            dump + `--ignore-table=drupal.watchdog --ignore-table-data=drupal.cache --ignore-table-data=drupal.sessions drupal node users`,
+		},
+		{
+			name:   "Included table patterns",
+			tables: []string{"node*", "/^users?$/", "watchdog"},
+			want: `tables=$(` + query + `'SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND (table_name LIKE '\''node%'\'' OR table_name REGEXP '\''^users?$'\'' OR table_name = '\''watchdog'\'')' drupal) && ` +
+				`{ [ -n "$tables" ] || { echo "No tables in the database match the tables to sync" >&2; false; }; } && ` +
+				dump + ` drupal $tables`,
+		},
+		{
+			name:            "Ignored table patterns",
+			ignoreTable:     []string{"watchdog"},
+			ignoreTableData: []string{"cache_*"},
+			want: `ignoreTableData=$(` + query + `'SELECT CONCAT('\''--ignore-table-data='\'', table_schema, '\''.'\'', table_name) FROM information_schema.tables WHERE table_schema = DATABASE() AND (table_name LIKE '\''cache\\_%'\'')' drupal) && ` +
+				dump + `--ignore-table=drupal.watchdog $ignoreTableData drupal `,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := BaseMariaDbSync{
+				DbHostname:      "db",
+				DbUsername:      "user",
+				DbPassword:      "pw",
+				DbPort:          "3306",
+				DbDatabase:      "drupal",
+				Tables:          tt.tables,
+				IgnoreTable:     tt.ignoreTable,
+				IgnoreTableData: tt.ignoreTableData,
+			}
+			command := m.getDumpCommand()
+			got, err := command.GetCommand()
+			if err != nil {
+				t.Fatalf("GetCommand() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getDumpCommand() got\n%v\nwant\n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_globToLike(t *testing.T) {
+	tests := []struct {
+		glob string
+		want string
+	}{
+		{glob: "cache_*", want: `cache\_%`},
+		{glob: "cache?", want: "cache_"},
+		{glob: "100%", want: `100\%`},
+		{glob: "watchdog", want: "watchdog"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.glob, func(t *testing.T) {
+			if got := globToLike(tt.glob); got != tt.want {
+				t.Errorf("globToLike() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
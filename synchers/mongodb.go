@@ -6,7 +6,6 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/spf13/viper"
 	"github.com/uselagoon/lagoon-sync/utils"
 )
 
@@ -40,6 +39,7 @@ type MongoDbSyncRoot struct {
 	LocalOverrides           MongoDbSyncLocal `yaml:"local"`
 	TransferId               string
 	TransferResourceOverride string
+	Transport                string `yaml:"transport"`
 }
 
 // Init related types and functions follow
@@ -77,7 +77,9 @@ func (m MongoDbSyncPlugin) UnmarshallYaml(root SyncherConfigRoot, targetService
 	}
 
 	// unmarshal environment variables as defaults
-	_ = UnmarshalIntoStruct(configMap, &mongodb)
+	if err := UnmarshalIntoStruct(configMap, &mongodb); err != nil {
+		return nil, fmt.Errorf("%w: unable to parse the %s config: %v", ErrConfigInvalid, targetService, err)
+	}
 
 	if len(root.LagoonSync) != 0 {
 		_ = UnmarshalIntoStruct(configMap, &mongodb)
@@ -86,7 +88,7 @@ func (m MongoDbSyncPlugin) UnmarshallYaml(root SyncherConfigRoot, targetService
 
 	if mongodb.Config.IsBaseMongoDbStructureEmpty() && &mongodb == nil {
 		m.isConfigEmpty = true
-		utils.LogFatalError("No syncer configuration could be found in", viper.GetViper().ConfigFileUsed())
+		return nil, fmt.Errorf("%w: no syncer configuration could be found", ErrConfigInvalid)
 	}
 
 	lagoonSyncer, _ := mongodb.PrepareSyncer()
@@ -156,6 +158,39 @@ func (m *MongoDbSyncRoot) GetLocalCommand(targetEnvironment Environment) []SyncC
 	}
 }
 
+// IsImportRetryable is true since mongorestore is run with --drop, replacing each collection it restores
+func (m *MongoDbSyncRoot) IsImportRetryable() bool {
+	return true
+}
+
+func (root *MongoDbSyncRoot) GetRemoteStreamCommand(sourceEnvironment Environment) SyncCommand {
+	m := root.Config
+
+	if sourceEnvironment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
+		m = root.getEffectiveLocalDetails()
+	}
+
+	// Leaving the value off --archive makes mongodump write the archive to stdout
+	return generateSyncCommand("mongodump --host {{ .hostname }} --port {{ .port }} --db {{ .database }} --archive",
+		map[string]interface{}{
+			"hostname": m.DbHostname,
+			"port":     m.DbPort,
+			"database": m.DbDatabase,
+		})
+}
+
+func (m *MongoDbSyncRoot) GetLocalStreamCommand(targetEnvironment Environment) SyncCommand {
+	l := m.Config
+	if targetEnvironment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
+		l = m.getEffectiveLocalDetails()
+	}
+	return generateSyncCommand("mongorestore --drop --host {{ .hostname }} --port {{ .port }} --archive",
+		map[string]interface{}{
+			"hostname": l.DbHostname,
+			"port":     l.DbPort,
+		})
+}
+
 func (m *MongoDbSyncRoot) GetFilesToCleanup(environment Environment) []string {
 	transferResource := m.GetTransferResource(environment)
 	return []string{
@@ -170,7 +205,8 @@ func (m *MongoDbSyncRoot) GetTransferResource(environment Environment) SyncerTra
 	}
 	return SyncerTransferResource{
 		Name:        resourceName,
-		IsDirectory: false}
+		IsDirectory: false,
+		Transport:   m.Transport}
 }
 
 func (m *MongoDbSyncRoot) SetTransferResource(transferResourceName string) error {
@@ -0,0 +1,254 @@
+package synchers
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/uselagoon/lagoon-sync/utils"
+)
+
+// What a sync would do with each of the tables or directories in a plan
+const (
+	SyncPlanItemSynced     = "synced"
+	SyncPlanItemSchemaOnly = "schema-only"
+	SyncPlanItemIgnored    = "ignored"
+)
+
+// DefaultPlanBandwidth is the transfer speed, in bytes a second, a plan's transfer time is estimated with if no other
+// is given
+const DefaultPlanBandwidth = 10 * 1000 * 1000
+
+// SyncPlanner is implemented by syncers that can measure what a sync would transfer, for `lagoon-sync plan`
+type SyncPlanner interface {
+	// GetPlanCommand will return the command that measures what would be exported from the environment. It prints a
+	// tab separated line for each table or directory: its name, its size in bytes, how many rows or files it has,
+	// and whether it's synced, synced without its data, or ignored.
+	GetPlanCommand(environment Environment) SyncCommand
+	// GetPlanTools will return the commands the sync runs on the source and on the target
+	GetPlanTools() (sourceTools []string, targetTools []string)
+}
+
+// SyncPlanItem is a table or directory a sync would transfer
+type SyncPlanItem struct {
+	Name   string `json:"name"`
+	Bytes  int64  `json:"bytes"`
+	Count  int64  `json:"count"`
+	Status string `json:"status"`
+}
+
+// SyncPlanEnvironment is what a plan found out about one end of the sync
+type SyncPlanEnvironment struct {
+	Environment string `json:"environment"`
+	// Directory is where the sync writes its transfer resource in the environment
+	Directory string `json:"directory"`
+	// FreeBytes is the free disk space in the directory, -1 if it couldn't be found out
+	FreeBytes int64 `json:"freeBytes"`
+	// Tools are the commands the sync runs in the environment, and whether they were found. It's empty if the
+	// environment couldn't be checked.
+	Tools map[string]bool `json:"tools"`
+}
+
+// SyncPlan is what a sync would do, without it having been run
+type SyncPlan struct {
+	SyncerType string              `json:"syncerType"`
+	Source     SyncPlanEnvironment `json:"source"`
+	Target     SyncPlanEnvironment `json:"target"`
+	Items      []SyncPlanItem      `json:"items"`
+	// TransferBytes is the size of everything that would be synced. Dumps and compression make what's actually
+	// transferred differ from it, so it's a rough measure.
+	TransferBytes int64 `json:"transferBytes"`
+	// EstimatedTransferMs is how long transferring TransferBytes would take at the bandwidth the plan was given
+	EstimatedTransferMs int64    `json:"estimatedTransferMs"`
+	Warnings            []string `json:"warnings,omitempty"`
+}
+
+// PlanSyncArguments describes the sync to plan
+type PlanSyncArguments struct {
+	SourceEnvironment Environment
+	TargetEnvironment Environment
+	LagoonSyncer      Syncer
+	SyncerType        string
+	SshOptionWrapper  *SSHOptionWrapper
+	// Bandwidth is the transfer speed in bytes a second, DefaultPlanBandwidth if not set
+	Bandwidth int64
+	Logger    *utils.Logger
+}
+
+// PlanSync measures what a sync would transfer from the source, and checks both ends of it have the tools and
+// space it needs, without changing anything
+func PlanSync(ctx context.Context, args PlanSyncArguments) (*SyncPlan, error) {
+	planner, ok := args.LagoonSyncer.(SyncPlanner)
+	if !ok {
+		return nil, fmt.Errorf("%w: the %s syncer can't be planned", ErrConfigInvalid, args.SyncerType)
+	}
+	bandwidth := args.Bandwidth
+	if bandwidth <= 0 {
+		bandwidth = DefaultPlanBandwidth
+	}
+
+	args.Logger.LogProcessStep("Measuring what would be synced from", args.SourceEnvironment.EnvironmentName)
+	output, err := captureCommand(ctx, args.SourceEnvironment, planner.GetPlanCommand(args.SourceEnvironment), args.SshOptionWrapper, args.Logger)
+	if err != nil {
+		return nil, fmt.Errorf("unable to measure what would be synced: %w", err)
+	}
+
+	plan := &SyncPlan{SyncerType: args.SyncerType, Items: parsePlanItems(output)}
+	for _, item := range plan.Items {
+		if item.Status == SyncPlanItemSynced {
+			plan.TransferBytes += item.Bytes
+		}
+	}
+	plan.EstimatedTransferMs = plan.TransferBytes * 1000 / bandwidth
+
+	sourceTools, targetTools := planner.GetPlanTools()
+	sourceResource := args.LagoonSyncer.GetTransferResource(args.SourceEnvironment)
+	targetResource := args.LagoonSyncer.GetTransferResource(args.TargetEnvironment)
+	// The rsync transport falls back to sftp without rsync, so it's checked for without being required
+	if sourceResource.Transport == "" || sourceResource.Transport == defaultTransportId {
+		if !utils.SliceContains(sourceTools, "rsync") {
+			sourceTools = append(sourceTools, "rsync")
+		}
+		if !utils.SliceContains(targetTools, "rsync") {
+			targetTools = append(targetTools, "rsync")
+		}
+	}
+	// Files are synced straight into place, so only dumps need space on the source
+	environments := []struct {
+		environment SyncPlanEnvironment
+		needsSpace  bool
+	}{
+		{environment: inspectPlanEnvironment(ctx, args.SourceEnvironment, sourceResource, sourceTools, plan, args.SshOptionWrapper, args.Logger), needsSpace: !sourceResource.IsDirectory},
+		{environment: inspectPlanEnvironment(ctx, args.TargetEnvironment, targetResource, targetTools, plan, args.SshOptionWrapper, args.Logger), needsSpace: true},
+	}
+	plan.Source, plan.Target = environments[0].environment, environments[1].environment
+
+	for _, e := range environments {
+		environment := e.environment
+		if e.needsSpace && environment.FreeBytes >= 0 && environment.FreeBytes < plan.TransferBytes {
+			plan.warn("%s has %d bytes free in %s, less than the %d bytes to be synced", environment.Environment, environment.FreeBytes, environment.Directory, plan.TransferBytes)
+		}
+		for _, tool := range sortedKeys(environment.Tools) {
+			if environment.Tools[tool] {
+				continue
+			}
+			if tool == "rsync" {
+				plan.warn("rsync isn't available on %s, so the transfer will fall back to sftp", environment.Environment)
+			} else {
+				plan.warn("%s isn't available on %s", tool, environment.Environment)
+			}
+		}
+	}
+	return plan, nil
+}
+
+// warn adds a warning to the plan, unless it already has it - as it would when both ends of the sync are local
+func (p *SyncPlan) warn(format string, a ...interface{}) {
+	warning := fmt.Sprintf(format, a...)
+	if !utils.SliceContains(p.Warnings, warning) {
+		p.Warnings = append(p.Warnings, warning)
+	}
+}
+
+// inspectPlanEnvironment finds out how much space is free where the transfer resource is written, and which of the
+// tools are available. Failing to is only a warning, so that the rest of the plan can still be reported.
+func inspectPlanEnvironment(ctx context.Context, environment Environment, resource SyncerTransferResource, tools []string, plan *SyncPlan, sshOptionWrapper *SSHOptionWrapper, logger *utils.Logger) SyncPlanEnvironment {
+	inspected := SyncPlanEnvironment{
+		Environment: environment.EnvironmentName,
+		Directory:   resource.Name,
+		FreeBytes:   -1,
+		Tools:       map[string]bool{},
+	}
+	if !resource.IsDirectory {
+		inspected.Directory = path.Dir(resource.Name)
+	}
+
+	// The directory may not exist until the sync creates it, so the space is measured in the closest that does
+	command := generateSyncCommand(`d={{ .directory }}; while [ ! -e "$d" ]; do d=$(dirname "$d"); done; df -Pk "$d" | awk 'NR==2 {print "free", $4}'; for tool in {{ .tools }}; do if command -v "$tool" >/dev/null 2>&1; then echo "tool $tool"; fi; done`,
+		map[string]interface{}{
+			"directory": inspected.Directory,
+			"tools":     strings.Join(tools, " "),
+		})
+
+	logger.LogProcessStep("Checking the disk space and tools on", environment.EnvironmentName)
+	output, err := captureCommand(ctx, environment, command, sshOptionWrapper, logger)
+	if err != nil {
+		plan.warn("unable to check %s: %v", environment.EnvironmentName, err)
+		return inspected
+	}
+
+	for _, tool := range tools {
+		inspected.Tools[tool] = false
+	}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) == 2 && fields[0] == "free":
+			if kilobytes, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				inspected.FreeBytes = kilobytes * 1024
+			}
+		case len(fields) == 2 && fields[0] == "tool":
+			if _, listed := inspected.Tools[fields[1]]; listed {
+				inspected.Tools[fields[1]] = true
+			}
+		}
+	}
+	return inspected
+}
+
+// parsePlanItems reads the lines a plan command prints, skipping anything else in its output
+func parsePlanItems(output string) []SyncPlanItem {
+	items := []SyncPlanItem{}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Split(strings.TrimRight(scanner.Text(), "\r"), "\t")
+		if len(fields) != 4 {
+			continue
+		}
+		bytes, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		count, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		items = append(items, SyncPlanItem{Name: fields[0], Bytes: bytes, Count: count, Status: fields[3]})
+	}
+	return items
+}
+
+// captureCommand runs a command in the environment and returns what it printed. Remote commands return their
+// stderr mixed in with it.
+func captureCommand(ctx context.Context, environment Environment, command SyncCommand, sshOptionWrapper *SSHOptionWrapper, logger *utils.Logger) (string, error) {
+	rendered, err := renderCommand(command)
+	if err != nil {
+		return "", err
+	}
+	logger.LogExecutionStep("Running the following", rendered.loggedString)
+
+	if environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
+		err, output, errstring := utils.Shellout(ctx, rendered.execString, rendered.secrets)
+		if err != nil && errstring != "" {
+			return output, fmt.Errorf("%w: %s", err, strings.TrimSpace(errstring))
+		}
+		return output, err
+	}
+	sshOptions := sshOptionWrapper.GetSSHOptionsForEnvironment(environment.EnvironmentName)
+	err, output := utils.RemoteShellout(ctx, rendered.execString, rendered.secrets, environment.ServiceName, environment.GetOpenshiftProjectName(), sshOptions.Host, sshOptions.Port, sshOptions.PrivateKey, sshOptions.SkipAgent, sshOptions.GetHostKeyVerification())
+	return output, err
+}
+
+func sortedKeys(values map[string]bool) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,110 @@
+package synchers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePlanItems(t *testing.T) {
+	output := "Warning: something on stderr\n" +
+		"users\t16384\t12\tsynced\n" +
+		"cache_page\t1048576\t300\tschema-only\r\n" +
+		"not\ta\tsize\tsynced\n"
+	want := []SyncPlanItem{
+		{Name: "users", Bytes: 16384, Count: 12, Status: SyncPlanItemSynced},
+		{Name: "cache_page", Bytes: 1048576, Count: 300, Status: SyncPlanItemSchemaOnly},
+	}
+	if got := parsePlanItems(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePlanItems() = %v, want %v", got, want)
+	}
+}
+
+func TestBaseMariaDbSync_planQuery(t *testing.T) {
+	tests := []struct {
+		name   string
+		config BaseMariaDbSync
+		want   []string
+	}{
+		{
+			name:   "Every table",
+			config: BaseMariaDbSync{},
+			want:   []string{"'synced' FROM information_schema.tables WHERE table_schema = DATABASE() ORDER BY"},
+		},
+		{
+			name:   "Selected tables",
+			config: BaseMariaDbSync{Tables: []string{"users", "cache_*"}},
+			want:   []string{"AND (table_name = 'users' OR table_name LIKE 'cache\\\\_%')"},
+		},
+		{
+			name:   "Ignored tables",
+			config: BaseMariaDbSync{IgnoreTable: []string{"/^tmp_/"}, IgnoreTableData: []string{"cache"}},
+			want:   []string{"IF(table_name REGEXP '^tmp_', 'ignored', IF(table_name = 'cache', 'schema-only', 'synced'))"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.planQuery()
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("planQuery() = %v, want it to contain %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPlanSync_Files(t *testing.T) {
+	dir := t.TempDir()
+	for name, size := range map[string]int{"a/one": 1500, "two": 500, "skip/three": 900} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	local := Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME}
+	plan, err := PlanSync(context.Background(), PlanSyncArguments{
+		SourceEnvironment: local,
+		TargetEnvironment: local,
+		LagoonSyncer:      &FilesSyncRoot{Config: BaseFilesSync{SyncPath: dir, Exclude: []string{"skip"}}},
+		SyncerType:        "files",
+		SshOptionWrapper:  &SSHOptionWrapper{},
+		Bandwidth:         1000,
+	})
+	if err != nil {
+		t.Fatalf("PlanSync() error = %v", err)
+	}
+
+	want := []SyncPlanItem{{Name: dir, Bytes: 2000, Count: 2, Status: SyncPlanItemSynced}}
+	if !reflect.DeepEqual(plan.Items, want) {
+		t.Errorf("PlanSync() items = %v, want %v", plan.Items, want)
+	}
+	if plan.TransferBytes != 2000 || plan.EstimatedTransferMs != 2000 {
+		t.Errorf("PlanSync() = %d bytes taking %dms, want 2000 bytes taking 2000ms", plan.TransferBytes, plan.EstimatedTransferMs)
+	}
+	if plan.Target.FreeBytes <= 0 {
+		t.Errorf("PlanSync() didn't find the free space on the target")
+	}
+	if _, checked := plan.Target.Tools["rsync"]; !checked {
+		t.Errorf("PlanSync() didn't check for rsync on the target")
+	}
+}
+
+func TestPlanSync_SyncersThatCantPlan(t *testing.T) {
+	_, err := PlanSync(context.Background(), PlanSyncArguments{
+		SourceEnvironment: Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME},
+		TargetEnvironment: Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME},
+		LagoonSyncer:      journalTestSyncer{dir: t.TempDir()},
+		SyncerType:        "test",
+		SshOptionWrapper:  &SSHOptionWrapper{},
+	})
+	if err == nil {
+		t.Errorf("PlanSync() of a syncer that can't plan didn't fail")
+	}
+}
@@ -4,9 +4,9 @@ import (
 	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/spf13/viper"
 	"github.com/uselagoon/lagoon-sync/utils"
 )
 
@@ -27,6 +27,11 @@ type PostgresSyncRoot struct {
 	LocalOverrides           PostgresSyncLocal `yaml:"local"`
 	TransferId               string
 	TransferResourceOverride string
+	Transport                string        `yaml:"transport"`
+	Sanitize                 SanitizeRules `yaml:"sanitize"`
+	SanitizeSalt             string        `yaml:"sanitizeSalt"`
+
+	salt string // the salt the sanitize rules hash with, generated once per run unless SanitizeSalt is set
 }
 
 type PostgresSyncLocal struct {
@@ -79,7 +84,12 @@ func (m PostgresSyncPlugin) UnmarshallYaml(syncerConfigRoot SyncherConfigRoot, t
 
 	// If yaml config is there then unmarshall into struct and override default values if there are any
 	if configMap != nil {
-		_ = UnmarshalIntoStruct(configMap, &postgres)
+		if err := UnmarshalIntoStruct(configMap, &postgres); err != nil {
+			return nil, fmt.Errorf("%w: unable to parse the %s config: %v", ErrConfigInvalid, targetService, err)
+		}
+		if err := postgres.Sanitize.Validate(); err != nil {
+			return nil, err
+		}
 		utils.LogDebugInfo("Config that will be used for sync", postgres)
 	} else {
 		// If config from active config file is empty, then use defaults
@@ -90,7 +100,7 @@ func (m PostgresSyncPlugin) UnmarshallYaml(syncerConfigRoot SyncherConfigRoot, t
 
 	if postgres.Config.IsBasePostgresDbStructureEmpty() && &postgres == nil {
 		m.isConfigEmpty = true
-		utils.LogFatalError("No syncer configuration could be found in", viper.GetViper().ConfigFileUsed())
+		return nil, fmt.Errorf("%w: no syncer configuration could be found", ErrConfigInvalid)
 	}
 
 	lagoonSyncer, _ := postgres.PrepareSyncer()
@@ -140,7 +150,8 @@ func (root *PostgresSyncRoot) GetRemoteCommand(environment Environment) []SyncCo
 
 	return []SyncCommand{
 		{
-			command: fmt.Sprintf("PGPASSWORD=\"%s\" pg_dump -h%s -U%s -p%s -d%s %s %s -Fc -w -f%s", m.DbPassword, m.DbHostname, m.DbUsername, m.DbPort, m.DbDatabase, tablesToExclude, tablesWhoseDataToExclude, transferResource.Name),
+			command:       fmt.Sprintf("PGPASSWORD=\"{{ .password }}\" pg_dump -h%s -U%s -p%s -d%s %s %s -Fc -w -f%s", m.DbHostname, m.DbUsername, m.DbPort, m.DbDatabase, tablesToExclude, tablesWhoseDataToExclude, transferResource.Name),
+			substitutions: map[string]interface{}{"password": SensitiveValue(m.DbPassword)},
 		},
 	}
 }
@@ -148,10 +159,180 @@ func (root *PostgresSyncRoot) GetRemoteCommand(environment Environment) []SyncCo
 func (m *PostgresSyncRoot) GetLocalCommand(environment Environment) []SyncCommand {
 	l := m.getEffectiveLocalDetails()
 	transferResource := m.GetTransferResource(environment)
-	return []SyncCommand{{
-		command: fmt.Sprintf("PGPASSWORD=\"%s\" pg_restore -O -c --if-exists -x -w -h%s -d%s -p%s -U%s %s", l.DbPassword, l.DbHostname, l.DbDatabase, l.DbPort, l.DbUsername, transferResource.Name),
+	commands := []SyncCommand{{
+		command:       fmt.Sprintf("PGPASSWORD=\"{{ .password }}\" pg_restore -O -c --if-exists -x -w -h%s -d%s -p%s -U%s %s", l.DbHostname, l.DbDatabase, l.DbPort, l.DbUsername, transferResource.Name),
+		substitutions: map[string]interface{}{"password": SensitiveValue(l.DbPassword)},
 	},
 	}
+	if sanitizeCommand, ok := m.getSanitizeCommand(l, false); ok {
+		commands = append(commands, sanitizeCommand)
+	}
+	return commands
+}
+
+// getSanitizeCommand is the command applying the sanitize rules to the restored database. It's run as part of the
+// import, so that the import isn't complete until the data is sanitized. With force, each statement is run on its
+// own, so that the statements after one that fails are still run. The salt is set for the session in PGOPTIONS,
+// rather than in psql's arguments.
+func (m *PostgresSyncRoot) getSanitizeCommand(l BasePostgresSync, force bool) (SyncCommand, bool) {
+	sql := m.Sanitize.sql(postgresDialect)
+	if sql == "" {
+		return SyncCommand{}, false
+	}
+	if m.salt == "" {
+		m.salt = newSanitizeSalt(m.SanitizeSalt)
+	}
+	if force {
+		var statements []string
+		for _, statement := range m.Sanitize.statements(postgresDialect) {
+			statements = append(statements, "-c "+shellQuote(statement))
+		}
+		sql = strings.Join(statements, " ")
+	} else {
+		// Statements given together with -c are run in a single transaction
+		sql = "-v ON_ERROR_STOP=1 -c " + shellQuote(sql)
+	}
+	return SyncCommand{
+		command: fmt.Sprintf("PGOPTIONS=\"-c lagoon_sync.sanitize_salt={{ .sanitizeSalt }}\" PGPASSWORD=\"{{ .password }}\" psql -w -h%s -d%s -p%s -U%s {{ .sanitizeSql }}", l.DbHostname, l.DbDatabase, l.DbPort, l.DbUsername),
+		substitutions: map[string]interface{}{
+			"password":     SensitiveValue(l.DbPassword),
+			"sanitizeSql":  sql,
+			"sanitizeSalt": SensitiveValue(m.salt),
+		},
+	}, true
+}
+
+func (m *PostgresSyncRoot) SanitizesData() bool {
+	return len(m.Sanitize) > 0
+}
+
+func (m *PostgresSyncRoot) GetSanitizeCommand(environment Environment) SyncCommand {
+	l := m.Config
+	if environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
+		l = m.getEffectiveLocalDetails()
+	}
+	command, _ := m.getSanitizeCommand(l, true)
+	return command
+}
+
+// IsImportRetryable is true since pg_restore is run with --clean, dropping objects before recreating them
+func (m *PostgresSyncRoot) IsImportRetryable() bool {
+	return true
+}
+
+func (root *PostgresSyncRoot) GetRemoteStreamCommand(environment Environment) SyncCommand {
+	m := root.Config
+	if environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
+		m = root.getEffectiveLocalDetails()
+	}
+
+	var tablesToExclude string
+	for _, s := range m.ExcludeTable {
+		tablesToExclude += fmt.Sprintf("--exclude-table=%s.%s ", m.DbDatabase, s)
+	}
+
+	var tablesWhoseDataToExclude string
+	for _, s := range m.ExcludeTableData {
+		tablesWhoseDataToExclude += fmt.Sprintf("--exclude-table-data=%s.%s ", m.DbDatabase, s)
+	}
+
+	return SyncCommand{
+		command:       fmt.Sprintf("PGPASSWORD=\"{{ .password }}\" pg_dump -h%s -U%s -p%s -d%s %s %s -Fc -w", m.DbHostname, m.DbUsername, m.DbPort, m.DbDatabase, tablesToExclude, tablesWhoseDataToExclude),
+		substitutions: map[string]interface{}{"password": SensitiveValue(m.DbPassword)},
+	}
+}
+
+func (m *PostgresSyncRoot) GetLocalStreamCommand(environment Environment) SyncCommand {
+	l := m.Config
+	if environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
+		l = m.getEffectiveLocalDetails()
+	}
+	restoreCommand := SyncCommand{
+		command:       fmt.Sprintf("PGPASSWORD=\"{{ .password }}\" pg_restore -O -c --if-exists -x -w -h%s -d%s -p%s -U%s", l.DbHostname, l.DbDatabase, l.DbPort, l.DbUsername),
+		substitutions: map[string]interface{}{"password": SensitiveValue(l.DbPassword)},
+	}
+	if sanitizeCommand, ok := m.getSanitizeCommand(l, false); ok {
+		restoreCommand.command += " && " + sanitizeCommand.command
+		restoreCommand.substitutions["sanitizeSql"] = sanitizeCommand.substitutions["sanitizeSql"]
+		restoreCommand.substitutions["sanitizeSalt"] = sanitizeCommand.substitutions["sanitizeSalt"]
+	}
+	return restoreCommand
+}
+
+func (m *PostgresSyncRoot) GetBackupCommand(environment Environment, backupFile string) SyncCommand {
+	l := m.Config
+	if environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
+		l = m.getEffectiveLocalDetails()
+	}
+	return SyncCommand{
+		command: fmt.Sprintf("PGPASSWORD=\"{{ .password }}\" pg_dump -h%s -U%s -p%s -d%s -Fc -w -f {{ .backupFile }}", l.DbHostname, l.DbUsername, l.DbPort, l.DbDatabase),
+		substitutions: map[string]interface{}{
+			"password":   SensitiveValue(l.DbPassword),
+			"backupFile": backupFile,
+		},
+	}
+}
+
+func (m *PostgresSyncRoot) GetRestoreCommand(environment Environment, backupFile string) SyncCommand {
+	l := m.Config
+	if environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
+		l = m.getEffectiveLocalDetails()
+	}
+	return SyncCommand{
+		// The public schema is cleared first, so that a restore doesn't leave behind tables a sync created that
+		// weren't there when the backup was taken
+		command: fmt.Sprintf("PGPASSWORD=\"{{ .password }}\" psql -w -h%s -d%s -p%s -U%s -v ON_ERROR_STOP=1 -c {{ .dropQuery }} && ", l.DbHostname, l.DbDatabase, l.DbPort, l.DbUsername) +
+			fmt.Sprintf("PGPASSWORD=\"{{ .password }}\" pg_restore -O -c --if-exists -x -w -h%s -d%s -p%s -U%s {{ .backupFile }}", l.DbHostname, l.DbDatabase, l.DbPort, l.DbUsername),
+		substitutions: map[string]interface{}{
+			"password":   SensitiveValue(l.DbPassword),
+			"dropQuery":  shellQuote("DROP SCHEMA IF EXISTS public CASCADE; CREATE SCHEMA public;"),
+			"backupFile": backupFile,
+		},
+	}
+}
+
+// GetPlanCommand lists the size of each table, with its indexes and toast tables, from pg_total_relation_size
+func (m *PostgresSyncRoot) GetPlanCommand(environment Environment) SyncCommand {
+	l := m.Config
+	if environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
+		l = m.getEffectiveLocalDetails()
+	}
+	return SyncCommand{
+		command: fmt.Sprintf("PGPASSWORD=\"{{ .password }}\" psql -w -h%s -d%s -p%s -U%s -At -F \"$(printf '\\t')\" -c {{ .planQuery }}", l.DbHostname, l.DbDatabase, l.DbPort, l.DbUsername),
+		substitutions: map[string]interface{}{
+			"password":  SensitiveValue(l.DbPassword),
+			"planQuery": shellQuote(l.planQuery()),
+		},
+	}
+}
+
+// planQuery is the query listing the size, estimated row count and plan status of each of the database's tables.
+// Excluded tables are matched by their name, or their schema and name.
+func (m BasePostgresSync) planQuery() string {
+	tableIn := func(tables []string) string {
+		literals := make([]string, 0, len(tables))
+		for _, table := range tables {
+			literals = append(literals, postgresDialect.literal(table))
+		}
+		list := strings.Join(literals, ", ")
+		return fmt.Sprintf("(c.relname IN (%s) OR n.nspname || '.' || c.relname IN (%s))", list, list)
+	}
+	status := postgresDialect.literal(SyncPlanItemSynced)
+	if len(m.ExcludeTable) > 0 || len(m.ExcludeTableData) > 0 {
+		status = "CASE"
+		if len(m.ExcludeTable) > 0 {
+			status += fmt.Sprintf(" WHEN %s THEN %s", tableIn(m.ExcludeTable), postgresDialect.literal(SyncPlanItemIgnored))
+		}
+		if len(m.ExcludeTableData) > 0 {
+			status += fmt.Sprintf(" WHEN %s THEN %s", tableIn(m.ExcludeTableData), postgresDialect.literal(SyncPlanItemSchemaOnly))
+		}
+		status += fmt.Sprintf(" ELSE %s END", postgresDialect.literal(SyncPlanItemSynced))
+	}
+	return fmt.Sprintf("SELECT c.relname, pg_total_relation_size(c.oid), GREATEST(c.reltuples, 0)::bigint, %s FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace WHERE c.relkind IN ('r', 'p') AND n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname NOT LIKE 'pg_toast%%' ORDER BY c.relname", status)
+}
+
+func (m *PostgresSyncRoot) GetPlanTools() ([]string, []string) {
+	return []string{"pg_dump"}, []string{"pg_restore"}
 }
 
 func (m *PostgresSyncRoot) GetFilesToCleanup(environment Environment) []string {
@@ -169,7 +350,8 @@ func (m *PostgresSyncRoot) GetTransferResource(environment Environment) SyncerTr
 
 	return SyncerTransferResource{
 		Name:        resourceName,
-		IsDirectory: false}
+		IsDirectory: false,
+		Transport:   m.Transport}
 }
 
 func (m *PostgresSyncRoot) SetTransferResource(transferResourceName string) error {
@@ -1,20 +1,17 @@
 package synchers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"os"
 	"strings"
 
-	"github.com/uselagoon/lagoon-sync/assets"
-
 	"github.com/uselagoon/lagoon-sync/prerequisite"
 	"github.com/uselagoon/lagoon-sync/utils"
 )
 
-func RunPrerequisiteCommand(environment Environment, syncer Syncer, syncerType string, dryRun bool, sshOptionWrapper *SSHOptionWrapper) (Environment, error) {
+func RunPrerequisiteCommand(ctx context.Context, environment Environment, syncer Syncer, syncerType string, dryRun bool, sshOptionWrapper *SSHOptionWrapper) (Environment, error) {
 
 	sshOptions := sshOptionWrapper.GetSSHOptionsForEnvironment(environment.EnvironmentName)
 
@@ -39,19 +36,17 @@ func RunPrerequisiteCommand(environment Environment, syncer Syncer, syncerType s
 	var output string
 
 	if environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
-		err, response, errstring := utils.Shellout(execString)
+		err, response, errstring := utils.Shellout(ctx, execString, nil)
 		if err != nil {
 			log.Printf(errstring)
 			return environment, err
 		}
-		if response != "" && debug == false {
-			log.Println(response)
-		}
+		utils.LogDebugInfo(response, nil)
 	} else {
-		err, output := utils.RemoteShellout(execString, environment.ServiceName, environment.GetOpenshiftProjectName(), sshOptions.Host, sshOptions.Port, sshOptions.PrivateKey, sshOptions.SkipAgent)
+		err, output := utils.RemoteShellout(ctx, execString, nil, environment.ServiceName, environment.GetOpenshiftProjectName(), sshOptions.Host, sshOptions.Port, sshOptions.PrivateKey, sshOptions.SkipAgent, sshOptions.GetHostKeyVerification())
 		utils.LogDebugInfo(output, nil)
 		if err != nil {
-			utils.LogFatalError("Unable to exec remote command: "+err.Error(), nil)
+			utils.LogError("Unable to exec remote command: "+err.Error(), nil)
 			return environment, err
 		}
 	}
@@ -77,11 +72,6 @@ func RunPrerequisiteCommand(environment Environment, syncer Syncer, syncerType s
 		}
 	}
 
-	lagoonSyncVersion := "unknown"
-	if data.Version != "" {
-		lagoonSyncVersion = data.Version
-	}
-
 	// Check if prerequisite checks were successful.
 	if !configRespSuccessful {
 		utils.LogDebugInfo("Unable to determine rsync config, will attempt to use 'rsync' path instead", environment.EnvironmentName)
@@ -92,22 +82,16 @@ func RunPrerequisiteCommand(environment Environment, syncer Syncer, syncerType s
 		utils.LogDebugInfo("Rsync found", environment.RsyncPath)
 	}
 
-	if !dryRun && !environment.RsyncAvailable {
-		// Add rsync to env
-		rsyncPath, err := createRsync(environment, syncer, lagoonSyncVersion, sshOptions)
-		if err != nil {
-			return environment, err
-		}
-
-		utils.LogDebugInfo("Rsync path", rsyncPath)
-		environment.RsyncPath = rsyncPath
-		return environment, nil
+	if !environment.RsyncAvailable {
+		// Rather than installing rsync, the rsync transport falls back to sftp when rsync can't be found
+		utils.LogDebugInfo("Rsync not found, transfers will fall back to sftp", environment.EnvironmentName)
+		environment.RsyncPath = "rsync"
 	}
 
 	return environment, nil
 }
 
-func PrerequisiteCleanUp(environment Environment, rsyncPath string, dryRun bool, sshOptionWrapper *SSHOptionWrapper) error {
+func PrerequisiteCleanUp(ctx context.Context, environment Environment, rsyncPath string, dryRun bool, sshOptionWrapper *SSHOptionWrapper, logger *utils.Logger) error {
 
 	sshOptions := sshOptionWrapper.GetSSHOptionsForEnvironment(environment.EnvironmentName)
 
@@ -115,119 +99,28 @@ func PrerequisiteCleanUp(environment Environment, rsyncPath string, dryRun bool,
 		return nil
 	}
 
-	utils.LogProcessStep("Beginning prerequisite resource cleanup on", environment.EnvironmentName)
+	logger.LogProcessStep("Beginning prerequisite resource cleanup on", environment.EnvironmentName)
 
 	execString := fmt.Sprintf("rm -r %s", rsyncPath)
 
 	if environment.EnvironmentName != LOCAL_ENVIRONMENT_NAME {
-		execString = GenerateRemoteCommand(environment, execString, sshOptions)
+		var err error
+		execString, err = GenerateRemoteCommand(environment, execString, sshOptions)
+		if err != nil {
+			return err
+		}
 	}
 
-	utils.LogExecutionStep("Running the following", execString)
+	logger.LogExecutionStep("Running the following", execString)
 
 	if !dryRun {
-		err, _, errstring := utils.Shellout(execString)
+		err, _, errstring := utils.Shellout(ctx, execString, nil)
 
 		if err != nil {
-			fmt.Println(errstring)
+			logger.LogError(errstring, nil)
 			return err
 		}
 	}
 
 	return nil
 }
-
-var RsyncAssetPath = "/tmp/rsync"
-
-// will add bundled rsync onto environment and return the new rsync path as string
-func createRsync(environment Environment, syncer Syncer, lagoonSyncVersion string, sshOptions SSHOptions) (string, error) {
-	utils.LogDebugInfo("%v environment doesn't have rsync", environment.EnvironmentName)
-	utils.LogDebugInfo("Downloading rsync asset on", environment.EnvironmentName)
-
-	environmentName := syncer.GetTransferResource(environment).Name
-	if syncer.GetTransferResource(environment).IsDirectory == true {
-		environmentName += "/"
-	}
-
-	// Create rsync asset
-	RsyncAssetPath, err := createRsyncAssetFromBytes(lagoonSyncVersion)
-	if err != nil {
-		log.Println(err)
-	}
-
-	rsyncDestinationPath := fmt.Sprintf("%vlagoon_sync_rsync_%v", "/tmp/", strings.ReplaceAll(lagoonSyncVersion, ".", "_"))
-
-	environment.RsyncLocalPath = rsyncDestinationPath
-	environment.RsyncPath = RsyncAssetPath
-
-	// If local we bail out here
-	if environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
-		return rsyncDestinationPath, nil
-	}
-
-	var execString string
-	command := fmt.Sprintf("'cat > %v && chmod +x %v' < %s",
-		rsyncDestinationPath,
-		rsyncDestinationPath,
-		rsyncDestinationPath,
-	)
-
-	if environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
-		execString = command
-	} else {
-		serviceArgument := ""
-		if environment.ServiceName != "" {
-			serviceArgument = fmt.Sprintf("service=%v", environment.ServiceName)
-
-		}
-
-		execString = fmt.Sprintf("ssh -t -o \"UserKnownHostsFile=/dev/null\" -o \"StrictHostKeyChecking=no\" -p %s %s@%s %s %s",
-			sshOptions.Port, environment.GetOpenshiftProjectName(), sshOptions.Host, serviceArgument, command)
-	}
-
-	utils.LogExecutionStep(fmt.Sprintf("Running the following for %s", environment.EnvironmentName), execString)
-
-	if err, _, errstring := utils.Shellout(execString); err != nil {
-		log.Println(errstring)
-		return "", err
-	}
-
-	// Remove local versioned rsync (post ssh transfer) - otherwise rsync will be avialable on target at /tmp/
-	log.Printf("Removing rsync binary locally stored: %s", rsyncDestinationPath)
-	if err := os.Remove(rsyncDestinationPath); err != nil {
-		log.Println(err.Error())
-	}
-
-	return rsyncDestinationPath, nil
-}
-
-func createRsyncAssetFromBytes(lagoonSyncVersion string) (string, error) {
-	tempRsyncPath := "/tmp/rsync"
-	err := ioutil.WriteFile(tempRsyncPath, assets.RsyncBin(), 0774)
-	if err != nil {
-		utils.LogFatalError("Unable to write to file", err)
-	}
-
-	if lagoonSyncVersion == "" {
-		utils.LogDebugInfo("No lagoon-sync version set", nil)
-	}
-
-	// Rename rsync binary with latest lagoon version appended.
-	versionedRsyncPath := fmt.Sprintf("%vlagoon_sync_rsync_%v", "/tmp/", strings.ReplaceAll(lagoonSyncVersion, ".", "_"))
-	cpRsyncCmd := fmt.Sprintf("cp %s %s",
-		tempRsyncPath,
-		versionedRsyncPath,
-	)
-
-	if err, _, errstring := utils.Shellout(cpRsyncCmd); err != nil {
-		log.Println(errstring)
-		return "", err
-	}
-
-	log.Printf("Removing temp rsync binary: %s", tempRsyncPath)
-	if err := os.Remove(tempRsyncPath); err != nil {
-		log.Println(err.Error())
-	}
-
-	return versionedRsyncPath, nil
-}
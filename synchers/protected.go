@@ -0,0 +1,81 @@
+package synchers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// defaultProtectedEnvironmentTypes are the Lagoon environment types protected unless the config says otherwise
+var defaultProtectedEnvironmentTypes = []string{"production"}
+
+// ProtectedEnvironments are the environments a sync can't overwrite without being explicitly told to, configured
+// under `lagoon-sync: protected-environments:`
+type ProtectedEnvironments struct {
+	// Environments are the names of the protected environments
+	Environments []string `yaml:"environments,omitempty" json:"environments,omitempty" mapstructure:"environments"`
+	// Patterns are regular expressions, any environment whose name they match is protected
+	Patterns []string `yaml:"patterns,omitempty" json:"patterns,omitempty" mapstructure:"patterns"`
+	// EnvironmentTypes are the Lagoon environment types that are protected, only production if not set. The type
+	// of an environment is looked up from the Lagoon API.
+	EnvironmentTypes []string `yaml:"environment-types,omitempty" json:"environmentTypes,omitempty" mapstructure:"environment-types"`
+
+	patterns []*regexp.Regexp
+}
+
+// DecodeProtectedEnvironments reads the protected environments from the `protected-environments` section of the
+// lagoon-sync config
+func DecodeProtectedEnvironments(raw interface{}) (ProtectedEnvironments, error) {
+	protected := ProtectedEnvironments{}
+	if raw != nil {
+		if err := mapstructure.Decode(raw, &protected); err != nil {
+			return ProtectedEnvironments{}, fmt.Errorf("%w: unable to parse the protected environments: %v", ErrConfigInvalid, err)
+		}
+	}
+	for _, pattern := range protected.Patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return ProtectedEnvironments{}, fmt.Errorf("%w: invalid protected environment pattern '%s': %v", ErrConfigInvalid, pattern, err)
+		}
+		protected.patterns = append(protected.patterns, compiled)
+	}
+	// An empty list turns off protecting environments by their type
+	if protected.EnvironmentTypes == nil {
+		protected.EnvironmentTypes = defaultProtectedEnvironmentTypes
+	}
+	return protected, nil
+}
+
+// ProtectsEnvironmentTypes reports whether environments are protected by their type, in which case the type of a
+// remote target has to be known before it can be synced into
+func (p ProtectedEnvironments) ProtectsEnvironmentTypes() bool {
+	return len(p.EnvironmentTypes) > 0
+}
+
+// Protects returns why the environment is protected, or an empty string if it isn't. The environment type is the
+// one the Lagoon API gives the environment, empty if it isn't known. The local environment is never protected.
+func (p ProtectedEnvironments) Protects(environmentName string, environmentType string) string {
+	if environmentName == LOCAL_ENVIRONMENT_NAME {
+		return ""
+	}
+	for _, name := range p.Environments {
+		if name == environmentName {
+			return "it's listed in the protected environments"
+		}
+	}
+	for _, pattern := range p.patterns {
+		if pattern.MatchString(environmentName) {
+			return fmt.Sprintf("it matches the protected environment pattern '%s'", pattern)
+		}
+	}
+	if environmentType != "" {
+		for _, protectedType := range p.EnvironmentTypes {
+			if strings.EqualFold(protectedType, environmentType) {
+				return fmt.Sprintf("it's a %s environment", environmentType)
+			}
+		}
+	}
+	return ""
+}
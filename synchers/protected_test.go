@@ -0,0 +1,74 @@
+package synchers
+
+import (
+	"testing"
+)
+
+func TestProtectedEnvironments_Protects(t *testing.T) {
+	tests := []struct {
+		name            string
+		config          interface{}
+		environmentName string
+		environmentType string
+		protected       bool
+		wantErr         bool
+	}{
+		{
+			name:            "Production environments are protected by default",
+			environmentName: "main",
+			environmentType: "production",
+			protected:       true,
+		},
+		{
+			name:            "Development environments aren't",
+			environmentName: "dev",
+			environmentType: "development",
+		},
+		{
+			name:            "Environments of an unknown type aren't",
+			environmentName: "main",
+		},
+		{
+			name:            "Listed environments",
+			config:          map[string]interface{}{"environments": []interface{}{"main", "prod"}},
+			environmentName: "prod",
+			protected:       true,
+		},
+		{
+			name:            "Environments matching a pattern",
+			config:          map[string]interface{}{"patterns": []interface{}{"^release-"}},
+			environmentName: "release-2024",
+			protected:       true,
+		},
+		{
+			name:            "Protecting by type can be turned off",
+			config:          map[string]interface{}{"environment-types": []interface{}{}},
+			environmentName: "main",
+			environmentType: "production",
+		},
+		{
+			name:            "The local environment is never protected",
+			config:          map[string]interface{}{"patterns": []interface{}{".*"}},
+			environmentName: LOCAL_ENVIRONMENT_NAME,
+		},
+		{
+			name:    "Invalid pattern",
+			config:  map[string]interface{}{"patterns": []interface{}{"release-("}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			protected, err := DecodeProtectedEnvironments(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeProtectedEnvironments() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if reason := protected.Protects(tt.environmentName, tt.environmentType); (reason != "") != tt.protected {
+				t.Errorf("Protects() = %q, want protected %v", reason, tt.protected)
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+package synchers
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uselagoon/lagoon-sync/utils"
+)
+
+const (
+	// RedisSyncModeKeys syncs the keys matching the syncer's patterns, with DUMP and RESTORE
+	RedisSyncModeKeys = "keys"
+	// RedisSyncModeRdb syncs a snapshot of the whole of the source, taken with redis-cli --rdb
+	RedisSyncModeRdb = "rdb"
+)
+
+type BaseRedisSync struct {
+	Hostname string `yaml:"hostname"`
+	Port     string `yaml:"port"`
+	Password string `yaml:"password"`
+	Database string `yaml:"database"`
+	// Cli is the client that's run, redis-cli unless it's set to valkey-cli
+	Cli string `yaml:"cli"`
+	// Mode is how the data is synced, either keys or rdb
+	Mode string `yaml:"mode"`
+	// Keys are the patterns of the keys synced in keys mode, every key if there are none
+	Keys []string `yaml:"keys"`
+	// DataDirectory is where the target's redis loads its snapshot from in rdb mode
+	DataDirectory   string `yaml:"data-directory"`
+	OutputDirectory string
+}
+
+type RedisSyncRoot struct {
+	Type                     string `yaml:"type" json:"type"`
+	ServiceName              string `yaml:"serviceName"`
+	Config                   BaseRedisSync
+	LocalOverrides           RedisSyncLocal `yaml:"local"`
+	TransferId               string
+	TransferResourceOverride string
+	Transport                string `yaml:"transport"`
+}
+
+type RedisSyncLocal struct {
+	Config BaseRedisSync
+}
+
+// SetDefaults is a public function that is used to set all defaults for this struct
+func (redisConfig *BaseRedisSync) SetDefaults() {
+	redisConfig.setDefaults()
+}
+
+func (redisConfig *BaseRedisSync) setDefaults() {
+	if redisConfig.Hostname == "" {
+		redisConfig.Hostname = "${REDIS_HOST:-redis}"
+	}
+	if redisConfig.Port == "" {
+		redisConfig.Port = "${REDIS_PORT:-6379}"
+	}
+	if redisConfig.Database == "" {
+		redisConfig.Database = "0"
+	}
+	if redisConfig.Cli == "" {
+		redisConfig.Cli = "redis-cli"
+	}
+	if redisConfig.Mode == "" {
+		redisConfig.Mode = RedisSyncModeKeys
+	}
+	if redisConfig.DataDirectory == "" {
+		redisConfig.DataDirectory = "/data"
+	}
+}
+
+// Init related types and functions follow
+
+type RedisSyncPlugin struct {
+	isConfigEmpty bool
+}
+
+func (m BaseRedisSync) IsBaseRedisSyncStructEmpty() bool {
+	return reflect.DeepEqual(m, BaseRedisSync{})
+}
+
+func (m RedisSyncPlugin) GetPluginId() string {
+	return "redis"
+}
+
+func (m RedisSyncPlugin) UnmarshallYaml(syncerConfigRoot SyncherConfigRoot, targetService string) (Syncer, error) {
+	redis := RedisSyncRoot{}
+	redis.Type = m.GetPluginId()
+	redis.Config.setDefaults()
+
+	configMap := syncerConfigRoot.LagoonSync[targetService]
+
+	// If yaml config is there then unmarshall into struct and override default values if there are any
+	if configMap != nil {
+		if err := UnmarshalIntoStruct(configMap, &redis); err != nil {
+			return nil, fmt.Errorf("%w: unable to parse the %s config: %v", ErrConfigInvalid, targetService, err)
+		}
+		utils.LogDebugInfo("Config that will be used for sync", redis)
+	} else {
+		utils.LogDebugInfo("Active syncer config is empty, so using defaults", redis)
+	}
+
+	if redis.Config.IsBaseRedisSyncStructEmpty() && &redis == nil {
+		m.isConfigEmpty = true
+		return nil, fmt.Errorf("%w: no syncer configuration could be found", ErrConfigInvalid)
+	}
+
+	return redis.PrepareSyncer()
+}
+
+func init() {
+	RegisterSyncer(RedisSyncPlugin{})
+}
+
+func (m *RedisSyncRoot) IsInitialized() (bool, error) {
+	return true, nil
+}
+
+// Sync related functions follow
+
+func (root *RedisSyncRoot) PrepareSyncer() (Syncer, error) {
+	if root.Config.Mode != RedisSyncModeKeys && root.Config.Mode != RedisSyncModeRdb {
+		return nil, fmt.Errorf("%w: the redis mode has to be %s or %s, not '%s'", ErrConfigInvalid, RedisSyncModeKeys, RedisSyncModeRdb, root.Config.Mode)
+	}
+	root.TransferId = strconv.FormatInt(time.Now().UnixNano(), 10)
+	return root, nil
+}
+
+func (root *RedisSyncRoot) GetPrerequisiteCommand(environment Environment, command string) SyncCommand {
+	lagoonSyncBin, _ := utils.FindLagoonSyncOnEnv()
+
+	return SyncCommand{
+		command: fmt.Sprintf("{{ .bin }} {{ .command }} || true"),
+		substitutions: map[string]interface{}{
+			"bin":     lagoonSyncBin,
+			"command": command,
+		},
+	}
+}
+
+func (root *RedisSyncRoot) GetRemoteCommand(environment Environment) []SyncCommand {
+	transferResource := root.GetTransferResource(environment)
+	return []SyncCommand{root.exportCommand(root.getEffectiveDetails(environment), transferResource.Name)}
+}
+
+func (root *RedisSyncRoot) GetLocalCommand(environment Environment) []SyncCommand {
+	transferResource := root.GetTransferResource(environment)
+	return []SyncCommand{root.importCommand(root.getEffectiveDetails(environment), transferResource.Name)}
+}
+
+// IsImportRetryable is true since both modes replace what they import - keys mode removes the keys matching its
+// patterns before restoring them, and rdb mode replaces the whole of the dataset
+func (root *RedisSyncRoot) IsImportRetryable() bool {
+	return true
+}
+
+func (root *RedisSyncRoot) GetRemoteStreamCommand(environment Environment) SyncCommand {
+	return root.exportCommand(root.getEffectiveDetails(environment), "")
+}
+
+func (root *RedisSyncRoot) GetLocalStreamCommand(environment Environment) SyncCommand {
+	return root.importCommand(root.getEffectiveDetails(environment), "")
+}
+
+// redisErrorCheck fails if the client printed an error. The client carries on past errors in the commands it's
+// piped, and exits successfully, so they're picked out of what it prints.
+const redisErrorCheck = `awk '/^\(error\)/ { print > "/dev/stderr"; failed = 1 } END { exit failed }'`
+
+// exportCommand writes the source's data to the file, or stdout if there's none.
+//
+// In keys mode, the keys matching the patterns are listed, and each key's PTTL and DUMP are fetched with a single
+// client. --no-raw quotes the dumps the same way the client reads quoted arguments, so the export is a list of
+// RESTORE commands that can be piped straight back into the client on the target.
+func (root *RedisSyncRoot) exportCommand(config BaseRedisSync, file string) SyncCommand {
+	cli, substitutions := config.cli()
+	if config.Mode == RedisSyncModeRdb {
+		substitutions["file"] = "-"
+		if file != "" {
+			substitutions["file"] = file
+		}
+		return generateSyncCommand(cli+" --rdb {{ .file }}", substitutions)
+	}
+
+	substitutions["keys"] = root.keysFile(config)
+	substitutions["redirect"] = ""
+	if file != "" {
+		substitutions["redirect"] = " > " + file
+	}
+	script := `keys={{ .keys }}; trap 'rm -f "$keys" "$keys.quoted" "$keys.replies"' EXIT; ` +
+		config.scanKeys(cli) + ` && ` +
+		`sed 's/[\\"]/\\&/g; s/.*/"&"/' "$keys" > "$keys.quoted" && ` +
+		`awk '{ print "PTTL " $0; print "DUMP " $0 }' "$keys.quoted" | ` + cli + ` --no-raw > "$keys.replies" && ` +
+		`awk 'NR == FNR { keys[NR] = $0; next } /^\(error\)/ { print > "/dev/stderr"; exit 1 } FNR % 2 == 1 { ttl = $2; next } $0 != "(nil)" { if (ttl < 0) ttl = 0; print "RESTORE " keys[FNR / 2] " " ttl " " $0 " REPLACE" }' "$keys.quoted" "$keys.replies"{{ .redirect }}`
+	return generateSyncCommand(script, substitutions)
+}
+
+// importCommand loads the data exported by exportCommand from the file, or stdin if there's none.
+//
+// In keys mode, the target's keys matching the patterns are removed before the export's RESTORE commands are run. In
+// rdb mode the snapshot replaces the target's, and redis is told to load it - which needs the data directory to be
+// reachable from where the import is run, and DEBUG to be enabled for the connection.
+func (root *RedisSyncRoot) importCommand(config BaseRedisSync, file string) SyncCommand {
+	cli, substitutions := config.cli()
+	substitutions["redirect"] = ""
+	if file != "" {
+		substitutions["redirect"] = " < " + file
+	}
+	if config.Mode == RedisSyncModeRdb {
+		substitutions["snapshot"] = strings.TrimSuffix(config.DataDirectory, "/") + "/dump.rdb"
+		return generateSyncCommand(`cat{{ .redirect }} > {{ .snapshot }}.lagoon_sync && mv {{ .snapshot }}.lagoon_sync {{ .snapshot }} && `+cli+` DEBUG RELOAD NOSAVE`, substitutions)
+	}
+
+	substitutions["keys"] = root.keysFile(config)
+	script := `keys={{ .keys }}; trap 'rm -f "$keys" "$keys.replies"' EXIT; ` +
+		config.scanKeys(cli) + ` && ` +
+		`sed 's/[\\"]/\\&/g; s/.*/UNLINK "&"/' "$keys" | ` + cli + ` --no-raw > "$keys.replies" && ` + redisErrorCheck + ` "$keys.replies" && ` +
+		cli + ` --no-raw{{ .redirect }} > "$keys.replies" && ` + redisErrorCheck + ` "$keys.replies"`
+	return generateSyncCommand(script, substitutions)
+}
+
+// scanKeys lists the keys matching the patterns into $keys, once each
+func (config BaseRedisSync) scanKeys(cli string) string {
+	var scans []string
+	for _, pattern := range config.keyPatterns() {
+		scans = append(scans, fmt.Sprintf(`%s --scan --pattern %s >> "$keys.scan"`, cli, shellQuote(pattern)))
+	}
+	return `: > "$keys.scan" && ` + strings.Join(scans, " && ") + ` && sort -u "$keys.scan" > "$keys" && rm -f "$keys.scan"`
+}
+
+// cli is the client command connecting to the database, with the substitutions it needs. The password is passed
+// in the environment, and as a SensitiveValue the command only refers to it, so it doesn't show up in process lists.
+func (config BaseRedisSync) cli() (string, map[string]interface{}) {
+	substitutions := map[string]interface{}{}
+	cli := fmt.Sprintf("%s -h %s -p %s", config.Cli, config.Hostname, config.Port)
+	if config.Mode == RedisSyncModeKeys {
+		cli += " -n " + config.Database
+	}
+	if config.Password != "" {
+		cli = `REDISCLI_AUTH="{{ .password }}" ` + cli
+		substitutions["password"] = SensitiveValue(config.Password)
+	}
+	return cli, substitutions
+}
+
+// keysFile is where the keys being synced are listed while they're exported or imported
+func (root *RedisSyncRoot) keysFile(config BaseRedisSync) string {
+	outputDirectory := config.OutputDirectory
+	if outputDirectory == "" {
+		outputDirectory = "/tmp/"
+	}
+	return fmt.Sprintf("%vlagoon_sync_redis_%v.keys", outputDirectory, root.TransferId)
+}
+
+func (config BaseRedisSync) keyPatterns() []string {
+	if len(config.Keys) == 0 {
+		return []string{"*"}
+	}
+	return config.Keys
+}
+
+func (root *RedisSyncRoot) GetFilesToCleanup(environment Environment) []string {
+	transferResource := root.GetTransferResource(environment)
+	return []string{
+		transferResource.Name,
+	}
+}
+
+func (root *RedisSyncRoot) GetTransferResource(environment Environment) SyncerTransferResource {
+	extension := "redis"
+	if root.Config.Mode == RedisSyncModeRdb {
+		extension = "rdb"
+	}
+	resourceName := fmt.Sprintf("%vlagoon_sync_redis_%v.%v", root.GetOutputDirectory(), root.TransferId, extension)
+	if root.TransferResourceOverride != "" {
+		resourceName = root.TransferResourceOverride
+	}
+	return SyncerTransferResource{
+		Name:        resourceName,
+		IsDirectory: false,
+		Transport:   root.Transport}
+}
+
+func (root *RedisSyncRoot) SetTransferResource(transferResourceName string) error {
+	root.TransferResourceOverride = transferResourceName
+	return nil
+}
+
+func (root *RedisSyncRoot) GetOutputDirectory() string {
+	m := root.Config
+	if len(m.OutputDirectory) == 0 {
+		return "/tmp/"
+	}
+	return m.OutputDirectory
+}
+
+// getEffectiveDetails is the config for the environment, with the local overrides applied on the local environment
+func (root *RedisSyncRoot) getEffectiveDetails(environment Environment) BaseRedisSync {
+	if environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
+		return root.getEffectiveLocalDetails()
+	}
+	return root.Config
+}
+
+// getEffectiveLocalDetails overrides the connection details. The mode and key patterns have to be the same on both
+// ends of the sync, so they can't be overridden.
+func (syncConfig *RedisSyncRoot) getEffectiveLocalDetails() BaseRedisSync {
+	returnDetails := syncConfig.Config
+
+	assignLocalOverride := func(target *string, override *string) {
+		if len(*override) > 0 {
+			*target = *override
+		}
+	}
+
+	assignLocalOverride(&returnDetails.Hostname, &syncConfig.LocalOverrides.Config.Hostname)
+	assignLocalOverride(&returnDetails.Port, &syncConfig.LocalOverrides.Config.Port)
+	assignLocalOverride(&returnDetails.Password, &syncConfig.LocalOverrides.Config.Password)
+	assignLocalOverride(&returnDetails.Database, &syncConfig.LocalOverrides.Config.Database)
+	assignLocalOverride(&returnDetails.Cli, &syncConfig.LocalOverrides.Config.Cli)
+	assignLocalOverride(&returnDetails.DataDirectory, &syncConfig.LocalOverrides.Config.DataDirectory)
+	assignLocalOverride(&returnDetails.OutputDirectory, &syncConfig.LocalOverrides.Config.OutputDirectory)
+	return returnDetails
+}
@@ -0,0 +1,135 @@
+package synchers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeRedisCli lists the same keys for every scan, and answers the commands piped to it - logging those that aren't
+// PTTL or DUMP, the way the import runs them
+const fakeRedisCli = `#!/bin/sh
+for arg; do
+  if [ "$arg" = "--scan" ]; then printf 'sess:b"q\nsess:a\nsess:b"q\n'; exit 0; fi
+done
+while IFS= read -r line; do
+  case "$line" in
+    PTTL*) echo "(integer) -1" ;;
+    DUMP*) echo '"\x00dump"' ;;
+    *) echo "$line" >> "$FAKE_REDIS_LOG"; echo OK ;;
+  esac
+done
+`
+
+func TestRedisSyncRoot_KeysMode(t *testing.T) {
+	dir := t.TempDir()
+	cli := filepath.Join(dir, "redis-cli")
+	if err := os.WriteFile(cli, []byte(fakeRedisCli), 0755); err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(dir, "commands.log")
+	local := Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME}
+	syncer, err := RedisSyncPlugin{}.UnmarshallYaml(SyncherConfigRoot{LagoonSync: map[string]interface{}{
+		"redis": map[string]interface{}{
+			"config": map[string]interface{}{"keys": []interface{}{"sess:*"}, "cli": "FAKE_REDIS_LOG=" + log + " " + cli},
+			"local":  map[string]interface{}{"config": map[string]interface{}{"outputdirectory": dir + "/"}},
+		},
+	}}, "redis")
+	if err != nil {
+		t.Fatalf("UnmarshallYaml() error = %v", err)
+	}
+	run := func(command SyncCommand, stdin string) string {
+		rendered, err := renderCommand(command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stdout bytes.Buffer
+		if err := runStreamCommand(context.Background(), local, rendered, strings.NewReader(stdin), &stdout, &SSHOptionWrapper{}, nil); err != nil {
+			t.Fatalf("running %s: %v", rendered.loggedString, err)
+		}
+		return stdout.String()
+	}
+
+	export := run(syncer.GetRemoteStreamCommand(local), "")
+	want := "RESTORE \"sess:a\" 0 \"\\x00dump\" REPLACE\nRESTORE \"sess:b\\\"q\" 0 \"\\x00dump\" REPLACE\n"
+	if export != want {
+		t.Errorf("export = %q, want %q", export, want)
+	}
+
+	// The keys matching the patterns are removed from the target before the export is restored
+	run(syncer.GetLocalStreamCommand(local), export)
+	logged, _ := os.ReadFile(log)
+	want = "UNLINK \"sess:a\"\nUNLINK \"sess:b\\\"q\"\n" + export
+	if string(logged) != want {
+		t.Errorf("import ran %q, want %q", logged, want)
+	}
+	// The keys are listed into the output directory while they're synced
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, "lagoon_sync_redis_*")); len(leftovers) != 0 {
+		t.Errorf("the keys files were left behind: %v", leftovers)
+	}
+}
+
+func TestRedisSyncRoot_Commands(t *testing.T) {
+	syncer := &RedisSyncRoot{
+		Config: BaseRedisSync{Mode: RedisSyncModeRdb, Password: "secret"},
+		LocalOverrides: RedisSyncLocal{Config: BaseRedisSync{
+			Hostname:      "127.0.0.1",
+			DataDirectory: "/var/lib/redis/",
+		}},
+	}
+	syncer.Config.setDefaults()
+	if _, err := syncer.PrepareSyncer(); err != nil {
+		t.Fatal(err)
+	}
+	remote := Environment{EnvironmentName: "main"}
+	local := Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME}
+
+	tests := []struct {
+		name         string
+		command      SyncCommand
+		wantCommand  string
+		wantRedacted string
+	}{
+		{
+			name:         "rdb export",
+			command:      syncer.GetRemoteCommand(remote)[0],
+			wantCommand:  `REDISCLI_AUTH="${LAGOON_SYNC_SECRET_PASSWORD}" redis-cli -h ${REDIS_HOST:-redis} -p ${REDIS_PORT:-6379} --rdb ` + syncer.GetTransferResource(remote).Name,
+			wantRedacted: `REDISCLI_AUTH="****" redis-cli -h ${REDIS_HOST:-redis} -p ${REDIS_PORT:-6379} --rdb ` + syncer.GetTransferResource(remote).Name,
+		},
+		{
+			name:        "rdb stream export",
+			command:     syncer.GetRemoteStreamCommand(remote),
+			wantCommand: `REDISCLI_AUTH="${LAGOON_SYNC_SECRET_PASSWORD}" redis-cli -h ${REDIS_HOST:-redis} -p ${REDIS_PORT:-6379} --rdb -`,
+		},
+		{
+			name:        "rdb import with local overrides",
+			command:     syncer.GetLocalStreamCommand(local),
+			wantCommand: `cat > /var/lib/redis/dump.rdb.lagoon_sync && mv /var/lib/redis/dump.rdb.lagoon_sync /var/lib/redis/dump.rdb && REDISCLI_AUTH="${LAGOON_SYNC_SECRET_PASSWORD}" redis-cli -h 127.0.0.1 -p ${REDIS_PORT:-6379} DEBUG RELOAD NOSAVE`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.command.GetCommand()
+			if err != nil || got != tt.wantCommand {
+				t.Errorf("GetCommand() = %v, %v, want %v", got, err, tt.wantCommand)
+			}
+			if tt.wantRedacted != "" {
+				if redacted, _ := tt.command.GetRedactedCommand(); redacted != tt.wantRedacted {
+					t.Errorf("GetRedactedCommand() = %v, want %v", redacted, tt.wantRedacted)
+				}
+			}
+		})
+	}
+
+	if name := syncer.GetTransferResource(remote).Name; !strings.HasSuffix(name, ".rdb") {
+		t.Errorf("GetTransferResource() = %v, want an rdb file", name)
+	}
+	syncer.Config.Mode = "aof"
+	if _, err := syncer.PrepareSyncer(); !errors.Is(err, ErrConfigInvalid) {
+		t.Errorf("PrepareSyncer() error = %v, want ErrConfigInvalid", err)
+	}
+}
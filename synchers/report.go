@@ -0,0 +1,176 @@
+package synchers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/uselagoon/lagoon-sync/utils"
+)
+
+const (
+	SyncStatusSucceeded = "succeeded"
+	SyncStatusFailed    = "failed"
+	SyncStatusSkipped   = "skipped"
+	SyncStatusCancelled = "cancelled"
+)
+
+// SyncReport records what a single sync did - how each phase went, how long it took and how much was transferred -
+// so that it can be summarised at the end of a run. A nil *SyncReport records nothing.
+type SyncReport struct {
+	SyncerType        string        `json:"syncer"`
+	SourceEnvironment string        `json:"source"`
+	TargetEnvironment string        `json:"target"`
+	Status            string        `json:"status"`
+	Error             string        `json:"error,omitempty"`
+	DurationMs        int64         `json:"durationMs"`
+	FilesTransferred  int           `json:"filesTransferred"`
+	BytesTransferred  int64         `json:"bytesTransferred"`
+	FilesDeleted      int           `json:"filesDeleted,omitempty"`
+	Placeholders      int           `json:"placeholders,omitempty"`
+	Phases            []PhaseReport `json:"phases"`
+
+	started time.Time
+}
+
+type PhaseReport struct {
+	Phase      SyncPhase `json:"phase"`
+	Status     string    `json:"status"`
+	DurationMs int64     `json:"durationMs"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type phaseEventFields struct {
+	Phase      SyncPhase `json:"phase"`
+	Status     string    `json:"status,omitempty"`
+	DurationMs *int64    `json:"durationMs,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type commandEventFields struct {
+	Environment string `json:"environment"`
+	Command     string `json:"command"`
+	Status      string `json:"status"`
+	DurationMs  int64  `json:"durationMs"`
+	ExitCode    *int   `json:"exitCode,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+type transferEventFields struct {
+	Transport        string `json:"transport"`
+	Source           string `json:"source"`
+	Target           string `json:"target"`
+	FilesTransferred int    `json:"filesTransferred"`
+	BytesTransferred int64  `json:"bytesTransferred"`
+}
+
+func (r *SyncReport) start(args RunSyncProcessFunctionTypeArguments) {
+	if r == nil {
+		return
+	}
+	r.SyncerType = args.SyncerType
+	r.SourceEnvironment = args.SourceEnvironment.EnvironmentName
+	r.TargetEnvironment = args.TargetEnvironment.EnvironmentName
+	r.Phases = []PhaseReport{}
+	r.started = time.Now()
+}
+
+func (r *SyncReport) finish(err error) {
+	if r == nil {
+		return
+	}
+	r.DurationMs = time.Since(r.started).Milliseconds()
+	r.Status = statusForError(err)
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+func (r *SyncReport) recordTransfer(files int, bytes int64) {
+	if r == nil {
+		return
+	}
+	r.FilesTransferred += files
+	r.BytesTransferred += bytes
+}
+
+func (r *SyncReport) recordDeletions(files int) {
+	if r == nil {
+		return
+	}
+	r.FilesDeleted += files
+}
+
+func (r *SyncReport) recordPlaceholders(files int) {
+	if r == nil {
+		return
+	}
+	r.Placeholders += files
+}
+
+// startPhase logs the start of a phase, and returns the function that logs its end and records it in the report
+func startPhase(phase SyncPhase, report *SyncReport, logger *utils.Logger) func(err error) {
+	logger.LogEvent("phase-start", phaseEventFields{Phase: phase})
+	started := time.Now()
+	return func(err error) {
+		endPhase(phase, statusForError(err), time.Since(started), err, report, logger)
+	}
+}
+
+// skipPhase records a phase that isn't run, either because it isn't needed or a previous run completed it
+func skipPhase(phase SyncPhase, report *SyncReport, logger *utils.Logger) {
+	endPhase(phase, SyncStatusSkipped, 0, nil, report, logger)
+}
+
+func endPhase(phase SyncPhase, status string, duration time.Duration, err error, report *SyncReport, logger *utils.Logger) {
+	durationMs := duration.Milliseconds()
+	fields := phaseEventFields{Phase: phase, Status: status, DurationMs: &durationMs}
+	if err != nil {
+		fields.Error = err.Error()
+	}
+	logger.LogEvent("phase-end", fields)
+
+	if report != nil {
+		report.Phases = append(report.Phases, PhaseReport{Phase: phase, Status: status, DurationMs: durationMs, Error: fields.Error})
+	}
+}
+
+// logCommandEvent logs a command that has been run, which should be the redacted form of the command
+func logCommandEvent(logger *utils.Logger, environment Environment, command string, started time.Time, err error) {
+	fields := commandEventFields{
+		Environment: environment.EnvironmentName,
+		Command:     command,
+		Status:      statusForError(err),
+		DurationMs:  time.Since(started).Milliseconds(),
+	}
+	if err != nil {
+		fields.Error = err.Error()
+		if exitCode, ok := utils.ExitCode(err); ok {
+			fields.ExitCode = &exitCode
+		}
+	}
+	logger.LogEvent("command", fields)
+}
+
+// recordTransfer logs how much a transport transferred, and adds it to the sync's report
+func recordTransfer(transport Transport, args TransportArguments, files int, bytes int64) {
+	args.Logger.LogEvent("transfer", transferEventFields{
+		Transport:        transport.GetTransportId(),
+		Source:           args.SourceEnvironment.EnvironmentName,
+		Target:           args.TargetEnvironment.EnvironmentName,
+		FilesTransferred: files,
+		BytesTransferred: bytes,
+	})
+	args.Report.recordTransfer(files, bytes)
+}
+
+func statusForError(err error) string {
+	switch {
+	case err == nil:
+		return SyncStatusSucceeded
+	case errors.Is(err, context.Canceled):
+		return SyncStatusCancelled
+	default:
+		return SyncStatusFailed
+	}
+}
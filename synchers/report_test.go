@@ -0,0 +1,63 @@
+package synchers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestParseRsyncStats(t *testing.T) {
+	output := `
+Number of files: 1,210 (reg: 1,100, dir: 110)
+Number of created files: 3
+Number of regular files transferred: 1,003
+Total file size: 52,428,800 bytes
+Total transferred file size: 10,485,760 bytes
+Literal data: 10,485,760 bytes
+`
+	files, bytes := parseRsyncStats(output)
+	if files != 1003 || bytes != 10485760 {
+		t.Errorf("parseRsyncStats() = %v, %v, want 1003, 10485760", files, bytes)
+	}
+
+	if files, bytes := parseRsyncStats("rsync: connection unexpectedly closed"); files != 0 || bytes != 0 {
+		t.Errorf("parseRsyncStats() = %v, %v without stats, want 0, 0", files, bytes)
+	}
+}
+
+func TestRunSyncProcess_Report(t *testing.T) {
+	dir := t.TempDir()
+	journal, err := NewSyncJournal(t.TempDir(), SyncJournal{SyncerType: "test"})
+	if err != nil {
+		t.Fatalf("NewSyncJournal() error = %v", err)
+	}
+	journal.MarkCompleted(SyncPhasePrerequisite)
+	journal.MarkCompleted(SyncPhaseSourceExport)
+	journal.MarkCompleted(SyncPhaseTransfer)
+
+	report := &SyncReport{}
+	err = RunSyncProcess(context.Background(), RunSyncProcessFunctionTypeArguments{
+		SourceEnvironment: Environment{EnvironmentName: "main"},
+		TargetEnvironment: Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME},
+		LagoonSyncer:      journalTestSyncer{dir: dir},
+		SyncerType:        "test",
+		SshOptionWrapper:  &SSHOptionWrapper{},
+		Journal:           journal,
+		Report:            report,
+	})
+	if err != nil {
+		t.Fatalf("RunSyncProcess() error = %v", err)
+	}
+
+	if report.Status != SyncStatusSucceeded || report.SyncerType != "test" || report.SourceEnvironment != "main" {
+		t.Errorf("RunSyncProcess() report = %+v", report)
+	}
+	var phases []string
+	for _, phase := range report.Phases {
+		phases = append(phases, string(phase.Phase)+":"+phase.Status)
+	}
+	want := []string{"source-export:skipped", "transfer:skipped", "target-import:succeeded", "cleanup:succeeded"}
+	if !reflect.DeepEqual(phases, want) {
+		t.Errorf("RunSyncProcess() reported phases %v, want %v", phases, want)
+	}
+}
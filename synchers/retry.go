@@ -0,0 +1,149 @@
+package synchers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/uselagoon/lagoon-sync/utils"
+)
+
+const (
+	defaultRetryBackoff = 5 * time.Second
+	maxRetryBackoff     = 5 * time.Minute
+)
+
+// defaultRetryableExitCodes are the exit codes that are retried when a phase doesn't list its own. For transfers,
+// these are the rsync exit codes for dropped connections and timeouts, and ssh's 255.
+var defaultRetryableExitCodes = map[SyncPhase][]int{
+	SyncPhaseTransfer: {10, 12, 30, 35, 255},
+}
+
+// PhasePolicy controls how long a phase of a sync may run for, and whether it's retried if it fails
+type PhasePolicy struct {
+	// Timeout is how long each attempt may take, no limit if zero
+	Timeout time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty" mapstructure:"timeout"`
+	// Attempts is how many times the phase is run before giving up, 1 if not set
+	Attempts int `yaml:"attempts,omitempty" json:"attempts,omitempty" mapstructure:"attempts"`
+	// Backoff is how long to wait before the first retry, doubling for each retry after that
+	Backoff time.Duration `yaml:"backoff,omitempty" json:"backoff,omitempty" mapstructure:"backoff"`
+	// RetryableExitCodes are the exit codes of failed commands that are worth retrying. Dropped ssh connections
+	// and attempts that time out are always retried.
+	RetryableExitCodes []int `yaml:"retryableExitCodes,omitempty" json:"retryableExitCodes,omitempty" mapstructure:"retryableExitCodes"`
+}
+
+// PhasePolicies holds the policy for each phase of a sync, configured under `lagoon-sync: phases:`
+type PhasePolicies map[SyncPhase]PhasePolicy
+
+// GetPolicy returns the policy for the phase, with defaults filled in
+func (p PhasePolicies) GetPolicy(phase SyncPhase) PhasePolicy {
+	policy := p[phase]
+	if policy.Attempts < 1 {
+		policy.Attempts = 1
+	}
+	if policy.Backoff <= 0 {
+		policy.Backoff = defaultRetryBackoff
+	}
+	if policy.RetryableExitCodes == nil {
+		policy.RetryableExitCodes = defaultRetryableExitCodes[phase]
+	}
+	return policy
+}
+
+// DecodePhasePolicies reads phase policies from the `phases` section of the lagoon-sync config. Durations are
+// given as strings such as "30s" or "10m".
+func DecodePhasePolicies(raw interface{}) (PhasePolicies, error) {
+	policies := PhasePolicies{}
+	if raw == nil {
+		return policies, nil
+	}
+
+	decoded := map[string]PhasePolicy{}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.StringToTimeDurationHookFunc(),
+		Result:     &decoded,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := decoder.Decode(raw); err != nil {
+		return nil, fmt.Errorf("%w: unable to parse phase policies: %v", ErrConfigInvalid, err)
+	}
+
+	for name, policy := range decoded {
+		phase := SyncPhase(name)
+		switch phase {
+		case SyncPhaseSourceExport, SyncPhaseTransfer, SyncPhaseTargetImport, SyncPhaseCleanup:
+		default:
+			return nil, fmt.Errorf("%w: unknown phase '%s' in phase policies, expected one of %s, %s, %s or %s", ErrConfigInvalid, name, SyncPhaseSourceExport, SyncPhaseTransfer, SyncPhaseTargetImport, SyncPhaseCleanup)
+		}
+		if policy.Attempts < 0 || policy.Timeout < 0 || policy.Backoff < 0 {
+			return nil, fmt.Errorf("%w: phase policy for %s can't have negative attempts, timeout or backoff", ErrConfigInvalid, name)
+		}
+		policies[phase] = policy
+	}
+	return policies, nil
+}
+
+// isRetryable reports whether a failed attempt is worth retrying under the policy
+func (p PhasePolicy) isRetryable(err error) bool {
+	var connectionErr *utils.ConnectionError
+	if errors.As(err, &connectionErr) {
+		return true
+	}
+	if exitCode, ok := utils.ExitCode(err); ok {
+		for _, retryable := range p.RetryableExitCodes {
+			if exitCode == retryable {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RetryableImporter is implemented by syncers that say whether their import can safely be run again after it
+// fails part way through. Imports of syncers that don't implement it are never retried.
+type RetryableImporter interface {
+	// IsImportRetryable returns true if the import replaces what's on the target, rather than adding to it
+	IsImportRetryable() bool
+}
+
+func isImportRetryable(syncer Syncer) bool {
+	importer, ok := syncer.(RetryableImporter)
+	return ok && importer.IsImportRetryable()
+}
+
+// runPhase runs a phase of the sync under its policy, giving each attempt its own timeout and retrying failures
+// that look transient. Nothing is retried once ctx itself is done.
+func runPhase(ctx context.Context, phase SyncPhase, policy PhasePolicy, logger *utils.Logger, run func(ctx context.Context) error) error {
+	backoff := policy.Backoff
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if policy.Timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, policy.Timeout)
+		}
+		err := run(attemptCtx)
+		timedOut := err != nil && ctx.Err() == nil && errors.Is(attemptCtx.Err(), context.DeadlineExceeded)
+		cancel()
+
+		if err == nil {
+			return nil
+		}
+		if timedOut {
+			err = fmt.Errorf("%s timed out after %v: %w", phase, policy.Timeout, err)
+		}
+		if ctx.Err() != nil || attempt >= policy.Attempts || !(timedOut || policy.isRetryable(err)) {
+			return err
+		}
+
+		logger.LogWarning(fmt.Sprintf("%s failed on attempt %d of %d, retrying in %v: %v", phase, attempt, policy.Attempts, backoff, err), nil)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxRetryBackoff)
+	}
+}
@@ -0,0 +1,313 @@
+package synchers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uselagoon/lagoon-sync/utils"
+	"gopkg.in/yaml.v2"
+)
+
+func TestDecodePhasePolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		want    PhasePolicies
+		wantErr bool
+	}{
+		{
+			name:   "No policies",
+			config: ``,
+			want:   PhasePolicies{},
+		},
+		{
+			name: "Durations and exit codes",
+			config: `
+transfer:
+  attempts: 3
+  backoff: 10s
+  timeout: 30m
+source-export:
+  attempts: 2
+  retryableExitCodes: [2]
+`,
+			want: PhasePolicies{
+				SyncPhaseTransfer:     {Attempts: 3, Backoff: 10 * time.Second, Timeout: 30 * time.Minute},
+				SyncPhaseSourceExport: {Attempts: 2, RetryableExitCodes: []int{2}},
+			},
+		},
+		{
+			name:    "Unknown phase",
+			config:  "prerequisite:\n  attempts: 2\n",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid duration",
+			config:  "transfer:\n  timeout: soon\n",
+			wantErr: true,
+		},
+		{
+			name:    "Negative attempts",
+			config:  "transfer:\n  attempts: -1\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var raw interface{}
+			if err := yaml.Unmarshal([]byte(tt.config), &raw); err != nil {
+				t.Fatal(err)
+			}
+			got, err := DecodePhasePolicies(raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodePhasePolicies() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodePhasePolicies() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunPhase(t *testing.T) {
+	exitErr := func(code int) error {
+		return fmt.Errorf("transfer failed: %w", &utils.ErrRemoteCommandFailed{ExitCode: code})
+	}
+	hang := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	tests := []struct {
+		name         string
+		phase        SyncPhase
+		policy       PhasePolicy
+		attempts     []func(ctx context.Context) error
+		wantAttempts int
+		wantErr      bool
+	}{
+		{
+			name:         "Runs once by default",
+			phase:        SyncPhaseTransfer,
+			attempts:     []func(ctx context.Context) error{func(ctx context.Context) error { return exitErr(255) }},
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+		{
+			name:   "Retries dropped connections",
+			phase:  SyncPhaseSourceExport,
+			policy: PhasePolicy{Attempts: 3},
+			attempts: []func(ctx context.Context) error{
+				func(ctx context.Context) error { return &utils.ConnectionError{Err: errors.New("connection reset")} },
+				func(ctx context.Context) error { return nil },
+			},
+			wantAttempts: 2,
+		},
+		{
+			name:   "Retries the default exit codes for transfers",
+			phase:  SyncPhaseTransfer,
+			policy: PhasePolicy{Attempts: 3},
+			attempts: []func(ctx context.Context) error{
+				func(ctx context.Context) error { return exitErr(255) },
+				func(ctx context.Context) error { return exitErr(12) },
+				func(ctx context.Context) error { return nil },
+			},
+			wantAttempts: 3,
+		},
+		{
+			name:   "Gives up after the last attempt",
+			phase:  SyncPhaseTransfer,
+			policy: PhasePolicy{Attempts: 2},
+			attempts: []func(ctx context.Context) error{
+				func(ctx context.Context) error { return exitErr(255) },
+				func(ctx context.Context) error { return exitErr(255) },
+			},
+			wantAttempts: 2,
+			wantErr:      true,
+		},
+		{
+			name:   "Doesn't retry other exit codes",
+			phase:  SyncPhaseSourceExport,
+			policy: PhasePolicy{Attempts: 3, RetryableExitCodes: []int{2}},
+			attempts: []func(ctx context.Context) error{
+				func(ctx context.Context) error { return exitErr(1) },
+			},
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+		{
+			name:   "Retries configured exit codes",
+			phase:  SyncPhaseSourceExport,
+			policy: PhasePolicy{Attempts: 3, RetryableExitCodes: []int{2}},
+			attempts: []func(ctx context.Context) error{
+				func(ctx context.Context) error { return exitErr(2) },
+				func(ctx context.Context) error { return nil },
+			},
+			wantAttempts: 2,
+		},
+		{
+			name:   "Retries attempts that time out",
+			phase:  SyncPhaseTargetImport,
+			policy: PhasePolicy{Attempts: 2, Timeout: 50 * time.Millisecond},
+			attempts: []func(ctx context.Context) error{
+				hang,
+				func(ctx context.Context) error { return nil },
+			},
+			wantAttempts: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := PhasePolicies{tt.phase: tt.policy}.GetPolicy(tt.phase)
+			policy.Backoff = time.Millisecond
+
+			attempts := 0
+			err := runPhase(context.Background(), tt.phase, policy, nil, func(ctx context.Context) error {
+				attempts++
+				if attempts > len(tt.attempts) {
+					t.Fatalf("runPhase() made more attempts than expected")
+				}
+				return tt.attempts[attempts-1](ctx)
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("runPhase() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("runPhase() made %v attempts, want %v", attempts, tt.wantAttempts)
+			}
+		})
+	}
+
+	t.Run("Doesn't retry once cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		attempts := 0
+		policy := PhasePolicy{Attempts: 3, Timeout: time.Second, Backoff: time.Millisecond}
+		err := runPhase(ctx, SyncPhaseTransfer, policy, nil, func(ctx context.Context) error {
+			attempts++
+			return hang(ctx)
+		})
+		if !errors.Is(err, context.DeadlineExceeded) || attempts != 1 {
+			t.Errorf("runPhase() = %v after %v attempts, want %v after 1", err, attempts, context.DeadlineExceeded)
+		}
+	})
+}
+
+// flakyImportSyncer has an import that fails the first time it's run
+type flakyImportSyncer struct {
+	journalTestSyncer
+}
+
+func (s flakyImportSyncer) GetLocalCommand(environment Environment) []SyncCommand {
+	failed := filepath.Join(s.dir, "failed")
+	imported := filepath.Join(s.dir, "imported")
+	return []SyncCommand{generateSyncCommand(fmt.Sprintf("if [ -f %s ]; then touch %s; else touch %s; exit 3; fi", failed, imported, failed), nil)}
+}
+
+// retryableImportSyncer is a flakyImportSyncer whose import can safely be run again
+type retryableImportSyncer struct {
+	flakyImportSyncer
+}
+
+func (s retryableImportSyncer) IsImportRetryable() bool {
+	return true
+}
+
+func TestRunSyncProcess_RetriesImport(t *testing.T) {
+	tests := []struct {
+		name       string
+		syncer     func(dir string) Syncer
+		wantImport bool
+	}{
+		{
+			name:       "Retryable import",
+			syncer:     func(dir string) Syncer { return retryableImportSyncer{flakyImportSyncer{journalTestSyncer{dir: dir}}} },
+			wantImport: true,
+		},
+		{
+			name:       "Import that can't be run twice",
+			syncer:     func(dir string) Syncer { return flakyImportSyncer{journalTestSyncer{dir: dir}} },
+			wantImport: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			journal, err := NewSyncJournal(t.TempDir(), SyncJournal{SyncerType: "test"})
+			if err != nil {
+				t.Fatalf("NewSyncJournal() error = %v", err)
+			}
+			journal.MarkCompleted(SyncPhasePrerequisite)
+			journal.MarkCompleted(SyncPhaseSourceExport)
+			journal.MarkCompleted(SyncPhaseTransfer)
+
+			err = RunSyncProcess(context.Background(), RunSyncProcessFunctionTypeArguments{
+				SourceEnvironment: Environment{EnvironmentName: "main"},
+				TargetEnvironment: Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME},
+				LagoonSyncer:      tt.syncer(dir),
+				SyncerType:        "test",
+				SshOptionWrapper:  &SSHOptionWrapper{},
+				Journal:           journal,
+				PhasePolicies: PhasePolicies{
+					SyncPhaseTargetImport: {Attempts: 2, Backoff: time.Millisecond, RetryableExitCodes: []int{3}},
+				},
+			})
+			if (err == nil) != tt.wantImport {
+				t.Errorf("RunSyncProcess() error = %v, want import %v", err, tt.wantImport)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "imported")); (err == nil) != tt.wantImport {
+				t.Errorf("RunSyncProcess() import completed = %v, want %v", err == nil, tt.wantImport)
+			}
+		})
+	}
+}
+
+// failingStreamSyncer streams more than the pipe holds into an import that gives up part way, counting attempts
+type failingStreamSyncer struct {
+	journalTestSyncer
+}
+
+func (s failingStreamSyncer) GetRemoteStreamCommand(environment Environment) SyncCommand {
+	return generateSyncCommand("head -c 10000000 /dev/zero; exit $?", nil)
+}
+
+func (s failingStreamSyncer) GetLocalStreamCommand(environment Environment) SyncCommand {
+	return generateSyncCommand("echo attempt >> "+filepath.Join(s.dir, "attempts")+"; head -c 10 > /dev/null; exit 3", nil)
+}
+
+func (s failingStreamSyncer) IsImportRetryable() bool {
+	return true
+}
+
+func TestRunSyncProcess_DoesntRetryFailedStreamedImport(t *testing.T) {
+	dir := t.TempDir()
+	local := Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME}
+
+	// The export dies of SIGPIPE (141) once the import stops reading, which mustn't make the import look transient
+	err := RunSyncProcess(context.Background(), RunSyncProcessFunctionTypeArguments{
+		SourceEnvironment: local,
+		TargetEnvironment: local,
+		LagoonSyncer:      failingStreamSyncer{journalTestSyncer{dir: dir}},
+		SyncerType:        "test",
+		SshOptionWrapper:  &SSHOptionWrapper{},
+		Stream:            true,
+		PhasePolicies: PhasePolicies{
+			SyncPhaseTransfer: {Attempts: 3, Backoff: time.Millisecond, RetryableExitCodes: []int{141, 255}},
+		},
+	})
+	if err == nil || !strings.Contains(err.Error(), "streaming import") {
+		t.Errorf("RunSyncProcess() error = %v, want the import's error", err)
+	}
+	attempts, _ := os.ReadFile(filepath.Join(dir, "attempts"))
+	if got := strings.Count(string(attempts), "attempt"); got != 1 {
+		t.Errorf("RunSyncProcess() ran the import %v times, want 1", got)
+	}
+}
@@ -0,0 +1,197 @@
+package synchers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Sanitize actions that can be applied to a column
+const (
+	SanitizeActionEmail = "email"
+	// SanitizeActionHash replaces values with a salted hash of them. This is pseudonymisation, not anonymisation:
+	// equal values still hash alike, so rows can be linked by them, and anyone who has the salt can test guesses at
+	// the original values.
+	SanitizeActionHash    = "hash"
+	SanitizeActionNullify = "nullify"
+	SanitizeActionFixed   = "fixed"
+)
+
+// sanitizeEmailDomain is the domain of the fake addresses the email action writes, reserved so that mail sent to
+// them from a development environment can never reach anyone
+const sanitizeEmailDomain = "example.com"
+
+// SanitizeRule describes how the data in a table is sanitized once it's imported into the target
+type SanitizeRule struct {
+	// Table is the table the rule applies to
+	Table string `yaml:"table" json:"table"`
+	// Truncate empties the table
+	Truncate bool `yaml:"truncate,omitempty" json:"truncate,omitempty"`
+	// Where is an SQL condition selecting the rows that are kept, the rest are deleted
+	Where string `yaml:"where,omitempty" json:"where,omitempty"`
+	// Columns are the columns whose values are replaced
+	Columns []SanitizeColumn `yaml:"columns,omitempty" json:"columns,omitempty"`
+}
+
+// SanitizeColumn describes how the values of a column are replaced
+type SanitizeColumn struct {
+	Name string `yaml:"name" json:"name"`
+	// Action is one of "email", "hash", "nullify" or "fixed"
+	Action string `yaml:"action" json:"action"`
+	// Value is the value the fixed action sets the column to
+	Value string `yaml:"value,omitempty" json:"value,omitempty"`
+}
+
+// SanitizeRules are the sanitize rules of a database syncer
+type SanitizeRules []SanitizeRule
+
+// newSanitizeSalt is the salt mixed into the values the email and hash actions hash, so that they can't be recovered
+// by hashing guesses at them. Unless one is configured, a new one is generated for every run, which means the same
+// value hashes differently from one run to the next. Either way it's made up of letters and digits alone, so that it
+// can be passed to the database without quoting.
+func newSanitizeSalt(configured string) string {
+	if configured != "" {
+		return hex.EncodeToString([]byte(configured))
+	}
+	return rand.Text()
+}
+
+// Sanitizer is implemented by database syncers that can sanitize the data they import. The dump of a sanitized sync
+// holds the data as it was before it's sanitized, so it's streamed into the target rather than written to disk.
+type Sanitizer interface {
+	// SanitizesData reports whether the syncer has any sanitize rules
+	SanitizesData() bool
+	// GetSanitizeCommand will return the command applying the sanitize rules to the target's database, carrying on
+	// past any that fail. It's run to sanitize whatever a failed import left behind.
+	GetSanitizeCommand(environment Environment) SyncCommand
+}
+
+// sanitizesData reports whether the syncer sanitizes the data it imports
+func sanitizesData(syncer Syncer) bool {
+	sanitizer, ok := syncer.(Sanitizer)
+	return ok && sanitizer.SanitizesData()
+}
+
+// Validate checks that every rule names its table and columns, and only uses known actions
+func (rules SanitizeRules) Validate() error {
+	for i, rule := range rules {
+		if rule.Table == "" {
+			return fmt.Errorf("%w: sanitize rule %d has no table", ErrConfigInvalid, i+1)
+		}
+		if !rule.Truncate && rule.Where == "" && len(rule.Columns) == 0 {
+			return fmt.Errorf("%w: the sanitize rule for %s doesn't truncate, filter or replace anything", ErrConfigInvalid, rule.Table)
+		}
+		for _, column := range rule.Columns {
+			if column.Name == "" {
+				return fmt.Errorf("%w: the sanitize rule for %s has a column with no name", ErrConfigInvalid, rule.Table)
+			}
+			switch column.Action {
+			case SanitizeActionEmail, SanitizeActionHash, SanitizeActionNullify, SanitizeActionFixed:
+			default:
+				return fmt.Errorf("%w: unknown sanitize action %q for %s.%s", ErrConfigInvalid, column.Action, rule.Table, column.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// sqlDialect covers the differences between databases in the SQL sanitize rules generate
+type sqlDialect struct {
+	// quoteIdentifier quotes a table or column name
+	quoteIdentifier func(name string) string
+	// hash is an expression hashing a column's value into a hex string
+	hash func(column string) string
+	// salt is an expression for the salt, which the sanitize command makes available to the session
+	salt string
+	// concat is an expression joining strings, which is null if any of them are
+	concat func(values ...string) string
+	// literal quotes a value as a string literal
+	literal func(value string) string
+}
+
+var mariadbDialect = sqlDialect{
+	quoteIdentifier: func(name string) string {
+		return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+	},
+	hash: func(column string) string {
+		return fmt.Sprintf("SHA2(%s, 256)", column)
+	},
+	salt: "@lagoon_sync_salt",
+	concat: func(values ...string) string {
+		return fmt.Sprintf("CONCAT(%s)", strings.Join(values, ", "))
+	},
+	literal: sqlString,
+}
+
+var postgresDialect = sqlDialect{
+	quoteIdentifier: func(name string) string {
+		return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+	},
+	hash: func(column string) string {
+		return fmt.Sprintf("md5(%s::text)", column)
+	},
+	salt: "current_setting('lagoon_sync.sanitize_salt')",
+	concat: func(values ...string) string {
+		return strings.Join(values, " || ")
+	},
+	literal: func(value string) string {
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	},
+}
+
+// statements are the SQL statements that apply the rules, in the order the rules are given. Rows are filtered
+// before columns are replaced, so that no work is done on rows that are about to be deleted.
+func (rules SanitizeRules) statements(dialect sqlDialect) []string {
+	var statements []string
+	for _, rule := range rules {
+		table := dialect.quoteIdentifier(rule.Table)
+		if rule.Truncate {
+			statements = append(statements, fmt.Sprintf("TRUNCATE TABLE %s", table))
+			continue
+		}
+		if rule.Where != "" {
+			// Rows the condition is null for are deleted too, they weren't selected to be kept
+			statements = append(statements, fmt.Sprintf("DELETE FROM %s WHERE (%s) IS NOT TRUE", table, rule.Where))
+		}
+		if len(rule.Columns) == 0 {
+			continue
+		}
+		assignments := make([]string, 0, len(rule.Columns))
+		for _, column := range rule.Columns {
+			name := dialect.quoteIdentifier(column.Name)
+			assignments = append(assignments, fmt.Sprintf("%s = %s", name, column.expression(dialect, name)))
+		}
+		statements = append(statements, fmt.Sprintf("UPDATE %s SET %s", table, strings.Join(assignments, ", ")))
+	}
+	return statements
+}
+
+// expression is the value the column is set to. Null values are left null by the actions that transform a value.
+func (column SanitizeColumn) expression(dialect sqlDialect, name string) string {
+	switch column.Action {
+	case SanitizeActionEmail:
+		// Hashing the address keeps addresses that were unique unique
+		return dialect.concat("SUBSTRING("+dialect.saltedHash(name)+", 1, 16)", dialect.literal("@"+sanitizeEmailDomain))
+	case SanitizeActionHash:
+		return dialect.saltedHash(name)
+	case SanitizeActionFixed:
+		return dialect.literal(column.Value)
+	default:
+		return "NULL"
+	}
+}
+
+// saltedHash is an expression hashing a column's value with the salt in front of it
+func (dialect sqlDialect) saltedHash(column string) string {
+	return dialect.hash(dialect.concat(dialect.salt, column))
+}
+
+// sql is the rules as a single SQL script, empty if there are no rules
+func (rules SanitizeRules) sql(dialect sqlDialect) string {
+	statements := rules.statements(dialect)
+	if len(statements) == 0 {
+		return ""
+	}
+	return strings.Join(statements, "; ") + ";"
+}
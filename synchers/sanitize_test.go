@@ -0,0 +1,314 @@
+package synchers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeRules_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   SanitizeRules
+		wantErr bool
+	}{
+		{
+			name:  "No rules",
+			rules: nil,
+		},
+		{
+			name: "Valid rules",
+			rules: SanitizeRules{
+				{Table: "sessions", Truncate: true},
+				{Table: "users", Where: "uid < 10", Columns: []SanitizeColumn{{Name: "mail", Action: "email"}, {Name: "name", Action: "fixed", Value: "user"}}},
+			},
+		},
+		{
+			name:    "Rule without a table",
+			rules:   SanitizeRules{{Truncate: true}},
+			wantErr: true,
+		},
+		{
+			name:    "Rule that does nothing",
+			rules:   SanitizeRules{{Table: "users"}},
+			wantErr: true,
+		},
+		{
+			name:    "Unknown action",
+			rules:   SanitizeRules{{Table: "users", Columns: []SanitizeColumn{{Name: "mail", Action: "scramble"}}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rules.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrConfigInvalid) {
+				t.Errorf("Validate() error = %v, want ErrConfigInvalid", err)
+			}
+		})
+	}
+}
+
+func TestSanitizeRules_statements(t *testing.T) {
+	rules := SanitizeRules{
+		{Table: "sessions", Truncate: true},
+		{Table: "users", Where: "uid <= 10", Columns: []SanitizeColumn{
+			{Name: "mail", Action: "email"},
+			{Name: "pass", Action: "hash"},
+			{Name: "phone", Action: "nullify"},
+			{Name: "name", Action: "fixed", Value: "O'Brien"},
+		}},
+	}
+
+	tests := []struct {
+		name    string
+		dialect sqlDialect
+		want    []string
+	}{
+		{
+			name:    "MariaDB",
+			dialect: mariadbDialect,
+			want: []string{
+				"TRUNCATE TABLE `sessions`",
+				"DELETE FROM `users` WHERE (uid <= 10) IS NOT TRUE",
+				"UPDATE `users` SET `mail` = CONCAT(SUBSTRING(SHA2(CONCAT(@lagoon_sync_salt, `mail`), 256), 1, 16), '@example.com'), `pass` = SHA2(CONCAT(@lagoon_sync_salt, `pass`), 256), `phone` = NULL, `name` = 'O\\'Brien'",
+			},
+		},
+		{
+			name:    "Postgres",
+			dialect: postgresDialect,
+			want: []string{
+				`TRUNCATE TABLE "sessions"`,
+				`DELETE FROM "users" WHERE (uid <= 10) IS NOT TRUE`,
+				`UPDATE "users" SET "mail" = SUBSTRING(md5(current_setting('lagoon_sync.sanitize_salt') || "mail"::text), 1, 16) || '@example.com', "pass" = md5(current_setting('lagoon_sync.sanitize_salt') || "pass"::text), "phone" = NULL, "name" = 'O''Brien'`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rules.statements(tt.dialect); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("statements() got\n%v\nwant\n%v", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestMariadbSyncRoot_sanitizedImport(t *testing.T) {
+	syncer, err := MariadbSyncPlugin{}.UnmarshallYaml(SyncherConfigRoot{LagoonSync: map[string]interface{}{
+		"mariadb": map[string]interface{}{
+			"sanitize": []interface{}{
+				map[string]interface{}{"table": "sessions", "truncate": true},
+			},
+		},
+	}}, "mariadb")
+	if err != nil {
+		t.Fatalf("UnmarshallYaml() error = %v", err)
+	}
+
+	target := Environment{EnvironmentName: "dev"}
+	commands := syncer.GetLocalCommand(target)
+	sanitize, err := commands[len(commands)-1].GetRedactedCommand()
+	if err != nil || !strings.Contains(sanitize, "printf '%s' 'TRUNCATE TABLE `sessions`;'; } | MYSQL_PWD") {
+		t.Errorf("GetLocalCommand() ends with %v, %v, want the sanitize command", sanitize, err)
+	}
+
+	stream, err := syncer.GetLocalStreamCommand(target).GetRedactedCommand()
+	if err != nil || !strings.Contains(stream, " && { printf ") || !strings.Contains(stream, "'TRUNCATE TABLE `sessions`;'") {
+		t.Errorf("GetLocalStreamCommand() = %v, %v, want the import followed by the sanitize command", stream, err)
+	}
+
+	// What a failed import leaves behind is sanitized as far as it can be
+	if !syncer.(Sanitizer).SanitizesData() {
+		t.Errorf("SanitizesData() = false, want true")
+	}
+	if sanitize, _ := syncer.(Sanitizer).GetSanitizeCommand(target).GetRedactedCommand(); !strings.Contains(sanitize, "mysql --force ") {
+		t.Errorf("GetSanitizeCommand() = %v, want it to carry on past errors", sanitize)
+	}
+}
+
+func TestPostgresSyncRoot_GetSanitizeCommand(t *testing.T) {
+	syncer := &PostgresSyncRoot{Sanitize: SanitizeRules{{Table: "sessions", Truncate: true}, {Table: "users", Where: "uid = 1"}}}
+	syncer.Config.setDefaults()
+
+	tests := []struct {
+		name    string
+		command SyncCommand
+		want    string
+	}{
+		{
+			name:    "As part of the import, in a single transaction",
+			command: syncer.GetLocalCommand(Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME})[1],
+			want:    `-v ON_ERROR_STOP=1 -c 'TRUNCATE TABLE "sessions"; DELETE FROM "users" WHERE (uid = 1) IS NOT TRUE;'`,
+		},
+		{
+			name:    "After a failed import, one statement at a time",
+			command: syncer.GetSanitizeCommand(Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME}),
+			want:    `-c 'TRUNCATE TABLE "sessions"' -c 'DELETE FROM "users" WHERE (uid = 1) IS NOT TRUE'`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := tt.command.GetRedactedCommand(); err != nil || !strings.HasSuffix(got, tt.want) {
+				t.Errorf("GetRedactedCommand() = %v, %v, want it to end with %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeSalt(t *testing.T) {
+	// Stand-ins for the database clients, recording what they're given
+	bin := t.TempDir()
+	for _, client := range []string{"mysql", "psql"} {
+		script := "#!/bin/sh\necho \"$*\" > \"$(dirname \"$0\")/" + client + ".args\"\necho \"$PGOPTIONS\" > \"$(dirname \"$0\")/" + client + ".env\"\ncat > \"$(dirname \"$0\")/" + client + ".stdin\"\n"
+		if err := os.WriteFile(filepath.Join(bin, client), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	read := func(name string) string {
+		contents, err := os.ReadFile(filepath.Join(bin, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(contents)
+	}
+	run := func(command SyncCommand) {
+		rendered, err := renderCommand(command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := runSyncCommand(context.Background(), Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME}, rendered, &SSHOptionWrapper{}, nil); err != nil {
+			t.Fatalf("runSyncCommand() error = %v", err)
+		}
+	}
+	rules := SanitizeRules{{Table: "users", Columns: []SanitizeColumn{{Name: "mail", Action: SanitizeActionEmail}}}}
+	local := Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME}
+	const configuredSalt = "pepper"
+	const encodedSalt = "706570706572" // hex encoded
+
+	t.Run("MariaDB sets it in the session", func(t *testing.T) {
+		mariadb := &MariadbSyncRoot{Sanitize: rules, SanitizeSalt: configuredSalt}
+		mariadb.Config.SetDefaults()
+		run(mariadb.GetSanitizeCommand(local))
+		if sql := read("mysql.stdin"); !strings.HasPrefix(sql, "SET @lagoon_sync_salt = UNHEX('"+encodedSalt+"'); UPDATE `users`") {
+			t.Errorf("mysql was given %q, want the salt set ahead of the rules", sql)
+		}
+		if args := read("mysql.args"); strings.Contains(args, encodedSalt) {
+			t.Errorf("mysql was given the salt in its arguments: %q", args)
+		}
+	})
+
+	t.Run("Postgres sets it in PGOPTIONS", func(t *testing.T) {
+		postgres := &PostgresSyncRoot{Sanitize: rules, SanitizeSalt: configuredSalt}
+		postgres.Config.setDefaults()
+		run(postgres.GetSanitizeCommand(local))
+		if env := read("psql.env"); strings.TrimSpace(env) != "-c lagoon_sync.sanitize_salt="+encodedSalt {
+			t.Errorf("psql was run with PGOPTIONS %q, want the salt", env)
+		}
+		if args := read("psql.args"); strings.Contains(args, encodedSalt) {
+			t.Errorf("psql was given the salt in its arguments: %q", args)
+		}
+	})
+
+	t.Run("Without one configured, each run gets its own", func(t *testing.T) {
+		first := &MariadbSyncRoot{Sanitize: rules}
+		second := &MariadbSyncRoot{Sanitize: rules}
+		firstSalt := first.GetSanitizeCommand(local).GetSecrets()["LAGOON_SYNC_SECRET_SANITIZESALT"]
+		if firstSalt == "" || firstSalt != first.GetLocalStreamCommand(local).GetSecrets()["LAGOON_SYNC_SECRET_SANITIZESALT"] {
+			t.Errorf("the salt %q isn't used throughout the run", firstSalt)
+		}
+		if firstSalt == second.GetSanitizeCommand(local).GetSecrets()["LAGOON_SYNC_SECRET_SANITIZESALT"] {
+			t.Errorf("two runs were given the same salt %q", firstSalt)
+		}
+	})
+}
+
+// sanitizeTestSyncer streams its dump into the imported file, or fails to if importFails, and records being
+// sanitized after a failed import
+type sanitizeTestSyncer struct {
+	journalTestSyncer
+	importFails bool
+}
+
+func (s sanitizeTestSyncer) GetRemoteStreamCommand(environment Environment) SyncCommand {
+	return generateSyncCommand("echo dump", nil)
+}
+
+func (s sanitizeTestSyncer) GetLocalStreamCommand(environment Environment) SyncCommand {
+	if s.importFails {
+		return generateSyncCommand("cat > /dev/null; exit 1", nil)
+	}
+	return generateSyncCommand("cat > "+filepath.Join(s.dir, "imported"), nil)
+}
+
+func (s sanitizeTestSyncer) SanitizesData() bool {
+	return true
+}
+
+func (s sanitizeTestSyncer) GetSanitizeCommand(environment Environment) SyncCommand {
+	return generateSyncCommand("touch "+filepath.Join(s.dir, "sanitized"), nil)
+}
+
+func TestRunSyncProcess_Sanitized(t *testing.T) {
+	tests := []struct {
+		name          string
+		args          RunSyncProcessFunctionTypeArguments
+		importFails   bool
+		wantErr       error
+		wantImported  bool
+		wantSanitized bool
+	}{
+		{
+			name:         "Sanitized syncs are streamed rather than dumped to disk",
+			wantImported: true,
+		},
+		{
+			name:          "What a failed import leaves behind is sanitized",
+			importFails:   true,
+			wantErr:       ErrTransferFailed,
+			wantSanitized: true,
+		},
+		{
+			name:    "The dump can't be left unimported",
+			args:    RunSyncProcessFunctionTypeArguments{SkipTargetImport: true},
+			wantErr: ErrConfigInvalid,
+		},
+		{
+			name:    "The dump can't be kept on the target",
+			args:    RunSyncProcessFunctionTypeArguments{SkipTargetCleanup: true},
+			wantErr: ErrConfigInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			args := tt.args
+			args.SourceEnvironment = Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME}
+			args.TargetEnvironment = Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME}
+			args.LagoonSyncer = sanitizeTestSyncer{journalTestSyncer: journalTestSyncer{dir: dir}, importFails: tt.importFails}
+			args.SyncerType = "test"
+			args.SshOptionWrapper = &SSHOptionWrapper{}
+
+			err := RunSyncProcess(context.Background(), args)
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RunSyncProcess() error = %v, want %v", err, tt.wantErr)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "exported")); err == nil {
+				t.Errorf("RunSyncProcess() dumped the data to disk")
+			}
+			if _, err := os.Stat(filepath.Join(dir, "imported")); (err == nil) != tt.wantImported {
+				t.Errorf("RunSyncProcess() imported = %v, want %v", err == nil, tt.wantImported)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "sanitized")); (err == nil) != tt.wantSanitized {
+				t.Errorf("RunSyncProcess() sanitized after the import = %v, want %v", err == nil, tt.wantSanitized)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package synchers
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uselagoon/lagoon-sync/utils"
+)
+
+// solrPollLimit is how many seconds a backup or restore is waited on before the sync gives up on it
+const solrPollLimit = 3600
+
+type BaseSolrSync struct {
+	// Url is the base url of the solr server, that the core's replication handler is found under
+	Url  string `yaml:"url"`
+	Core string `yaml:"core"`
+	// SnapshotDirectory is where snapshots are written by the source's solr, and read by the target's. It has to be
+	// in one of solr's allowed paths, and defaults to the core's data directory.
+	SnapshotDirectory string `yaml:"snapshot-directory"`
+	// SolrSnapshotDirectory is the snapshot directory as solr sees it, if lagoon-sync is run somewhere it's mounted
+	// at a different path - a local cli service sharing the solr volume, say
+	SolrSnapshotDirectory string `yaml:"solr-snapshot-directory"`
+	OutputDirectory       string
+}
+
+type SolrSyncRoot struct {
+	Type                     string `yaml:"type" json:"type"`
+	ServiceName              string `yaml:"serviceName"`
+	Config                   BaseSolrSync
+	LocalOverrides           SolrSyncLocal `yaml:"local"`
+	TransferId               string
+	TransferResourceOverride string
+	Transport                string `yaml:"transport"`
+}
+
+type SolrSyncLocal struct {
+	Config BaseSolrSync
+}
+
+// SetDefaults is a public function that is used to set all defaults for this struct
+func (solrConfig *BaseSolrSync) SetDefaults() {
+	solrConfig.setDefaults()
+}
+
+func (solrConfig *BaseSolrSync) setDefaults() {
+	if solrConfig.Url == "" {
+		solrConfig.Url = "http://${SOLR_HOST:-solr}:${SOLR_PORT:-8983}/solr"
+	}
+	if solrConfig.Core == "" {
+		solrConfig.Core = "drupal"
+	}
+}
+
+// Init related types and functions follow
+
+type SolrSyncPlugin struct {
+	isConfigEmpty bool
+}
+
+func (m BaseSolrSync) IsBaseSolrSyncStructEmpty() bool {
+	return reflect.DeepEqual(m, BaseSolrSync{})
+}
+
+func (m SolrSyncPlugin) GetPluginId() string {
+	return "solr"
+}
+
+func (m SolrSyncPlugin) UnmarshallYaml(syncerConfigRoot SyncherConfigRoot, targetService string) (Syncer, error) {
+	solr := SolrSyncRoot{}
+	solr.Type = m.GetPluginId()
+	solr.Config.setDefaults()
+
+	configMap := syncerConfigRoot.LagoonSync[targetService]
+
+	// If yaml config is there then unmarshall into struct and override default values if there are any
+	if configMap != nil {
+		if err := UnmarshalIntoStruct(configMap, &solr); err != nil {
+			return nil, fmt.Errorf("%w: unable to parse the %s config: %v", ErrConfigInvalid, targetService, err)
+		}
+		utils.LogDebugInfo("Config that will be used for sync", solr)
+	} else {
+		utils.LogDebugInfo("Active syncer config is empty, so using defaults", solr)
+	}
+
+	if solr.Config.IsBaseSolrSyncStructEmpty() && &solr == nil {
+		m.isConfigEmpty = true
+		return nil, fmt.Errorf("%w: no syncer configuration could be found", ErrConfigInvalid)
+	}
+
+	return solr.PrepareSyncer()
+}
+
+func init() {
+	RegisterSyncer(SolrSyncPlugin{})
+}
+
+func (m *SolrSyncRoot) IsInitialized() (bool, error) {
+	return true, nil
+}
+
+// Sync related functions follow
+
+func (root *SolrSyncRoot) PrepareSyncer() (Syncer, error) {
+	for _, core := range []string{root.Config.Core, root.LocalOverrides.Config.Core} {
+		if strings.ContainsAny(core, "/?&# ") {
+			return nil, fmt.Errorf("%w: '%s' isn't a valid solr core name", ErrConfigInvalid, core)
+		}
+	}
+	root.TransferId = strconv.FormatInt(time.Now().UnixNano(), 10)
+	return root, nil
+}
+
+func (root *SolrSyncRoot) GetPrerequisiteCommand(environment Environment, command string) SyncCommand {
+	lagoonSyncBin, _ := utils.FindLagoonSyncOnEnv()
+
+	return SyncCommand{
+		command: fmt.Sprintf("{{ .bin }} {{ .command }} || true"),
+		substitutions: map[string]interface{}{
+			"bin":     lagoonSyncBin,
+			"command": command,
+		},
+	}
+}
+
+func (root *SolrSyncRoot) GetRemoteCommand(environment Environment) []SyncCommand {
+	transferResource := root.GetTransferResource(environment)
+	return []SyncCommand{root.exportCommand(root.getEffectiveDetails(environment), transferResource.Name)}
+}
+
+func (root *SolrSyncRoot) GetLocalCommand(environment Environment) []SyncCommand {
+	transferResource := root.GetTransferResource(environment)
+	return []SyncCommand{root.importCommand(root.getEffectiveDetails(environment), transferResource.Name)}
+}
+
+// IsImportRetryable is true since a restore replaces the whole of the target core's index
+func (root *SolrSyncRoot) IsImportRetryable() bool {
+	return true
+}
+
+func (root *SolrSyncRoot) GetRemoteStreamCommand(environment Environment) SyncCommand {
+	return root.exportCommand(root.getEffectiveDetails(environment), "")
+}
+
+func (root *SolrSyncRoot) GetLocalStreamCommand(environment Environment) SyncCommand {
+	return root.importCommand(root.getEffectiveDetails(environment), "")
+}
+
+// solrRequest defines solr_request, which makes a request to the core's replication handler with curl, or wget in
+// images that don't have it
+const solrRequest = `solr_request() { if command -v curl > /dev/null; then curl -fsS "{{ .url }}/{{ .core }}/replication?wt=xml&$1"; else wget -q -O - "{{ .url }}/{{ .core }}/replication?wt=xml&$1"; fi; }; `
+
+// exportCommand has the source's solr take a snapshot of the core, and archives it to the file, or stdout if there's
+// none. The backup is asynchronous, so the replication handler's details are polled until they report the snapshot
+// was taken. The snapshot is removed once it's archived.
+func (root *SolrSyncRoot) exportCommand(config BaseSolrSync, file string) SyncCommand {
+	substitutions := root.substitutions(config)
+	substitutions["file"] = "-"
+	if file != "" {
+		substitutions["file"] = file
+	}
+	script := solrRequest +
+		`rm -rf {{ .snapshot }} && solr_request "command=backup&location={{ .location }}&name={{ .name }}" > /dev/null && ` +
+		solrPoll("command=details", `<str name="snapshotName">{{ .name }}</str>`, "the snapshot of {{ .core }}") + ` && ` +
+		`tar -C {{ .directory }} -czf {{ .file }} snapshot.{{ .name }}; status=$?; rm -rf {{ .snapshot }}; exit $status`
+	return generateSyncCommand(script, substitutions)
+}
+
+// importCommand unpacks the snapshot archived by exportCommand from the file, or stdin if there's none, and has the
+// target's solr restore the core from it, polling until the restore is done. The snapshot is removed afterwards.
+func (root *SolrSyncRoot) importCommand(config BaseSolrSync, file string) SyncCommand {
+	substitutions := root.substitutions(config)
+	substitutions["file"] = "-"
+	if file != "" {
+		substitutions["file"] = file
+	}
+	script := solrRequest +
+		`rm -rf {{ .snapshot }} && mkdir -p {{ .directory }} && tar -C {{ .directory }} -xzf {{ .file }} && ` +
+		`solr_request "command=restore&location={{ .location }}&name={{ .name }}" > /dev/null && ` +
+		solrPoll("command=restorestatus", `<str name="snapshotName">snapshot.{{ .name }}</str>`, "the restore of {{ .core }}") +
+		`; status=$?; rm -rf {{ .snapshot }}; exit $status`
+	return generateSyncCommand(script, substitutions)
+}
+
+// solrPoll makes the request every second until its response names the snapshot with a success status, and fails
+// if the status is failed or the poll limit is reached. It runs in a subshell, so that exiting it is just a failure.
+func solrPoll(request string, snapshot string, description string) string {
+	return fmt.Sprintf(`(tries=0; while :; do response=$(solr_request "%s" | tr -d '\n'); `+
+		`case "$response" in *'%s'*) case "$response" in *'<str name="status">success</str>'*) exit 0 ;; *'<str name="status">failed</str>'*) echo "%s failed: $response" >&2; exit 1 ;; esac ;; esac; `+
+		`tries=$((tries + 1)); if [ $tries -ge %d ]; then echo "%s didn't finish in time" >&2; exit 1; fi; sleep 1; done)`,
+		request, snapshot, description, solrPollLimit, description)
+}
+
+func (root *SolrSyncRoot) substitutions(config BaseSolrSync) map[string]interface{} {
+	directory := config.snapshotDirectory()
+	location := directory
+	if config.SolrSnapshotDirectory != "" {
+		location = strings.TrimSuffix(config.SolrSnapshotDirectory, "/")
+	}
+	return map[string]interface{}{
+		"url":       strings.TrimSuffix(config.Url, "/"),
+		"core":      config.Core,
+		"name":      root.TransferId,
+		"directory": directory,
+		"location":  location,
+		"snapshot":  fmt.Sprintf("%s/snapshot.%s", directory, root.TransferId),
+	}
+}
+
+// snapshotDirectory defaults to the core's data directory in the solr images, which solr is always allowed to write to
+func (config BaseSolrSync) snapshotDirectory() string {
+	if config.SnapshotDirectory == "" {
+		return fmt.Sprintf("/var/solr/data/%s/data", config.Core)
+	}
+	return strings.TrimSuffix(config.SnapshotDirectory, "/")
+}
+
+func (root *SolrSyncRoot) GetFilesToCleanup(environment Environment) []string {
+	transferResource := root.GetTransferResource(environment)
+	return []string{
+		transferResource.Name,
+	}
+}
+
+func (root *SolrSyncRoot) GetTransferResource(environment Environment) SyncerTransferResource {
+	resourceName := fmt.Sprintf("%vlagoon_sync_solr_%v.tar.gz", root.GetOutputDirectory(), root.TransferId)
+	if root.TransferResourceOverride != "" {
+		resourceName = root.TransferResourceOverride
+	}
+	return SyncerTransferResource{
+		Name:        resourceName,
+		IsDirectory: false,
+		Transport:   root.Transport}
+}
+
+func (root *SolrSyncRoot) SetTransferResource(transferResourceName string) error {
+	root.TransferResourceOverride = transferResourceName
+	return nil
+}
+
+func (root *SolrSyncRoot) GetOutputDirectory() string {
+	m := root.Config
+	if len(m.OutputDirectory) == 0 {
+		return "/tmp/"
+	}
+	return m.OutputDirectory
+}
+
+// getEffectiveDetails is the config for the environment, with the local overrides applied on the local environment
+func (root *SolrSyncRoot) getEffectiveDetails(environment Environment) BaseSolrSync {
+	if environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
+		return root.getEffectiveLocalDetails()
+	}
+	return root.Config
+}
+
+func (syncConfig *SolrSyncRoot) getEffectiveLocalDetails() BaseSolrSync {
+	returnDetails := syncConfig.Config
+
+	assignLocalOverride := func(target *string, override *string) {
+		if len(*override) > 0 {
+			*target = *override
+		}
+	}
+
+	assignLocalOverride(&returnDetails.Url, &syncConfig.LocalOverrides.Config.Url)
+	assignLocalOverride(&returnDetails.Core, &syncConfig.LocalOverrides.Config.Core)
+	assignLocalOverride(&returnDetails.SnapshotDirectory, &syncConfig.LocalOverrides.Config.SnapshotDirectory)
+	assignLocalOverride(&returnDetails.SolrSnapshotDirectory, &syncConfig.LocalOverrides.Config.SolrSnapshotDirectory)
+	assignLocalOverride(&returnDetails.OutputDirectory, &syncConfig.LocalOverrides.Config.OutputDirectory)
+	return returnDetails
+}
@@ -0,0 +1,142 @@
+package synchers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSolrCurl answers the replication handler's requests the way solr does, taking snapshots of a core whose index
+// is a single file, and restoring them into the restored directory
+const fakeSolrCurl = `#!/bin/sh
+query="${2#*\?}"
+param() { printf '%%s\n' "$query" | tr '&' '\n' | sed -n "s/^$1=//p"; }
+case "$(param command)" in
+  backup) mkdir -p "$(param location)/snapshot.$(param name)" && echo index > "$(param location)/snapshot.$(param name)/segments_1" && param name > %[1]s/backup ;;
+  details) printf '<response>\n<lst name="backup">\n<str name="status">success</str>\n<str name="snapshotName">%%s</str>\n</lst>\n</response>\n' "$(cat %[1]s/backup)" ;;
+  restore) echo "$2" > %[1]s/restore && cp -R "$(param location)/snapshot.$(param name)" %[1]s/restored ;;
+  restorestatus) printf '<lst name="restorestatus"><str name="snapshotName">snapshot.%%s</str><str name="status">%[2]s</str></lst>\n' "$(cat %[1]s/backup)" ;;
+esac
+`
+
+func TestSolrSyncRoot_Sync(t *testing.T) {
+	for _, restoreStatus := range []string{"success", "failed"} {
+		t.Run(restoreStatus, func(t *testing.T) {
+			bin := t.TempDir()
+			state := t.TempDir()
+			if err := os.WriteFile(filepath.Join(bin, "curl"), []byte(fmt.Sprintf(fakeSolrCurl, state, restoreStatus)), 0755); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+			source := t.TempDir()
+			target := t.TempDir()
+			local := Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME}
+			syncer, err := SolrSyncPlugin{}.UnmarshallYaml(SyncherConfigRoot{LagoonSync: map[string]interface{}{
+				"solr": map[string]interface{}{
+					"config": map[string]interface{}{"core": "search", "snapshot-directory": source},
+					"local":  map[string]interface{}{"config": map[string]interface{}{"core": "search_local", "snapshot-directory": target + "/"}},
+				},
+			}}, "solr")
+			if err != nil {
+				t.Fatalf("UnmarshallYaml() error = %v", err)
+			}
+			run := func(command SyncCommand, environment Environment, stdin []byte) ([]byte, error) {
+				rendered, err := renderCommand(command)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var stdout bytes.Buffer
+				err = runStreamCommand(context.Background(), environment, rendered, bytes.NewReader(stdin), &stdout, &SSHOptionWrapper{}, nil)
+				return stdout.Bytes(), err
+			}
+
+			// The export is run against the source's config, which is how the remote environment's commands are built
+			archive, err := run(syncer.GetRemoteStreamCommand(Environment{EnvironmentName: "main"}), local, nil)
+			if err != nil {
+				t.Fatalf("export error = %v", err)
+			}
+			if snapshots, _ := filepath.Glob(filepath.Join(source, "snapshot.*")); len(snapshots) != 0 {
+				t.Errorf("the source's snapshot was left behind: %v", snapshots)
+			}
+
+			_, err = run(syncer.GetLocalStreamCommand(local), local, archive)
+			if (err != nil) != (restoreStatus == "failed") {
+				t.Fatalf("import error = %v, with a %s restore", err, restoreStatus)
+			}
+			if snapshots, _ := filepath.Glob(filepath.Join(target, "snapshot.*")); len(snapshots) != 0 {
+				t.Errorf("the target's snapshot was left behind: %v", snapshots)
+			}
+			// The snapshot taken on the source is restored into the target's core
+			request, _ := os.ReadFile(filepath.Join(state, "restore"))
+			if !strings.Contains(string(request), "/solr/search_local/replication?") || !strings.Contains(string(request), "location="+target+"&") {
+				t.Errorf("restore request = %s", request)
+			}
+			if index, err := os.ReadFile(filepath.Join(state, "restored", "segments_1")); err != nil || string(index) != "index\n" {
+				t.Errorf("restored index = %q, %v", index, err)
+			}
+		})
+	}
+}
+
+func TestSolrSyncRoot_Commands(t *testing.T) {
+	syncer := &SolrSyncRoot{
+		LocalOverrides: SolrSyncLocal{Config: BaseSolrSync{
+			Url:                   "http://localhost:8983/solr/",
+			SnapshotDirectory:     "/app/.solr",
+			SolrSnapshotDirectory: "/var/solr/snapshots",
+		}},
+	}
+	syncer.Config.setDefaults()
+	if _, err := syncer.PrepareSyncer(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		environment Environment
+		want        []string
+	}{
+		{
+			name:        "defaults to the core's data directory",
+			environment: Environment{EnvironmentName: "main"},
+			want: []string{
+				`curl -fsS "http://${SOLR_HOST:-solr}:${SOLR_PORT:-8983}/solr/drupal/replication?wt=xml&$1"`,
+				`location=/var/solr/data/drupal/data&name=` + syncer.TransferId,
+				`tar -C /var/solr/data/drupal/data -czf ` + syncer.GetTransferResource(Environment{}).Name,
+			},
+		},
+		{
+			name:        "solr sees the local snapshot directory at a different path",
+			environment: Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME},
+			want: []string{
+				`curl -fsS "http://localhost:8983/solr/drupal/replication?wt=xml&$1"`,
+				`location=/var/solr/snapshots&name=` + syncer.TransferId,
+				`tar -C /app/.solr -czf ` + syncer.GetTransferResource(Environment{}).Name,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := syncer.GetRemoteCommand(tt.environment)[0].GetCommand()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("GetRemoteCommand() = %v, want it to contain %v", got, want)
+				}
+			}
+		})
+	}
+
+	syncer.LocalOverrides.Config.Core = "drupal?command=delete"
+	if _, err := syncer.PrepareSyncer(); !errors.Is(err, ErrConfigInvalid) {
+		t.Errorf("PrepareSyncer() error = %v, want ErrConfigInvalid", err)
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/uselagoon/lagoon-sync/prerequisite"
+	"github.com/uselagoon/lagoon-sync/utils"
 	"gopkg.in/yaml.v2"
 )
 
@@ -18,6 +20,10 @@ type Syncer interface {
 	GetRemoteCommand(environment Environment) []SyncCommand
 	// GetLocalCommand will return the command to be run on the target system
 	GetLocalCommand(environment Environment) []SyncCommand
+	// GetRemoteStreamCommand will return the command run on the source system that writes the resource to stdout when streaming
+	GetRemoteStreamCommand(environment Environment) SyncCommand
+	// GetLocalStreamCommand will return the command run on the target system that reads the resource from stdin when streaming
+	GetLocalStreamCommand(environment Environment) SyncCommand
 	// GetTransferResource will return the command that executes the transfer
 	GetTransferResource(environment Environment) SyncerTransferResource
 	// SetTransferResource allows for the overriding of the resource transfer name that's typically generated
@@ -30,6 +36,35 @@ type Syncer interface {
 	IsInitialized() (bool, error)
 }
 
+// TableSelector is implemented by database syncers that can sync a selection of tables, in place of the tables
+// their config selects
+type TableSelector interface {
+	// SetTables limits the sync to the tables, which can be table names, glob patterns or /regular expressions/
+	SetTables(tables []string) error
+}
+
+// MultiResourceSyncer is implemented by syncers that transfer more than one resource in a sync. GetTransferResource
+// returns the first of them.
+type MultiResourceSyncer interface {
+	// GetTransferResources will return each of the resources the sync transfers, in the order they're transferred
+	GetTransferResources(environment Environment) []SyncerTransferResource
+}
+
+// getTransferResources returns the resources the syncer transfers, which is only the one for most syncers
+func getTransferResources(syncer Syncer, environment Environment) []SyncerTransferResource {
+	if multiResourceSyncer, ok := syncer.(MultiResourceSyncer); ok {
+		return multiResourceSyncer.GetTransferResources(environment)
+	}
+	return []SyncerTransferResource{syncer.GetTransferResource(environment)}
+}
+
+// SensitiveValue marks a command substitution, such as a password, that is shown as "****" wherever the command is
+// logged or printed. It isn't written into the command that's run either - that refers to a shell variable holding
+// it instead - so it has to be substituted somewhere the shell expands variables, such as between double quotes.
+type SensitiveValue string
+
+const redactedValue = "****"
+
 type SyncCommand struct {
 	command       string
 	substitutions map[string]interface{}
@@ -38,10 +73,43 @@ type SyncCommand struct {
 
 // SyncerTransferResource describes what it is the is produced by the actions of GetRemoteCommand()
 type SyncerTransferResource struct {
-	Name             string   `yaml:"name,omitempty" json:"name,omitempty"`
-	IsDirectory      bool     `yaml:"isDirectory,omitempty" json:"isDirectory,omitempty"`
-	ExcludeResources []string `yaml:"excludeResources,omitempty" json:"excludeResources,omitempty"`
-	SkipCleanup      bool     `yaml:"skipCleanup,omitempty" json:"skipCleanup,omitempty"`
+	Name             string    `yaml:"name,omitempty" json:"name,omitempty"`
+	IsDirectory      bool      `yaml:"isDirectory,omitempty" json:"isDirectory,omitempty"`
+	ExcludeResources []string  `yaml:"excludeResources,omitempty" json:"excludeResources,omitempty"`
+	SkipCleanup      bool      `yaml:"skipCleanup,omitempty" json:"skipCleanup,omitempty"`
+	Transport        string    `yaml:"transport,omitempty" json:"transport,omitempty"`               // the id of the transport used to move the resource, rsync if empty
+	Incremental      bool      `yaml:"incremental,omitempty" json:"incremental,omitempty"`           // only transfer the files in the directory that have changed since the last sync
+	IncludeResources []string  `yaml:"includeResources,omitempty" json:"includeResources,omitempty"` // if set, only the files matching these are transferred
+	MaxFileSize      int64     `yaml:"maxFileSize,omitempty" json:"maxFileSize,omitempty"`           // if set, files larger than this many bytes are skipped
+	ModifiedSince    time.Time `yaml:"modifiedSince,omitempty" json:"modifiedSince,omitempty"`       // if set, files last modified before this are skipped
+	Placeholders     bool      `yaml:"placeholders,omitempty" json:"placeholders,omitempty"`         // replace large images with generated placeholders when syncing to local
+	PlaceholderSize  int64     `yaml:"placeholderSize,omitempty" json:"placeholderSize,omitempty"`   // images larger than this many bytes are replaced by placeholders
+}
+
+// filtersFiles reports whether some of the files in the resource's directory are left out of the transfer by
+// something other than its excludes
+func (r SyncerTransferResource) filtersFiles() bool {
+	return len(r.IncludeResources) > 0 || r.MaxFileSize > 0 || !r.ModifiedSince.IsZero()
+}
+
+// listsFiles reports whether the files in the resource's directory have to be listed to work out which of them
+// to transfer
+func (r SyncerTransferResource) listsFiles() bool {
+	return r.IsDirectory && (r.Incremental || r.filtersFiles())
+}
+
+// placeholdersFor reports whether the resource's large images are replaced by placeholders when syncing to the
+// target. Placeholders are generated locally, so they're only ever written to the local environment.
+func (r SyncerTransferResource) placeholdersFor(target Environment) bool {
+	return r.IsDirectory && r.Placeholders && target.EnvironmentName == LOCAL_ENVIRONMENT_NAME
+}
+
+// selects reports whether a file passes the resource's size and age filters
+func (r SyncerTransferResource) selects(file FileState) bool {
+	if r.MaxFileSize > 0 && file.Size > r.MaxFileSize {
+		return false
+	}
+	return r.ModifiedSince.IsZero() || file.Mtime >= r.ModifiedSince.Unix()
 }
 
 type Environment struct {
@@ -72,6 +140,19 @@ type SSHOptions struct {
 	PrivateKey string `yaml:"privateKey,omitempty" json:"privateKey,omitempty"`
 	SkipAgent  bool
 	RsyncArgs  string `yaml:"rsyncArgs,omitempty" json:"rsyncArgs,omitempty"`
+	// HostKeyPolicy is one of "strict", "accept-new" (the default) or "insecure"
+	HostKeyPolicy       string   `yaml:"hostKeyPolicy,omitempty" json:"hostKeyPolicy,omitempty"`
+	KnownHostsFile      string   `yaml:"knownHostsFile,omitempty" json:"knownHostsFile,omitempty"`
+	HostKeyFingerprints []string `yaml:"hostKeyFingerprints,omitempty" json:"hostKeyFingerprints,omitempty"`
+}
+
+// GetHostKeyVerification returns how the keys of the hosts these options connect to should be verified
+func (o SSHOptions) GetHostKeyVerification() utils.HostKeyVerification {
+	return utils.HostKeyVerification{
+		Policy:         o.HostKeyPolicy,
+		KnownHostsFile: o.KnownHostsFile,
+		Fingerprints:   o.HostKeyFingerprints,
+	}
 }
 
 func (r Environment) GetOpenshiftProjectName() string {
@@ -88,7 +169,7 @@ func UnmarshalIntoStruct(pluginIn interface{}, pluginOut interface{}) error {
 	return yaml.Unmarshal(b, pluginOut)
 }
 
-func GenerateRemoteCommand(remoteEnvironment Environment, command string, sshOptions SSHOptions) string {
+func GenerateRemoteCommand(remoteEnvironment Environment, command string, sshOptions SSHOptions) (string, error) {
 	var sshOptionsStr bytes.Buffer
 	if sshOptions.Verbose {
 		sshOptionsStr.WriteString(" -v")
@@ -98,11 +179,16 @@ func GenerateRemoteCommand(remoteEnvironment Environment, command string, sshOpt
 		sshOptionsStr.WriteString(fmt.Sprintf(" -i %s", sshOptions.PrivateKey))
 	}
 
+	hostKeyOptions, err := sshOptions.GetHostKeyVerification().SshCommandOptions(sshOptions.Host, sshOptions.Port)
+	if err != nil {
+		return "", err
+	}
+
 	serviceArgument := ""
 	if remoteEnvironment.ServiceName != "" {
 		serviceArgument = fmt.Sprintf("service=%v", remoteEnvironment.ServiceName)
 	}
 
-	return fmt.Sprintf("ssh%s -tt -o LogLevel=FATAL -o \"UserKnownHostsFile=/dev/null\" -o \"StrictHostKeyChecking=no\" -p %s %s@%s %s '%s'",
-		sshOptionsStr.String(), sshOptions.Port, remoteEnvironment.GetOpenshiftProjectName(), sshOptions.Host, serviceArgument, command)
+	return fmt.Sprintf("ssh%s -tt -o LogLevel=FATAL %s -p %s %s@%s %s '%s'",
+		sshOptionsStr.String(), hostKeyOptions, sshOptions.Port, remoteEnvironment.GetOpenshiftProjectName(), sshOptions.Host, serviceArgument, command), nil
 }
@@ -1,7 +1,6 @@
 package synchers
 
 import (
-	"errors"
 	"fmt"
 )
 
@@ -42,7 +41,7 @@ func GetSyncerForTypeFromConfigRoot(syncerId string, root SyncherConfigRoot) (Sy
 	}
 
 	if syncerMap[syncerId] == nil {
-		return nil, errors.New(fmt.Sprintf("Syncer of type '%s' not registered", syncerId))
+		return nil, fmt.Errorf("%w: syncer of type '%s' not registered", ErrConfigInvalid, syncerId)
 	}
 
 	return syncerMap[syncerId].UnmarshallYaml(root, syncerId)
@@ -78,6 +78,38 @@ func NewBasePostgresSyncRootFromService(service utils.Service) (Syncer, error) {
 	return retSyncRoot, nil
 }
 
+// NewBaseRedisSyncRootFromService returns a RedisSyncRoot populated from a service definition.
+// Returns an error for unsupported service types.
+func NewBaseRedisSyncRootFromService(service utils.Service) (Syncer, error) {
+	allowed := map[string]bool{
+		"redis":            true,
+		"redis-persistent": true,
+	}
+
+	serviceType := service.Type
+	if serviceType == "" {
+		serviceType = service.Name
+	}
+
+	if !allowed[serviceType] {
+		return &RedisSyncRoot{}, fmt.Errorf("unsupported redis service type: %s", serviceType)
+	}
+
+	name := strings.ToUpper(service.Name)
+	retSyncRoot := &RedisSyncRoot{
+		ServiceName: service.Name,
+		Type:        RedisSyncPlugin{}.GetPluginId(),
+		Config: BaseRedisSync{
+			Hostname: fmt.Sprintf("${%v_HOST:-%v}", name, service.Name),
+			Port:     fmt.Sprintf("${%v_PORT:-6379}", name),
+		},
+	}
+	retSyncRoot.Config.setDefaults()
+	retSyncRoot.PrepareSyncer()
+
+	return retSyncRoot, nil
+}
+
 // NewBaseFilesSyncFromService returns a FilesSyncRoot populated from a service definition.
 // Returns an error if the service has no volumes.
 func NewBaseFilesSyncRootFromService(service utils.Service, volumePath string) (Syncer, error) {
@@ -2,27 +2,28 @@ package synchers
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"io"
 	"os"
+	"strings"
 	"text/template"
+	"time"
 
-	"github.com/spf13/viper"
 	"github.com/uselagoon/lagoon-sync/utils"
 	"gopkg.in/yaml.v2"
 )
 
 var shellToUse = "sh"
-var debug = viper.Get("show-debug")
 
 // UnmarshallLagoonYamlToLagoonSyncStructure will take a bytestream and return a fully parsed lagoon sync config structure
 func UnmarshallLagoonYamlToLagoonSyncStructure(data []byte) (SyncherConfigRoot, error) {
 	lagoonConfig := SyncherConfigRoot{}
 	err := yaml.Unmarshal(data, &lagoonConfig)
 
-	if err != nil && debug == false {
-		return SyncherConfigRoot{}, errors.New("Unable to parse lagoon config yaml setup")
+	if err != nil {
+		return SyncherConfigRoot{}, fmt.Errorf("Unable to parse lagoon config yaml setup: %w", err)
 	}
 	return lagoonConfig, nil
 }
@@ -39,108 +40,342 @@ type RunSyncProcessFunctionTypeArguments struct {
 	SkipTargetImport     bool
 	LocalArchiveOnly     bool //we now allow this syncher to only run the archive only locally - if this is set, we simply run the first step
 	TransferResourceName string
+	Stream               bool          // pipe the source export straight into the target import, without any intermediate dump files
+	Journal              *SyncJournal  // if set, progress is recorded here and phases it has already completed are skipped
+	Logger               *utils.Logger // if set, messages are logged with this logger's prefix
+	PhasePolicies        PhasePolicies // timeouts and retries for each phase, every phase is run once without a timeout if not set
+	Report               *SyncReport   // if set, filled in with what the sync did
+	Hooks                SyncHooks     // commands run before and after the export, import and cleanup
+	BackupTarget         bool          // back up the target's database before importing over it
+	BackupPolicy         BackupPolicy  // where backups of the target are kept, and how many
+	Delete               bool          // remove files from the target that are no longer on the source (files syncers only)
+	ManifestDirectory    string        // where incremental files syncs keep their manifests, they aren't kept if empty
+	KeepFailedFiles      bool          // keep the files a failed run generated, so that a resume can pick up from them
+	// ConfirmDeletions is asked before Delete removes files from the target, with the files it would remove. They're
+	// only removed if it's nil or returns nil.
+	ConfirmDeletions func(target Environment, files []string) error
 }
 
-type RunSyncProcessFunctionType = func(args RunSyncProcessFunctionTypeArguments) error
+type RunSyncProcessFunctionType = func(ctx context.Context, args RunSyncProcessFunctionTypeArguments) error
 
-func RunSyncProcess(args RunSyncProcessFunctionTypeArguments) error {
-	var err error
+// RunSyncProcess works through the phases of a sync. If ctx is cancelled part way through, the running command is
+// killed and everything generated so far is cleaned up before returning.
+func RunSyncProcess(ctx context.Context, args RunSyncProcessFunctionTypeArguments) (err error) {
+	journal := args.Journal
+	report := args.Report
+	report.start(args)
+	defer func() {
+		report.finish(err)
+	}()
 
 	if _, err := args.LagoonSyncer.IsInitialized(); err != nil {
 		return err
 	}
 
-	sourceRsyncPath := "rsync" //args.SourceEnvironment.RsyncPath
-	args.SourceEnvironment.RsyncPath = "rsync"
+	if journal.IsFinished() {
+		args.Logger.LogProcessStep("Sync run has already completed, nothing to do", journal.RunId)
+		return nil
+	}
 
-	err = SyncRunSourceCommand(args.SourceEnvironment, args.LagoonSyncer, args.DryRun, args.SshOptionWrapper)
-	if err != nil {
-		_ = SyncCleanUp(args.SourceEnvironment, args.LagoonSyncer, args.DryRun, args.SshOptionWrapper)
-		return err
+	if _, ok := args.LagoonSyncer.(TargetBackuper); args.BackupTarget && !ok {
+		return fmt.Errorf("%w: the %s syncer can't back up the target", ErrConfigInvalid, args.SyncerType)
 	}
 
-	if args.LocalArchiveOnly == true {
-		return nil // we're done here
+	// The dump of a sanitized sync holds the data as it was before it's sanitized, so it's never written to disk -
+	// the sync is streamed, and the options that would leave the dump behind, or unimported, are refused
+	sanitizing := sanitizesData(args.LagoonSyncer)
+	if sanitizing {
+		if args.SkipTargetImport || args.LocalArchiveOnly || args.SkipSourceCleanup || args.SkipTargetCleanup {
+			return fmt.Errorf("%w: the %s syncer sanitizes the data it imports, so its dump can't be kept or left unimported", ErrConfigInvalid, args.SyncerType)
+		}
+		if !supportsStreaming(args.LagoonSyncer, args.SourceEnvironment, args.TargetEnvironment) {
+			return fmt.Errorf("%w: the %s syncer sanitizes the data it imports, but can't stream it", ErrConfigInvalid, args.SyncerType)
+		}
+		if !args.Stream {
+			args.Logger.LogProcessStep("Streaming the sync, so that the data isn't written to disk before it's sanitized", nil)
+			args.Stream = true
+		}
+	}
+
+	// Resources that are incremental or filtered, and any that files are deleted from, are transferred file by file
+	transferFileByFile := args.Delete
+	for _, transferResource := range getTransferResources(args.LagoonSyncer, args.SourceEnvironment) {
+		if args.Delete && !transferResource.IsDirectory {
+			return fmt.Errorf("%w: the %s syncer doesn't sync files, so it can't delete them", ErrConfigInvalid, args.SyncerType)
+		}
+		if transferResource.Placeholders && !transferResource.placeholdersFor(args.TargetEnvironment) {
+			args.Logger.LogWarning("Placeholders are only generated when syncing to the local environment, so the images in "+transferResource.Name+" will be synced", nil)
+		}
+		transferFileByFile = transferFileByFile || transferResource.listsFiles() || transferResource.placeholdersFor(args.TargetEnvironment)
+	}
+
+	// Cleaning up has to happen even once the run has been cancelled, so it isn't tied to ctx
+	cleanupCtx := context.WithoutCancel(ctx)
+	cleanupPolicy := args.PhasePolicies.GetPolicy(SyncPhaseCleanup)
+	syncCleanUp := func(environment Environment) {
+		_ = runPhase(cleanupCtx, SyncPhaseCleanup, cleanupPolicy, args.Logger, func(ctx context.Context) error {
+			return SyncCleanUp(ctx, environment, args.LagoonSyncer, args.DryRun, args.SshOptionWrapper, args.Logger)
+		})
+	}
+	prerequisiteCleanUp := func(environment Environment, rsyncPath string) {
+		_ = runPhase(cleanupCtx, SyncPhaseCleanup, cleanupPolicy, args.Logger, func(ctx context.Context) error {
+			return PrerequisiteCleanUp(ctx, environment, rsyncPath, args.DryRun, args.SshOptionWrapper, args.Logger)
+		})
+	}
+
+	// Running an import again after it fails part way through is only safe if it replaces what's already there
+	importPolicy := args.PhasePolicies.GetPolicy(SyncPhaseTargetImport)
+	streamPolicy := args.PhasePolicies.GetPolicy(SyncPhaseTransfer)
+	if !isImportRetryable(args.LagoonSyncer) {
+		if importPolicy.Attempts > 1 || (args.Stream && streamPolicy.Attempts > 1) {
+			args.Logger.LogWarning(fmt.Sprintf("The import for syncer type '%v' can't safely be run twice, so it won't be retried", args.SyncerType), nil)
+		}
+		importPolicy.Attempts = 1
+		streamPolicy.Attempts = 1
+	}
+
+	runHooks := func(ctx context.Context, point SyncHookPoint, environment Environment) error {
+		return runHooks(ctx, args.Hooks, point, environment, args.DryRun, args.SshOptionWrapper, args.Logger)
+	}
+
+	// The target is backed up once, before the first attempt at importing. A resumed run already has its backup,
+	// and backing up again would only capture what the failed import left behind.
+	backupTarget := func() error {
+		if !args.BackupTarget || (journal != nil && journal.BackupFile != "") {
+			return nil
+		}
+		runId := "dry-run"
+		if journal != nil {
+			runId = journal.RunId
+		}
+		backupFile := args.BackupPolicy.BackupFile(args.SyncerType, runId)
+		if err := SyncBackupTarget(ctx, args.TargetEnvironment, args.LagoonSyncer, args.SyncerType, backupFile, journal.referencedBackupFiles(), args.BackupPolicy, args.DryRun, args.SshOptionWrapper, args.Logger); err != nil {
+			return err
+		}
+		if !args.DryRun {
+			_ = journal.RecordBackup(backupFile)
+		}
+		return nil
 	}
 
+	if args.Stream {
+		if args.LocalArchiveOnly || args.SkipTargetImport {
+			return errors.New("Streaming transfers always import on the target, and can't be used to only produce a dump")
+		}
+		if supportsStreaming(args.LagoonSyncer, args.SourceEnvironment, args.TargetEnvironment) {
+			// A streamed run is a single transfer, so it runs under the transfer policy
+			endPhase := startPhase(SyncPhaseTransfer, report, args.Logger)
+			if err = backupTarget(); err != nil {
+				endPhase(err)
+				_ = journal.RecordError(err)
+				return err
+			}
+			err = runPhase(ctx, SyncPhaseTransfer, streamPolicy, args.Logger, func(ctx context.Context) error {
+				if err := runHooks(ctx, SyncHookPreSource, args.SourceEnvironment); err != nil {
+					return err
+				}
+				if err := runHooks(ctx, SyncHookPreImport, args.TargetEnvironment); err != nil {
+					return err
+				}
+				if err := SyncRunStreamingTransfer(ctx, args.SourceEnvironment, args.TargetEnvironment, args.LagoonSyncer, args.DryRun, args.SshOptionWrapper, args.Logger, report); err != nil {
+					return err
+				}
+				if err := runHooks(ctx, SyncHookPostSource, args.SourceEnvironment); err != nil {
+					return err
+				}
+				return runHooks(ctx, SyncHookPostImport, args.TargetEnvironment)
+			})
+			if err == nil {
+				err = runHooks(ctx, SyncHookPostCleanup, args.TargetEnvironment)
+			}
+			if err != nil && sanitizing && !args.DryRun {
+				sanitizeFailedImport(cleanupCtx, args)
+			}
+			endPhase(err)
+			if err != nil {
+				_ = journal.RecordError(err)
+				return err
+			}
+			// There is nothing on disk to pick up from when streaming, so the whole run is recorded in one go
+			for _, phase := range []SyncPhase{SyncPhasePrerequisite, SyncPhaseSourceExport, SyncPhaseTransfer, SyncPhaseTargetImport, SyncPhaseCleanup} {
+				_ = journal.MarkCompleted(phase)
+			}
+			return nil
+		}
+		args.Logger.LogWarning(fmt.Sprintf("Syncer type '%v' does not support streaming, falling back to a file based transfer", args.SyncerType), nil)
+	}
+
+	sourceRsyncPath := "rsync" //args.SourceEnvironment.RsyncPath
+	args.SourceEnvironment.RsyncPath = "rsync"
 	args.TargetEnvironment.RsyncPath = "rsync"
 	targetRsyncPath := args.TargetEnvironment.RsyncPath
+	_ = journal.MarkCompleted(SyncPhasePrerequisite)
+
+	// cleanUpFailedRun removes what a failed run generated on both environments - including whatever a failed
+	// transfer got as far as writing on the target - other than what the skip cleanup options ask to keep
+	cleanUpFailedRun := func() {
+		prerequisiteCleanUp(args.SourceEnvironment, sourceRsyncPath)
+		if !args.SkipSourceCleanup {
+			syncCleanUp(args.SourceEnvironment)
+		}
+		prerequisiteCleanUp(args.TargetEnvironment, targetRsyncPath)
+		if !args.SkipTargetCleanup {
+			syncCleanUp(args.TargetEnvironment)
+		}
+	}
 
-	err = SyncRunTransfer(args.SourceEnvironment, args.TargetEnvironment, args.LagoonSyncer, args.DryRun, args.SshOptionWrapper)
-	if err != nil {
-		_ = PrerequisiteCleanUp(args.SourceEnvironment, sourceRsyncPath, args.DryRun, args.SshOptionWrapper)
-		_ = SyncCleanUp(args.SourceEnvironment, args.LagoonSyncer, args.DryRun, args.SshOptionWrapper)
+	// failRun records why the run stopped. The generated files are only kept, for a resume to pick up from, if the
+	// run is journaled and asked to keep them - otherwise they're cleaned up, since they can hold unsanitized data,
+	// and a resume starts over. A cancelled run is always cleaned up.
+	failRun := func(err error) error {
+		if ctx.Err() != nil {
+			args.Logger.LogWarning("Sync cancelled, cleaning up", nil)
+		}
+		if ctx.Err() != nil || journal == nil || !args.KeepFailedFiles {
+			cleanUpFailedRun()
+			_ = journal.Restart(err)
+			if journal != nil {
+				args.Logger.LogWarning(fmt.Sprintf("Generated files have been cleaned up, run 'lagoon-sync resume %v' to start this sync over", journal.RunId), nil)
+			}
+			return err
+		}
+		_ = journal.RecordError(err)
+		args.Logger.LogWarning(fmt.Sprintf("Generated files have been kept, run 'lagoon-sync resume %v' to continue this sync", journal.RunId), nil)
 		return err
 	}
 
-	if !args.SkipTargetImport {
-		err = SyncRunTargetCommand(args.TargetEnvironment, args.LagoonSyncer, args.DryRun, args.SshOptionWrapper)
+	if journal.HasCompleted(SyncPhaseSourceExport) {
+		args.Logger.LogProcessStep("Skipping export on source, already completed", args.SourceEnvironment.EnvironmentName)
+		skipPhase(SyncPhaseSourceExport, report, args.Logger)
+	} else {
+		endPhase := startPhase(SyncPhaseSourceExport, report, args.Logger)
+		err = runPhase(ctx, SyncPhaseSourceExport, args.PhasePolicies.GetPolicy(SyncPhaseSourceExport), args.Logger, func(ctx context.Context) error {
+			if err := runHooks(ctx, SyncHookPreSource, args.SourceEnvironment); err != nil {
+				return err
+			}
+			if err := SyncRunSourceCommand(ctx, args.SourceEnvironment, args.LagoonSyncer, args.DryRun, args.SshOptionWrapper, args.Logger); err != nil {
+				return err
+			}
+			return runHooks(ctx, SyncHookPostSource, args.SourceEnvironment)
+		})
+		endPhase(err)
 		if err != nil {
-			_ = PrerequisiteCleanUp(args.SourceEnvironment, sourceRsyncPath, args.DryRun, args.SshOptionWrapper)
-			_ = PrerequisiteCleanUp(args.TargetEnvironment, targetRsyncPath, args.DryRun, args.SshOptionWrapper)
-			_ = SyncCleanUp(args.SourceEnvironment, args.LagoonSyncer, args.DryRun, args.SshOptionWrapper)
-			_ = SyncCleanUp(args.TargetEnvironment, args.LagoonSyncer, args.DryRun, args.SshOptionWrapper)
+			// A partial export can't be reused, so it is cleaned up even when failed runs keep their files
+			if !args.SkipSourceCleanup {
+				syncCleanUp(args.SourceEnvironment)
+			}
+			_ = journal.RecordError(err)
 			return err
 		}
+		_ = journal.MarkCompleted(SyncPhaseSourceExport)
+	}
+
+	if args.LocalArchiveOnly == true {
+		return nil // we're done here
+	}
+
+	if journal.HasCompleted(SyncPhaseTransfer) {
+		args.Logger.LogProcessStep("Skipping transfer, already completed", nil)
+		skipPhase(SyncPhaseTransfer, report, args.Logger)
 	} else {
-		utils.LogProcessStep("Skipping target import step", nil)
+		endPhase := startPhase(SyncPhaseTransfer, report, args.Logger)
+		err = runPhase(ctx, SyncPhaseTransfer, args.PhasePolicies.GetPolicy(SyncPhaseTransfer), args.Logger, func(ctx context.Context) error {
+			if transferFileByFile {
+				return SyncRunIncrementalTransfer(ctx, args)
+			}
+			return SyncRunTransfer(ctx, args.SourceEnvironment, args.TargetEnvironment, args.LagoonSyncer, args.DryRun, args.SshOptionWrapper, args.Logger, report)
+		})
+		endPhase(err)
+		if err != nil {
+			return failRun(err)
+		}
+		_ = journal.MarkCompleted(SyncPhaseTransfer)
 	}
 
-	_ = PrerequisiteCleanUp(args.SourceEnvironment, sourceRsyncPath, args.DryRun, args.SshOptionWrapper)
-	_ = PrerequisiteCleanUp(args.TargetEnvironment, targetRsyncPath, args.DryRun, args.SshOptionWrapper)
+	if args.SkipTargetImport {
+		args.Logger.LogProcessStep("Skipping target import step", nil)
+		skipPhase(SyncPhaseTargetImport, report, args.Logger)
+	} else if journal.HasCompleted(SyncPhaseTargetImport) {
+		args.Logger.LogProcessStep("Skipping import on target, already completed", args.TargetEnvironment.EnvironmentName)
+		skipPhase(SyncPhaseTargetImport, report, args.Logger)
+	} else {
+		endPhase := startPhase(SyncPhaseTargetImport, report, args.Logger)
+		err = backupTarget()
+		if err == nil {
+			err = runPhase(ctx, SyncPhaseTargetImport, importPolicy, args.Logger, func(ctx context.Context) error {
+				if err := runHooks(ctx, SyncHookPreImport, args.TargetEnvironment); err != nil {
+					return err
+				}
+				if err := SyncRunTargetCommand(ctx, args.TargetEnvironment, args.LagoonSyncer, args.DryRun, args.SshOptionWrapper, args.Logger); err != nil {
+					return err
+				}
+				return runHooks(ctx, SyncHookPostImport, args.TargetEnvironment)
+			})
+		}
+		endPhase(err)
+		if err != nil {
+			return failRun(err)
+		}
+		_ = journal.MarkCompleted(SyncPhaseTargetImport)
+	}
+
+	endPhase := startPhase(SyncPhaseCleanup, report, args.Logger)
+	prerequisiteCleanUp(args.SourceEnvironment, sourceRsyncPath)
+	prerequisiteCleanUp(args.TargetEnvironment, targetRsyncPath)
 	if !args.SkipSourceCleanup {
-		_ = SyncCleanUp(args.SourceEnvironment, args.LagoonSyncer, args.DryRun, args.SshOptionWrapper)
+		syncCleanUp(args.SourceEnvironment)
 	}
 	if !args.SkipTargetCleanup {
-		_ = SyncCleanUp(args.TargetEnvironment, args.LagoonSyncer, args.DryRun, args.SshOptionWrapper)
+		syncCleanUp(args.TargetEnvironment)
 	} else {
-		utils.LogProcessStep("File on the target saved as: "+args.LagoonSyncer.GetTransferResource(args.TargetEnvironment).Name, nil)
+		args.Logger.LogProcessStep("File on the target saved as: "+args.LagoonSyncer.GetTransferResource(args.TargetEnvironment).Name, nil)
 	}
+	// Cleaning up is best effort, a failure to remove something doesn't fail the sync - but a failing hook does
+	err = runHooks(ctx, SyncHookPostCleanup, args.TargetEnvironment)
+	endPhase(err)
+	if err != nil {
+		_ = journal.RecordError(err)
+		return err
+	}
+	_ = journal.MarkCompleted(SyncPhaseCleanup)
 
 	return nil
 }
 
-func SyncRunSourceCommand(remoteEnvironment Environment, syncer Syncer, dryRun bool, sshOptionWrapper *SSHOptionWrapper) error {
+// sanitizeFailedImport applies the sanitize rules to whatever a failed import left in the target's database, so that
+// the data it got as far as importing isn't left there unsanitized
+func sanitizeFailedImport(ctx context.Context, args RunSyncProcessFunctionTypeArguments) {
+	command, err := renderCommand(args.LagoonSyncer.(Sanitizer).GetSanitizeCommand(args.TargetEnvironment))
+	if err == nil {
+		args.Logger.LogExecutionStep("The import failed, sanitizing what it imported with", command.loggedString)
+		err = runSyncCommand(ctx, args.TargetEnvironment, command, args.SshOptionWrapper, args.Logger)
+	}
+	if err != nil {
+		args.Logger.LogWarning(fmt.Sprintf("Unable to sanitize what the failed import left in %s's database, it may hold unsanitized data", args.TargetEnvironment.EnvironmentName), err.Error())
+	}
+}
+
+func SyncRunSourceCommand(ctx context.Context, remoteEnvironment Environment, syncer Syncer, dryRun bool, sshOptionWrapper *SSHOptionWrapper, logger *utils.Logger) error {
 
-	utils.LogProcessStep("Beginning export on source environment", remoteEnvironment.EnvironmentName)
+	logger.LogProcessStep("Beginning export on source environment", remoteEnvironment.EnvironmentName)
 
 	remoteCommands := syncer.GetRemoteCommand(remoteEnvironment)
 	for _, remoteCommand := range remoteCommands {
 		if remoteCommand.NoOp {
-			log.Printf("Found No Op for environment %s - skipping step", remoteEnvironment.EnvironmentName)
+			logger.LogProcessStep(fmt.Sprintf("Found No Op for environment %s - skipping step", remoteEnvironment.EnvironmentName), nil)
 			return nil
 		}
 
-		command, commandErr := remoteCommand.GetCommand()
+		command, commandErr := renderCommand(remoteCommand)
 		if commandErr != nil {
 			return commandErr
 		}
 
-		var execString string
-		execString = command
-
-		utils.LogExecutionStep("Running the following for source", execString)
+		logger.LogExecutionStep("Running the following for source", command.loggedString)
 
 		if !dryRun {
-
-			if remoteEnvironment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
-				err, outstring, errstring := utils.Shellout(execString)
-				if err != nil {
-					if errstring != "" {
-						utils.LogError(errstring, nil)
-					}
-					return err
-				}
-				utils.LogDebugInfo(outstring, nil)
-			} else {
-				sshOptions := sshOptionWrapper.GetSSHOptionsForEnvironment(remoteEnvironment.EnvironmentName)
-				err, output := utils.RemoteShellout(execString, remoteEnvironment.ServiceName, remoteEnvironment.GetOpenshiftProjectName(), sshOptions.Host, sshOptions.Port, sshOptions.PrivateKey, sshOptions.SkipAgent)
-				if err != nil {
-					utils.LogError(output, nil)
-					return err
-				} else {
-					utils.LogDebugInfo(output, nil)
-				}
+			if err := runSyncCommand(ctx, remoteEnvironment, command, sshOptionWrapper, logger); err != nil {
+				return err
 			}
 		}
 	}
@@ -148,171 +383,285 @@ func SyncRunSourceCommand(remoteEnvironment Environment, syncer Syncer, dryRun b
 	return nil
 }
 
-func SyncRunTransfer(sourceEnvironment Environment, targetEnvironment Environment, syncer Syncer, dryRun bool, sshOptionWrapper *SSHOptionWrapper) error {
-	utils.LogProcessStep("Beginning file transfer logic", nil)
+// renderedCommand is a command ready to be run, along with the form of it that's safe to log and the secrets it
+// refers to
+type renderedCommand struct {
+	execString   string
+	loggedString string
+	secrets      utils.CommandSecrets
+}
 
-	// TODO: This is going to be the trickiest of the ssh option calculations.
-	// We need to determine ssh endpoints for both environments separately
-	sshOptions := sshOptionWrapper.Default
+// plainCommand is a command that has nothing in it to keep out of the logs
+func plainCommand(command string) renderedCommand {
+	return renderedCommand{execString: command, loggedString: command}
+}
 
-	// If we're transferring to the same resource, we can skip this whole process.
-	if sourceEnvironment.EnvironmentName == targetEnvironment.EnvironmentName {
-		utils.LogDebugInfo("Source and target environments are the same, skipping transfer", nil)
-		return nil
+// renderCommand renders a command to be run, along with the form of it that's safe to log
+func renderCommand(command SyncCommand) (renderedCommand, error) {
+	execString, err := command.GetCommand()
+	if err != nil {
+		return renderedCommand{}, err
+	}
+	loggedString, err := command.GetRedactedCommand()
+	if err != nil {
+		return renderedCommand{}, err
+	}
+	return renderedCommand{execString: execString, loggedString: loggedString, secrets: command.GetSecrets()}, nil
+}
+
+// runSyncCommand runs one of a syncer's commands in the given environment, logging its output
+func runSyncCommand(ctx context.Context, environment Environment, command renderedCommand, sshOptionWrapper *SSHOptionWrapper, logger *utils.Logger) error {
+	started := time.Now()
+	var err error
+	if environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
+		var outstring, errstring string
+		err, outstring, errstring = utils.Shellout(ctx, command.execString, command.secrets)
+		if err != nil {
+			if errstring != "" {
+				logger.LogError(errstring, nil)
+			}
+		} else {
+			logger.LogDebugInfo(outstring, nil)
+		}
+	} else {
+		sshOptions := sshOptionWrapper.GetSSHOptionsForEnvironment(environment.EnvironmentName)
+		var output string
+		err, output = utils.RemoteShellout(ctx, command.execString, command.secrets, environment.ServiceName, environment.GetOpenshiftProjectName(), sshOptions.Host, sshOptions.Port, sshOptions.PrivateKey, sshOptions.SkipAgent, sshOptions.GetHostKeyVerification())
+		if err != nil {
+			logger.LogError(output, nil)
+		} else {
+			logger.LogDebugInfo(output, nil)
+		}
+	}
+	logCommandEvent(logger, environment, command.loggedString, started, err)
+	return err
+}
+
+func SyncRunTransfer(ctx context.Context, sourceEnvironment Environment, targetEnvironment Environment, syncer Syncer, dryRun bool, sshOptionWrapper *SSHOptionWrapper, logger *utils.Logger, report *SyncReport) error {
+	logger.LogProcessStep("Beginning file transfer logic", nil)
+	if skip, err := checkTransferEnvironments(sourceEnvironment, targetEnvironment, logger); skip || err != nil {
+		return err
+	}
+
+	targetResources := getTransferResources(syncer, targetEnvironment)
+	for i, sourceResource := range getTransferResources(syncer, sourceEnvironment) {
+		if err := runTransport(ctx, sourceEnvironment, targetEnvironment, sourceResource, targetResources[i], dryRun, sshOptionWrapper, logger, report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runTransport transfers a resource with the transport it's configured to use
+func runTransport(ctx context.Context, sourceEnvironment Environment, targetEnvironment Environment, sourceResource SyncerTransferResource, targetResource SyncerTransferResource, dryRun bool, sshOptionWrapper *SSHOptionWrapper, logger *utils.Logger, report *SyncReport) error {
+	transport, err := GetTransport(sourceResource.Transport)
+	if err != nil {
+		return err
+	}
+	logger.LogDebugInfo("Transferring using transport", transport.GetTransportId())
+
+	err = transport.Transfer(ctx, TransportArguments{
+		SourceEnvironment: sourceEnvironment,
+		TargetEnvironment: targetEnvironment,
+		SourceResource:    sourceResource,
+		TargetResource:    targetResource,
+		DryRun:            dryRun,
+		SshOptionWrapper:  sshOptionWrapper,
+		Logger:            logger,
+		Report:            report,
+	})
+	if err != nil {
+		return transferError(err)
 	}
+	return nil
+}
 
-	// For now, we assert that _one_ of the environments _has_ to be local
-	executeRsyncRemotelyOnTarget := false
-	if sourceEnvironment.EnvironmentName != LOCAL_ENVIRONMENT_NAME && targetEnvironment.EnvironmentName != LOCAL_ENVIRONMENT_NAME {
-		//TODO: if we have multiple remotes, we need to treat the target environment as local, and run the rysync from there ...
-		utils.LogWarning(fmt.Sprintf("Using %s syncer for remote to remote transfer is experimental at present", viper.Get("syncer-type")), nil)
-		utils.LogDebugInfo("Since we're syncing across two remote systems, we're pulling the files to the target", targetEnvironment.EnvironmentName)
-		executeRsyncRemotelyOnTarget = true
+// checkTransferEnvironments checks there's something to transfer between the environments, returning true if the
+// transfer can be skipped because they're the same
+func checkTransferEnvironments(sourceEnvironment Environment, targetEnvironment Environment, logger *utils.Logger) (bool, error) {
+	// If we're transferring to the same resource, we can skip this whole process.
+	if sourceEnvironment.EnvironmentName == targetEnvironment.EnvironmentName {
+		logger.LogDebugInfo("Source and target environments are the same, skipping transfer", nil)
+		return true, nil
 	}
 
 	if sourceEnvironment.EnvironmentName == LOCAL_ENVIRONMENT_NAME && targetEnvironment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
-		utils.LogFatalError("In order to rsync, at least _one_ of the environments must be remote", nil)
+		return false, fmt.Errorf("%w: in order to rsync, at least _one_ of the environments must be remote", ErrConfigInvalid)
 	}
+	return false, nil
+}
 
-	sourceEnvironmentName := syncer.GetTransferResource(sourceEnvironment).Name
-	if syncer.GetTransferResource(sourceEnvironment).IsDirectory == true {
-		sourceEnvironmentName += "/"
+// transferError marks a failed transfer with ErrTransferFailed, leaving cancellations as they are
+func transferError(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
 	}
+	return fmt.Errorf("%w: %w", ErrTransferFailed, err)
+}
 
-	// lagoonRsyncService keeps track of precisely where we're going to be rsyncing from.
-	lagoonRsyncService := "cli"
-	// rsyncRemoteSystemUsername is used by the rsync command to set up the ssh tunnel
-	rsyncRemoteSystemUsername := ""
+// isRemoteToRemoteSync reports whether neither end of a sync is the environment lagoon-sync is running in
+func isRemoteToRemoteSync(sourceEnvironment Environment, targetEnvironment Environment) bool {
+	return sourceEnvironment.EnvironmentName != LOCAL_ENVIRONMENT_NAME && targetEnvironment.EnvironmentName != LOCAL_ENVIRONMENT_NAME
+}
 
-	if sourceEnvironment.EnvironmentName != LOCAL_ENVIRONMENT_NAME {
-		//sourceEnvironmentName = fmt.Sprintf("%s@ssh.lagoon.amazeeio.cloud:%s", sourceEnvironment.GetOpenshiftProjectName(), sourceEnvironmentName)
-		sourceEnvironmentName = fmt.Sprintf(":%s", sourceEnvironmentName)
-		rsyncRemoteSystemUsername = sourceEnvironment.GetOpenshiftProjectName()
-		if sourceEnvironment.ServiceName != "" {
-			lagoonRsyncService = sourceEnvironment.ServiceName
-		}
+// SyncRunStreamingTransfer pipes the stdout of the syncer's source stream command into the stdin of its target stream
+// command. Remote commands are run over ssh sessions, so the data is relayed through the process running lagoon-sync.
+func SyncRunStreamingTransfer(ctx context.Context, sourceEnvironment Environment, targetEnvironment Environment, syncer Syncer, dryRun bool, sshOptionWrapper *SSHOptionWrapper, logger *utils.Logger, report *SyncReport) error {
+	logger.LogProcessStep("Beginning streaming transfer", nil)
+
+	sourceCommand := syncer.GetRemoteStreamCommand(sourceEnvironment)
+	targetCommand := syncer.GetLocalStreamCommand(targetEnvironment)
+	if sourceCommand.NoOp || targetCommand.NoOp {
+		return errors.New("This syncer does not support streaming transfers")
 	}
 
-	targetEnvironmentName := syncer.GetTransferResource(targetEnvironment).Name
-	if targetEnvironment.EnvironmentName != LOCAL_ENVIRONMENT_NAME && executeRsyncRemotelyOnTarget == false {
-		//targetEnvironmentName = fmt.Sprintf("%s@ssh.lagoon.amazeeio.cloud:%s", targetEnvironment.GetOpenshiftProjectName(), targetEnvironmentName)
-		targetEnvironmentName = fmt.Sprintf(":%s", targetEnvironmentName)
-		rsyncRemoteSystemUsername = targetEnvironment.GetOpenshiftProjectName()
-		if targetEnvironment.ServiceName != "" {
-			lagoonRsyncService = targetEnvironment.ServiceName
-		}
+	renderedSource, err := renderCommand(sourceCommand)
+	if err != nil {
+		return err
+	}
+	renderedTarget, err := renderCommand(targetCommand)
+	if err != nil {
+		return err
 	}
 
-	syncExcludes := " "
-	for _, e := range syncer.GetTransferResource(sourceEnvironment).ExcludeResources {
-		syncExcludes += fmt.Sprintf("--exclude=%v ", e)
+	logger.LogExecutionStep(fmt.Sprintf("Streaming the output of the following from source (%s)", sourceEnvironment.EnvironmentName), renderedSource.loggedString)
+	logger.LogExecutionStep(fmt.Sprintf("Into the following on target (%s)", targetEnvironment.EnvironmentName), renderedTarget.loggedString)
+
+	if dryRun {
+		return nil
 	}
 
-	var sshOptionsStr bytes.Buffer
-	verboseFlag := ""
-	if sshOptions.Verbose {
-		verboseFlag = "-v"
-		sshOptionsStr.WriteString(" -v")
+	bytesTransferred, err := pipeStreamCommands(ctx, sourceEnvironment, renderedSource, targetEnvironment, renderedTarget, sshOptionWrapper, logger)
+	logger.LogEvent("transfer", transferEventFields{
+		Transport:        "stream",
+		Source:           sourceEnvironment.EnvironmentName,
+		Target:           targetEnvironment.EnvironmentName,
+		BytesTransferred: bytesTransferred,
+	})
+	report.recordTransfer(0, bytesTransferred)
+	if err != nil {
+		return transferError(err)
 	}
+	return nil
+}
 
-	if sshOptions.PrivateKey != "" {
-		sshOptionsStr.WriteString(fmt.Sprintf(" -i %s", sshOptions.PrivateKey))
+// pipeStreamCommands runs the source command and pipes its stdout into the stdin of the target command, returning
+// the number of bytes that were piped across. When the target fails, the source is stopped, and its failure to
+// write into the closed pipe isn't what's reported - the target's is.
+func pipeStreamCommands(ctx context.Context, sourceEnvironment Environment, sourceCommand renderedCommand, targetEnvironment Environment, targetCommand renderedCommand, sshOptionWrapper *SSHOptionWrapper, logger *utils.Logger) (int64, error) {
+	pipeReader, pipeWriter := io.Pipe()
+	counter := &countingWriter{writer: pipeWriter}
+	sourceCtx, stopSource := context.WithCancel(ctx)
+	defer stopSource()
+
+	sourceErr := make(chan error, 1)
+	go func() {
+		err := runStreamCommand(sourceCtx, sourceEnvironment, sourceCommand, nil, counter, sshOptionWrapper, logger)
+		// Closing the writer signals EOF (or the error) to the target's stdin
+		pipeWriter.CloseWithError(err)
+		sourceErr <- err
+	}()
+
+	targetErr := runStreamCommand(ctx, targetEnvironment, targetCommand, pipeReader, nil, sshOptionWrapper, logger)
+	// If the target stops reading early, this unblocks the source rather than leaving it writing into the void
+	pipeReader.Close()
+	if targetErr != nil {
+		stopSource()
 	}
 
-	sourceEnvSshOptions := sshOptionWrapper.GetSSHOptionsForEnvironment(targetEnvironment.EnvironmentName)
-	rsyncArgs := sshOptions.RsyncArgs
+	err := <-sourceErr
+	// The source only stopped because the target did if its writes hit the closed pipe, or it was stopped here
+	stoppedByTarget := targetErr != nil && (errors.Is(counter.err, io.ErrClosedPipe) || (errors.Is(err, context.Canceled) && ctx.Err() == nil))
+	if err != nil && !stoppedByTarget {
+		return counter.written, fmt.Errorf("streaming export on %s failed: %w", sourceEnvironment.EnvironmentName, err)
+	}
+	if targetErr != nil {
+		return counter.written, fmt.Errorf("streaming import on %s failed: %w", targetEnvironment.EnvironmentName, targetErr)
+	}
 
-	execString := fmt.Sprintf("%s %s --rsync-path=%s %s -e \"ssh%s -o LogLevel=FATAL -o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no -p %s -l %s %s service=%s\" %s %s %s",
-		targetEnvironment.RsyncPath,
-		rsyncArgs,
-		sourceEnvironment.RsyncPath,
-		verboseFlag,
-		sshOptionsStr.String(),
-		sourceEnvSshOptions.Port,
-		rsyncRemoteSystemUsername,
-		sourceEnvSshOptions.Host,
-		lagoonRsyncService,
-		syncExcludes,
-		sourceEnvironmentName,
-		targetEnvironmentName)
+	return counter.written, nil
+}
 
-	utils.LogExecutionStep(fmt.Sprintf("Running the following for target (%s)", targetEnvironment.EnvironmentName), execString)
+// countingWriter counts the bytes written through it, and keeps the first error writing them
+type countingWriter struct {
+	writer  io.Writer
+	written int64
+	err     error
+}
 
-	if !dryRun {
-		if executeRsyncRemotelyOnTarget {
-			TargetEnvSshOptions := sshOptionWrapper.GetSSHOptionsForEnvironment(targetEnvironmentName)
-			err, output := utils.RemoteShellout(execString, targetEnvironment.ServiceName, targetEnvironment.GetOpenshiftProjectName(), TargetEnvSshOptions.Host, TargetEnvSshOptions.Port, TargetEnvSshOptions.PrivateKey, TargetEnvSshOptions.SkipAgent)
-			utils.LogDebugInfo(output, nil)
-			if err != nil {
-				utils.LogFatalError("Unable to exec remote command: "+err.Error(), nil)
-				return err
-			}
-		} else {
-			if err, _, errstring := utils.Shellout(execString); err != nil {
-				utils.LogFatalError(errstring, nil)
-				return err
-			}
-		}
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.written += int64(n)
+	if err != nil && w.err == nil {
+		w.err = err
 	}
+	return n, err
+}
+
+// supportsStreaming reports whether the syncer provides commands for both ends of a streaming transfer
+func supportsStreaming(syncer Syncer, sourceEnvironment Environment, targetEnvironment Environment) bool {
+	return !syncer.GetRemoteStreamCommand(sourceEnvironment).NoOp && !syncer.GetLocalStreamCommand(targetEnvironment).NoOp
+}
 
+// runStreamCommand runs a command in the given environment with stdin and stdout attached
+func runStreamCommand(ctx context.Context, environment Environment, command renderedCommand, stdin io.Reader, stdout io.Writer, sshOptionWrapper *SSHOptionWrapper, logger *utils.Logger) error {
+	started := time.Now()
+	var err error
+	var errstring string
+	if environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
+		err, errstring = utils.StreamShellout(ctx, command.execString, command.secrets, stdin, stdout)
+	} else {
+		sshOptions := sshOptionWrapper.GetSSHOptionsForEnvironment(environment.EnvironmentName)
+		err, errstring = utils.RemoteStreamShellout(ctx, command.execString, command.secrets, environment.ServiceName, environment.GetOpenshiftProjectName(), sshOptions.Host, sshOptions.Port, sshOptions.PrivateKey, sshOptions.SkipAgent, sshOptions.GetHostKeyVerification(), stdin, stdout)
+	}
+	logCommandEvent(logger, environment, command.loggedString, started, err)
+	if err != nil {
+		if errstring != "" {
+			logger.LogError(errstring, nil)
+		}
+		return err
+	}
+	logger.LogDebugInfo(errstring, nil)
 	return nil
 }
 
-func SyncRunTargetCommand(targetEnvironment Environment, syncer Syncer, dryRun bool, sshOptionWrapper *SSHOptionWrapper) error {
+func SyncRunTargetCommand(ctx context.Context, targetEnvironment Environment, syncer Syncer, dryRun bool, sshOptionWrapper *SSHOptionWrapper, logger *utils.Logger) error {
 
-	utils.LogProcessStep("Beginning import on target environment", targetEnvironment.EnvironmentName)
+	logger.LogProcessStep("Beginning import on target environment", targetEnvironment.EnvironmentName)
 
 	targetCommands := syncer.GetLocalCommand(targetEnvironment)
 
 	for _, targetCommand := range targetCommands {
 		if targetCommand.NoOp {
-			log.Printf("Found No Op for environment %s - skipping step", targetEnvironment.EnvironmentName)
+			logger.LogProcessStep(fmt.Sprintf("Found No Op for environment %s - skipping step", targetEnvironment.EnvironmentName), nil)
 			return nil
 		}
 
-		var execString string
-		tcomm, commandErr := targetCommand.GetCommand()
+		command, commandErr := renderCommand(targetCommand)
 		if commandErr != nil {
 			return commandErr
 		}
-		execString = tcomm
 
-		utils.LogExecutionStep(fmt.Sprintf("Running the following for target (%s)", targetEnvironment.EnvironmentName), execString)
+		logger.LogExecutionStep(fmt.Sprintf("Running the following for target (%s)", targetEnvironment.EnvironmentName), command.loggedString)
 		if !dryRun {
-			if targetEnvironment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
-				err, outstring, errstring := utils.Shellout(execString)
-				if err != nil {
-					if errstring != "" {
-						utils.LogError(errstring, nil)
-					}
-					return err
-				}
-				utils.LogDebugInfo(outstring, nil)
-			} else {
-				sshOptions := sshOptionWrapper.GetSSHOptionsForEnvironment(targetEnvironment.EnvironmentName)
-				err, output := utils.RemoteShellout(execString, targetEnvironment.ServiceName, targetEnvironment.GetOpenshiftProjectName(), sshOptions.Host, sshOptions.Port, sshOptions.PrivateKey, sshOptions.SkipAgent)
-				if err != nil {
-					utils.LogError(output, nil)
-					return err
-				} else {
-					utils.LogDebugInfo(output, nil)
-				}
+			if err := runSyncCommand(ctx, targetEnvironment, command, sshOptionWrapper, logger); err != nil {
+				return err
 			}
 		}
 	}
 	return nil
 }
 
-func SyncCleanUp(environment Environment, syncer Syncer, dryRun bool, sshOptionWrapper *SSHOptionWrapper) error {
+func SyncCleanUp(ctx context.Context, environment Environment, syncer Syncer, dryRun bool, sshOptionWrapper *SSHOptionWrapper, logger *utils.Logger) error {
 	transferResouce := syncer.GetTransferResource(environment)
 
 	sshOptions := sshOptionWrapper.GetSSHOptionsForEnvironment(environment.EnvironmentName)
 
 	if transferResouce.SkipCleanup == true {
-		log.Printf("Skipping cleanup for %v on %v environment", transferResouce.Name, environment.EnvironmentName)
+		logger.LogProcessStep(fmt.Sprintf("Skipping cleanup for %v on %v environment", transferResouce.Name, environment.EnvironmentName), nil)
 		return nil
 	}
-	utils.LogProcessStep("Beginning resource cleanup on", environment.EnvironmentName)
+	logger.LogProcessStep("Beginning resource cleanup on", environment.EnvironmentName)
 
 	filesToCleanUp := syncer.GetFilesToCleanup(environment)
 
@@ -320,19 +669,23 @@ func SyncCleanUp(environment Environment, syncer Syncer, dryRun bool, sshOptionW
 		transferResourceName := fileToCleanup
 		execString := fmt.Sprintf("rm -r %s || true", transferResourceName)
 
-		utils.LogExecutionStep("Running the following", execString)
+		logger.LogExecutionStep("Running the following", execString)
 		if !dryRun {
 			if environment.EnvironmentName != LOCAL_ENVIRONMENT_NAME {
-				err, output := utils.RemoteShellout(execString, environment.ServiceName, environment.GetOpenshiftProjectName(), sshOptions.Host, sshOptions.Port, sshOptions.PrivateKey, sshOptions.SkipAgent)
-				utils.LogDebugInfo(output, nil)
+				started := time.Now()
+				err, output := utils.RemoteShellout(ctx, execString, nil, environment.ServiceName, environment.GetOpenshiftProjectName(), sshOptions.Host, sshOptions.Port, sshOptions.PrivateKey, sshOptions.SkipAgent, sshOptions.GetHostKeyVerification())
+				logger.LogDebugInfo(output, nil)
+				logCommandEvent(logger, environment, execString, started, err)
 				if err != nil {
-					utils.LogFatalError("Unable to exec remote command: "+err.Error(), nil)
+					logger.LogError("Unable to exec remote command: "+err.Error(), nil)
 					return err
 				}
 			}
-			err, _, errstring := utils.Shellout(execString)
+			started := time.Now()
+			err, _, errstring := utils.Shellout(ctx, execString, nil)
+			logCommandEvent(logger, Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME}, execString, started, err)
 			if err != nil {
-				utils.LogFatalError(errstring, nil)
+				logger.LogError(errstring, nil)
 				return err
 			}
 		}
@@ -355,7 +708,38 @@ func generateSyncCommand(commandString string, substitutions map[string]interfac
 	}
 }
 
+// GetCommand renders the command that's run. Sensitive substitutions are rendered as references to the shell
+// variables GetSecrets passes them in, so that they never appear in the command's arguments.
 func (c SyncCommand) GetCommand() (string, error) {
+	return c.render(false)
+}
+
+// GetSecrets returns the values of the command's sensitive substitutions, by the name of the variable each is
+// referred to by in the command
+func (c SyncCommand) GetSecrets() utils.CommandSecrets {
+	var secrets utils.CommandSecrets
+	for key, value := range c.substitutions {
+		if sensitive, ok := value.(SensitiveValue); ok {
+			if secrets == nil {
+				secrets = utils.CommandSecrets{}
+			}
+			secrets[secretVariable(key)] = string(sensitive)
+		}
+	}
+	return secrets
+}
+
+// secretVariable is the name of the shell variable a sensitive substitution is passed to its command in
+func secretVariable(key string) string {
+	return "LAGOON_SYNC_SECRET_" + strings.ToUpper(key)
+}
+
+// GetRedactedCommand renders the command with any sensitive substitutions replaced by "****", for logging
+func (c SyncCommand) GetRedactedCommand() (string, error) {
+	return c.render(true)
+}
+
+func (c SyncCommand) render(redact bool) (string, error) {
 	if c.NoOp == true {
 		return "", errors.New("The command is marked as NoOp(eration) and does not generate a string")
 	}
@@ -363,8 +747,18 @@ func (c SyncCommand) GetCommand() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	substitutions := make(map[string]interface{}, len(c.substitutions))
+	for key, value := range c.substitutions {
+		if _, ok := value.(SensitiveValue); ok {
+			value = "${" + secretVariable(key) + "}"
+			if redact {
+				value = redactedValue
+			}
+		}
+		substitutions[key] = value
+	}
 	var output bytes.Buffer
-	if err := templ.Execute(&output, c.substitutions); err != nil {
+	if err := templ.Execute(&output, substitutions); err != nil {
 		return "", err
 	}
 	return output.String(), nil
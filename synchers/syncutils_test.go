@@ -1,6 +1,15 @@
 package synchers
 
-import "testing"
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uselagoon/lagoon-sync/utils"
+)
 
 func TestSyncCommand_GetCommand(t *testing.T) {
 	type fields struct {
@@ -64,3 +73,144 @@ func TestSyncCommand_GetCommand(t *testing.T) {
 		})
 	}
 }
+
+func TestSyncCommand_GetRedactedCommand(t *testing.T) {
+	tests := []struct {
+		name          string
+		command       string
+		substitutions map[string]interface{}
+		wantCommand   string
+		wantSecrets   utils.CommandSecrets
+		wantRedacted  string
+	}{
+		{
+			name:          "No sensitive substitutions",
+			command:       "mysql -h{{ .hostname }}",
+			substitutions: map[string]interface{}{"hostname": "mariadb"},
+			wantCommand:   "mysql -hmariadb",
+			wantRedacted:  "mysql -hmariadb",
+		},
+		{
+			name:    "Sensitive substitution",
+			command: `MYSQL_PWD="{{ .password }}" mysql -h{{ .hostname }}`,
+			substitutions: map[string]interface{}{
+				"hostname": "mariadb",
+				"password": SensitiveValue("s3cret"),
+			},
+			wantCommand:  `MYSQL_PWD="${LAGOON_SYNC_SECRET_PASSWORD}" mysql -hmariadb`,
+			wantSecrets:  utils.CommandSecrets{"LAGOON_SYNC_SECRET_PASSWORD": "s3cret"},
+			wantRedacted: `MYSQL_PWD="****" mysql -hmariadb`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := generateSyncCommand(tt.command, tt.substitutions)
+			got, err := c.GetCommand()
+			if err != nil || got != tt.wantCommand {
+				t.Errorf("GetCommand() = %v, %v, want %v", got, err, tt.wantCommand)
+			}
+			if secrets := c.GetSecrets(); !reflect.DeepEqual(secrets, tt.wantSecrets) {
+				t.Errorf("GetSecrets() = %v, want %v", secrets, tt.wantSecrets)
+			}
+			got, err = c.GetRedactedCommand()
+			if err != nil || got != tt.wantRedacted {
+				t.Errorf("GetRedactedCommand() = %v, %v, want %v", got, err, tt.wantRedacted)
+			}
+		})
+	}
+}
+
+func TestRunSyncCommand_PassesSecretsOutsideTheCommand(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "password")
+	command, err := renderCommand(generateSyncCommand(`printf %s "{{ .password }}" > {{ .output }}`, map[string]interface{}{
+		"password": SensitiveValue("s3cret"),
+		"output":   output,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(command.execString, "s3cret") || strings.Contains(command.loggedString, "s3cret") {
+		t.Fatalf("renderCommand() put the password in the command: %q", command.execString)
+	}
+
+	if err := runSyncCommand(context.Background(), Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME}, command, &SSHOptionWrapper{}, nil); err != nil {
+		t.Fatalf("runSyncCommand() error = %v", err)
+	}
+	got, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "s3cret" {
+		t.Errorf("the command saw the password as %q, want %q", got, "s3cret")
+	}
+}
+
+// streamTestSyncer only provides the stream commands, which is all SyncRunStreamingTransfer needs
+type streamTestSyncer struct {
+	Syncer
+	source string
+	target string
+}
+
+func (s streamTestSyncer) GetRemoteStreamCommand(environment Environment) SyncCommand {
+	return generateSyncCommand(s.source, nil)
+}
+
+func (s streamTestSyncer) GetLocalStreamCommand(environment Environment) SyncCommand {
+	return generateSyncCommand(s.target, nil)
+}
+
+func TestSyncRunStreamingTransfer(t *testing.T) {
+	outputFile := filepath.Join(t.TempDir(), "streamed")
+	local := Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME}
+
+	tests := []struct {
+		name    string
+		syncer  streamTestSyncer
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "Source output is piped into target",
+			syncer: streamTestSyncer{source: "printf 'streamed data'", target: "cat > " + outputFile},
+			want:   "streamed data",
+		},
+		{
+			name:    "Failing source is reported",
+			syncer:  streamTestSyncer{source: "printf partial; exit 3", target: "cat > " + outputFile},
+			want:    "partial",
+			wantErr: "streaming export on local failed",
+		},
+		{
+			name:    "Failing target is reported",
+			syncer:  streamTestSyncer{source: "printf 'streamed data'", target: "cat > /dev/null; exit 4"},
+			wantErr: "streaming import on local failed",
+		},
+		{
+			// The source is killed by SIGPIPE once the target stops reading, which isn't the failure to report
+			name:    "Target that stops reading part way is reported rather than the source it stops",
+			syncer:  streamTestSyncer{source: "head -c 10000000 /dev/zero; exit $?", target: "head -c 10 > /dev/null; exit 3"},
+			wantErr: "streaming import on local failed: exit status 3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(outputFile)
+			err := SyncRunStreamingTransfer(context.Background(), local, local, tt.syncer, false, &SSHOptionWrapper{}, nil, nil)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("SyncRunStreamingTransfer() error = %v, want %v", err, tt.wantErr)
+				return
+			}
+			if tt.want == "" {
+				return
+			}
+			got, err := os.ReadFile(outputFile)
+			if err != nil {
+				t.Fatalf("Unable to read streamed output: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("SyncRunStreamingTransfer() streamed = %v, want %v", string(got), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package synchers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/uselagoon/lagoon-sync/utils"
+)
+
+/**
+* Transports move a syncer's transfer resource from the source environment to the target environment.
+*
+* Much like syncers, transports register themselves here, and are selected per syncer with the `transport` key
+* in the syncer's configuration. If none is given, the rsync transport is used.
+ */
+
+const defaultTransportId = "rsync"
+
+// transportMap maps transport identifiers (eg. "rsync", "sftp") to their implementations
+var transportMap = map[string]Transport{}
+
+// TransportArguments describes a single transfer
+type TransportArguments struct {
+	SourceEnvironment Environment
+	TargetEnvironment Environment
+	SourceResource    SyncerTransferResource
+	TargetResource    SyncerTransferResource
+	Files             []string // if not nil, only these files, relative to the source directory, are transferred
+	DryRun            bool
+	SshOptionWrapper  *SSHOptionWrapper
+	Logger            *utils.Logger
+	Report            *SyncReport // if set, the amount transferred is added to it
+}
+
+type Transport interface {
+	// GetTransportId returns the name used to select this transport in configuration
+	GetTransportId() string
+	// Transfer copies the source resource to the target resource, stopping if the context is cancelled
+	Transfer(ctx context.Context, args TransportArguments) error
+}
+
+func RegisterTransport(transport Transport) {
+	transportMap[transport.GetTransportId()] = transport
+}
+
+// GetTransport returns the transport registered under the given id, or the default transport if the id is empty
+func GetTransport(transportId string) (Transport, error) {
+	if transportId == "" {
+		transportId = defaultTransportId
+	}
+	transport, exists := transportMap[transportId]
+	if !exists {
+		return nil, fmt.Errorf("%w: transport '%s' not registered, available transports are %v", ErrConfigInvalid, transportId, GetTransportIds())
+	}
+	return transport, nil
+}
+
+// GetTransportIds lists the registered transports
+func GetTransportIds() []string {
+	var ids []string
+	for id := range transportMap {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
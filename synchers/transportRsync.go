@@ -0,0 +1,203 @@
+package synchers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uselagoon/lagoon-sync/utils"
+)
+
+// RsyncTransport transfers resources with rsync over ssh
+type RsyncTransport struct{}
+
+func (t RsyncTransport) GetTransportId() string {
+	return "rsync"
+}
+
+func (t RsyncTransport) Transfer(ctx context.Context, args TransportArguments) error {
+	// rsync has to be available on both ends - if it isn't, we fall back to copying over sftp rather than
+	// trying to install it, or streaming with ssh-cat where sftp can't reach the service
+	if !args.DryRun {
+		for _, environment := range []Environment{args.SourceEnvironment, args.TargetEnvironment} {
+			if !isRsyncAvailable(ctx, environment, args.SshOptionWrapper) {
+				if !sftpReachesService(args.SourceEnvironment) || !sftpReachesService(args.TargetEnvironment) {
+					args.Logger.LogWarning(fmt.Sprintf("rsync isn't available on %s, falling back to ssh-cat", environment.EnvironmentName), nil)
+					return SshCatTransport{}.Transfer(ctx, args)
+				}
+				args.Logger.LogWarning(fmt.Sprintf("rsync isn't available on %s, falling back to sftp", environment.EnvironmentName), nil)
+				return SftpTransport{}.Transfer(ctx, args)
+			}
+		}
+	}
+
+	// When both environments are remote, the rsync is run on the target, which pulls the files from the source.
+	// Otherwise, rsync runs locally and connects to whichever environment is remote.
+	executeRsyncRemotelyOnTarget := isRemoteToRemoteSync(args.SourceEnvironment, args.TargetEnvironment)
+	if executeRsyncRemotelyOnTarget {
+		args.Logger.LogDebugInfo("Since we're syncing across two remote systems, we're pulling the files to the target", args.TargetEnvironment.EnvironmentName)
+	}
+
+	// The ssh endpoint that rsync connects to is always that of the remote end of the transfer
+	remoteEnvironment := args.SourceEnvironment
+	if args.SourceEnvironment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
+		remoteEnvironment = args.TargetEnvironment
+	}
+	rsyncSshOptions := args.SshOptionWrapper.GetSSHOptionsForEnvironment(remoteEnvironment.EnvironmentName)
+
+	sourceEnvironmentName := args.SourceResource.Name
+	if args.SourceResource.IsDirectory == true {
+		sourceEnvironmentName += "/"
+	}
+	if args.SourceEnvironment.EnvironmentName != LOCAL_ENVIRONMENT_NAME {
+		sourceEnvironmentName = fmt.Sprintf(":%s", sourceEnvironmentName)
+	}
+
+	targetEnvironmentName := args.TargetResource.Name
+	if args.TargetEnvironment.EnvironmentName != LOCAL_ENVIRONMENT_NAME && executeRsyncRemotelyOnTarget == false {
+		targetEnvironmentName = fmt.Sprintf(":%s", targetEnvironmentName)
+	}
+
+	// lagoonRsyncService keeps track of precisely where we're going to be rsyncing from.
+	lagoonRsyncService := defaultServiceName
+	if remoteEnvironment.ServiceName != "" {
+		lagoonRsyncService = remoteEnvironment.ServiceName
+	}
+
+	syncExcludes := " "
+	for _, e := range args.SourceResource.ExcludeResources {
+		syncExcludes += fmt.Sprintf("--exclude=%v ", e)
+	}
+
+	var sshOptionsStr bytes.Buffer
+	verboseFlag := ""
+	if rsyncSshOptions.Verbose {
+		verboseFlag = "-v"
+		sshOptionsStr.WriteString(" -v")
+	}
+
+	// A private key path only makes sense on the machine running lagoon-sync - when rsync is run on the target
+	// environment, it has to rely on the target's own ssh key.
+	if rsyncSshOptions.PrivateKey != "" && !executeRsyncRemotelyOnTarget {
+		sshOptionsStr.WriteString(fmt.Sprintf(" -i %s", rsyncSshOptions.PrivateKey))
+	}
+
+	// The target environment can't see our known_hosts file, so when rsync runs there it's handed the key we verify here
+	var hostKeyOptions, knownHostsPrefix string
+	var err error
+	if executeRsyncRemotelyOnTarget {
+		knownHostsPrefix, hostKeyOptions, err = rsyncSshOptions.GetHostKeyVerification().RemoteSshCommandOptions(rsyncSshOptions.Host, rsyncSshOptions.Port, "/tmp/lagoon-sync-known-hosts")
+	} else {
+		hostKeyOptions, err = rsyncSshOptions.GetHostKeyVerification().SshCommandOptions(rsyncSshOptions.Host, rsyncSshOptions.Port)
+	}
+	if err != nil {
+		return err
+	}
+
+	// A selection of files is handed to rsync on its stdin
+	rsyncArgs := rsyncSshOptions.RsyncArgs
+	if args.Files != nil {
+		rsyncArgs += " --files-from=-"
+	}
+
+	// --stats gets rsync to report how much it transferred
+	execString := fmt.Sprintf("%s%s --stats %s --rsync-path=%s %s -e \"ssh%s -o LogLevel=FATAL %s -p %s -l %s %s service=%s\" %s %s %s",
+		knownHostsPrefix,
+		args.TargetEnvironment.RsyncPath,
+		rsyncArgs,
+		args.SourceEnvironment.RsyncPath,
+		verboseFlag,
+		sshOptionsStr.String(),
+		hostKeyOptions,
+		rsyncSshOptions.Port,
+		remoteEnvironment.GetOpenshiftProjectName(),
+		rsyncSshOptions.Host,
+		lagoonRsyncService,
+		syncExcludes,
+		sourceEnvironmentName,
+		targetEnvironmentName)
+
+	args.Logger.LogExecutionStep(fmt.Sprintf("Running the following for target (%s)", args.TargetEnvironment.EnvironmentName), execString)
+
+	if !args.DryRun {
+		started := time.Now()
+		var output string
+		if args.Files != nil {
+			rsyncEnvironment := Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME}
+			if executeRsyncRemotelyOnTarget {
+				rsyncEnvironment = args.TargetEnvironment
+			}
+			var stdout bytes.Buffer
+			err = runStreamCommand(ctx, rsyncEnvironment, plainCommand(execString), fileListReader(args.Files), &stdout, args.SshOptionWrapper, args.Logger)
+			output = stdout.String()
+			args.Logger.LogDebugInfo(output, nil)
+			if err != nil {
+				return err
+			}
+		} else if executeRsyncRemotelyOnTarget {
+			targetEnvSshOptions := args.SshOptionWrapper.GetSSHOptionsForEnvironment(args.TargetEnvironment.EnvironmentName)
+			err, output = utils.RemoteShellout(ctx, execString, nil, args.TargetEnvironment.ServiceName, args.TargetEnvironment.GetOpenshiftProjectName(), targetEnvSshOptions.Host, targetEnvSshOptions.Port, targetEnvSshOptions.PrivateKey, targetEnvSshOptions.SkipAgent, targetEnvSshOptions.GetHostKeyVerification())
+			args.Logger.LogDebugInfo(output, nil)
+			logCommandEvent(args.Logger, args.TargetEnvironment, execString, started, err)
+			if err != nil {
+				args.Logger.LogError("Unable to exec remote command: "+err.Error(), nil)
+				return err
+			}
+		} else {
+			var errstring string
+			err, output, errstring = utils.Shellout(ctx, execString, nil)
+			logCommandEvent(args.Logger, Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME}, execString, started, err)
+			if err != nil {
+				args.Logger.LogError(errstring, nil)
+				return err
+			}
+			args.Logger.LogDebugInfo(output, nil)
+		}
+		filesTransferred, bytesTransferred := parseRsyncStats(output)
+		recordTransfer(t, args, filesTransferred, bytesTransferred)
+	}
+
+	return nil
+}
+
+var rsyncFilesTransferred = regexp.MustCompile(`Number of (?:regular )?files transferred: ([\d,.]+)`)
+var rsyncBytesTransferred = regexp.MustCompile(`Total transferred file size: ([\d,.]+) bytes`)
+
+// parseRsyncStats reads the number of files and bytes transferred from the output of rsync --stats
+func parseRsyncStats(output string) (int, int64) {
+	var filesTransferred int
+	var bytesTransferred int64
+	if match := rsyncFilesTransferred.FindStringSubmatch(output); match != nil {
+		filesTransferred, _ = strconv.Atoi(stripNumberSeparators(match[1]))
+	}
+	if match := rsyncBytesTransferred.FindStringSubmatch(output); match != nil {
+		bytesTransferred, _ = strconv.ParseInt(stripNumberSeparators(match[1]), 10, 64)
+	}
+	return filesTransferred, bytesTransferred
+}
+
+// stripNumberSeparators removes the thousands separators rsync puts in numbers, which depend on the locale
+func stripNumberSeparators(number string) string {
+	return strings.NewReplacer(",", "", ".", "").Replace(number)
+}
+
+// isRsyncAvailable checks whether the rsync binary can be found in the given environment
+func isRsyncAvailable(ctx context.Context, environment Environment, sshOptionWrapper *SSHOptionWrapper) bool {
+	if environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
+		_, err := exec.LookPath("rsync")
+		return err == nil
+	}
+
+	sshOptions := sshOptionWrapper.GetSSHOptionsForEnvironment(environment.EnvironmentName)
+	err, _ := utils.RemoteShellout(ctx, "command -v rsync", nil, environment.ServiceName, environment.GetOpenshiftProjectName(), sshOptions.Host, sshOptions.Port, sshOptions.PrivateKey, sshOptions.SkipAgent, sshOptions.GetHostKeyVerification())
+	return err == nil
+}
+
+func init() {
+	RegisterTransport(RsyncTransport{})
+}
@@ -0,0 +1,365 @@
+package synchers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/uselagoon/lagoon-sync/utils"
+)
+
+// SftpTransport copies resources with sftp, over the same kind of ssh connection lagoon-sync uses to run commands.
+// Nothing needs to be installed on either end beyond an ssh server offering the sftp subsystem.
+// When both ends are remote, files are relayed through the machine running lagoon-sync.
+type SftpTransport struct{}
+
+func (t SftpTransport) GetTransportId() string {
+	return "sftp"
+}
+
+func (t SftpTransport) Transfer(ctx context.Context, args TransportArguments) error {
+	for _, environment := range []Environment{args.SourceEnvironment, args.TargetEnvironment} {
+		if !sftpReachesService(environment) {
+			return fmt.Errorf("%w: sftp sessions can't be routed to the %s service on %s, use the ssh-cat transport for it instead",
+				ErrConfigInvalid, environment.ServiceName, environment.EnvironmentName)
+		}
+	}
+
+	args.Logger.LogExecutionStep(fmt.Sprintf("Copying %s on %s to %s on %s over sftp",
+		args.SourceResource.Name, args.SourceEnvironment.EnvironmentName,
+		args.TargetResource.Name, args.TargetEnvironment.EnvironmentName), nil)
+
+	if args.DryRun {
+		return nil
+	}
+
+	sourceFs, err := openTransportFileSystem(args.SourceEnvironment, args.SshOptionWrapper)
+	if err != nil {
+		return err
+	}
+	defer sourceFs.Close()
+
+	targetFs, err := openTransportFileSystem(args.TargetEnvironment, args.SshOptionWrapper)
+	if err != nil {
+		return err
+	}
+	defer targetFs.Close()
+
+	utils.ShowSpinner()
+	defer utils.HideSpinner()
+
+	copier := &sftpCopier{
+		ctx:      ctx,
+		sourceFs: sourceFs,
+		targetFs: targetFs,
+		excludes: args.SourceResource.ExcludeResources,
+		logger:   args.Logger,
+	}
+	switch {
+	case args.Files != nil:
+		err = copier.copyFiles(args.SourceResource.Name, args.TargetResource.Name, args.Files)
+	case args.SourceResource.IsDirectory:
+		err = copier.copyDirectory(args.SourceResource.Name, args.TargetResource.Name, "")
+	default:
+		err = copier.copyFile(args.SourceResource.Name, args.TargetResource.Name)
+	}
+
+	args.Logger.LogProcessStep(fmt.Sprintf("Copied %d files (%d bytes), skipped %d unchanged files",
+		copier.filesCopied, copier.bytesTransferred, copier.filesSkipped), nil)
+	recordTransfer(t, args, copier.filesCopied, copier.bytesTransferred)
+
+	return err
+}
+
+// transportFileSystem is the set of file operations the sftp transport needs, so that it can treat the local
+// file system and remote sftp file systems alike
+type transportFileSystem interface {
+	Open(name string) (io.ReadCloser, error)
+	Create(name string) (io.WriteCloser, error)
+	Stat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.FileInfo, error)
+	MkdirAll(name string) error
+	Chtimes(name string, atime time.Time, mtime time.Time) error
+	Join(elem ...string) string
+	Close() error
+}
+
+// defaultServiceName is the service that ssh sessions land on when they aren't routed to one
+const defaultServiceName = "cli"
+
+// sftpReachesService reports whether an sftp session to the environment lands on its service. Only commands can be
+// routed to a service, so sftp sessions always land on the default one.
+func sftpReachesService(environment Environment) bool {
+	return environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME || environment.ServiceName == "" || environment.ServiceName == defaultServiceName
+}
+
+func openTransportFileSystem(environment Environment, sshOptionWrapper *SSHOptionWrapper) (transportFileSystem, error) {
+	if environment.EnvironmentName == LOCAL_ENVIRONMENT_NAME {
+		return localFileSystem{}, nil
+	}
+
+	sshOptions := sshOptionWrapper.GetSSHOptionsForEnvironment(environment.EnvironmentName)
+	sshClient, err := utils.GetSSHConnection(environment.GetOpenshiftProjectName(), sshOptions.Host, sshOptions.Port, sshOptions.PrivateKey, sshOptions.SkipAgent, sshOptions.GetHostKeyVerification())
+	if err != nil {
+		return nil, err
+	}
+
+	sftpClient, err := sftp.NewClient(sshClient)
+	if err != nil {
+		return nil, fmt.Errorf("unable to start sftp session on %s: %w", environment.EnvironmentName, err)
+	}
+
+	return sftpFileSystem{client: sftpClient}, nil
+}
+
+type localFileSystem struct{}
+
+func (l localFileSystem) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (l localFileSystem) Create(name string) (io.WriteCloser, error) {
+	return os.Create(name)
+}
+
+func (l localFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (l localFileSystem) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	var infos []os.FileInfo
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (l localFileSystem) MkdirAll(name string) error {
+	return os.MkdirAll(name, 0755)
+}
+
+func (l localFileSystem) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+func (l localFileSystem) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (l localFileSystem) Close() error {
+	return nil
+}
+
+// sftpFileSystem runs over the pooled ssh connection to the environment, so closing it only ends the sftp session
+type sftpFileSystem struct {
+	client *sftp.Client
+}
+
+func (s sftpFileSystem) Open(name string) (io.ReadCloser, error) {
+	return s.client.Open(name)
+}
+
+func (s sftpFileSystem) Create(name string) (io.WriteCloser, error) {
+	return s.client.Create(name)
+}
+
+func (s sftpFileSystem) Stat(name string) (os.FileInfo, error) {
+	return s.client.Stat(name)
+}
+
+func (s sftpFileSystem) ReadDir(name string) ([]os.FileInfo, error) {
+	return s.client.ReadDir(name)
+}
+
+func (s sftpFileSystem) MkdirAll(name string) error {
+	return s.client.MkdirAll(name)
+}
+
+func (s sftpFileSystem) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return s.client.Chtimes(name, atime, mtime)
+}
+
+// Join always uses forward slashes, since remote environments are linux containers
+func (s sftpFileSystem) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+func (s sftpFileSystem) Close() error {
+	return s.client.Close()
+}
+
+// sftpCopier copies files between two transportFileSystems, skipping excluded and unchanged files and keeping
+// count of what it has done
+type sftpCopier struct {
+	ctx              context.Context
+	sourceFs         transportFileSystem
+	targetFs         transportFileSystem
+	excludes         []string
+	logger           *utils.Logger
+	filesCopied      int
+	filesSkipped     int
+	bytesTransferred int64
+}
+
+// copyFile copies a single file, keeping its modification time. Files that already exist on the target with
+// the same size and modification time are skipped.
+func (c *sftpCopier) copyFile(sourcePath string, targetPath string) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+
+	sourceInfo, err := c.sourceFs.Stat(sourcePath)
+	if err != nil {
+		return fmt.Errorf("unable to read %s on source: %w", sourcePath, err)
+	}
+
+	if targetInfo, err := c.targetFs.Stat(targetPath); err == nil && isUnchanged(sourceInfo, targetInfo) {
+		c.logger.LogDebugInfo("Skipping unchanged file", sourcePath)
+		c.filesSkipped++
+		return nil
+	}
+
+	sourceFile, err := c.sourceFs.Open(sourcePath)
+	if err != nil {
+		return fmt.Errorf("unable to open %s on source: %w", sourcePath, err)
+	}
+	defer sourceFile.Close()
+
+	if err := c.targetFs.MkdirAll(c.targetFs.Join(targetPath, "..")); err != nil {
+		return fmt.Errorf("unable to create directory for %s on target: %w", targetPath, err)
+	}
+
+	targetFile, err := c.targetFs.Create(targetPath)
+	if err != nil {
+		return fmt.Errorf("unable to create %s on target: %w", targetPath, err)
+	}
+
+	written, err := io.Copy(targetFile, contextReader{ctx: c.ctx, reader: sourceFile})
+	c.bytesTransferred += written
+	if err != nil {
+		targetFile.Close()
+		return fmt.Errorf("unable to copy %s: %w", sourcePath, err)
+	}
+	if err := targetFile.Close(); err != nil {
+		return fmt.Errorf("unable to write %s on target: %w", targetPath, err)
+	}
+	c.filesCopied++
+
+	return c.targetFs.Chtimes(targetPath, time.Now(), sourceInfo.ModTime())
+}
+
+// copyDirectory copies the contents of the source directory into the target directory, recursively.
+// relativeDir is the path of the directory being copied relative to the root of the transfer, used for excludes.
+func (c *sftpCopier) copyDirectory(sourceDir string, targetDir string, relativeDir string) error {
+	if err := c.targetFs.MkdirAll(targetDir); err != nil {
+		return fmt.Errorf("unable to create %s on target: %w", targetDir, err)
+	}
+
+	entries, err := c.sourceFs.ReadDir(sourceDir)
+	if err != nil {
+		return fmt.Errorf("unable to read %s on source: %w", sourceDir, err)
+	}
+
+	for _, entry := range entries {
+		sourcePath := c.sourceFs.Join(sourceDir, entry.Name())
+		targetPath := c.targetFs.Join(targetDir, entry.Name())
+		relativePath := path.Join(relativeDir, entry.Name())
+
+		if isExcluded(relativePath, c.excludes) {
+			c.logger.LogDebugInfo("Skipping excluded path", sourcePath)
+			continue
+		}
+
+		switch {
+		case entry.IsDir():
+			if err := c.copyDirectory(sourcePath, targetPath, relativePath); err != nil {
+				return err
+			}
+		case entry.Mode().IsRegular():
+			if err := c.copyFile(sourcePath, targetPath); err != nil {
+				return err
+			}
+		default:
+			c.logger.LogDebugInfo("Skipping, not a regular file or directory", sourcePath)
+		}
+	}
+
+	return nil
+}
+
+// copyFiles copies a selection of the files in the source directory into the target directory. The files are
+// given by their path relative to the directories.
+func (c *sftpCopier) copyFiles(sourceDir string, targetDir string, files []string) error {
+	for _, file := range files {
+		elements := strings.Split(file, "/")
+		if err := c.copyFile(c.sourceFs.Join(append([]string{sourceDir}, elements...)...), c.targetFs.Join(append([]string{targetDir}, elements...)...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// contextReader stops a copy part way through a file once the context is cancelled
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
+
+// isUnchanged compares files the way rsync does by default, by size and modification time. Modification times are
+// compared to the second, since that's all sftp keeps.
+func isUnchanged(sourceInfo os.FileInfo, targetInfo os.FileInfo) bool {
+	return targetInfo.Mode().IsRegular() &&
+		sourceInfo.Size() == targetInfo.Size() &&
+		sourceInfo.ModTime().Unix() == targetInfo.ModTime().Unix()
+}
+
+// isExcluded matches a path, relative to the root of the transfer, against rsync style exclude patterns. Patterns
+// without a slash match any file or directory with that name, patterns with a slash match the relative path, and
+// a leading slash anchors the pattern to the root of the transfer.
+func isExcluded(relativePath string, excludes []string) bool {
+	for _, pattern := range excludes {
+		pattern = strings.TrimSuffix(pattern, "/")
+		var matched bool
+		switch {
+		case strings.HasPrefix(pattern, "/"):
+			matched, _ = path.Match(strings.TrimPrefix(pattern, "/"), relativePath)
+		case strings.Contains(pattern, "/"):
+			matched, _ = path.Match(pattern, relativePath)
+			if !matched {
+				// unanchored patterns can match at any depth
+				matched, _ = path.Match("*/"+pattern, relativePath)
+			}
+		default:
+			matched, _ = path.Match(pattern, path.Base(relativePath))
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	RegisterTransport(SftpTransport{})
+}
@@ -0,0 +1,69 @@
+package synchers
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SshCatTransport streams resources over plain ssh sessions - `cat` for single files and `tar` for directories.
+// It only needs a shell on either end, so it works on images that have neither rsync nor an sftp server. The data
+// is relayed through the machine running lagoon-sync.
+type SshCatTransport struct{}
+
+func (t SshCatTransport) GetTransportId() string {
+	return "ssh-cat"
+}
+
+func (t SshCatTransport) Transfer(ctx context.Context, args TransportArguments) error {
+	// A selection of files is written to a list on the source for tar to read, which it removes once it's done
+	listFile := ""
+	if args.Files != nil {
+		listFile = fmt.Sprintf("/tmp/lagoon_sync_files_%d", time.Now().UnixNano())
+	}
+	sourceExecString, targetExecString := t.getCommands(args.SourceResource, args.TargetResource, listFile)
+
+	args.Logger.LogExecutionStep(fmt.Sprintf("Streaming the output of the following from source (%s)", args.SourceEnvironment.EnvironmentName), sourceExecString)
+	args.Logger.LogExecutionStep(fmt.Sprintf("Into the following on target (%s)", args.TargetEnvironment.EnvironmentName), targetExecString)
+
+	if args.DryRun {
+		return nil
+	}
+
+	if listFile != "" {
+		listCommand := fmt.Sprintf("cat > %s", listFile)
+		if err := runStreamCommand(ctx, args.SourceEnvironment, plainCommand(listCommand), fileListReader(args.Files), nil, args.SshOptionWrapper, args.Logger); err != nil {
+			return fmt.Errorf("unable to write the list of files to transfer on %s: %w", args.SourceEnvironment.EnvironmentName, err)
+		}
+	}
+
+	bytesTransferred, err := pipeStreamCommands(ctx, args.SourceEnvironment, plainCommand(sourceExecString), args.TargetEnvironment, plainCommand(targetExecString), args.SshOptionWrapper, args.Logger)
+	recordTransfer(t, args, 0, bytesTransferred)
+	return err
+}
+
+func (t SshCatTransport) getCommands(sourceResource SyncerTransferResource, targetResource SyncerTransferResource, listFile string) (string, string) {
+	if !sourceResource.IsDirectory {
+		return fmt.Sprintf("cat %s", sourceResource.Name),
+			fmt.Sprintf("mkdir -p %s && cat > %s", filepath.Dir(targetResource.Name), targetResource.Name)
+	}
+
+	targetExecString := fmt.Sprintf("mkdir -p %s && tar -C %s -xf -", targetResource.Name, targetResource.Name)
+	if listFile != "" {
+		return fmt.Sprintf("tar -C %s -cf - -T %s; status=$?; rm -f %s; exit $status", sourceResource.Name, listFile, listFile), targetExecString
+	}
+
+	tarArgs := []string{"tar", "-C", sourceResource.Name}
+	for _, e := range sourceResource.ExcludeResources {
+		tarArgs = append(tarArgs, fmt.Sprintf("--exclude='%v'", e))
+	}
+	tarArgs = append(tarArgs, "-cf", "-", ".")
+
+	return strings.Join(tarArgs, " "), targetExecString
+}
+
+func init() {
+	RegisterTransport(SshCatTransport{})
+}
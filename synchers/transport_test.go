@@ -0,0 +1,207 @@
+package synchers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGetTransport(t *testing.T) {
+	tests := []struct {
+		name        string
+		transportId string
+		want        string
+		wantErr     bool
+	}{
+		{name: "Defaults to rsync", transportId: "", want: "rsync"},
+		{name: "Rsync", transportId: "rsync", want: "rsync"},
+		{name: "Sftp", transportId: "sftp", want: "sftp"},
+		{name: "Ssh cat", transportId: "ssh-cat", want: "ssh-cat"},
+		{name: "Unknown transport", transportId: "carrier-pigeon", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetTransport(tt.transportId)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetTransport() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && got.GetTransportId() != tt.want {
+				t.Errorf("GetTransport() got = %v, want %v", got.GetTransportId(), tt.want)
+			}
+		})
+	}
+}
+
+func TestTransportFromConfig(t *testing.T) {
+	root := SyncherConfigRoot{
+		LagoonSync: map[string]interface{}{
+			"mariadb": map[string]interface{}{
+				"transport": "ssh-cat",
+			},
+		},
+	}
+	syncer, err := GetSyncerForTypeFromConfigRoot("mariadb", root)
+	if err != nil {
+		t.Fatalf("GetSyncerForTypeFromConfigRoot() error = %v", err)
+	}
+	if got := syncer.GetTransferResource(Environment{}).Transport; got != "ssh-cat" {
+		t.Errorf("GetTransferResource() transport = %v, want ssh-cat", got)
+	}
+}
+
+func TestSshCatTransport_getCommands(t *testing.T) {
+	tests := []struct {
+		name       string
+		source     SyncerTransferResource
+		target     SyncerTransferResource
+		listFile   string
+		wantSource string
+		wantTarget string
+	}{
+		{
+			name:       "Single file",
+			source:     SyncerTransferResource{Name: "/tmp/dump.sql.gz"},
+			target:     SyncerTransferResource{Name: "/tmp/dump.sql.gz"},
+			wantSource: "cat /tmp/dump.sql.gz",
+			wantTarget: "mkdir -p /tmp && cat > /tmp/dump.sql.gz",
+		},
+		{
+			name:       "Directory with excludes",
+			source:     SyncerTransferResource{Name: "/app/files", IsDirectory: true, ExcludeResources: []string{"css", "*.tmp"}},
+			target:     SyncerTransferResource{Name: "/app/files", IsDirectory: true},
+			wantSource: "tar -C /app/files --exclude='css' --exclude='*.tmp' -cf - .",
+			wantTarget: "mkdir -p /app/files && tar -C /app/files -xf -",
+		},
+		{
+			name:       "Selection of files",
+			source:     SyncerTransferResource{Name: "/app/files", IsDirectory: true, ExcludeResources: []string{"css"}},
+			target:     SyncerTransferResource{Name: "/app/files", IsDirectory: true},
+			listFile:   "/tmp/files",
+			wantSource: "tar -C /app/files -cf - -T /tmp/files; status=$?; rm -f /tmp/files; exit $status",
+			wantTarget: "mkdir -p /app/files && tar -C /app/files -xf -",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotSource, gotTarget := SshCatTransport{}.getCommands(tt.source, tt.target, tt.listFile)
+			if gotSource != tt.wantSource {
+				t.Errorf("getCommands() source = %v, want %v", gotSource, tt.wantSource)
+			}
+			if gotTarget != tt.wantTarget {
+				t.Errorf("getCommands() target = %v, want %v", gotTarget, tt.wantTarget)
+			}
+		})
+	}
+}
+
+func TestSftpTransport_ServiceName(t *testing.T) {
+	tests := []struct {
+		name        string
+		environment Environment
+		wantErr     bool
+	}{
+		{name: "Default service", environment: Environment{EnvironmentName: "main"}},
+		{name: "Cli service", environment: Environment{EnvironmentName: "main", ServiceName: "cli"}},
+		{name: "Another service", environment: Environment{EnvironmentName: "main", ServiceName: "nginx"}, wantErr: true},
+		{name: "Local", environment: Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME, ServiceName: "nginx"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := SftpTransport{}.Transfer(context.Background(), TransportArguments{
+				SourceEnvironment: tt.environment,
+				TargetEnvironment: Environment{EnvironmentName: LOCAL_ENVIRONMENT_NAME},
+				SourceResource:    SyncerTransferResource{Name: "/app/files", IsDirectory: true},
+				TargetResource:    SyncerTransferResource{Name: "/app/files", IsDirectory: true},
+				DryRun:            true,
+				SshOptionWrapper:  &SSHOptionWrapper{},
+			})
+			if tt.wantErr != errors.Is(err, ErrConfigInvalid) || (!tt.wantErr && err != nil) {
+				t.Errorf("Transfer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSftpCopier_copyDirectory(t *testing.T) {
+	sourceDir := t.TempDir()
+	targetDir := filepath.Join(t.TempDir(), "files")
+
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for name, contents := range map[string]string{
+		"a.txt":          "a",
+		"nested/b.txt":   "bb",
+		"nested/c/d.md":  "ddd",
+		"css/style.css":  "excluded",
+		"nested/tmp.log": "excluded",
+	} {
+		p := filepath.Join(sourceDir, name)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		os.WriteFile(p, []byte(contents), 0644)
+		os.Chtimes(p, modTime, modTime)
+	}
+
+	copier := &sftpCopier{ctx: context.Background(), sourceFs: localFileSystem{}, targetFs: localFileSystem{}, excludes: []string{"css", "*.log"}}
+	if err := copier.copyDirectory(sourceDir, targetDir, ""); err != nil {
+		t.Fatalf("copyDirectory() error = %v", err)
+	}
+
+	for name, contents := range map[string]string{"a.txt": "a", "nested/b.txt": "bb", "nested/c/d.md": "ddd"} {
+		p := filepath.Join(targetDir, name)
+		got, err := os.ReadFile(p)
+		if err != nil || string(got) != contents {
+			t.Errorf("copyDirectory() %v = %v (%v), want %v", name, string(got), err, contents)
+			continue
+		}
+		info, _ := os.Stat(p)
+		if !info.ModTime().Equal(modTime) {
+			t.Errorf("copyDirectory() didn't keep the modification time of %v", name)
+		}
+	}
+	for _, name := range []string{"css", "nested/tmp.log"} {
+		if _, err := os.Stat(filepath.Join(targetDir, name)); err == nil {
+			t.Errorf("copyDirectory() copied excluded path %v", name)
+		}
+	}
+	if copier.filesCopied != 3 || copier.bytesTransferred != 6 || copier.filesSkipped != 0 {
+		t.Errorf("copyDirectory() copied %v files (%v bytes), skipped %v", copier.filesCopied, copier.bytesTransferred, copier.filesSkipped)
+	}
+
+	// Running it again should skip everything, apart from the file that's changed in the meantime
+	os.WriteFile(filepath.Join(sourceDir, "a.txt"), []byte("changed"), 0644)
+	copier = &sftpCopier{ctx: context.Background(), sourceFs: localFileSystem{}, targetFs: localFileSystem{}, excludes: []string{"css", "*.log"}}
+	if err := copier.copyDirectory(sourceDir, targetDir, ""); err != nil {
+		t.Fatalf("copyDirectory() error = %v", err)
+	}
+	if copier.filesCopied != 1 || copier.bytesTransferred != 7 || copier.filesSkipped != 2 {
+		t.Errorf("copyDirectory() second run copied %v files (%v bytes), skipped %v", copier.filesCopied, copier.bytesTransferred, copier.filesSkipped)
+	}
+}
+
+func TestIsExcluded(t *testing.T) {
+	tests := []struct {
+		name         string
+		relativePath string
+		excludes     []string
+		want         bool
+	}{
+		{name: "No excludes", relativePath: "a/b.txt", want: false},
+		{name: "Name at any depth", relativePath: "a/css", excludes: []string{"css"}, want: true},
+		{name: "Glob on name", relativePath: "a/b/c.log", excludes: []string{"*.log"}, want: true},
+		{name: "Trailing slash", relativePath: "styles", excludes: []string{"styles/"}, want: true},
+		{name: "Anchored pattern matches at root", relativePath: "php", excludes: []string{"/php"}, want: true},
+		{name: "Anchored pattern doesn't match deeper", relativePath: "a/php", excludes: []string{"/php"}, want: false},
+		{name: "Pattern with slash", relativePath: "sites/default/files", excludes: []string{"default/files"}, want: true},
+		{name: "No match", relativePath: "a/b.txt", excludes: []string{"css", "*.log"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isExcluded(tt.relativePath, tt.excludes); got != tt.want {
+				t.Errorf("isExcluded() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
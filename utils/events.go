@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// OutputFormatText logs coloured, human readable messages
+	OutputFormatText = "text"
+	// OutputFormatJSON writes newline delimited JSON events instead, for CI systems to parse
+	OutputFormatJSON = "json"
+)
+
+var outputFormat = OutputFormatText
+
+// eventWriter is where JSON events are written, one per line
+var eventWriter io.Writer = os.Stdout
+
+func SetOutputFormat(format string) error {
+	switch format {
+	case OutputFormatText, OutputFormatJSON:
+		outputFormat = format
+		return nil
+	default:
+		return fmt.Errorf("unknown output format '%s', expected %s or %s", format, OutputFormatText, OutputFormatJSON)
+	}
+}
+
+func IsJSONOutput() bool {
+	return outputFormat == OutputFormatJSON
+}
+
+// LogEvent writes an event when the output format is JSON, and does nothing otherwise. The fields, which should
+// marshal to a JSON object, are written alongside the event's name, the time and the logger's prefix (as "sync").
+func (l *Logger) LogEvent(event string, fields interface{}) {
+	if !IsJSONOutput() {
+		return
+	}
+	logMx.Lock()
+	defer logMx.Unlock()
+	l.writeEvent(event, fields)
+}
+
+// writeEvent does the work of LogEvent, the caller must hold logMx
+func (l *Logger) writeEvent(event string, fields interface{}) {
+	line := map[string]interface{}{}
+	if fields != nil {
+		data, err := json.Marshal(fields)
+		if err == nil {
+			_ = json.Unmarshal(data, &line)
+		}
+	}
+	line["event"] = event
+	line["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	if l != nil && l.prefix != "" {
+		line["sync"] = l.prefix
+	}
+
+	data, err := json.Marshal(line)
+	if err != nil {
+		data, _ = json.Marshal(map[string]interface{}{"event": event, "error": err.Error()})
+	}
+	eventWriter.Write(append(data, '\n'))
+}
+
+type logEventFields struct {
+	Level   string      `json:"level"`
+	Message string      `json:"message"`
+	Output  interface{} `json:"output,omitempty"`
+}
+
+// logJSON writes a log message as a "log" event if the output format is JSON, returning false if it isn't
+func (l *Logger) logJSON(level string, message string, output interface{}) bool {
+	if !IsJSONOutput() {
+		return false
+	}
+	logMx.Lock()
+	defer logMx.Unlock()
+	if err, ok := output.(error); ok {
+		output = err.Error()
+	}
+	l.writeEvent("log", logEventFields{Level: level, Message: message, Output: output})
+	return true
+}
+
+func LogEvent(event string, fields interface{}) {
+	defaultLogger.LogEvent(event, fields)
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func captureEvents(t *testing.T, format string) *bytes.Buffer {
+	var buffer bytes.Buffer
+	previousWriter, previousFormat := eventWriter, outputFormat
+	eventWriter = &buffer
+	if err := SetOutputFormat(format); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		eventWriter, outputFormat = previousWriter, previousFormat
+	})
+	return &buffer
+}
+
+func TestLogEvent(t *testing.T) {
+	buffer := captureEvents(t, OutputFormatJSON)
+
+	NewLogger("mariadb").LogEvent("phase-start", struct {
+		Phase string `json:"phase"`
+	}{Phase: "transfer"})
+	NewLogger("").LogWarning("Something to note", nil)
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("LogEvent() wrote %v lines, want 2: %v", len(lines), buffer.String())
+	}
+
+	var event map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &event); err != nil {
+		t.Fatalf("LogEvent() wrote invalid JSON: %v", err)
+	}
+	if event["event"] != "phase-start" || event["phase"] != "transfer" || event["sync"] != "mariadb" || event["time"] == nil {
+		t.Errorf("LogEvent() = %v", event)
+	}
+
+	event = map[string]interface{}{}
+	if err := json.Unmarshal([]byte(lines[1]), &event); err != nil {
+		t.Fatalf("LogWarning() wrote invalid JSON: %v", err)
+	}
+	if event["event"] != "log" || event["level"] != "warning" || event["message"] != "Something to note" {
+		t.Errorf("LogWarning() = %v", event)
+	}
+}
+
+func TestLogEvent_textOutput(t *testing.T) {
+	buffer := captureEvents(t, OutputFormatText)
+	LogEvent("summary", nil)
+	if buffer.Len() != 0 {
+		t.Errorf("LogEvent() wrote %v with text output", buffer.String())
+	}
+}
+
+func TestSetOutputFormat(t *testing.T) {
+	captureEvents(t, OutputFormatText)
+	if err := SetOutputFormat("yaml"); err == nil {
+		t.Errorf("SetOutputFormat() accepted an unknown format")
+	}
+}
@@ -0,0 +1,322 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/mitchellh/go-homedir"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	// HostKeyPolicyStrict only connects to hosts whose key is already in the known_hosts file
+	HostKeyPolicyStrict = "strict"
+	// HostKeyPolicyAcceptNew adds the keys of hosts we haven't seen before to the known_hosts file, but refuses
+	// to connect if a known host's key has changed
+	HostKeyPolicyAcceptNew = "accept-new"
+	// HostKeyPolicyInsecure doesn't verify host keys at all
+	HostKeyPolicyInsecure = "insecure"
+
+	DefaultHostKeyPolicy = HostKeyPolicyAcceptNew
+)
+
+// knownHostsMx guards writes to known_hosts files, since several syncs may learn the same host at once
+var knownHostsMx sync.Mutex
+
+// HostKeyVerification describes how the keys of the hosts we connect to over ssh are verified
+type HostKeyVerification struct {
+	// Policy is one of HostKeyPolicyStrict, HostKeyPolicyAcceptNew or HostKeyPolicyInsecure, DefaultHostKeyPolicy if empty
+	Policy string
+	// KnownHostsFile defaults to ~/.ssh/known_hosts, a leading ~ is expanded to the home directory
+	KnownHostsFile string
+	// Fingerprints pins the host keys that are acceptable, as SHA256 fingerprints ("SHA256:...") - when set,
+	// the host key must match one of them whatever the policy
+	Fingerprints []string
+}
+
+func (h HostKeyVerification) GetPolicy() string {
+	if h.Policy == "" {
+		return DefaultHostKeyPolicy
+	}
+	return h.Policy
+}
+
+// GetKnownHostsFile returns the known_hosts file with ~ expanded, since neither the Go client nor the ssh options
+// it's passed to expand it themselves
+func (h HostKeyVerification) GetKnownHostsFile() (string, error) {
+	if h.KnownHostsFile != "" {
+		knownHostsFile, err := homedir.Expand(h.KnownHostsFile)
+		if err != nil {
+			return "", fmt.Errorf("unable to expand the known_hosts file %s: %w", h.KnownHostsFile, err)
+		}
+		return knownHostsFile, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("no known_hosts file given and no home directory available: %w", err)
+	}
+	return filepath.Join(home, ".ssh", "known_hosts"), nil
+}
+
+func (h HostKeyVerification) Validate() error {
+	switch h.GetPolicy() {
+	case HostKeyPolicyStrict, HostKeyPolicyAcceptNew, HostKeyPolicyInsecure:
+	default:
+		return fmt.Errorf("unknown host key policy '%s', expected one of %s, %s or %s", h.Policy, HostKeyPolicyStrict, HostKeyPolicyAcceptNew, HostKeyPolicyInsecure)
+	}
+	for _, f := range h.Fingerprints {
+		if !strings.HasPrefix(f, "SHA256:") {
+			return fmt.Errorf("host key fingerprint '%s' should be a SHA256 fingerprint, as printed by 'ssh-keygen -lf'", f)
+		}
+	}
+	return nil
+}
+
+// HostKeyCallback returns the callback the Go ssh client uses to verify host keys
+func (h HostKeyVerification) HostKeyCallback() (ssh.HostKeyCallback, error) {
+	if err := h.Validate(); err != nil {
+		return nil, err
+	}
+
+	if len(h.Fingerprints) > 0 {
+		return h.checkFingerprint, nil
+	}
+
+	switch h.GetPolicy() {
+	case HostKeyPolicyInsecure:
+		return ssh.InsecureIgnoreHostKey(), nil
+	case HostKeyPolicyStrict:
+		knownHostsFile, err := h.GetKnownHostsFile()
+		if err != nil {
+			return nil, err
+		}
+		callback, err := knownhosts.New(knownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read known_hosts file %s, which the strict host key policy requires: %w", knownHostsFile, err)
+		}
+		return callback, nil
+	default:
+		return h.acceptNewCallback()
+	}
+}
+
+func (h HostKeyVerification) checkFingerprint(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	fingerprint := ssh.FingerprintSHA256(key)
+	for _, f := range h.Fingerprints {
+		if f == fingerprint {
+			return nil
+		}
+	}
+	return fmt.Errorf("host key for %s has fingerprint %s, which doesn't match any of the pinned fingerprints", hostname, fingerprint)
+}
+
+func (h HostKeyVerification) acceptNewCallback() (ssh.HostKeyCallback, error) {
+	knownHostsFile, err := h.GetKnownHostsFile()
+	if err != nil {
+		return nil, err
+	}
+
+	knownHostsMx.Lock()
+	defer knownHostsMx.Unlock()
+	if err := ensureFileExists(knownHostsFile); err != nil {
+		return nil, err
+	}
+	callback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read known_hosts file %s: %w", knownHostsFile, err)
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+			// Either the key is known, or it has changed - which we never accept
+			return err
+		}
+
+		LogWarning(fmt.Sprintf("Adding host key %s for %s to %s", ssh.FingerprintSHA256(key), hostname, knownHostsFile), nil)
+		return appendKnownHost(knownHostsFile, hostname, key)
+	}, nil
+}
+
+// HostKeyAlgorithms lists the key algorithms of the keys already known for the address, so that the Go client
+// negotiates a key type it can verify. It returns nil if there's nothing to go on.
+func (h HostKeyVerification) HostKeyAlgorithms(address string) []string {
+	if len(h.Fingerprints) > 0 || h.GetPolicy() == HostKeyPolicyInsecure {
+		return nil
+	}
+	knownHostsFile, err := h.GetKnownHostsFile()
+	if err != nil {
+		return nil
+	}
+	callback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil
+	}
+
+	// Checking a throwaway key gets us the list of the keys that are known for the address
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil
+	}
+	placeholderKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		return nil
+	}
+	var keyErr *knownhosts.KeyError
+	if !errors.As(callback(address, &net.TCPAddr{IP: net.IPv4zero}, placeholderKey), &keyErr) {
+		return nil
+	}
+
+	var algorithms []string
+	for _, known := range keyErr.Want {
+		switch known.Key.Type() {
+		case ssh.KeyAlgoRSA:
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		default:
+			algorithms = append(algorithms, known.Key.Type())
+		}
+	}
+	return algorithms
+}
+
+// SshCommandOptions returns the options for an ssh command run on this machine (directly, or by rsync)
+// to verify host keys in the same way the Go client does
+func (h HostKeyVerification) SshCommandOptions(host string, port string) (string, error) {
+	if err := h.Validate(); err != nil {
+		return "", err
+	}
+
+	if len(h.Fingerprints) > 0 {
+		// ssh can't check fingerprints itself, so we verify the key here and hand ssh a known_hosts file with just that key
+		knownHostsFile, err := h.pinnedKnownHostsFile(host, port)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("-o UserKnownHostsFile=%s -o StrictHostKeyChecking=yes", knownHostsFile), nil
+	}
+
+	switch h.GetPolicy() {
+	case HostKeyPolicyInsecure:
+		return "-o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no", nil
+	case HostKeyPolicyStrict:
+		knownHostsFile, err := h.GetKnownHostsFile()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("-o UserKnownHostsFile=%s -o StrictHostKeyChecking=yes", knownHostsFile), nil
+	default:
+		knownHostsFile, err := h.GetKnownHostsFile()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("-o UserKnownHostsFile=%s -o StrictHostKeyChecking=accept-new", knownHostsFile), nil
+	}
+}
+
+// RemoteSshCommandOptions is the equivalent of SshCommandOptions for an ssh command run on a remote environment,
+// where our known_hosts file isn't available. The key is verified from here, and the returned prefix command
+// writes it to a known_hosts file on the remote environment for the ssh options to use.
+func (h HostKeyVerification) RemoteSshCommandOptions(host string, port string, knownHostsFile string) (string, string, error) {
+	if err := h.Validate(); err != nil {
+		return "", "", err
+	}
+	if len(h.Fingerprints) == 0 && h.GetPolicy() == HostKeyPolicyInsecure {
+		return "", "-o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no", nil
+	}
+
+	key, err := FetchHostKey(host, port, h)
+	if err != nil {
+		return "", "", err
+	}
+	prefix := fmt.Sprintf("printf '%%s\\n' '%s' > %s && ", knownhosts.Line([]string{knownhosts.Normalize(net.JoinHostPort(host, port))}, key), knownHostsFile)
+	return prefix, fmt.Sprintf("-o UserKnownHostsFile=%s -o StrictHostKeyChecking=yes", knownHostsFile), nil
+}
+
+var errHostKeyFetched = errors.New("host key fetched")
+
+// FetchHostKey connects to the host just long enough to get its key, which is verified as the Go client would
+func FetchHostKey(host string, port string, verification HostKeyVerification) (ssh.PublicKey, error) {
+	callback, err := verification.HostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+
+	address := net.JoinHostPort(host, port)
+	var hostKey ssh.PublicKey
+	config := &ssh.ClientConfig{
+		HostKeyAlgorithms: verification.HostKeyAlgorithms(address),
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if err := callback(hostname, remote, key); err != nil {
+				return err
+			}
+			hostKey = key
+			// There's no need to go on and authenticate
+			return errHostKeyFetched
+		},
+	}
+
+	client, err := ssh.Dial("tcp", address, config)
+	if client != nil {
+		client.Close()
+	}
+	if hostKey == nil {
+		return nil, fmt.Errorf("unable to verify host key for %s: %w", address, err)
+	}
+	return hostKey, nil
+}
+
+func (h HostKeyVerification) pinnedKnownHostsFile(host string, port string) (string, error) {
+	key, err := FetchHostKey(host, port, h)
+	if err != nil {
+		return "", err
+	}
+
+	knownHostsFile := filepath.Join(os.TempDir(), fmt.Sprintf("lagoon-sync-known-hosts-%s-%s", strings.ReplaceAll(host, ":", "_"), port))
+	line := knownhosts.Line([]string{knownhosts.Normalize(net.JoinHostPort(host, port))}, key)
+	if err := os.WriteFile(knownHostsFile, []byte(line+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("unable to write known_hosts file for pinned host key: %w", err)
+	}
+	return knownHostsFile, nil
+}
+
+func ensureFileExists(name string) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func appendKnownHost(knownHostsFile string, hostname string, key ssh.PublicKey) error {
+	knownHostsMx.Lock()
+	defer knownHostsMx.Unlock()
+
+	// Another connection may have added the host since the file was read
+	if callback, err := knownhosts.New(knownHostsFile); err == nil {
+		var keyErr *knownhosts.KeyError
+		err := callback(hostname, &net.TCPAddr{IP: net.IPv4zero}, key)
+		if err == nil || (errors.As(err, &keyErr) && len(keyErr.Want) > 0) {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(knownHostsFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to add host key to %s: %w", knownHostsFile, err)
+	}
+	defer file.Close()
+	_, err = fmt.Fprintln(file, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+	return err
+}
@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/go-homedir"
+	"golang.org/x/crypto/ssh"
+)
+
+// startTestSshServer starts an ssh server that only does the key exchange, which is all host key checks need
+func startTestSshServer(t *testing.T) (string, string, ssh.Signer) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				ssh.NewServerConn(conn, config)
+			}()
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	return host, port, signer
+}
+
+func TestFetchHostKey(t *testing.T) {
+	host, port, signer := startTestSshServer(t)
+	fingerprint := ssh.FingerprintSHA256(signer.PublicKey())
+
+	t.Run("Accept new adds the key, then holds the host to it", func(t *testing.T) {
+		knownHostsFile := filepath.Join(t.TempDir(), "known_hosts")
+		verification := HostKeyVerification{Policy: HostKeyPolicyAcceptNew, KnownHostsFile: knownHostsFile}
+
+		if _, err := FetchHostKey(host, port, verification); err != nil {
+			t.Fatalf("FetchHostKey() first connection error = %v", err)
+		}
+		contents, _ := os.ReadFile(knownHostsFile)
+		if strings.Count(string(contents), "\n") != 1 {
+			t.Fatalf("FetchHostKey() known_hosts = %v, want a single line", string(contents))
+		}
+
+		if _, err := FetchHostKey(host, port, verification); err != nil {
+			t.Fatalf("FetchHostKey() second connection error = %v", err)
+		}
+		contents, _ = os.ReadFile(knownHostsFile)
+		if strings.Count(string(contents), "\n") != 1 {
+			t.Errorf("FetchHostKey() added the same host twice: %v", string(contents))
+		}
+
+		// Same host and port, different key
+		otherHost, otherPort, _ := startTestSshServer(t)
+		os.WriteFile(knownHostsFile, []byte(strings.Replace(string(contents), port, otherPort, 1)), 0600)
+		if _, err := FetchHostKey(otherHost, otherPort, verification); err == nil {
+			t.Errorf("FetchHostKey() accepted a changed host key")
+		}
+	})
+
+	t.Run("Strict refuses unknown hosts", func(t *testing.T) {
+		knownHostsFile := filepath.Join(t.TempDir(), "known_hosts")
+		os.WriteFile(knownHostsFile, []byte{}, 0600)
+		verification := HostKeyVerification{Policy: HostKeyPolicyStrict, KnownHostsFile: knownHostsFile}
+
+		if _, err := FetchHostKey(host, port, verification); err == nil {
+			t.Errorf("FetchHostKey() accepted an unknown host")
+		}
+	})
+
+	t.Run("Pinned fingerprints", func(t *testing.T) {
+		verification := HostKeyVerification{Fingerprints: []string{fingerprint}}
+		if _, err := FetchHostKey(host, port, verification); err != nil {
+			t.Errorf("FetchHostKey() error = %v with matching fingerprint", err)
+		}
+
+		verification = HostKeyVerification{Fingerprints: []string{"SHA256:nope"}}
+		if _, err := FetchHostKey(host, port, verification); err == nil {
+			t.Errorf("FetchHostKey() accepted a host key that isn't pinned")
+		}
+	})
+}
+
+func TestHostKeyVerification_SshCommandOptions(t *testing.T) {
+	home, err := homedir.Dir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		verification HostKeyVerification
+		want         string
+		wantErr      bool
+	}{
+		{
+			name:         "Insecure",
+			verification: HostKeyVerification{Policy: HostKeyPolicyInsecure},
+			want:         "-o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no",
+		},
+		{
+			name:         "Strict",
+			verification: HostKeyVerification{Policy: HostKeyPolicyStrict, KnownHostsFile: "/tmp/known_hosts"},
+			want:         "-o UserKnownHostsFile=/tmp/known_hosts -o StrictHostKeyChecking=yes",
+		},
+		{
+			name:         "Accept new by default",
+			verification: HostKeyVerification{KnownHostsFile: "/tmp/known_hosts"},
+			want:         "-o UserKnownHostsFile=/tmp/known_hosts -o StrictHostKeyChecking=accept-new",
+		},
+		{
+			name:         "Known hosts file in the home directory",
+			verification: HostKeyVerification{Policy: HostKeyPolicyStrict, KnownHostsFile: "~/.ssh/lagoon_known_hosts"},
+			want:         "-o UserKnownHostsFile=" + filepath.Join(home, ".ssh", "lagoon_known_hosts") + " -o StrictHostKeyChecking=yes",
+		},
+		{
+			name:         "Unknown policy",
+			verification: HostKeyVerification{Policy: "yolo"},
+			wantErr:      true,
+		},
+		{
+			name:         "Fingerprint that isn't SHA256",
+			verification: HostKeyVerification{Fingerprints: []string{"aa:bb:cc"}},
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.verification.SshCommandOptions("ssh.example.com", "32222")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SshCommandOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("SshCommandOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,107 +2,174 @@ package utils
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"reflect"
+	"sync"
 
-	"github.com/spf13/viper"
 	"github.com/withmandala/go-log"
 )
 
 var colour bool
+var showDebug bool
+
+// logMx stops messages logged from concurrently running syncs from interleaving
+var logMx sync.Mutex
 
 func SetColour(c bool) {
 	colour = c
 }
 
-func LogProcessStep(message string, output interface{}) {
+// SetShowDebug turns on logging of debug information
+func SetShowDebug(debug bool) {
+	showDebug = debug
+}
+
+// Logger logs in the same way as the package level Log functions, but prefixes every message so that the output
+// of syncs running side by side can be told apart. A nil *Logger logs without a prefix.
+type Logger struct {
+	prefix string
+}
+
+func NewLogger(prefix string) *Logger {
+	return &Logger{prefix: prefix}
+}
+
+func (l *Logger) withPrefix(message string) string {
+	if l == nil || l.prefix == "" {
+		return message
+	}
+	return fmt.Sprintf("[%s] %s", l.prefix, message)
+}
+
+func newLogger() *log.Logger {
 	logger := log.New(os.Stdout)
 	if colour {
 		logger.WithColor()
 	} else {
 		logger.WithoutColor()
 	}
+	return logger
+}
+
+func (l *Logger) LogProcessStep(message string, output interface{}) {
+	if l.logJSON("info", message, output) {
+		return
+	}
+	logMx.Lock()
+	defer logMx.Unlock()
+	logger := newLogger()
 	if output == nil {
-		logger.Info(message)
+		logger.Info(l.withPrefix(message))
 	} else if output != nil {
-		logger.Info(message, output)
+		logger.Info(l.withPrefix(message), output)
 	}
 }
 
-func LogExecutionStep(message string, output interface{}) {
-	logger := log.New(os.Stdout)
-	if colour {
-		logger.WithColor()
-	} else {
-		logger.WithoutColor()
+func (l *Logger) LogExecutionStep(message string, output interface{}) {
+	if l.logJSON("info", message, output) {
+		return
 	}
+	logMx.Lock()
+	defer logMx.Unlock()
+	logger := newLogger()
 	if output == nil {
-		logger.Info(message)
+		logger.Info(l.withPrefix(message))
 	} else if output != nil {
-		logger.Info(message, output)
+		logger.Info(l.withPrefix(message), output)
 	}
 }
 
-func LogDebugInfo(message string, output interface{}) {
-	logger := log.New(os.Stdout).WithDebug()
-	if colour {
-		logger.WithColor()
-	} else {
-		logger.WithoutColor()
+func (l *Logger) LogDebugInfo(message string, output interface{}) {
+	if !showDebug {
+		return
 	}
-	if debug := viper.Get("show-debug"); debug == true {
-		if output == nil {
-			logger.Debug(message)
-		} else if output != nil {
-			if reflect.TypeOf(output).String() == "string" {
-				logger.Debug(message, output)
-			}
-			if reflect.TypeOf(output).String() != "string" {
-				s, _ := json.MarshalIndent(output, "", "  ")
-				logger.Debug(message, string(s))
-			}
+	if l.logJSON("debug", message, output) {
+		return
+	}
+	logMx.Lock()
+	defer logMx.Unlock()
+	logger := newLogger().WithDebug()
+	if output == nil {
+		logger.Debug(l.withPrefix(message))
+	} else if output != nil {
+		if reflect.TypeOf(output).String() == "string" {
+			logger.Debug(l.withPrefix(message), output)
+		}
+		if reflect.TypeOf(output).String() != "string" {
+			s, _ := json.MarshalIndent(output, "", "  ")
+			logger.Debug(l.withPrefix(message), string(s))
 		}
 	}
 }
 
-func LogError(message string, output interface{}) {
-	logger := log.New(os.Stdout)
-	if colour {
-		logger.WithColor()
-	} else {
-		logger.WithoutColor()
+func (l *Logger) LogError(message string, output interface{}) {
+	if l.logJSON("error", message, output) {
+		return
 	}
+	logMx.Lock()
+	defer logMx.Unlock()
+	logger := newLogger()
 	if output == nil {
-		logger.Error(message)
+		logger.Error(l.withPrefix(message))
 	} else if output != nil {
-		logger.Error(message, output)
+		logger.Error(l.withPrefix(message), output)
 	}
 }
 
-func LogFatalError(message string, output interface{}) {
-	logger := log.New(os.Stdout)
-	if colour {
-		logger.WithColor()
-	} else {
-		logger.WithoutColor()
+// LogFatalError logs the message and exits the process. Only the commands in cmd should call it - everything else
+// returns an error, so that whatever is using it decides whether to exit.
+func (l *Logger) LogFatalError(message string, output interface{}) {
+	if l.logJSON("fatal", message, output) {
+		os.Exit(1)
 	}
+	logMx.Lock()
+	defer logMx.Unlock()
+	logger := newLogger()
 	if output == nil {
-		logger.Fatal(message)
+		logger.Fatal(l.withPrefix(message))
 	} else if output != nil {
-		logger.Fatal(message, output)
+		logger.Fatal(l.withPrefix(message), output)
 	}
 }
 
-func LogWarning(message string, output interface{}) {
-	logger := log.New(os.Stdout)
-	if colour {
-		logger.WithColor()
-	} else {
-		logger.WithoutColor()
+func (l *Logger) LogWarning(message string, output interface{}) {
+	if l.logJSON("warning", message, output) {
+		return
 	}
+	logMx.Lock()
+	defer logMx.Unlock()
+	logger := newLogger()
 	if output == nil {
-		logger.Warn(message)
+		logger.Warn(l.withPrefix(message))
 	} else if output != nil {
-		logger.Warn(message, output)
+		logger.Warn(l.withPrefix(message), output)
 	}
 }
+
+// defaultLogger backs the package level Log functions
+var defaultLogger *Logger
+
+func LogProcessStep(message string, output interface{}) {
+	defaultLogger.LogProcessStep(message, output)
+}
+
+func LogExecutionStep(message string, output interface{}) {
+	defaultLogger.LogExecutionStep(message, output)
+}
+
+func LogDebugInfo(message string, output interface{}) {
+	defaultLogger.LogDebugInfo(message, output)
+}
+
+func LogError(message string, output interface{}) {
+	defaultLogger.LogError(message, output)
+}
+
+func LogFatalError(message string, output interface{}) {
+	defaultLogger.LogFatalError(message, output)
+}
+
+func LogWarning(message string, output interface{}) {
+	defaultLogger.LogWarning(message, output)
+}
@@ -2,13 +2,20 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
@@ -17,11 +24,81 @@ import (
 const ShellToUse = "sh"
 
 var validAuthMethod *ssh.AuthMethod
+var validAuthMethodMx sync.Mutex
 
-func Shellout(command string) (error, string, string) {
+func getValidAuthMethod() *ssh.AuthMethod {
+	validAuthMethodMx.Lock()
+	defer validAuthMethodMx.Unlock()
+	return validAuthMethod
+}
+
+// cacheValidAuthMethod stores the auth method that worked, unless a concurrent connection got there first
+func cacheValidAuthMethod(am ssh.AuthMethod) {
+	validAuthMethodMx.Lock()
+	defer validAuthMethodMx.Unlock()
+	if validAuthMethod == nil {
+		LogDebugInfo("Dial success - caching auth method for subsequent runs", os.Stdout)
+		validAuthMethod = &am
+	}
+}
+
+// commandWaitDelay is how long a cancelled local command's output is waited on, in case something it started
+// is still holding it open
+const commandWaitDelay = 5 * time.Second
+
+// CommandSecrets are values, such as passwords, that a command refers to by the name of a shell variable rather
+// than having in its arguments, where anyone able to list the processes on the machine running it would see them.
+// They're set in the environment of a local command, and read from stdin ahead of a remote one.
+type CommandSecrets map[string]string
+
+func (s CommandSecrets) names() []string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// environ is the environment a local command is run with - our own, along with the secrets
+func (s CommandSecrets) environ() []string {
+	if len(s) == 0 {
+		return nil
+	}
+	env := os.Environ()
+	for _, name := range s.names() {
+		env = append(env, name+"="+s[name])
+	}
+	return env
+}
+
+// remoteCommand prefixes a remote command with reading the secrets into shell variables, one per line, and returns
+// the stdin that supplies them ahead of whatever the command itself reads. The variables aren't exported, so only
+// what the command explicitly passes them to sees them.
+func (s CommandSecrets) remoteCommand(command string, stdin io.Reader) (string, io.Reader, error) {
+	if len(s) == 0 {
+		return command, stdin, nil
+	}
+	var reads, values strings.Builder
+	for _, name := range s.names() {
+		if strings.ContainsAny(s[name], "\n\x00") {
+			return "", nil, fmt.Errorf("the value of %s can't be passed to a remote command as it contains a newline or null byte", name)
+		}
+		fmt.Fprintf(&reads, "IFS= read -r %s || exit 1; ", name)
+		values.WriteString(s[name] + "\n")
+	}
+	if stdin == nil {
+		return reads.String() + command, strings.NewReader(values.String()), nil
+	}
+	return reads.String() + command, io.MultiReader(strings.NewReader(values.String()), stdin), nil
+}
+
+// Shellout runs a command locally, returning what it wrote to stdout and stderr
+func Shellout(ctx context.Context, command string, secrets CommandSecrets) (error, string, string) {
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
-	cmd := exec.Command(ShellToUse, "-c", command)
+	cmd := newCommand(ctx, command)
+	cmd.Env = secrets.environ()
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 	err := cmd.Start()
@@ -31,9 +108,20 @@ func Shellout(command string) (error, string, string) {
 	ShowSpinner()
 	defer HideSpinner()
 	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err(), stdout.String(), stderr.String()
+	}
 	return err, stdout.String(), stderr.String()
 }
 
+// newCommand creates a shell command that is killed if the context is cancelled
+func newCommand(ctx context.Context, command string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, ShellToUse, "-c", command)
+	killProcessGroupOnCancel(cmd)
+	cmd.WaitDelay = commandWaitDelay
+	return cmd
+}
+
 func getAuthMethodFromPrivateKey(filename string) (ssh.AuthMethod, error) {
 	privateKeyBytes, err := os.ReadFile(filename)
 
@@ -94,35 +182,232 @@ func isPassphraseMissingError(err error) bool {
 	return ok
 }
 
-func RemoteShellout(command string, service string, remoteUser string, remoteHost string, remotePort string, privateKeyfile string, skipSshAgent bool) (error, string) {
+// RemoteShellout runs a command on the remote service, returning its combined output
+func RemoteShellout(ctx context.Context, command string, secrets CommandSecrets, service string, remoteUser string, remoteHost string, remotePort string, privateKeyfile string, skipSshAgent bool, hostKeyVerification HostKeyVerification) (error, string) {
+	// Create a session on the pooled connection
+	session, err := newSSHSession(remoteUser, remoteHost, remotePort, privateKeyfile, skipSshAgent, hostKeyVerification)
+	if err != nil {
+		return err, ""
+	}
+	defer session.Close()
+
+	command, session.Stdin, err = secrets.remoteCommand(command, nil)
+	if err != nil {
+		return err, ""
+	}
+
+	ShowSpinner()
+	defer HideSpinner()
+
+	var output []byte
+	err = runSession(ctx, session, func() error {
+		var err error
+		output, err = session.CombinedOutput(fmt.Sprintf("service=%s %s", service, command))
+		return err
+	})
+	if err != nil {
+		return remoteCommandError(err, string(output)), string(output)
+	}
+
+	return nil, string(output)
+}
+
+// StreamShellout runs a command locally, wiring up the given stdin and stdout - either of which may be nil.
+// Anything written to stderr is returned as a string.
+func StreamShellout(ctx context.Context, command string, secrets CommandSecrets, stdin io.Reader, stdout io.Writer) (error, string) {
+	var stderr bytes.Buffer
+	cmd := newCommand(ctx, command)
+	cmd.Env = secrets.environ()
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	err := cmd.Start()
+	if err != nil {
+		return err, ""
+	}
+	ShowSpinner()
+	defer HideSpinner()
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err(), stderr.String()
+	}
+	return err, stderr.String()
+}
+
+// RemoteStreamShellout is the remote equivalent of StreamShellout - the command is run on the remote service
+// with the given stdin and stdout attached to the ssh session.
+func RemoteStreamShellout(ctx context.Context, command string, secrets CommandSecrets, service string, remoteUser string, remoteHost string, remotePort string, privateKeyfile string, skipSshAgent bool, hostKeyVerification HostKeyVerification, stdin io.Reader, stdout io.Writer) (error, string) {
+	session, err := newSSHSession(remoteUser, remoteHost, remotePort, privateKeyfile, skipSshAgent, hostKeyVerification)
+	if err != nil {
+		return err, ""
+	}
+	defer session.Close()
+
+	command, session.Stdin, err = secrets.remoteCommand(command, stdin)
+	if err != nil {
+		return err, ""
+	}
+
+	var stderr bytes.Buffer
+	session.Stdout = stdout
+	session.Stderr = &stderr
 
+	ShowSpinner()
+	defer HideSpinner()
+
+	err = runSession(ctx, session, func() error {
+		return session.Run(fmt.Sprintf("service=%s %s", service, command))
+	})
+	if err != nil {
+		return remoteCommandError(err, stderr.String()), stderr.String()
+	}
+
+	return nil, stderr.String()
+}
+
+// runSession runs the command started by run, killing it if the context is cancelled first
+func runSession(ctx context.Context, session *ssh.Session, run func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- run()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		// Not every ssh server passes signals on, so the session is closed too - which ends the command on the rest
+		_ = session.Signal(ssh.SIGKILL)
+		session.Close()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func remoteCommandError(err error, stderr string) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if exitErr, ok := err.(*ssh.ExitError); ok {
+		return &ErrRemoteCommandFailed{ExitCode: exitErr.ExitStatus(), Stderr: stderr}
+	}
+	// The other end of a stream going away isn't the connection failing, so it's not retried as if it were
+	if errors.Is(err, io.ErrClosedPipe) || errors.Is(err, syscall.EPIPE) {
+		return fmt.Errorf("the stream the remote command was writing to was closed: %w", err)
+	}
+	return &ConnectionError{Err: err}
+}
+
+// ErrSSHAuth is returned when an ssh connection can't be authenticated - either none of the available keys were
+// accepted, or the host key of the server couldn't be verified. Retrying won't help.
+var ErrSSHAuth = errors.New("ssh authentication failed")
+
+// ErrRemoteCommandFailed is returned when a command run over ssh exits with a non-zero status
+type ErrRemoteCommandFailed struct {
+	ExitCode int
+	// Stderr is what the command wrote to stderr, or all of its output where the two weren't kept apart
+	Stderr string
+}
+
+// How much of the end of a failed command's stderr is included in its error
+const (
+	stderrTailLines = 5
+	stderrTailBytes = 512
+)
+
+// secretPattern matches the values of passwords, tokens and the like in a command's output, and the password in
+// a url's credentials
+var secretPattern = regexp.MustCompile(`(?i)((?:password|passwd|pwd|secret|token|api[_-]?key)["']?\s*[=:]\s*["']?|://[^/\s:@]+:)[^\s"'@,;]+`)
+
+func (e *ErrRemoteCommandFailed) Error() string {
+	tail := stderrTail(e.Stderr)
+	if tail == "" {
+		return fmt.Sprintf("remote command failed with exit code %d", e.ExitCode)
+	}
+	return fmt.Sprintf("remote command failed with exit code %d: %s", e.ExitCode, tail)
+}
+
+// stderrTail is the last few lines of stderr on a single line, with anything that looks like a secret redacted
+func stderrTail(stderr string) string {
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	if len(lines) > stderrTailLines {
+		lines = lines[len(lines)-stderrTailLines:]
+	}
+	tail := strings.TrimSpace(secretPattern.ReplaceAllString(strings.Join(lines, " | "), "${1}****"))
+	if len(tail) > stderrTailBytes {
+		tail = "..." + strings.ToValidUTF8(tail[len(tail)-stderrTailBytes:], "")
+	}
+	return tail
+}
+
+// ConnectionError is returned when the ssh connection fails, rather than the command run over it. These are
+// usually transient, so are worth retrying.
+type ConnectionError struct {
+	Err error
+}
+
+func (e *ConnectionError) Error() string {
+	return fmt.Sprintf("ssh error: %v", e.Err)
+}
+
+func (e *ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode returns the exit code of the failed local or remote command behind err, if there is one
+func ExitCode(err error) (int, bool) {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), true
+	}
+	var remoteErr *ErrRemoteCommandFailed
+	if errors.As(err, &remoteErr) {
+		return remoteErr.ExitCode, true
+	}
+	return 0, false
+}
+
+// getSSHClient dials the remote host, trying the available auth methods until one succeeds
+func getSSHClient(remoteUser string, remoteHost string, remotePort string, privateKeyfile string, skipSshAgent bool, hostKeyVerification HostKeyVerification) (*ssh.Client, error) {
 	sshAuthSock, present := os.LookupEnv("SSH_AUTH_SOCK")
 	skipAgent := !present || skipSshAgent
 
 	var authMethods []ssh.AuthMethod
 
-	if validAuthMethod == nil { // This makes it so that in subsequent calls, we don't have to recheck all auth methods
+	cachedAuthMethod := getValidAuthMethod()
+	if cachedAuthMethod == nil { // This makes it so that in subsequent calls, we don't have to recheck all auth methods
 		LogDebugInfo("First time running, no cached valid auth methods", os.Stdout)
 		authMethods = getAuthmethods(skipAgent, privateKeyfile, sshAuthSock, authMethods)
 	} else {
 		LogDebugInfo("Found existing auth method", os.Stdout)
 		authMethods = []ssh.AuthMethod{
-			*validAuthMethod,
+			*cachedAuthMethod,
 		}
 	}
 
-	if len(authMethods) == 0 && validAuthMethod == nil {
-		return errors.New("No valid authentication methods provided"), ""
+	if len(authMethods) == 0 {
+		return nil, fmt.Errorf("%w: no valid authentication methods provided", ErrSSHAuth)
+	}
+
+	hostKeyCallback, err := hostKeyVerification.HostKeyCallback()
+	if err != nil {
+		return nil, err
 	}
 
+	// A host key that fails verification will fail with every auth method, so we note it and stop trying
+	var hostKeyErr error
+	address := net.JoinHostPort(remoteHost, remotePort)
 	config := &ssh.ClientConfig{
-		User:            remoteUser,
-		Auth:            authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		User: remoteUser,
+		Auth: authMethods,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKeyErr = hostKeyCallback(hostname, remote, key)
+			return hostKeyErr
+		},
+		HostKeyAlgorithms: hostKeyVerification.HostKeyAlgorithms(address),
 	}
 
 	var client *ssh.Client
-	var err error
 
 	//we need to iterate over the auth methods till we find one that works
 	// for subsequent runs, this will only run once, since only whatever is in
@@ -131,48 +416,32 @@ func RemoteShellout(command string, service string, remoteUser string, remoteHos
 		config.Auth = []ssh.AuthMethod{
 			am,
 		}
-		client, err = ssh.Dial("tcp", remoteHost+":"+remotePort, config)
+		client, err = ssh.Dial("tcp", address, config)
+		if hostKeyErr != nil {
+			return nil, fmt.Errorf("%w: host key verification failed: %w", ErrSSHAuth, hostKeyErr)
+		}
 		if err != nil {
 			continue
 		}
 
-		if validAuthMethod == nil {
-			LogDebugInfo("Dial success - caching auth method for subsequent runs", os.Stdout)
-			validAuthMethod = &am // set the valid auth method so that future calls won't need to retry
-		}
+		cacheValidAuthMethod(am) // set the valid auth method so that future calls won't need to retry
 		break
 	}
 
-	if validAuthMethod == nil {
-		return errors.New("unable to find valid auth method for ssh"), ""
-	}
-
 	if client == nil {
-		return errors.New("unable to connect via ssh"), ""
-	}
-
-	defer client.Close()
-
-	// Create a session
-	session, err := client.NewSession()
-	if err != nil {
-		return err, ""
-	}
-	defer session.Close()
-
-	ShowSpinner()
-	defer HideSpinner()
-
-	output, err := session.CombinedOutput(fmt.Sprintf("service=%s %s", service, command))
-	if err != nil {
-		if exitErr, ok := err.(*ssh.ExitError); ok {
-			return fmt.Errorf("remote command failed with exit code %d", exitErr.ExitStatus()), string(output)
-		} else {
-			return fmt.Errorf("ssh error: %v", err), string(output)
+		if isAuthError(err) {
+			return nil, fmt.Errorf("%w: unable to find valid auth method for ssh: %v", ErrSSHAuth, err)
 		}
+		return nil, &ConnectionError{Err: fmt.Errorf("unable to connect via ssh: %w", err)}
 	}
 
-	return nil, string(output)
+	return client, nil
+}
+
+// isAuthError reports whether dialling failed because the server didn't accept any of the auth methods, which
+// x/crypto/ssh only reports in the error's text
+func isAuthError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "unable to authenticate")
 }
 
 func getAuthmethods(skipAgent bool, privateKeyfile string, sshAuthSock string, authMethods []ssh.AuthMethod) []ssh.AuthMethod {
@@ -0,0 +1,8 @@
+//go:build windows
+
+package utils
+
+import "os/exec"
+
+// killProcessGroupOnCancel leaves the default behaviour in place on windows, where only the shell itself is killed
+func killProcessGroupOnCancel(cmd *exec.Cmd) {}
@@ -12,6 +12,10 @@ import (
 var showSpinner bool
 var spinnerMx sync.Mutex
 
+// spinnerUsers counts the callers currently showing the spinner, so that when syncs run concurrently
+// one finishing doesn't hide the spinner while others are still working
+var spinnerUsers int
+
 var spinner *spinner2.Spinner
 
 func SetShowSpinner(spin bool) {
@@ -23,7 +27,9 @@ func SetShowSpinner(spin bool) {
 func ShowSpinner() {
 	spinnerMx.Lock()
 	defer spinnerMx.Unlock()
-	if showSpinner {
+	spinnerUsers++
+	// The spinner would garble JSON output, so it's never shown alongside it
+	if showSpinner && !IsJSONOutput() {
 		if spinner != nil {
 			if spinner.Active() {
 				return
@@ -38,6 +44,12 @@ func ShowSpinner() {
 func HideSpinner() {
 	spinnerMx.Lock()
 	defer spinnerMx.Unlock()
+	if spinnerUsers > 0 {
+		spinnerUsers--
+	}
+	if spinnerUsers > 0 {
+		return
+	}
 	if showSpinner && spinner != nil {
 		if spinner.Active() {
 			spinner.Stop()
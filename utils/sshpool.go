@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshKeepAliveInterval is how often pooled connections are checked, so that dropped connections are noticed
+// (and idle ones kept open) between the phases of a sync
+var sshKeepAliveInterval = 30 * time.Second
+
+type sshConnectionKey struct {
+	user string
+	host string
+	port string
+}
+
+// pooledSSHConnection wraps a cached client. mx is held while the connection is being dialled, so that concurrent
+// syncs to the same environment wait for a single connection rather than each dialling their own.
+type pooledSSHConnection struct {
+	mx     sync.Mutex
+	client *ssh.Client
+}
+
+// sshConnectionPool holds one connection per (user, host, port) for the duration of a run
+var sshConnectionPool = struct {
+	mx          sync.Mutex
+	connections map[sshConnectionKey]*pooledSSHConnection
+}{connections: map[sshConnectionKey]*pooledSSHConnection{}}
+
+// GetSSHConnection returns the pooled connection to the remote, dialling it if there isn't a live one already.
+// The connection is shared, so callers mustn't close it - see CloseSSHConnections.
+func GetSSHConnection(remoteUser string, remoteHost string, remotePort string, privateKeyfile string, skipSshAgent bool, hostKeyVerification HostKeyVerification) (*ssh.Client, error) {
+	key := sshConnectionKey{user: remoteUser, host: remoteHost, port: remotePort}
+
+	sshConnectionPool.mx.Lock()
+	connection, exists := sshConnectionPool.connections[key]
+	if !exists {
+		connection = &pooledSSHConnection{}
+		sshConnectionPool.connections[key] = connection
+	}
+	sshConnectionPool.mx.Unlock()
+
+	connection.mx.Lock()
+	defer connection.mx.Unlock()
+
+	if connection.client != nil {
+		if isConnectionAlive(connection.client) {
+			return connection.client, nil
+		}
+		LogDebugInfo(fmt.Sprintf("Connection to %s@%s:%s has dropped, reconnecting", remoteUser, remoteHost, remotePort), nil)
+		connection.client.Close()
+		connection.client = nil
+	}
+
+	LogDebugInfo(fmt.Sprintf("Opening connection to %s@%s:%s", remoteUser, remoteHost, remotePort), nil)
+	client, err := getSSHClient(remoteUser, remoteHost, remotePort, privateKeyfile, skipSshAgent, hostKeyVerification)
+	if err != nil {
+		return nil, err
+	}
+	connection.client = client
+	go keepConnectionAlive(connection, client)
+
+	return client, nil
+}
+
+// newSSHSession opens a session on the pooled connection to the remote. If the connection turns out to have gone
+// away, it's redialled once.
+func newSSHSession(remoteUser string, remoteHost string, remotePort string, privateKeyfile string, skipSshAgent bool, hostKeyVerification HostKeyVerification) (*ssh.Session, error) {
+	client, err := GetSSHConnection(remoteUser, remoteHost, remotePort, privateKeyfile, skipSshAgent, hostKeyVerification)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := client.NewSession()
+	if err == nil {
+		return session, nil
+	}
+
+	LogDebugInfo(fmt.Sprintf("Unable to open session on %s@%s:%s, reconnecting", remoteUser, remoteHost, remotePort), err.Error())
+	client.Close()
+	client, err = GetSSHConnection(remoteUser, remoteHost, remotePort, privateKeyfile, skipSshAgent, hostKeyVerification)
+	if err != nil {
+		return nil, err
+	}
+	session, err = client.NewSession()
+	if err != nil {
+		return nil, &ConnectionError{Err: err}
+	}
+	return session, nil
+}
+
+// CloseSSHConnections closes every pooled connection. It's called once a run is complete - syncs running
+// side by side share connections, so individual syncs leave them open.
+func CloseSSHConnections() {
+	sshConnectionPool.mx.Lock()
+	defer sshConnectionPool.mx.Unlock()
+	for key, connection := range sshConnectionPool.connections {
+		connection.mx.Lock()
+		if connection.client != nil {
+			connection.client.Close()
+			connection.client = nil
+		}
+		connection.mx.Unlock()
+		delete(sshConnectionPool.connections, key)
+	}
+}
+
+func isConnectionAlive(client *ssh.Client) bool {
+	_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+	return err == nil
+}
+
+// keepConnectionAlive pings the connection until it's closed, closing it if the remote stops answering so
+// that the next caller reconnects
+func keepConnectionAlive(connection *pooledSSHConnection, client *ssh.Client) {
+	closed := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(closed)
+	}()
+
+	ticker := time.NewTicker(sshKeepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+			if !isConnectionAlive(client) {
+				client.Close()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package utils
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	osexec "os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testExecServer is an ssh server that answers every command with "ok" (apart from commands containing "hang",
+// which never finish, and commands for the "sh" service, which are run by a local shell), counting the connections
+// made to it and recording the commands it's sent
+type testExecServer struct {
+	host        string
+	port        string
+	mx          sync.Mutex
+	connections []net.Conn
+	commands    []string
+}
+
+func (s *testExecServer) receivedCommands() []string {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return append([]string{}, s.commands...)
+}
+
+func (s *testExecServer) connectionCount() int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return len(s.connections)
+}
+
+func (s *testExecServer) dropConnections() {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	for _, conn := range s.connections {
+		conn.Close()
+	}
+}
+
+func startTestExecServer(t *testing.T) *testExecServer {
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	signer, _ := ssh.NewSignerFromKey(privateKey)
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &testExecServer{}
+	server.host, server.port, _ = net.SplitHostPort(listener.Addr().String())
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.mx.Lock()
+			server.connections = append(server.connections, conn)
+			server.mx.Unlock()
+			go server.serveConnection(conn, config)
+		}
+	}()
+
+	return server
+}
+
+func (s *testExecServer) serveConnection(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer channel.Close()
+			for request := range channelRequests {
+				if request.Type != "exec" {
+					request.Reply(false, nil)
+					continue
+				}
+				request.Reply(true, nil)
+				var exec struct{ Command string }
+				ssh.Unmarshal(request.Payload, &exec)
+				s.mx.Lock()
+				s.commands = append(s.commands, exec.Command)
+				s.mx.Unlock()
+				if command, ok := strings.CutPrefix(exec.Command, "service=sh "); ok {
+					cmd := osexec.Command("sh", "-c", command)
+					cmd.Stdin, cmd.Stdout, cmd.Stderr = channel, channel, channel.Stderr()
+					var status uint32
+					if err := cmd.Run(); err != nil {
+						status = 1
+					}
+					channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+					return
+				}
+				if strings.Contains(exec.Command, "hang") {
+					// Never finishes, the client has to give up on it
+					continue
+				}
+				channel.Write([]byte("ok"))
+				channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+				return
+			}
+		}()
+	}
+}
+
+func TestRemoteShellout_reusesConnections(t *testing.T) {
+	server := startTestExecServer(t)
+	privateKey, err := generatePrivateKey(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(CloseSSHConnections)
+
+	hostKeys := HostKeyVerification{Policy: HostKeyPolicyInsecure}
+	run := func() {
+		err, output := RemoteShellout(context.Background(), "true", nil, "cli", "project-env", server.host, server.port, privateKey, true, hostKeys)
+		if err != nil || output != "ok" {
+			t.Fatalf("RemoteShellout() = %v, %v", err, output)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		run()
+	}
+	if got := server.connectionCount(); got != 1 {
+		t.Errorf("RemoteShellout() made %v connections for 3 commands, want 1", got)
+	}
+
+	// A dropped connection should be replaced transparently
+	server.dropConnections()
+	run()
+	if got := server.connectionCount(); got != 2 {
+		t.Errorf("RemoteShellout() made %v connections after the first was dropped, want 2", got)
+	}
+
+	// Once the pool is closed, the next command needs a new connection
+	CloseSSHConnections()
+	run()
+	if got := server.connectionCount(); got != 3 {
+		t.Errorf("RemoteShellout() made %v connections after closing the pool, want 3", got)
+	}
+}
+
+func TestRemoteShellout_cancelled(t *testing.T) {
+	server := startTestExecServer(t)
+	privateKey, err := generatePrivateKey(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(CloseSSHConnections)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	hostKeys := HostKeyVerification{Policy: HostKeyPolicyInsecure}
+	err, _ = RemoteShellout(ctx, "hang", nil, "cli", "project-env", server.host, server.port, privateKey, true, hostKeys)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("RemoteShellout() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The connection is still usable for the next command
+	err, output := RemoteShellout(context.Background(), "true", nil, "cli", "project-env", server.host, server.port, privateKey, true, hostKeys)
+	if err != nil || output != "ok" {
+		t.Errorf("RemoteShellout() after cancelling = %v, %v", err, output)
+	}
+}
+
+func TestShellout_cancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err, _, _ := Shellout(ctx, "sleep 10; echo done", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shellout() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Shellout() took %v to stop after being cancelled", time.Since(start))
+	}
+}
+
+func TestCommandSecrets(t *testing.T) {
+	server := startTestExecServer(t)
+	privateKey, err := generatePrivateKey(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(CloseSSHConnections)
+	hostKeys := HostKeyVerification{Policy: HostKeyPolicyInsecure}
+
+	secrets := CommandSecrets{"FIRST_SECRET": "s3cret 'quoted' $value", "SECOND_SECRET": "other"}
+	const command = `printf '%s|%s|' "$FIRST_SECRET" "$SECOND_SECRET"; cat`
+	const want = "s3cret 'quoted' $value|other|streamed"
+
+	t.Run("Local commands get them from the environment", func(t *testing.T) {
+		var stdout strings.Builder
+		err, stderr := StreamShellout(context.Background(), command, secrets, strings.NewReader("streamed"), &stdout)
+		if err != nil || stdout.String() != want {
+			t.Errorf("StreamShellout() = %v, %q (%v), want %q", err, stdout.String(), stderr, want)
+		}
+	})
+
+	t.Run("Remote commands read them from stdin", func(t *testing.T) {
+		var stdout strings.Builder
+		err, stderr := RemoteStreamShellout(context.Background(), command, secrets, "sh", "project-env", server.host, server.port, privateKey, true, hostKeys, strings.NewReader("streamed"), &stdout)
+		if err != nil || stdout.String() != want {
+			t.Errorf("RemoteStreamShellout() = %v, %q (%v), want %q", err, stdout.String(), stderr, want)
+		}
+		err, output := RemoteShellout(context.Background(), command, secrets, "sh", "project-env", server.host, server.port, privateKey, true, hostKeys)
+		if err != nil || output != "s3cret 'quoted' $value|other|" {
+			t.Errorf("RemoteShellout() = %v, %q", err, output)
+		}
+		for _, sent := range server.receivedCommands() {
+			if strings.Contains(sent, "s3cret") || strings.Contains(sent, "other") {
+				t.Errorf("the secrets were sent in the command %q", sent)
+			}
+		}
+	})
+
+	t.Run("Remote secrets must fit on a line", func(t *testing.T) {
+		err, _ := RemoteShellout(context.Background(), command, CommandSecrets{"FIRST_SECRET": "two\nlines"}, "sh", "project-env", server.host, server.port, privateKey, true, hostKeys)
+		if err == nil {
+			t.Errorf("RemoteShellout() accepted a secret containing a newline")
+		}
+	})
+}
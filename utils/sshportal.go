@@ -3,10 +3,10 @@ package utils
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	lclient "github.com/uselagoon/machinery/api/lagoon/client"
 	"github.com/uselagoon/machinery/api/schema"
-	"github.com/uselagoon/machinery/utils/sshtoken"
 	"golang.org/x/net/context"
 )
 
@@ -22,8 +22,8 @@ type ApiConn struct {
 	sshPort         string
 }
 
-func (r *ApiConn) Init(graphqlEndpoint, sshkeyPath, sshHost, sshPort string) error {
-	token, err := sshtoken.RetrieveToken(sshkeyPath, sshHost, sshPort, nil, nil, false)
+func (r *ApiConn) Init(graphqlEndpoint, sshkeyPath, sshHost, sshPort string, hostKeyVerification HostKeyVerification) error {
+	token, err := retrieveToken(sshkeyPath, sshHost, sshPort, hostKeyVerification)
 	if err != nil {
 		return err
 	}
@@ -36,6 +36,27 @@ func (r *ApiConn) Init(graphqlEndpoint, sshkeyPath, sshHost, sshPort string) err
 	return nil
 }
 
+// retrieveToken gets a token from the ssh portal's token service. The token service doesn't check host keys itself,
+// so the token is requested over our own connection, which verifies the host before it's handed our key.
+func retrieveToken(sshkeyPath, sshHost, sshPort string, hostKeyVerification HostKeyVerification) (string, error) {
+	client, err := getSSHClient("lagoon", sshHost, sshPort, sshkeyPath, false, hostKeyVerification)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("couldn't open session: %w", err)
+	}
+	defer session.Close()
+	out, err := session.CombinedOutput("token")
+	if err != nil {
+		return "", fmt.Errorf("couldn't get token: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
 func (r *ApiConn) GetProjectEnvironmentDeployTargets(projectName string) (*[]schema.Environment, error) {
 	if r.token == "" {
 		return nil, errors.New("ApiConn has not been initialized")
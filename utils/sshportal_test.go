@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// startTestTokenServer starts an ssh server that answers the token command the way the ssh portal does, counting
+// how many tokens it has handed out
+func startTestTokenServer(t *testing.T) (string, string, ssh.Signer, *atomic.Int32) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	tokens := &atomic.Int32{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, channels, requests, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(requests)
+				for newChannel := range channels {
+					channel, channelRequests, err := newChannel.Accept()
+					if err != nil {
+						return
+					}
+					for request := range channelRequests {
+						if request.Type != "exec" || string(request.Payload[4:]) != "token" {
+							request.Reply(false, nil)
+							continue
+						}
+						request.Reply(true, nil)
+						tokens.Add(1)
+						channel.Write([]byte("test-token\n"))
+						channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+						channel.Close()
+					}
+				}
+			}()
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	return host, port, signer, tokens
+}
+
+func TestApiConn_Init(t *testing.T) {
+	host, port, signer, tokens := startTestTokenServer(t)
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	_, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(clientKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("A host that doesn't match is never asked for a token", func(t *testing.T) {
+		conn := ApiConn{}
+		err := conn.Init("https://api.example.com/graphql", keyFile, host, port, HostKeyVerification{Fingerprints: []string{"SHA256:nope"}})
+		if err == nil {
+			t.Fatalf("Init() accepted a host key that isn't pinned")
+		}
+		if tokens.Load() != 0 {
+			t.Errorf("Init() requested a token from a host that failed verification")
+		}
+	})
+
+	t.Run("A verified host hands out a token", func(t *testing.T) {
+		conn := ApiConn{}
+		err := conn.Init("https://api.example.com/graphql", keyFile, host, port, HostKeyVerification{Fingerprints: []string{ssh.FingerprintSHA256(signer.PublicKey())}})
+		if err != nil {
+			t.Fatalf("Init() error = %v", err)
+		}
+		if conn.token != "test-token" {
+			t.Errorf("Init() token = %q, want %q", conn.token, "test-token")
+		}
+	})
+}